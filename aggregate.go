@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The most groups which PIVOT turns into columns, since each of them is a
+// column of the single result.
+const maxPivotColumns = 1000
+
+// Return true iff the query has a result per group of files, rather than per
+// file (i.e. it has a GROUP BY clause or an aggregate function).
+func isGrouped(q *query.Query) bool {
+	if len(q.GroupBy) > 0 {
+		return true
+	}
+	for _, c := range q.Columns {
+		if c.Aggregate != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Return a result per group of the results with the same values for each of
+// the query's GROUP BY attributes, in order of those values, with the value of
+// each of the query's aggregate functions over the group. A query without a
+// GROUP BY clause has a single group, even when there are no results.
+func aggregateResults(q *query.Query, results []result, opts query.QueryOptions) []result {
+	partitions := partitionResults(results, q.GroupBy)
+	if len(q.GroupBy) == 0 && len(partitions) == 0 {
+		partitions = [][]int{{}}
+	}
+
+	groups := make([]result, 0, len(partitions))
+	for _, partition := range partitions {
+		// The group's attributes are the same for each of its results, so
+		// they're the values of the first.
+		var group result
+		if len(partition) > 0 {
+			group = results[partition[0]]
+		}
+		group.computed = make([]interface{}, len(q.Columns))
+
+		for i, c := range q.Columns {
+			if c.Aggregate != nil {
+				group.computed[i] = computeAggregate(c.Aggregate, results, partition, opts)
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	orderBy := make([]query.Ordering, 0, len(q.GroupBy))
+	for _, attribute := range q.GroupBy {
+		orderBy = append(orderBy, query.Ordering{Attribute: attribute})
+	}
+	sortResults(groups, orderBy)
+
+	return groups
+}
+
+// Return the value of the aggregate function over the results in partition (a
+// list of indices of results).
+func computeAggregate(fn *query.AggregateFunction, results []result, partition []int,
+	opts query.QueryOptions) interface{} {
+	switch fn.Type {
+	case query.Count:
+		if fn.Attribute == "*" {
+			return len(partition)
+		}
+
+		// Only the files for which the attribute isn't NULL are counted.
+		count := 0
+		for _, i := range partition {
+			if results[i].value(fn.Attribute) != nil {
+				count++
+			}
+		}
+		return count
+
+	case query.Median:
+		values := numericValues(fn.Attribute, results, partition)
+		if len(values) == 0 {
+			return 0.0
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		// The median of an even number of values is the mean of the middle
+		// two, otherwise it's the middle value itself (which is always a
+		// float64 too, so that the column has a single type).
+		middle := len(values) / 2
+		if len(values)%2 == 0 {
+			return (float64(values[middle-1]) + float64(values[middle])) / 2
+		}
+		return float64(values[middle])
+
+	case query.Stddev, query.StddevSamp, query.Variance:
+		n, variance := welfordVariance(numericValues(fn.Attribute, results, partition))
+		switch fn.Type {
+		case query.Variance:
+			return variance
+		case query.Stddev:
+			return math.Sqrt(variance)
+		}
+		// The sample standard deviation is undefined for fewer than two
+		// files.
+		if n < 2 {
+			return nil
+		}
+		return math.Sqrt(variance * float64(n) / float64(n-1))
+
+	case query.StringAgg:
+		return stringAgg(fn, results, partition, opts.StringAggMaxLength)
+
+	case query.ArrayAgg:
+		t := columnType(query.Column{Attribute: fn.Attribute})
+		values := make([]interface{}, 0, len(partition))
+		for _, i := range orderPartition(fn.OrderBy, results, partition) {
+			values = append(values, jsonValue(results[i].value(fn.Attribute), t))
+		}
+		b, _ := json.Marshal(values)
+		return string(b)
+
+	case query.BitAnd, query.BitOr, query.BitXor:
+		return bitAggregate(fn, results, partition)
+
+	case query.Max, query.Min:
+		values := numericValues(fn.Attribute, results, partition)
+		if len(values) == 0 {
+			return nil
+		}
+		extreme := values[0]
+		for _, v := range values[1:] {
+			if fn.Type == query.Max && v > extreme || fn.Type == query.Min && v < extreme {
+				extreme = v
+			}
+		}
+		return extreme
+
+	case query.Checksum:
+		return checksum(fn.Attributes, results, partition)
+
+	case query.ChecksumAgg:
+		return checksumAgg(fn, results, partition)
+	}
+	return nil
+}
+
+// Return the hex-encoded SHA-256 hash of the JSON array of the results in
+// partition, each of which is a JSON array of its values of the attributes.
+// The results are sorted first, so the checksum doesn't depend on the order
+// the files were walked in.
+func checksum(attributes []string, results []result, partition []int) string {
+	types := make([]attributeType, len(attributes))
+	for i, attribute := range attributes {
+		types[i] = columnType(query.Column{Attribute: attribute})
+	}
+
+	rows := make([]json.RawMessage, 0, len(partition))
+	for _, i := range partition {
+		values := make([]interface{}, len(attributes))
+		for j, attribute := range attributes {
+			values[j] = jsonValue(results[i].value(attribute), types[j])
+		}
+		b, _ := json.Marshal(values)
+		rows = append(rows, b)
+	}
+	sort.Slice(rows, func(i, j int) bool { return bytes.Compare(rows[i], rows[j]) < 0 })
+
+	b, _ := json.Marshal(rows)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Return the checksum of CHECKSUM_AGG's attribute over the results in
+// partition (without NULL values). Without an ORDER BY, it's the hex-encoded
+// XOR of the CRC-32 of each value, so it doesn't depend on the order the files
+// were walked in (and values which appear an even number of times cancel
+// out). With an ORDER BY, it's the hex-encoded SHA-256 hash of the values in
+// that order, so reordering them changes it.
+func checksumAgg(fn *query.AggregateFunction, results []result, partition []int) string {
+	if len(fn.OrderBy) == 0 {
+		var sum uint32
+		for _, i := range partition {
+			if v := results[i].value(fn.Attribute); v != nil {
+				sum ^= crc32.ChecksumIEEE([]byte(formatValue(v)))
+			}
+		}
+		return fmt.Sprintf("%08x", sum)
+	}
+
+	// Each value is terminated by a NUL byte, so that the boundaries between
+	// them are part of the hash.
+	h := sha256.New()
+	for _, i := range orderPartition(fn.OrderBy, results, partition) {
+		if v := results[i].value(fn.Attribute); v != nil {
+			h.Write([]byte(formatValue(v)))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Return the bitwise AND, OR, or XOR of the integer attribute over the results
+// in partition, which is a mode for the mode attribute, or NULL if there are
+// no (non-NULL) values.
+func bitAggregate(fn *query.AggregateFunction, results []result, partition []int) interface{} {
+	var acc uint64
+	var isMode bool
+	n := 0
+	for _, i := range partition {
+		var bits uint64
+		switch v := results[i].value(fn.Attribute).(type) {
+		case os.FileMode:
+			bits, isMode = uint64(v), true
+		case int64:
+			bits = uint64(v)
+		default:
+			continue
+		}
+
+		switch {
+		case n == 0:
+			acc = bits
+		case fn.Type == query.BitAnd:
+			acc &= bits
+		case fn.Type == query.BitOr:
+			acc |= bits
+		case fn.Type == query.BitXor:
+			acc ^= bits
+		}
+		n++
+	}
+
+	switch {
+	case n == 0:
+		return nil
+	case isMode:
+		return os.FileMode(acc)
+	}
+	return int64(acc)
+}
+
+// Return a copy of partition (a list of indices of results), sorted by the
+// orderings (if there are any) while otherwise in the same order.
+func orderPartition(orderBy []query.Ordering, results []result, partition []int) []int {
+	ordered := append([]int(nil), partition...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return compareOrdering(orderBy, results[ordered[i]], results[ordered[j]]) < 0
+	})
+	return ordered
+}
+
+// Return the values of STRING_AGG's attribute for the results in partition
+// (without NULL values), joined by its separator, in the order of its ORDER BY
+// or otherwise the order the files were walked in. If the value is longer than
+// maxLength characters (and maxLength isn't 0), it's truncated and followed by
+// "...".
+func stringAgg(fn *query.AggregateFunction, results []result, partition []int, maxLength int) string {
+	values := make([]string, 0, len(partition))
+	for _, i := range orderPartition(fn.OrderBy, results, partition) {
+		if v := results[i].value(fn.Attribute); v != nil {
+			values = append(values, formatValue(v))
+		}
+	}
+
+	s := strings.Join(values, fn.Separator)
+	if runes := []rune(s); maxLength > 0 && len(runes) > maxLength {
+		return string(runes[:maxLength]) + "..."
+	}
+	return s
+}
+
+// Return the number of values, and their population variance (0 if there are
+// none), computed with Welford's online algorithm for numerical stability.
+func welfordVariance(values []int64) (int, float64) {
+	var mean, m2 float64
+	for i, v := range values {
+		x := float64(v)
+		delta := x - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (x - mean)
+	}
+	if len(values) == 0 {
+		return 0, 0
+	}
+	return len(values), m2 / float64(len(values))
+}
+
+// Return the values of the numeric attribute of the results in partition,
+// without NULL values.
+func numericValues(attribute string, results []result, partition []int) []int64 {
+	values := make([]int64, 0, len(partition))
+	for _, i := range partition {
+		if v, ok := results[i].value(attribute).(int64); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Return the type of the aggregate function's value.
+func aggregateType(fn *query.AggregateFunction) attributeType {
+	switch fn.Type {
+	case query.Median, query.Stddev, query.Variance:
+		return attributeType{jsonType: "number"}
+	case query.StddevSamp:
+		return attributeType{jsonType: "number", nullable: true}
+	case query.StringAgg, query.ArrayAgg, query.Checksum, query.ChecksumAgg:
+		return attributeType{jsonType: "string"}
+	case query.BitAnd, query.BitOr, query.BitXor:
+		t := columnType(query.Column{Attribute: fn.Attribute})
+		t.nullable = true
+		return t
+	case query.Max, query.Min:
+		return attributeType{jsonType: "integer", nullable: true}
+	}
+	return attributeType{jsonType: "integer"}
+}
+
+// Turn each group of the pivoted query's results into a column of a single
+// result, named after the group's value of the GROUP BY attribute and with the
+// value of the aggregate function over the group. Returns the query with those
+// columns, and the result, or no results if there are no groups.
+func pivotResults(q *query.Query, results []result) (*query.Query, []result, error) {
+	if len(results) > maxPivotColumns {
+		return nil, nil, fmt.Errorf("PIVOT of %d groups exceeds the maximum of %d columns",
+			len(results), maxPivotColumns)
+	}
+
+	aggregate := 0
+	for i, c := range q.Columns {
+		if c.Aggregate != nil {
+			aggregate = i
+		}
+	}
+
+	pivoted := *q
+	pivoted.Columns = make([]query.Column, 0, len(results))
+	row := result{computed: make([]interface{}, 0, len(results))}
+	for _, r := range results {
+		name := formatValue(r.value(q.GroupBy[0]))
+		if name == "" {
+			name = "NULL"
+		}
+
+		pivoted.Columns = append(pivoted.Columns, query.Column{
+			Aggregate: q.Columns[aggregate].Aggregate,
+			Alias:     name,
+		})
+		row.computed = append(row.computed, r.computed[aggregate])
+	}
+
+	if len(results) == 0 {
+		return &pivoted, []result{}, nil
+	}
+	return &pivoted, []result{row}, nil
+}
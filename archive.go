@@ -0,0 +1,409 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The bytes which begin every (non-empty) ZIP archive.
+var zipMagic = []byte("PK\x03\x04")
+
+// The separator between the path of an archive and the path of one of its
+// members (e.g. archive.zip!/src/main.go).
+const archiveSeparator = "!/"
+
+// A decompressor returns a reader of the decompressed contents of r.
+type decompressor func(r io.Reader) (io.Reader, error)
+
+// The decompressor of the TAR archives with each extension. Compressions
+// which require third-party dependencies are only registered when built with
+// their build tag (e.g. xz in xz.go).
+var tarDecompressors = map[string]decompressor{
+	".tar":     func(r io.Reader) (io.Reader, error) { return r, nil },
+	".tar.gz":  func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	".tgz":     func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	".tar.bz2": func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	".tbz2":    func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+}
+
+// The build tag which registers the decompressor of each TAR extension that
+// isn't built by default.
+var tarTags = map[string]string{
+	".tar.xz": "xz",
+	".txz":    "xz",
+}
+
+// Return true iff the file at path in fsys is a ZIP archive, either by its
+// extension or by its magic bytes.
+func isZip(fsys vfs, path string, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		return true
+	}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zipMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, zipMagic)
+}
+
+// Return the TAR extension of name (e.g. .tar.gz), or the empty string if it
+// isn't a TAR archive.
+func tarExtension(name string) string {
+	lower := strings.ToLower(name)
+	for ext := range tarDecompressors {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	for ext := range tarTags {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Return a reader of the TAR archive r with the extension ext.
+func decompressTar(ext string, r io.Reader) (io.Reader, error) {
+	decompress, ok := tarDecompressors[ext]
+	if !ok {
+		return nil, fmt.Errorf("%s archives require building with -tags %s", ext, tarTags[ext])
+	}
+	return decompress(r)
+}
+
+// Walk the source in fsys, unless it's a ZIP or TAR archive, in which case its
+// members are walked instead (see walkArchive).
+func walkSource(fsys vfs, src string, nested bool, opts query.QueryOptions, fn filepath.WalkFunc) error {
+	info, err := fsys.Stat(src)
+	if err != nil || !info.Mode().IsRegular() {
+		return fsys.Walk(src, fn)
+	}
+
+	if isZip(fsys, src, info) || tarExtension(src) != "" {
+		err := walkArchive(fsys, src, info, nested, opts, fn)
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	return fsys.Walk(src, fn)
+}
+
+// Walk the members of the archive at path as if it were a directory (without
+// calling fn for the archive itself). Each member's path is the archive's
+// path followed by archiveSeparator and the member's name. Directories which
+// aren't members themselves, but contain members, are walked too. If nested
+// is set, the members of each archive inside the archive are walked after it.
+//
+// The members of a ZIP archive are walked in order of their names, and their
+// size is their uncompressed size. TAR archives are streamed rather than
+// extracted, so their members are walked in the order they're archived.
+func walkArchive(fsys vfs, path string, info os.FileInfo, nested bool, opts query.QueryOptions,
+	fn filepath.WalkFunc) error {
+	w := &archiveWalker{prefix: path, nested: nested, opts: opts, fn: fn}
+
+	f, err := fsys.Open(path)
+	if err != nil {
+		return fn(path, nil, err)
+	}
+	defer f.Close()
+
+	if ext := tarExtension(path); ext != "" {
+		r, err := decompressTar(ext, f)
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		return w.walkTar(r)
+	}
+
+	r, err := newZipReader(f, info.Size())
+	if err != nil {
+		return fn(path, nil, err)
+	}
+	return w.walkZip(r)
+}
+
+// Return a reader of the ZIP archive r, which is size bytes long. Archives
+// which can't be read at an offset are read into memory, since the directory
+// of a ZIP archive is at its end.
+func newZipReader(r io.Reader, size int64) (*zip.Reader, error) {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return zip.NewReader(ra, size)
+	}
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+}
+
+// An archiveWalker walks the members of the archive at prefix, which is depth
+// levels below the root.
+type archiveWalker struct {
+	prefix string
+	depth  int
+	nested bool
+	opts   query.QueryOptions
+	fn     filepath.WalkFunc
+
+	// Directories which were skipped (by fn returning filepath.SkipDir), each
+	// followed by a slash.
+	skipped []string
+}
+
+// Call fn for the member name, unless it's below the maximum depth or in a
+// directory which was skipped. Returns true iff the member was visited.
+func (w *archiveWalker) visit(name string, info os.FileInfo) (bool, error) {
+	if hasAnyPrefix(name, w.skipped) {
+		return false, nil
+	}
+	if w.opts.MaxDepth > 0 && w.memberDepth(name) > w.opts.MaxDepth {
+		return false, nil
+	}
+
+	if err := w.fn(w.member(name), info, nil); err != nil {
+		if err != filepath.SkipDir {
+			return false, err
+		}
+		if info.IsDir() {
+			w.skipped = append(w.skipped, name+"/")
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Return the path of the member name.
+func (w *archiveWalker) member(name string) string {
+	return w.prefix + archiveSeparator + name
+}
+
+// Return how many levels below the root the member name is.
+func (w *archiveWalker) memberDepth(name string) int {
+	return w.depth + strings.Count(name, "/") + 1
+}
+
+// Walk the members of the archive inside this archive at name (if nested
+// archives are walked), which is opened with open.
+func (w *archiveWalker) walkNested(name string, info os.FileInfo, open func() (io.ReadCloser, error)) error {
+	ext := tarExtension(name)
+	if !w.nested || info.IsDir() || (ext == "" && !strings.EqualFold(path.Ext(name), ".zip")) {
+		return nil
+	}
+
+	nested := &archiveWalker{
+		prefix: w.member(name),
+		depth:  w.memberDepth(name),
+		nested: w.nested,
+		opts:   w.opts,
+		fn:     w.fn,
+	}
+
+	rc, err := open()
+	if err != nil {
+		return w.visitError(name, info, err)
+	}
+	defer rc.Close()
+
+	if ext != "" {
+		r, err := decompressTar(ext, rc)
+		if err != nil {
+			return w.visitError(name, info, err)
+		}
+		err = nested.walkTar(r)
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	r, err := newZipReader(rc, info.Size())
+	if err != nil {
+		return w.visitError(name, info, err)
+	}
+	err = nested.walkZip(r)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// Call fn with the error reading the member name.
+func (w *archiveWalker) visitError(name string, info os.FileInfo, err error) error {
+	if err := w.fn(w.member(name), info, err); err != nil && err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
+// Walk the members of the ZIP archive r, in order of their names.
+func (w *archiveWalker) walkZip(r *zip.Reader) error {
+	files := make(map[string]*zip.File, len(r.File))
+	infos := make(map[string]os.FileInfo, len(r.File))
+	for _, f := range r.File {
+		name := cleanMemberName(f.Name)
+		if name == "" {
+			continue
+		}
+		files[name], infos[name] = f, f.FileInfo()
+
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := infos[dir]; !ok {
+				infos[dir] = impliedDir(path.Base(dir))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		visited, err := w.visit(name, infos[name])
+		if err != nil {
+			return err
+		}
+
+		if f, ok := files[name]; ok && visited {
+			if err := w.walkNested(name, infos[name], f.Open); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Walk the members of the TAR archive r, in the order they're archived. The
+// information of each member includes the offset of its contents.
+func (w *archiveWalker) walkTar(r io.Reader) error {
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	seen := make(map[string]bool)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return w.fn(w.prefix, nil, err)
+		}
+
+		name := cleanMemberName(hdr.Name)
+		if name == "" || seen[name] {
+			continue
+		}
+
+		// Directories which aren't members themselves are walked before
+		// their first member.
+		var dirs []string
+		for dir := path.Dir(name); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			dirs = append(dirs, dir)
+		}
+		for i := len(dirs) - 1; i >= 0; i-- {
+			seen[dirs[i]] = true
+			if _, err := w.visit(dirs[i], impliedDir(path.Base(dirs[i]))); err != nil {
+				return err
+			}
+		}
+
+		seen[name] = true
+		info := tarInfo{FileInfo: hdr.FileInfo(), offset: counter.n}
+		visited, err := w.visit(name, info)
+		if err != nil {
+			return err
+		}
+
+		if visited {
+			open := func() (io.ReadCloser, error) { return ioutil.NopCloser(tr), nil }
+			if err := w.walkNested(name, info, open); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Return the name of an archive's member as a relative, slash-separated path
+// (e.g. src/main.go), or the empty string for the archive's root.
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// Return true iff name begins with any of prefixes.
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// A countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// A tarInfo is the information of a TAR archive's member, along with the
+// offset of its contents in the (uncompressed) archive.
+type tarInfo struct {
+	os.FileInfo
+	offset int64
+}
+
+// Return the offset of the contents of the file in its TAR archive, and false
+// if it isn't a member of one.
+func tarOffset(info os.FileInfo) (int64, bool) {
+	if t, ok := info.(tarInfo); ok {
+		return t.offset, true
+	}
+	return 0, false
+}
+
+// An impliedDir is a directory which isn't listed itself, but contains files
+// which are (e.g. src in an archive with a src/main.go member, or in a bucket
+// with a src/main.go object).
+type impliedDir string
+
+func (d impliedDir) Name() string       { return string(d) }
+func (d impliedDir) Size() int64        { return 0 }
+func (d impliedDir) Mode() os.FileMode  { return os.ModeDir | 0755 }
+func (d impliedDir) ModTime() time.Time { return time.Time{} }
+func (d impliedDir) IsDir() bool        { return true }
+func (d impliedDir) Sys() interface{}   { return nil }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return the type of the arithmetic expression's values: integers if each of
+// its operands is an integer (see query.Expression.IsInteger), otherwise
+// numbers. Either is nullable, since it may divide by zero.
+func expressionType(e *query.Expression) attributeType {
+	t := attributeType{jsonType: "integer", nullable: true}
+	if !e.IsInteger() {
+		t.jsonType = "number"
+	}
+	return t
+}
+
+// An evaluationError is raised (with panic) by evaluateExpression, since the
+// conditions and columns which evaluate an expression can't return an error.
+// It's recovered by recoverEvaluation, so it never escapes the evaluation of
+// a query (which is synchronous).
+type evaluationError struct {
+	err error
+}
+
+// Return the value of the arithmetic expression whose attributes have the
+// values returned by value (see compare.Evaluate), raising an evaluationError
+// if it overflows.
+func evaluateExpression(e *query.Expression, value func(attribute string) interface{}) interface{} {
+	v, err := cmp.Evaluate(e, value)
+	if err != nil {
+		panic(evaluationError{err})
+	}
+	return v
+}
+
+// Recover the evaluationError raised while evaluating a query (if there was
+// one) into *err, so that the query fails with it. It must be deferred.
+func recoverEvaluation(err *error) {
+	if r := recover(); r != nil {
+		e, ok := r.(evaluationError)
+		if !ok {
+			panic(r)
+		}
+		*err = e.err
+	}
+}
@@ -0,0 +1,140 @@
+//go:build arrow
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	destinationWriters["arrow"] = writeArrow
+}
+
+// Return the Arrow type of the column's values. Times are microseconds since
+// the Unix epoch, in UTC.
+func arrowType(c query.Column) arrow.DataType {
+	t := columnType(c)
+	switch {
+	case t.jsonType == "integer":
+		return arrow.PrimitiveTypes.Int64
+	case t.jsonType == "number":
+		return arrow.PrimitiveTypes.Float64
+	case t.format == "date-time":
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	}
+	return arrow.BinaryTypes.String
+}
+
+// Return the Arrow schema of the columns, with a field for each of them.
+func arrowSchema(columns []query.Column) *arrow.Schema {
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, c := range columns {
+		fields = append(fields, arrow.Field{
+			Name:     c.Name(),
+			Type:     arrowType(c),
+			Nullable: columnType(c).nullable,
+		})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// Build a record with a row per result, which must be released by the caller.
+func newArrowRecord(schema *arrow.Schema, columns []query.Column, results []result) arrow.Record {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	for _, r := range results {
+		for i, c := range columns {
+			switch v := r.column(i, c).(type) {
+			case os.FileMode:
+				b.Field(i).(*array.StringBuilder).Append(v.String())
+			case time.Time:
+				b.Field(i).(*array.TimestampBuilder).Append(arrow.Timestamp(v.UnixNano() / int64(time.Microsecond)))
+			case string:
+				if v == "" && columnType(c).nullable {
+					b.Field(i).AppendNull()
+				} else {
+					b.Field(i).(*array.StringBuilder).Append(v)
+				}
+			case int64:
+				b.Field(i).(*array.Int64Builder).Append(v)
+			case int:
+				b.Field(i).(*array.Int64Builder).Append(int64(v))
+			case float64:
+				b.Field(i).(*array.Float64Builder).Append(v)
+			case nil:
+				b.Field(i).AppendNull()
+			}
+		}
+	}
+
+	return b.NewRecord()
+}
+
+// Evaluate the query and return its results as an Arrow table, with a column
+// for each of the query's columns. A query without results returns an empty
+// table. The table must be released by the caller.
+func evaluateArrow(ctx context.Context, input string) (arrow.Table, error) {
+	q, err := query.RunParser(input)
+	if err != nil {
+		return nil, err
+	}
+	qopts, _, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := evaluateWith(ctx, q, qopts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	schema := arrowSchema(q.Columns)
+	rec := newArrowRecord(schema, q.Columns, results)
+	defer rec.Release()
+
+	return array.NewTableFromRecords(schema, []arrow.Record{rec}), nil
+}
+
+// Write the results to a new Arrow IPC file at dest.Path.
+func writeArrow(dest *query.Destination, columns []query.Column, results []result) error {
+	if dest.Replace {
+		return fmt.Errorf("INTO OR REPLACE isn't supported for arrow")
+	}
+
+	f, err := os.Create(dest.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	schema := arrowSchema(columns)
+	w, err := ipc.NewFileWriter(f, ipc.WithSchema(schema))
+	if err != nil {
+		return err
+	}
+
+	rec := newArrowRecord(schema, columns, results)
+	defer rec.Release()
+	if err := w.Write(rec); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,88 @@
+//go:build arrow
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+func TestEvaluateArrow(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "aaa", "b": "b"})
+	input := "SELECT name, size, ext, time, ROW_NUMBER() OVER () AS n FROM " + dir +
+		" WHERE file IS reg ORDER BY size"
+
+	table, err := evaluateArrow(context.Background(), input)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	defer table.Release()
+
+	type Field struct {
+		name     string
+		id       arrow.Type
+		nullable bool
+	}
+	expected := []Field{
+		{"name", arrow.STRING, false},
+		{"size", arrow.INT64, false},
+		{"ext", arrow.STRING, true},
+		{"time", arrow.TIMESTAMP, false},
+		{"n", arrow.INT64, false},
+	}
+	fields := table.Schema().Fields()
+	if len(fields) != len(expected) {
+		t.Fatalf("\nExpected %d fields\n     Got %v", len(expected), fields)
+	}
+	for i, f := range fields {
+		if f.Name != expected[i].name || f.Type.ID() != expected[i].id || f.Nullable != expected[i].nullable {
+			t.Fatalf("\nExpected %+v\n     Got %v", expected[i], f)
+		}
+	}
+
+	if table.NumRows() != 2 {
+		t.Fatalf("\nExpected 2 rows\n     Got %d", table.NumRows())
+	}
+	sizes := table.Column(1).Data().Chunk(0).(*array.Int64)
+	if sizes.Value(0) != 1 || sizes.Value(1) != 3 {
+		t.Fatalf("\nExpected sizes [1 3]\n     Got %v", sizes)
+	}
+	if exts := table.Column(2).Data().Chunk(0); !exts.IsNull(0) || exts.IsNull(1) {
+		t.Fatalf("\nExpected ext [null .go]\n     Got %v", exts)
+	}
+
+	empty, err := evaluateArrow(context.Background(), "SELECT name FROM "+dir+" WHERE size > 1gb")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	defer empty.Release()
+	if empty == nil || empty.NumRows() != 0 || empty.NumCols() != 1 {
+		t.Fatalf("\nExpected an empty table with 1 column\n     Got %v", empty)
+	}
+
+	// The IPC file must be readable with the same schema and rows.
+	path := filepath.Join(t.TempDir(), "files.arrow")
+	if _, err := runLines(input+" INTO '"+path+"' FORMAT ARROW", &options{}); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !r.Schema().Equal(table.Schema()) || r.NumRecords() != 1 {
+		t.Fatalf("\nExpected 1 record of %v\n     Got %d of %v", table.Schema(), r.NumRecords(), r.Schema())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// An assertionError is the failure of an ASSERT statement. The failures of a
+// query file's assertions are collected, rather than stopping at the first.
+type assertionError struct {
+	message string
+}
+
+func (e *assertionError) Error() string {
+	return "assertion failed: " + e.message
+}
+
+// The symbol of each of the comparators which an ASSERT statement may use.
+var comparatorSymbols = map[query.TokenType]string{
+	query.Equals:            "=",
+	query.NotEquals:         "<>",
+	query.GreaterThan:       ">",
+	query.GreaterThanEquals: ">=",
+	query.LessThan:          "<",
+	query.LessThanEquals:    "<=",
+}
+
+// Evaluate the subquery of the assertion, and return an *assertionError
+// unless its comparison is true.
+func runAssert(a *query.Assertion, qopts query.QueryOptions, variables map[string]string) error {
+	ok, actual, err := evaluateComparison(a, qopts, variables)
+	if err != nil || ok {
+		return err
+	}
+
+	if a.Message != "" {
+		return &assertionError{message: a.Message}
+	}
+	return &assertionError{message: fmt.Sprintf("expected %s %s, got %q",
+		comparatorSymbols[a.Comparator], a.Value, actual)}
+}
+
+// Evaluate the subquery of the comparison (of an ASSERT or IF statement), and
+// return whether the single column of its first result (as it's shown by the
+// default format, or empty if there are no results) satisfies it, along with
+// the column's value. The values are compared as numbers if they both are,
+// and as strings otherwise.
+func evaluateComparison(a *query.Assertion, qopts query.QueryOptions,
+	variables map[string]string) (bool, string, error) {
+	unbind, err := bindVariables(a.Query, variables)
+	if err != nil {
+		return false, "", err
+	}
+	defer unbind()
+	if err := checkSources(a.Query, qopts); err != nil {
+		return false, "", err
+	}
+
+	results, err := evaluateWith(context.Background(), a.Query, qopts, nil)
+	if err != nil {
+		return false, "", err
+	}
+	actual := ""
+	if len(results) > 0 {
+		actual = formatValue(results[0].column(0, a.Query.Columns[0]))
+	}
+
+	var order int
+	x, errX := strconv.ParseFloat(actual, 64)
+	y, errY := strconv.ParseFloat(a.Value, 64)
+	if errX == nil && errY == nil {
+		switch {
+		case x < y:
+			order = -1
+		case x > y:
+			order = 1
+		}
+	} else {
+		order = strings.Compare(actual, a.Value)
+	}
+
+	var ok bool
+	switch a.Comparator {
+	case query.Equals:
+		ok = order == 0
+	case query.NotEquals:
+		ok = order != 0
+	case query.GreaterThan:
+		ok = order > 0
+	case query.GreaterThanEquals:
+		ok = order >= 0
+	case query.LessThan:
+		ok = order < 0
+	case query.LessThanEquals:
+		ok = order <= 0
+	}
+	return ok, actual, nil
+}
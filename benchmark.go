@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Run the query n times and write how long each evaluation took to w, as a
+// line of Go benchmark output (so that it may be compared with benchstat).
+// The first run only warms up the filesystem cache, so it isn't timed.
+func runBenchmark(q *query.Query, qopts query.QueryOptions, n int, w io.Writer) error {
+	if n < 2 {
+		return errors.New("-benchmark requires at least 2 runs (the first is a warm-up)")
+	}
+
+	if _, err := evaluateWith(context.Background(), q, qopts, nil); err != nil {
+		return err
+	}
+
+	durations := make([]time.Duration, 0, n-1)
+	for i := 1; i < n; i++ {
+		start := time.Now()
+		evaluateWith(context.Background(), q, qopts, nil)
+		durations = append(durations, time.Since(start))
+	}
+
+	_, err := fmt.Fprintln(w, formatBenchmark(durations))
+	return err
+}
+
+// Format the durations of each run as a Go benchmark line, with the mean, min,
+// max, and 99th percentile durations.
+func formatBenchmark(durations []time.Duration) string {
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	mean := total / time.Duration(len(sorted))
+	p99 := sorted[(len(sorted)*99+99)/100-1]
+
+	return fmt.Sprintf("BenchmarkFSQL\t%d\t%d ns/op\t%d min-ns/op\t%d max-ns/op\t%d p99-ns/op",
+		len(sorted), mean.Nanoseconds(), sorted[0].Nanoseconds(),
+		sorted[len(sorted)-1].Nanoseconds(), p99.Nanoseconds())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return the default directory of the result cache, $XDG_CACHE_HOME/fsql (or
+// the platform's equivalent), or "" if there isn't one.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fsql")
+}
+
+// A resultCache stores the output of queries in the files of dir, each of
+// which expires ttl after it's written. The name of each file is its key, the
+// hash of the query, the options which alter its output, and the tag of its
+// sources (see writeSourcesTag), so that the output is no longer read from
+// the cache once one of the sources changes.
+type resultCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// Return the key of the output of the query in the format, or false if the
+// query's output can't be cached.
+func (c *resultCache) key(q *query.Query, format string, opts *options) (string, bool) {
+	encoded, err := json.Marshal(q)
+	if err != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write(encoded)
+	fmt.Fprintf(h, "\x00%s\x00%t\x00%q\x00%t\x00%t\x00%t\x00%d\x00", format, opts.count, opts.title, opts.noStyle,
+		opts.noCrossDevice, opts.ascii, opts.maxFileSize)
+	if !writeSourcesTag(h, q, map[string]bool{}) {
+		return "", false
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// Return the cached output of the key, or false if it isn't cached or has
+// expired.
+func (c *resultCache) get(key string) ([]byte, bool) {
+	path := filepath.Join(c.dir, key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return nil, false
+	}
+
+	output, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return output, true
+}
+
+// Cache the output of the key. It's written to a temporary file first, so
+// that a concurrent get never reads part of it.
+func (c *resultCache) put(key string, output []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(c.dir, key+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(output); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(c.dir, key))
+}
+
+// Cache the output of the key (like put), warning on errw if it can't be.
+func (c *resultCache) save(key string, output []byte, errw io.Writer) {
+	if err := c.put(key, output); err != nil {
+		fmt.Fprintf(errw, "warning: could not cache the output: %v\n", err)
+	}
+}
+
+// Write the tag of the query's sources to w, which changes (like an ETag) when
+// the files of one of the sources may have. Rather than walking each source,
+// it's made of the modification times of the source and of the directories
+// directly inside it, which change when a file is added to, removed from, or
+// renamed in one of its top two levels. It returns false if one of the
+// sources can't be tagged: a remote source, or one with a TABLESAMPLE clause
+// (whose results differ each time it's evaluated). Each source is written as
+// its absolute path, so that a relative source run from two directories has
+// two tags. The names of the CTEs defined so far are in ctes, since they
+// aren't directories.
+func writeSourcesTag(w io.Writer, q *query.Query, ctes map[string]bool) bool {
+	if q.Sample != nil {
+		return false
+	}
+
+	for _, cte := range q.With {
+		if !writeSourcesTag(w, cte.Query, ctes) {
+			return false
+		}
+		ctes[cte.Name] = true
+	}
+
+	if q.Join != nil {
+		for _, subquery := range []*query.Query{q.Join.Lateral, q.Join.Subquery} {
+			if subquery != nil && !writeSourcesTag(w, subquery, ctes) {
+				return false
+			}
+		}
+	}
+
+	for _, src := range q.Sources["include"] {
+		if ctes[src] || q.Values[src] != nil {
+			continue
+		}
+		switch sourceScheme(src) {
+		case "":
+		case "series":
+			continue
+		default:
+			return false
+		}
+
+		abs, err := filepath.Abs(src)
+		if err != nil {
+			return false
+		}
+		info, err := os.Stat(src)
+		if err != nil {
+			fmt.Fprintf(w, "%s\x00\x00", abs)
+			continue
+		}
+		fmt.Fprintf(w, "%s\x00%d\x00", abs, info.ModTime().UnixNano())
+		if !info.IsDir() {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(src)
+		if err != nil {
+			return false
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				fmt.Fprintf(w, "%s\x00%d\x00", entry.Name(), entry.ModTime().UnixNano())
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return the value of the CASE expression for the file at path (with info):
+// the result of the first of its branches whose condition the file satisfies
+// (evaluated with compareFn), otherwise that of ELSE, or nil (NULL) if it has
+// no ELSE.
+func evaluateCase(expr *query.CaseExpression, path string, info os.FileInfo,
+	compareFn func(query.Condition, string, os.FileInfo) bool) interface{} {
+	chosen := expr.Else
+	for i, branch := range expr.Branches {
+		ok := branch.When.Evaluate(info, func(c query.Condition, info os.FileInfo) bool {
+			return compareFn(c, path, info)
+		})
+		if ok {
+			chosen = &expr.Branches[i].Then
+			break
+		}
+	}
+
+	switch {
+	case chosen == nil || chosen.Null:
+		return nil
+	case chosen.Case != nil:
+		return evaluateCase(chosen.Case, path, info, compareFn)
+	case chosen.Attribute != "":
+		return fileResult(path, info).value(chosen.Attribute)
+	}
+	return chosen.Value
+}
+
+// Return the result of the file at path (with info), whose attributes a
+// condition's expression is evaluated with.
+func fileResult(path string, info os.FileInfo) result {
+	// The values of some attributes depend on the type of the file's
+	// information, which a lazyFileInfo wraps.
+	file := info
+	if l, ok := info.(*lazyFileInfo); ok {
+		file = l.FileInfo
+	}
+	return result{path: path, info: file, depth: int(cmp.Depth(info))}
+}
+
+// Return the type of the CASE expression's values: that of an attribute if
+// each of its results which isn't NULL is the attribute's value (e.g. for
+// NULLIF), otherwise text. Either is nullable.
+func caseType(expr *query.CaseExpression) attributeType {
+	attributes := make(map[string]bool)
+	values := false
+	var add func(expr *query.CaseExpression)
+	addResult := func(r *query.CaseResult) {
+		switch {
+		case r == nil || r.Null:
+		case r.Case != nil:
+			add(r.Case)
+		case r.Attribute != "":
+			attributes[r.Attribute] = true
+		default:
+			values = true
+		}
+	}
+	add = func(expr *query.CaseExpression) {
+		for i := range expr.Branches {
+			addResult(&expr.Branches[i].Then)
+		}
+		addResult(expr.Else)
+	}
+	add(expr)
+
+	t := attributeType{jsonType: "string"}
+	if len(attributes) == 1 && !values {
+		for attribute := range attributes {
+			t = columnType(query.Column{Attribute: attribute})
+		}
+	}
+	t.nullable = true
+	return t
+}
+
+// Compute the value of each of the CASE and arithmetic expression columns for
+// the result, whose file has info.
+func computeExpressions(columns []query.Column, r *result, info os.FileInfo,
+	compareFn func(query.Condition, string, os.FileInfo) bool) {
+	for i, c := range columns {
+		if c.Case == nil && c.Expression == nil {
+			continue
+		}
+		if r.computed == nil {
+			r.computed = make([]interface{}, len(columns))
+		}
+		if c.Case != nil {
+			r.computed[i] = evaluateCase(c.Case, r.path, info, compareFn)
+		} else {
+			r.computed[i] = evaluateExpression(c.Expression, r.value)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Write each value of each result on a single line, separated by spaces, and
+// quoted for a POSIX shell (see shellQuote), so that the output may be part
+// of a command, e.g. eval "vim $(fsql 'SELECT name ... COMPACT')". Nothing is
+// written if there are no results.
+func formatCompact(w io.Writer, columns []query.Column, results []result) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	words := make([]string, 0, len(results)*len(columns))
+	for _, r := range results {
+		for i, c := range columns {
+			words = append(words, shellQuote(formatValue(r.column(i, c))))
+		}
+	}
+	_, err := fmt.Fprintln(w, strings.Join(words, " "))
+	return err
+}
+
+// Return the value as a single word of a POSIX shell: as it is, if each of
+// its characters is one which the shell never treats specially, otherwise in
+// single quotes, which keep each character but ' itself (which closes the
+// quotes, so it's written as ' \' and ' without the spaces).
+func shellQuote(value string) string {
+	if value != "" && strings.Trim(value, shellSafe) == "" {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// The characters which a POSIX shell never treats specially in a word.
+const shellSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-"
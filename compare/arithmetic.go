@@ -0,0 +1,185 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// ErrOverflow is returned (wrapped, along with the expression) when an integer
+// expression's value doesn't fit in an int64, rather than wrapping around.
+var ErrOverflow = errors.New("integer overflow")
+
+// Evaluate returns the value of the arithmetic expression, whose attributes
+// have the values returned by value: an int64 if each of its operands is an
+// integer (so that e.g. size / 3 is truncated), a float64 otherwise, or nil
+// (NULL) if one of its operands is NULL, it divides by zero, or it shifts by a
+// negative number of bits. An error wrapping ErrOverflow is returned if an
+// integer (or the result of +, -, *, or / of integers) is out of range.
+func Evaluate(e *query.Expression, value func(attribute string) interface{}) (interface{}, error) {
+	if e.Operator == query.Unknown {
+		if e.Attribute != "" {
+			if mode, ok := value(e.Attribute).(os.FileMode); ok {
+				return int64(mode), nil
+			}
+			return value(e.Attribute), nil
+		}
+		return literal(e.Value)
+	}
+
+	// The negation of an integer literal is parsed along with it, since the
+	// smallest int64 is only in range when it's negated.
+	if e.Left == nil && e.Operator == query.Minus && e.Right.Operator == query.Unknown && e.Right.Attribute == "" {
+		if n, err := strconv.ParseInt("-"+e.Right.Value, 0, 64); err == nil {
+			return n, nil
+		}
+	}
+
+	b, err := Evaluate(e.Right, value)
+	if err != nil {
+		return nil, err
+	}
+	if e.Left == nil {
+		switch b := b.(type) {
+		case int64:
+			if e.Operator == query.Tilde {
+				return ^b, nil
+			}
+			if b == math.MinInt64 {
+				return nil, fmt.Errorf("%w in %s", ErrOverflow, e)
+			}
+			return -b, nil
+		case float64:
+			return -b, nil
+		}
+		return nil, nil
+	}
+
+	a, err := Evaluate(e.Left, value)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := arithmetic(e.Operator, a, b)
+	if !ok {
+		return nil, fmt.Errorf("%w in %s", ErrOverflow, e)
+	}
+	return v, nil
+}
+
+// Return the value of a number in an expression: an int64 if it's an integer
+// (which may be octal or hexadecimal, e.g. 0644), and a float64 otherwise.
+func literal(s string) (interface{}, error) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err == nil {
+		return n, nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("%w: %s", ErrOverflow, s)
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f, nil
+}
+
+// Return the result of the arithmetic operator applied to a and b, which are
+// int64s or float64s (see Evaluate), and false if it's an integer which
+// overflows. The operands of the bitwise operators are always int64s, and a
+// shift drops the bits shifted out, so they never overflow.
+func arithmetic(op query.TokenType, a, b interface{}) (interface{}, bool) {
+	if a == nil || b == nil {
+		return nil, true
+	}
+
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		switch op {
+		case query.Plus:
+			if y > 0 && x > math.MaxInt64-y || y < 0 && x < math.MinInt64-y {
+				return nil, false
+			}
+			return x + y, true
+		case query.Minus:
+			if y < 0 && x > math.MaxInt64+y || y > 0 && x < math.MinInt64+y {
+				return nil, false
+			}
+			return x - y, true
+		case query.Star:
+			if x != 0 && ((x*y)/x != y || x == -1 && y == math.MinInt64) {
+				return nil, false
+			}
+			return x * y, true
+		case query.Ampersand:
+			return x & y, true
+		case query.Pipe:
+			return x | y, true
+		case query.Caret:
+			return x ^ y, true
+		}
+		if op == query.ShiftLeft || op == query.ShiftRight {
+			if y < 0 {
+				return nil, true
+			}
+			if op == query.ShiftLeft {
+				return x << uint64(y), true
+			}
+			return x >> uint64(y), true
+		}
+		if y == 0 {
+			return nil, true
+		}
+		if op == query.Slash {
+			if x == math.MinInt64 && y == -1 {
+				return nil, false
+			}
+			return x / y, true
+		}
+		return x % y, true
+	}
+
+	f, g := toFloat(a), toFloat(b)
+	switch op {
+	case query.Plus:
+		return f + g, true
+	case query.Minus:
+		return f - g, true
+	case query.Star:
+		return f * g, true
+	}
+	if g == 0 {
+		return nil, true
+	}
+	if op == query.Slash {
+		return f / g, true
+	}
+	return math.Mod(f, g), true
+}
+
+// Return the int64 or float64 v as a float64.
+func toFloat(v interface{}) float64 {
+	if n, ok := v.(int64); ok {
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+// Expression compares the value v of an arithmetic expression (see Evaluate)
+// with the condition's value. An integer is compared with an integer value
+// like the expression's own (which may be octal or hexadecimal, e.g. 0644),
+// and otherwise with Value.
+func Expression(condition query.Condition, v interface{}) bool {
+	n, ok := v.(int64)
+	value, err := strconv.ParseInt(condition.Value, 0, 64)
+	if !ok || err != nil || condition.Comparator == query.Is {
+		return Value(condition, v)
+	}
+
+	retval := Numeric(condition.Comparator, n, value)
+	if condition.Negate {
+		return !retval
+	}
+	return retval
+}
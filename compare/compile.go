@@ -0,0 +1,371 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kshvmdn/fsql/fsqlplugin"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A predicate reports whether a file satisfies a condition.
+type predicate func(path string, info os.FileInfo) bool
+
+// A valuer returns the value of an expression (e.g. a CASE expression) for a
+// file, nil for NULL.
+type valuer func(path string, info os.FileInfo) interface{}
+
+// ErrUnsupported is returned by Compile when the WHERE clause has a condition
+// which can only be evaluated along with the rest of the query, so callers
+// must evaluate the condition tree instead.
+var ErrUnsupported = errors.New("condition can't be compiled")
+
+// Compile compiles the WHERE clause of the query into a function which reports
+// whether a file satisfies it. Each condition's value is parsed (and each
+// regular expression compiled) once, rather than for every file, so the
+// function is much faster than evaluating the condition tree when it's called
+// repeatedly. The query's case_sensitive pragma is respected. If one of the
+// conditions can't be compiled, or the query has a clause which also decides
+// which files are its results (a JOIN, which EXISTS is rewritten to, a CTE,
+// HAVING, or QUALIFY), the error is ErrUnsupported.
+func Compile(input string) (func(path string, info os.FileInfo) bool, error) {
+	q, err := query.RunParser(input)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, _, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case q.Join != nil:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, q.Join)
+	case len(q.With) > 0:
+		return nil, fmt.Errorf("%w: WITH", ErrUnsupported)
+	case q.Having != nil:
+		return nil, fmt.Errorf("%w: HAVING", ErrUnsupported)
+	case q.Qualify != nil:
+		return nil, fmt.Errorf("%w: QUALIFY", ErrUnsupported)
+	}
+
+	fn, err := compileNode(q.ConditionTree, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return fn, nil
+}
+
+// Compile the condition tree rooted at root, like ConditionNode.Evaluate.
+func compileNode(root *query.ConditionNode, opts query.QueryOptions) (predicate, error) {
+	if root == nil {
+		return func(string, os.FileInfo) bool { return true }, nil
+	}
+
+	if root.Condition != nil {
+		return compileCondition(*root.Condition, opts)
+	}
+
+	left, err := compileNode(root.Left, opts)
+	if err != nil {
+		return nil, err
+	}
+	right, err := compileNode(root.Right, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root.Type {
+	case query.And:
+		return func(path string, info os.FileInfo) bool {
+			return left(path, info) && right(path, info)
+		}, nil
+	case query.Or:
+		return func(path string, info os.FileInfo) bool {
+			return left(path, info) || right(path, info)
+		}, nil
+	}
+
+	return func(string, os.FileInfo) bool { return false }, nil
+}
+
+// Compile a single condition. Conditions with a size or time value which
+// can't be parsed are never satisfied, even when negated.
+func compileCondition(condition query.Condition, opts query.QueryOptions) (predicate, error) {
+	var fn predicate
+	never := func(string, os.FileInfo) bool { return false }
+
+	// The values of a comparison with ALL or ANY are those of its subquery,
+	// which has to be evaluated first.
+	if condition.Subquery != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, condition.Quantifier)
+	}
+
+	// The value of a CASE expression may be NULL, which Value handles (along
+	// with the condition's negation).
+	if condition.Case != nil {
+		value, err := compileCase(condition.Case, opts)
+		if err != nil {
+			return nil, err
+		}
+		return func(path string, info os.FileInfo) bool {
+			return Value(condition, value(path, info))
+		}, nil
+	}
+
+	// So may the value of an arithmetic expression, which Expression handles.
+	// The function can't return an error, so an expression which overflows
+	// (see ErrOverflow) doesn't satisfy the condition, even when negated.
+	if condition.Expression != nil {
+		value, err := compileExpression(condition.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return func(path string, info os.FileInfo) bool {
+			v, err := value(path, info)
+			return err == nil && Expression(condition, v)
+		}, nil
+	}
+
+	// Functions may be NULL, so the condition's negation is handled by
+	// Nullable.
+	if IsFunction(condition) {
+		return func(path string, info os.FileInfo) bool {
+			v, ok := Function(condition, path)
+			return Nullable(condition, v, ok)
+		}, nil
+	}
+
+	switch condition.Attribute {
+	case "name":
+		var err error
+		if fn, err = compileName(condition.Comparator, condition.Value, opts); err != nil {
+			return nil, err
+		}
+
+	case "size":
+		size, err := ParseSize(condition.Value)
+		if err != nil {
+			return never, nil
+		}
+		fn = func(path string, info os.FileInfo) bool {
+			return Numeric(condition.Comparator, info.Size(), size)
+		}
+
+	case "time":
+		t, err := ParseTime(condition.Value)
+		if err != nil {
+			return never, nil
+		}
+		fn = func(path string, info os.FileInfo) bool {
+			return Time(condition.Comparator, info.ModTime(), t)
+		}
+
+	case "file":
+		fn = func(path string, info os.FileInfo) bool {
+			return File(condition.Comparator, info, condition.Value)
+		}
+
+	case "dir":
+		fn = func(path string, info os.FileInfo) bool {
+			return Alpha(condition.Comparator, filepath.Dir(path), condition.Value)
+		}
+
+	case "depth":
+		depth, err := strconv.ParseInt(condition.Value, 10, 64)
+		if err != nil {
+			return never, nil
+		}
+		fn = func(path string, info os.FileInfo) bool {
+			return Numeric(condition.Comparator, Depth(info), depth)
+		}
+
+	case "contains_text":
+		// A file whose contents are skipped satisfies neither the condition
+		// nor its negation (like NULL).
+		return func(path string, info os.FileInfo) bool {
+			contains, ok := ContainsText(path, info, condition.Argument, opts.CaseInsensitive)
+			return ok && contains != condition.Negate
+		}, nil
+
+	default:
+		attr, ok := fsqlplugin.LookupAttribute(condition.Attribute)
+		if !ok {
+			fn = never
+			break
+		}
+		fn = func(path string, info os.FileInfo) bool {
+			return Alpha(condition.Comparator, attr.Value(path, info), condition.Value)
+		}
+	}
+
+	if condition.Negate {
+		return func(path string, info os.FileInfo) bool { return !fn(path, info) }, nil
+	}
+	return fn, nil
+}
+
+// Compile the CASE expression into a function which returns its value for a
+// file: the result of the first of its branches whose condition the file
+// satisfies, otherwise that of ELSE, or nil (NULL) if it has no ELSE.
+func compileCase(expr *query.CaseExpression, opts query.QueryOptions) (valuer, error) {
+	whens := make([]predicate, len(expr.Branches))
+	thens := make([]valuer, len(expr.Branches))
+	for i, branch := range expr.Branches {
+		var err error
+		if whens[i], err = compileNode(branch.When, opts); err != nil {
+			return nil, err
+		}
+		if thens[i], err = compileCaseResult(&expr.Branches[i].Then, opts); err != nil {
+			return nil, err
+		}
+	}
+	otherwise, err := compileCaseResult(expr.Else, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(path string, info os.FileInfo) interface{} {
+		for i, when := range whens {
+			if when(path, info) {
+				return thens[i](path, info)
+			}
+		}
+		return otherwise(path, info)
+	}, nil
+}
+
+// Compile the result of a branch of a CASE expression (nil for NULL).
+func compileCaseResult(r *query.CaseResult, opts query.QueryOptions) (valuer, error) {
+	switch {
+	case r == nil || r.Null:
+		return func(string, os.FileInfo) interface{} { return nil }, nil
+	case r.Case != nil:
+		return compileCase(r.Case, opts)
+	case r.Attribute != "":
+		return compileAttribute(r.Attribute)
+	}
+	return func(string, os.FileInfo) interface{} { return r.Value }, nil
+}
+
+// Compile the arithmetic expression into a function which returns its value
+// for a file, or an error if it overflows (see Evaluate).
+func compileExpression(e *query.Expression) (func(path string, info os.FileInfo) (interface{}, error), error) {
+	// The value of each of the expression's attributes, by attribute.
+	values := make(map[string]valuer)
+	var add func(e *query.Expression) error
+	add = func(e *query.Expression) error {
+		if e == nil {
+			return nil
+		}
+		if e.Attribute != "" && values[e.Attribute] == nil {
+			value, err := compileAttribute(e.Attribute)
+			if err != nil {
+				return err
+			}
+			values[e.Attribute] = value
+		}
+		if err := add(e.Left); err != nil {
+			return err
+		}
+		return add(e.Right)
+	}
+	if err := add(e); err != nil {
+		return nil, err
+	}
+
+	return func(path string, info os.FileInfo) (interface{}, error) {
+		return Evaluate(e, func(attribute string) interface{} {
+			return values[attribute](path, info)
+		})
+	}, nil
+}
+
+// Compile the value of the attribute for a file, which is the same as its
+// value in a result (so a name is the file's path).
+func compileAttribute(attribute string) (valuer, error) {
+	switch attribute {
+	case "mode":
+		return func(path string, info os.FileInfo) interface{} { return info.Mode() }, nil
+	case "size":
+		return func(path string, info os.FileInfo) interface{} { return info.Size() }, nil
+	case "time":
+		return func(path string, info os.FileInfo) interface{} { return info.ModTime() }, nil
+	case "dir":
+		return func(path string, info os.FileInfo) interface{} { return filepath.Dir(path) }, nil
+	case "depth":
+		return func(path string, info os.FileInfo) interface{} { return Depth(info) }, nil
+	case "ext":
+		return func(path string, info os.FileInfo) interface{} { return filepath.Ext(info.Name()) }, nil
+	case "name":
+		return func(path string, info os.FileInfo) interface{} { return path }, nil
+	}
+
+	// The other attributes (e.g. tar_offset) depend on the filesystem the
+	// file was found in.
+	attr, ok := fsqlplugin.LookupAttribute(attribute)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, attribute)
+	}
+	return func(path string, info os.FileInfo) interface{} { return attr.Value(path, info) }, nil
+}
+
+// Compile a comparison of each file's name with value, like Alpha.
+func compileName(comp query.TokenType, value string, opts query.QueryOptions) (predicate, error) {
+	name := func(info os.FileInfo) string { return info.Name() }
+	if opts.CaseInsensitive && comp != query.RLike {
+		value = strings.ToLower(value)
+		name = func(info os.FileInfo) string { return strings.ToLower(info.Name()) }
+	}
+
+	var match func(string) bool
+	switch comp {
+	case query.Equals:
+		match = func(a string) bool { return a == value }
+	case query.NotEquals:
+		match = func(a string) bool { return a != value }
+	case query.Like:
+		match = compileLike(value)
+	case query.RLike:
+		if opts.CaseInsensitive {
+			value = "(?i)" + value
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	case query.Contains:
+		match = func(a string) bool { return strings.Contains(a, value) }
+	case query.BeginsWith:
+		match = func(a string) bool { return strings.HasPrefix(a, value) }
+	default:
+		match = func(string) bool { return false }
+	}
+
+	return func(path string, info os.FileInfo) bool { return match(name(info)) }, nil
+}
+
+// Compile a LIKE pattern, in which a leading or trailing % matches any prefix
+// or suffix.
+func compileLike(b string) func(string) bool {
+	switch {
+	case len(b) > 1 && b[0] == '%' && b[len(b)-1] == '%':
+		sub := b[1 : len(b)-1]
+		return func(a string) bool { return strings.Contains(a, sub) }
+	case len(b) > 0 && b[0] == '%':
+		suffix := b[1:]
+		return func(a string) bool { return strings.HasSuffix(a, suffix) }
+	case len(b) > 0 && b[len(b)-1] == '%':
+		prefix := b[:len(b)-1]
+		return func(a string) bool { return strings.HasPrefix(a, prefix) }
+	}
+	return func(a string) bool { return strings.Contains(a, b) }
+}
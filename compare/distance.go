@@ -0,0 +1,59 @@
+package compare
+
+import "unicode/utf8"
+
+// Levenshtein returns the Levenshtein distance between a and b: the fewest
+// insertions, deletions, and substitutions of a rune which change one into
+// the other, e.g. 3 from kitten to sitting. It's computed by the
+// Wagner-Fischer algorithm, in O(mn) time, keeping only a row of the distances
+// between prefixes (of the shorter string), so in O(min(m, n)) space.
+func Levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	if len(s) < len(t) {
+		s, t = t, s
+	}
+
+	// row[j] is the distance between the prefix of s read so far and the
+	// first j runes of t.
+	row := make([]int, len(t)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(t); j++ {
+			substitution := diagonal
+			if s[i-1] != t[j-1] {
+				substitution++
+			}
+			diagonal = row[j]
+			row[j] = min3(row[j]+1, row[j-1]+1, substitution)
+		}
+	}
+	return row[len(t)]
+}
+
+// LevenshteinRatio returns the Levenshtein distance between a and b,
+// normalized to [0, 1] by the length (in runes) of the longer of them: 0 if
+// they're equal, and 1 if they have nothing in common.
+func LevenshteinRatio(a, b string) float64 {
+	n := utf8.RuneCountInString(a)
+	if m := utf8.RuneCountInString(b); m > n {
+		n = m
+	}
+	if n == 0 {
+		return 0
+	}
+	return float64(Levenshtein(a, b)) / float64(n)
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
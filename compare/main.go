@@ -1,8 +1,10 @@
 package compare
 
 import (
+	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,6 +34,10 @@ func Alpha(comp query.TokenType, a, b string) bool {
 		return strings.Contains(a, b)
 	case query.RLike:
 		return regexp.MustCompile(b).MatchString(a)
+	case query.Contains:
+		return strings.Contains(a, b)
+	case query.BeginsWith:
+		return strings.HasPrefix(a, b)
 	}
 	return false
 }
@@ -55,6 +61,25 @@ func Numeric(comp query.TokenType, a, b int64) bool {
 	return false
 }
 
+// Float compares two numbers a and b.
+func Float(comp query.TokenType, a, b float64) bool {
+	switch comp {
+	case query.Equals:
+		return a == b
+	case query.NotEquals:
+		return a != b
+	case query.GreaterThanEquals:
+		return a >= b
+	case query.GreaterThan:
+		return a > b
+	case query.LessThanEquals:
+		return a <= b
+	case query.LessThan:
+		return a < b
+	}
+	return false
+}
+
 // Time compares two times a and b.
 func Time(comp query.TokenType, a, b time.Time) bool {
 	switch comp {
@@ -74,6 +99,63 @@ func Time(comp query.TokenType, a, b time.Time) bool {
 	return false
 }
 
+// Value compares a computed value v (e.g. of a CASE expression) with the
+// condition's value: as numbers if v is a number (whose value may also be a
+// size), as times if it's a time, and as strings otherwise. NULL is only
+// satisfied by IS NULL (see Nullable).
+func Value(condition query.Condition, v interface{}) bool {
+	if v == nil || condition.Comparator == query.Is {
+		return Nullable(condition, fmt.Sprint(v), v != nil)
+	}
+
+	var retval bool
+	switch v := v.(type) {
+	case int, int64, float64:
+		// A number may also be compared with a size (e.g. 1mb).
+		b, err := strconv.ParseFloat(condition.Value, 64)
+		if err != nil {
+			size, err := ParseSize(condition.Value)
+			if err != nil {
+				return false
+			}
+			b = float64(size)
+		}
+		a, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
+		retval = Float(condition.Comparator, a, b)
+
+	case time.Time:
+		t, err := ParseTime(condition.Value)
+		if err != nil {
+			return false
+		}
+		retval = Time(condition.Comparator, v, t)
+
+	default:
+		retval = Alpha(condition.Comparator, fmt.Sprint(v), condition.Value)
+	}
+
+	if condition.Negate {
+		return !retval
+	}
+	return retval
+}
+
+// A DepthInfo is the information of a file which also has its depth: how many
+// levels it is below the source it was found in (0 for the source itself).
+type DepthInfo interface {
+	os.FileInfo
+	Depth() int
+}
+
+// Depth returns the depth of the file (see DepthInfo), or 0 if its depth isn't
+// known.
+func Depth(info os.FileInfo) int64 {
+	if d, ok := info.(DepthInfo); ok {
+		return int64(d.Depth())
+	}
+	return 0
+}
+
 // File compares the file type of the provided file with fileType.
 func File(comp query.TokenType, file os.FileInfo, fileType string) bool {
 	switch comp {
@@ -87,3 +169,43 @@ func File(comp query.TokenType, file os.FileInfo, fileType string) bool {
 	}
 	return false
 }
+
+const (
+	uBYTE     = 1.0
+	uKILOBYTE = 1024 * uBYTE
+	uMEGABYTE = 1024 * uKILOBYTE
+	uGIGABYTE = 1024 * uMEGABYTE
+)
+
+// ParseSize parses a size value in bytes, or in kilobytes / megabytes /
+// gigabytes when it ends with kb / mb / gb (e.g. 100kb).
+func ParseSize(value string) (int64, error) {
+	mult := uBYTE
+
+	if len(value) > 2 {
+		unit := strings.ToLower(value[len(value)-2:])
+		switch unit {
+		case "kb":
+			mult = uKILOBYTE
+		case "mb":
+			mult = uMEGABYTE
+		case "gb":
+			mult = uGIGABYTE
+		}
+
+		if mult > 1 {
+			value = value[:len(value)-2]
+		}
+	}
+
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(size * mult), nil
+}
+
+// ParseTime parses a time value in the MMM DD YYYY HH MM format.
+func ParseTime(value string) (time.Time, error) {
+	return time.Parse(query.TimeLayout, value)
+}
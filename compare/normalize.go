@@ -0,0 +1,22 @@
+package compare
+
+// The function which converts a string to each Unicode normalization form (by
+// its name, e.g. NFC). They're only registered when built with -tags norm (see
+// normalize_norm.go), since they require golang.org/x/text.
+var normalizers = map[string]func(string) string{}
+
+// Normalize returns s in the Unicode normalization form (NFC, NFD, NFKC, or
+// NFKD), and false if it can't be converted to it.
+func Normalize(s, form string) (string, bool) {
+	normalize, ok := normalizers[form]
+	if !ok {
+		return "", false
+	}
+	return normalize(s), true
+}
+
+// CanNormalize returns true iff strings can be converted to the Unicode
+// normalization forms, which requires building with -tags norm.
+func CanNormalize() bool {
+	return len(normalizers) > 0
+}
@@ -0,0 +1,12 @@
+//go:build norm
+
+package compare
+
+import "golang.org/x/text/unicode/norm"
+
+func init() {
+	normalizers["NFC"] = norm.NFC.String
+	normalizers["NFD"] = norm.NFD.String
+	normalizers["NFKC"] = norm.NFKC.String
+	normalizers["NFKD"] = norm.NFKD.String
+}
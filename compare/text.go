@@ -0,0 +1,62 @@
+package compare
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+)
+
+// The number of bytes at the start of a file which IsText checks.
+const textSniffLen = 8000
+
+// IsText returns true iff contents are text rather than binary, i.e. there's
+// no NUL byte in their first 8000 bytes (like git's heuristic).
+func IsText(contents []byte) bool {
+	if len(contents) > textSniffLen {
+		contents = contents[:textSniffLen]
+	}
+	return bytes.IndexByte(contents, 0) < 0
+}
+
+// ErrSkipped is the error of the contents of a file which aren't read (e.g.
+// since it's larger than -max-file-size).
+var ErrSkipped = errors.New("the file's contents aren't read")
+
+// A ContentsInfo is the information of a file which also has the file's
+// contents (e.g. read once, when they're first needed), which ContainsText
+// uses rather than reading the file itself.
+type ContentsInfo interface {
+	os.FileInfo
+	Contents() ([]byte, error)
+}
+
+// ContainsText returns true iff the file at path is a text file whose contents
+// contain text (ignoring case if fold is set). Files which aren't regular, or
+// which are binary (see IsText), never contain any text. It returns false for
+// ok if the file's contents are skipped (see ErrSkipped), in which case it's
+// NULL.
+func ContainsText(path string, info os.FileInfo, text string, fold bool) (contains, ok bool) {
+	if info == nil || !info.Mode().IsRegular() {
+		return false, true
+	}
+
+	var contents []byte
+	var err error
+	if c, ok := info.(ContentsInfo); ok {
+		contents, err = c.Contents()
+	} else {
+		contents, err = ioutil.ReadFile(path)
+	}
+	if errors.Is(err, ErrSkipped) {
+		return false, false
+	}
+	if err != nil || !IsText(contents) {
+		return false, true
+	}
+
+	if fold {
+		return bytes.Contains(bytes.ToLower(contents), bytes.ToLower([]byte(text))), true
+	}
+	return bytes.Contains(contents, []byte(text)), true
+}
@@ -0,0 +1,111 @@
+package compare
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Xattr returns the value of the extended attribute key of the file at path,
+// and false if the file doesn't have it (i.e. it's NULL), which is always the
+// case on platforms without extended attributes.
+func Xattr(path, key string) (string, bool) {
+	return getxattr(path, key)
+}
+
+// XattrKeys returns the sorted, comma-separated keys of the extended
+// attributes of the file at path, and false if they can't be listed (i.e.
+// they're NULL).
+func XattrKeys(path string) (string, bool) {
+	buf, ok := listxattr(path)
+	if !ok {
+		return "", false
+	}
+
+	keys := strings.FieldsFunc(string(buf), func(r rune) bool { return r == 0 })
+	sort.Strings(keys)
+	return strings.Join(keys, ","), true
+}
+
+// Function returns the result of the function a condition compares for the
+// file at path, and false if it's NULL.
+func Function(condition query.Condition, path string) (string, bool) {
+	switch condition.Attribute {
+	case "xattr":
+		return Xattr(path, condition.Argument)
+	case "xattr_keys":
+		return XattrKeys(path)
+	case "soundex":
+		return query.SoundexCode(query.PhoneticName(filepath.Base(path))), true
+	case "metaphone":
+		return query.MetaphoneCode(query.PhoneticName(filepath.Base(path))), true
+	case "levenshtein":
+		return strconv.Itoa(Levenshtein(functionAttribute(condition.Argument, path), condition.Target)), true
+	case "levenshtein_ratio":
+		ratio := LevenshteinRatio(functionAttribute(condition.Argument, path), condition.Target)
+		return strconv.FormatFloat(ratio, 'f', -1, 64), true
+	case "normalize":
+		return Normalize(functionAttribute(condition.Argument, path), condition.Target)
+	}
+	return "", false
+}
+
+// Return the value of the attribute (name or dir) of the file at path which a
+// function of an attribute (e.g. LEVENSHTEIN) is computed from.
+func functionAttribute(attribute, path string) string {
+	if attribute == "dir" {
+		return filepath.Dir(path)
+	}
+	return filepath.Base(path)
+}
+
+// IsFunction returns true iff the condition compares the result of a function
+// rather than an attribute.
+func IsFunction(condition query.Condition) bool {
+	switch condition.Attribute {
+	case "xattr", "xattr_keys", "soundex", "metaphone", "levenshtein", "levenshtein_ratio", "normalize":
+		return true
+	}
+	return false
+}
+
+// Return true iff the condition compares the result of a function whose value
+// is a number, which is compared numerically.
+func isNumericFunction(condition query.Condition) bool {
+	return condition.Attribute == "levenshtein" || condition.Attribute == "levenshtein_ratio"
+}
+
+// Nullable compares a, which is NULL unless ok is set, with the condition's
+// value (like Alpha, or Float if it's the value of a numeric function). IS
+// NULL is only satisfied by NULL (and IS NOT NULL only by any other value),
+// any other comparison is never satisfied by NULL, even when negated. IS any
+// other value is the same as = for NORMALIZE (whose value is a name), and is
+// never satisfied by a phonetic code.
+func Nullable(condition query.Condition, a string, ok bool) bool {
+	if condition.Comparator == query.Is && strings.EqualFold(condition.Value, "null") {
+		return ok == condition.Negate
+	}
+	if !ok {
+		return false
+	}
+	if condition.Comparator == query.Is && condition.Attribute == "normalize" {
+		condition.Comparator = query.Equals
+	}
+
+	if isNumericFunction(condition) {
+		x, errA := strconv.ParseFloat(a, 64)
+		y, errB := strconv.ParseFloat(condition.Value, 64)
+		if errA != nil || errB != nil {
+			return false
+		}
+		return Float(condition.Comparator, x, y) != condition.Negate
+	}
+
+	if condition.Negate {
+		return !Alpha(condition.Comparator, a, condition.Value)
+	}
+	return Alpha(condition.Comparator, a, condition.Value)
+}
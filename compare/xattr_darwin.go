@@ -0,0 +1,35 @@
+//go:build xattr
+
+package compare
+
+import "golang.org/x/sys/unix"
+
+// Return the value of the extended attribute key of the file at path, and
+// whether the file has it.
+func getxattr(path, key string) (string, bool) {
+	size, err := unix.Getxattr(path, key, nil)
+	if err != nil {
+		return "", false
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Getxattr(path, key, buf); err != nil {
+		return "", false
+	}
+	return string(buf[:size]), true
+}
+
+// Return the NUL-terminated keys of the extended attributes of the file at
+// path, and whether they could be listed.
+func listxattr(path string) ([]byte, bool) {
+	size, err := unix.Listxattr(path, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	buf := make([]byte, size)
+	if size, err = unix.Listxattr(path, buf); err != nil {
+		return nil, false
+	}
+	return buf[:size], true
+}
@@ -0,0 +1,33 @@
+package compare
+
+import "syscall"
+
+// Return the value of the extended attribute key of the file at path, and
+// whether the file has it.
+func getxattr(path, key string) (string, bool) {
+	size, err := syscall.Getxattr(path, key, nil)
+	if err != nil {
+		return "", false
+	}
+
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(path, key, buf); err != nil {
+		return "", false
+	}
+	return string(buf[:size]), true
+}
+
+// Return the NUL-terminated keys of the extended attributes of the file at
+// path, and whether they could be listed.
+func listxattr(path string) ([]byte, bool) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil {
+		return nil, false
+	}
+
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, false
+	}
+	return buf[:size], true
+}
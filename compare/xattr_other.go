@@ -0,0 +1,15 @@
+//go:build !linux && !(darwin && xattr)
+
+package compare
+
+// Extended attributes aren't supported on this platform (or on macOS without
+// -tags xattr), so no file has any.
+func getxattr(path, key string) (string, bool) {
+	return "", false
+}
+
+// Extended attributes aren't supported on this platform, so they can't be
+// listed.
+func listxattr(path string) ([]byte, bool) {
+	return nil, false
+}
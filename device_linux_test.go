@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// /proc is a mount point below / (of the proc filesystem), so it's on a
+// different device.
+func TestNoCrossDevice(t *testing.T) {
+	root, err := os.Lstat("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := os.Lstat("/proc")
+	if err != nil {
+		t.Skip("/proc isn't mounted")
+	}
+	if rootDevice, _ := device("/", root); !crossesDevice(&rootDevice, "/proc", proc) {
+		t.Skip("/proc is on the same device as /")
+	}
+
+	// Only /proc is walked below /.
+	for _, noCrossDevice := range []bool{false, true} {
+		var paths []string
+		err := walk("/", query.QueryOptions{NoCrossDevice: noCrossDevice}, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if filepath.Dir(path) == "/" && path != "/" && path != "/proc" {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Dir(path) == "/proc" && noCrossDevice {
+				t.Fatalf("\nExpected /proc not to be walked\n     Got %s", path)
+			}
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) < 2 || !reflect.DeepEqual(paths[:2], []string{"/", "/proc"}) ||
+			noCrossDevice != (len(paths) == 2) {
+			t.Fatalf("\nExpected / and /proc (and its files unless -no-cross-device)\n     Got %s",
+				strings.Join(paths, ", "))
+		}
+	}
+
+	type Case struct {
+		opts     *options
+		expected []string
+	}
+
+	cases := []Case{
+		{&options{}, []string{"/proc/self"}},
+		{&options{noCrossDevice: true}, []string{}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines("SELECT name FROM / WHERE dir = /proc AND name = self", c.opts)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
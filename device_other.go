@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// The devices which files are on aren't known on this platform, so walks
+// aren't kept to a single device.
+func device(path string, info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Return the serial number of the volume which the file at path is on, since
+// its information doesn't have it.
+func device(path string, info os.FileInfo) (uint64, bool) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+	// Directories can only be opened with FILE_FLAG_BACKUP_SEMANTICS.
+	h, err := syscall.CreateFile(name, 0, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, false
+	}
+	defer syscall.CloseHandle(h)
+
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(h, &data); err != nil {
+		return 0, false
+	}
+	return uint64(data.VolumeSerialNumber), true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// A baseline is the sorted paths of the results of a query, which later runs
+// of the query may be compared against.
+type baseline struct {
+	Paths []string `json:"paths"`
+}
+
+// Create the baseline of the results.
+func newBaseline(results []result) *baseline {
+	b := &baseline{Paths: make([]string, 0, len(results))}
+	for _, r := range results {
+		b.Paths = append(b.Paths, r.path)
+	}
+	sort.Strings(b.Paths)
+	return b
+}
+
+// Read the baseline saved in the file at path.
+func readBaseline(path string) (*baseline, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(baseline)
+	if err := json.Unmarshal(contents, b); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %v", path, err)
+	}
+	sort.Strings(b.Paths)
+	return b, nil
+}
+
+// Save the baseline to the file at path.
+func (b *baseline) save(path string) error {
+	contents, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(contents, '\n'), 0644)
+}
+
+// Write the paths which were added (prefixed with +) or removed (prefixed with
+// -) in b since old, in sorted order.
+func (b *baseline) writeDiff(w io.Writer, old *baseline) error {
+	i, j := 0, 0
+	for i < len(old.Paths) || j < len(b.Paths) {
+		var err error
+		switch {
+		case j == len(b.Paths) || (i < len(old.Paths) && old.Paths[i] < b.Paths[j]):
+			_, err = fmt.Fprintf(w, "- %s\n", old.Paths[i])
+			i++
+		case i == len(old.Paths) || b.Paths[j] < old.Paths[i]:
+			_, err = fmt.Fprintf(w, "+ %s\n", b.Paths[j])
+			j++
+		default:
+			i, j = i+1, j+1
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write the difference between the results and the -diff baseline (if it's
+// set), then save the results as the -save-baseline baseline (if it's set).
+func runDiff(results []result, opts *options, w io.Writer) error {
+	current := newBaseline(results)
+
+	if opts.diff != "" {
+		old, err := readBaseline(opts.diff)
+		if err != nil {
+			return err
+		}
+		if err := current.writeDiff(w, old); err != nil {
+			return err
+		}
+	}
+
+	if opts.saveBaseline != "" {
+		return current.save(opts.saveBaseline)
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A step of a query's evaluation.
+type planStep int
+
+const (
+	scanStep planStep = iota
+	joinStep
+	filterStep
+	unpivotStep
+	aggregateStep
+	havingStep
+	windowStep
+	qualifyStep
+	sortStep
+	distinctStep
+	limitStep
+	selectStep
+)
+
+// A node of a query plan, representing a single step of the query's
+// evaluation. Each node's input is the output of its children. When the query
+// is analyzed, the number of results each step produced and the time spent in
+// the step itself (excluding its children) are recorded in its node.
+type planNode struct {
+	name     string
+	detail   string
+	children []*planNode
+	rows     int
+	elapsed  time.Duration
+}
+
+// Record that the step produced rows more results, taking elapsed. A nil
+// *planNode is valid and records nothing.
+func (n *planNode) record(rows int, elapsed time.Duration) {
+	if n == nil {
+		return
+	}
+	n.rows += rows
+	n.elapsed += elapsed
+}
+
+type planKey struct {
+	step   planStep
+	source string // Only set for scanStep.
+}
+
+// The plan of a single query, with a node for each step of its evaluation.
+type queryPlan struct {
+	root  *planNode
+	nodes map[planKey]*planNode
+
+	// The plan of the subquery of the query's JOIN (either a LATERAL subquery,
+	// or the subquery of EXISTS), if it has one.
+	subquery *queryPlan
+}
+
+// Create the plan of the query. Sources which name one of tables are scanned
+// rather than walked.
+func newQueryPlan(q *query.Query, tables map[string][]result) *queryPlan {
+	plan := &queryPlan{nodes: make(map[planKey]*planNode)}
+	children := make([]*planNode, 0)
+
+	for _, src := range q.Sources["include"] {
+		key := planKey{step: scanStep, source: src}
+		if _, ok := plan.nodes[key]; ok {
+			continue
+		}
+
+		n := &planNode{name: "Walk", detail: src}
+		if values, ok := q.Values[src]; ok {
+			n.name = "Values"
+			n.detail += fmt.Sprintf(" (%s) with %d rows", strings.Join(values.Columns, ", "), len(values.Rows))
+		} else if _, ok := tables[src]; ok {
+			n.name = "Scan"
+		} else if len(q.Sources["exclude"]) > 0 {
+			n.detail += fmt.Sprintf(" excluding %s", strings.Join(q.Sources["exclude"], ", "))
+		}
+		if q.Sample != nil {
+			n.detail += fmt.Sprintf(" sampling %s (%g PERCENT)",
+				strings.ToUpper(q.Sample.Method), q.Sample.Percent)
+		}
+		if pushed := pushDownPredicates(q.ConditionTree); n.name == "Walk" && pushed != nil &&
+			(q.Join == nil || q.Join.Semi() && src == q.Sources["include"][0]) {
+			n.detail += " " + pushed.String()
+		}
+
+		plan.nodes[key] = n
+		children = append(children, n)
+	}
+
+	// Each of the following steps (if the query requires it) takes the
+	// output of the previous one.
+	add := func(step planStep, name, detail string) {
+		n := &planNode{name: name, detail: detail, children: children}
+		plan.nodes[planKey{step: step}] = n
+		children = []*planNode{n}
+	}
+
+	// The plan of the JOIN's subquery is the last input of the JOIN. The
+	// right source of a SEMI or ANTI JOIN only filters the results of the
+	// left one, so it's joined after the WHERE clause.
+	var right []*planNode
+	if q.Join != nil {
+		if q.Join.Semi() && len(children) > 1 {
+			children, right = children[:1], children[1:]
+		}
+		sub := q.Join.Lateral
+		if sub == nil {
+			sub = q.Join.Subquery
+		}
+		if sub != nil {
+			plan.subquery = newQueryPlan(sub, tables)
+			right = append(right, plan.subquery.root)
+		}
+	}
+	join := func() {
+		children = append(children, right...)
+		add(joinStep, "Join", q.Join.String())
+	}
+
+	if q.Join != nil && !q.Join.Semi() {
+		join()
+	}
+	if q.ConditionTree != nil {
+		add(filterStep, "Filter", q.ConditionTree.String())
+	}
+	if q.Join != nil && q.Join.Semi() {
+		join()
+	}
+	if q.Unpivot != nil {
+		add(unpivotStep, "Unpivot", q.Unpivot.String())
+	}
+
+	names := make([]string, 0, len(q.Columns))
+	windows := make([]string, 0)
+	aggregates := make([]string, 0)
+	for _, c := range q.Columns {
+		names = append(names, c.Name())
+		if c.Window != nil && !c.Window.InOutputOrder {
+			windows = append(windows, c.Name())
+		}
+		if c.Aggregate != nil {
+			aggregates = append(aggregates, c.Aggregate.String())
+		}
+	}
+
+	if isGrouped(q) {
+		detail := strings.Join(aggregates, ", ")
+		if len(q.GroupBy) > 0 {
+			detail = strings.TrimSpace(detail + " GROUP BY " + strings.Join(q.GroupBy, ", "))
+		}
+		if q.Pivot {
+			detail += " PIVOT"
+		}
+		add(aggregateStep, "Aggregate", detail)
+	}
+
+	if q.Having != nil {
+		add(havingStep, "Having", q.Having.String())
+	}
+	if len(windows) > 0 {
+		add(windowStep, "Window", strings.Join(windows, ", "))
+	}
+	if q.Qualify != nil {
+		add(qualifyStep, "Qualify", q.Qualify.String())
+	}
+	if len(q.OrderBy) > 0 {
+		add(sortStep, "Sort", orderingString(q.OrderBy))
+	}
+	if q.Distinct {
+		detail := ""
+		if q.DistinctOn != nil {
+			detail = fmt.Sprintf("ON (%s)", strings.Join(q.DistinctOn, ", "))
+		}
+		add(distinctStep, "Distinct", detail)
+	}
+	if q.Limit >= 0 || q.Offset > 0 {
+		detail := make([]string, 0, 2)
+		if q.Limit >= 0 {
+			detail = append(detail, strconv.Itoa(q.Limit))
+		}
+		if q.Offset > 0 {
+			detail = append(detail, fmt.Sprintf("OFFSET %d", q.Offset))
+		}
+		add(limitStep, "Limit", strings.Join(detail, " "))
+	}
+	add(selectStep, "Select", strings.Join(names, ", "))
+
+	plan.root = children[0]
+	return plan
+}
+
+// Return the plan's node for the step (and source, for scanStep), or nil if
+// the plan doesn't include the step. A nil *queryPlan is valid and always
+// returns nil.
+func (p *queryPlan) node(step planStep, source string) *planNode {
+	if p == nil {
+		return nil
+	}
+	return p.nodes[planKey{step: step, source: source}]
+}
+
+// Return the plan of the subquery of the query's JOIN, or nil if it doesn't
+// have one. A nil *queryPlan is valid and always returns nil.
+func (p *queryPlan) subqueryPlan() *queryPlan {
+	if p == nil {
+		return nil
+	}
+	return p.subquery
+}
+
+// Return the ORDER BY clause's sort keys in their query form.
+func orderingString(orderBy []query.Ordering) string {
+	keys := make([]string, 0, len(orderBy))
+	for _, ordering := range orderBy {
+		key := ordering.Attribute
+		if ordering.Desc {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, ", ")
+}
+
+// Write the plan rooted at n, indenting each node by its depth. When analyze
+// is set, each node is annotated with the results it produced and its time.
+func writePlan(w io.Writer, n *planNode, depth int, analyze bool) error {
+	line := strings.Repeat("  ", depth) + n.name
+	if n.detail != "" {
+		line += ": " + n.detail
+	}
+	if analyze {
+		line += fmt.Sprintf(" (rows=%d time=%s)", n.rows, n.elapsed)
+	}
+
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	for _, child := range n.children {
+		if err := writePlan(w, child, depth+1, analyze); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write the plan of the query (preceded by the plan of each of its CTEs) to w.
+// If the query is analyzed, it's evaluated first, and the total time taken is
+// written after the plan.
+func runExplain(q *query.Query, qopts query.QueryOptions, w io.Writer) (err error) {
+	defer recoverEvaluation(&err)
+	start := time.Now()
+
+	tables := make(map[string][]result, len(q.With))
+	for _, cte := range q.With {
+		plan := newQueryPlan(cte.Query, tables)
+		if q.Analyze {
+			tables[cte.Name] = evaluate(context.Background(), cte.Query, tables, qopts, nil, plan)
+		} else {
+			tables[cte.Name] = nil
+		}
+
+		root := &planNode{name: "CTE", detail: cte.Name, children: []*planNode{plan.root},
+			rows: len(tables[cte.Name])}
+		if err := writePlan(w, root, 0, q.Analyze); err != nil {
+			return err
+		}
+	}
+
+	plan := newQueryPlan(q, tables)
+	if q.Analyze {
+		evaluate(context.Background(), q, tables, qopts, nil, plan)
+	}
+
+	if err := writePlan(w, plan.root, 0, q.Analyze); err != nil {
+		return err
+	}
+
+	if q.Analyze {
+		_, err := fmt.Fprintf(w, "Execution time: %s\n", time.Since(start))
+		return err
+	}
+
+	return nil
+}
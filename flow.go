@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The most statements which a query file may run, so that a GOTO which loops
+// forever is stopped.
+const maxSteps = 1000
+
+// Return true iff the GOTO statement q continues at its label: either it's
+// unconditional, or its IF condition is true.
+func jumps(q *query.Query, opts *options) (bool, error) {
+	if q.If == nil {
+		return true, nil
+	}
+
+	qopts, _, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return false, err
+	}
+	qopts.NoCrossDevice = opts.noCrossDevice
+	qopts.MaxFileSize = opts.maxFileSize
+
+	ok, _, err := evaluateComparison(q.If, qopts, opts.variables)
+	return ok, err
+}
+
+// Write the message of the RAISE statement to errw, with its level, or if its
+// level is ERROR, return it as an error, which stops the query file.
+func runRaise(r *query.RaiseStatement, variables map[string]string, errw io.Writer) error {
+	var message strings.Builder
+	for _, part := range r.Message {
+		if part.Variable == "" {
+			message.WriteString(part.Text)
+			continue
+		}
+		value, ok := variables[part.Variable]
+		if !ok {
+			return fmt.Errorf("variable @%s isn't set", part.Variable)
+		}
+		message.WriteString(value)
+	}
+
+	if r.Level == query.Error {
+		return errors.New("ERROR: " + message.String())
+	}
+	fmt.Fprintf(errw, "%s: %s\n", strings.ToUpper(r.Level.String()), message.String())
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kshvmdn/fsql/fsqlplugin"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A formatter writes the value of each column for each result to w.
+type formatter func(w io.Writer, columns []query.Column, results []result) error
+
+var formatters = map[string]formatter{
+	"default":          formatDefault,
+	"json":             formatJSON,
+	"machine-readable": formatMachineReadable,
+	"markdown":         formatMarkdown,
+	"html":             formatHTML,
+	"xml":              formatXML,
+	"tabulate":         formatTabulateDefault,
+	"compact":          formatCompact,
+}
+
+// The build tag which registers each format that isn't built by default.
+var formatTags = map[string]string{
+	"toml": "toml",
+	"yaml": "yaml",
+}
+
+// The type of an attribute's value, as written by the json format.
+type attributeType struct {
+	jsonType string // The JSON Schema type of the value.
+	format   string // The JSON Schema format of the value, if it's a string.
+	nullable bool   // Whether an empty value is written as null.
+}
+
+// The type of each attribute which may be selected. Window function columns
+// are typed by windowType, aggregate function columns by aggregateType, and
+// the value column of UNPIVOT by unpivotType.
+var attributeTypes = map[string]attributeType{
+	"mode":  {jsonType: "string"},
+	"size":  {jsonType: "integer"},
+	"time":  {jsonType: "string", format: "date-time"},
+	"dir":   {jsonType: "string"},
+	"depth": {jsonType: "integer"},
+	"ext":   {jsonType: "string", nullable: true},
+	"name":  {jsonType: "string"},
+
+	"tar_offset": {jsonType: "integer", nullable: true},
+	"commit":     {jsonType: "string", nullable: true},
+}
+
+// Return the type of the column's value. Attributes registered by plugins are
+// nullable strings.
+func columnType(c query.Column) attributeType {
+	if c.Window != nil {
+		return windowType(c.Window)
+	}
+	if c.Aggregate != nil {
+		return aggregateType(c.Aggregate)
+	}
+	if c.Unpivot != nil {
+		if c.Unpivoted == c.Unpivot.NameColumn {
+			return attributeType{jsonType: "string"}
+		}
+		t, _ := unpivotType(c.Unpivot)
+		return t
+	}
+	if c.Coalesce != nil {
+		_, attribute := query.SplitQualified(c.Coalesce[0])
+		return columnType(query.Column{Attribute: attribute})
+	}
+	if c.Case != nil {
+		return caseType(c.Case)
+	}
+	if c.Expression != nil {
+		return expressionType(c.Expression)
+	}
+	if t, ok := attributeTypes[c.Attribute]; ok {
+		return t
+	}
+	if _, ok := fsqlplugin.LookupAttribute(c.Attribute); ok {
+		return attributeType{jsonType: "string", nullable: true}
+	}
+	return attributeType{}
+}
+
+// Return the value of attribute for this result, nil if it's NULL.
+func (r result) value(attribute string) interface{} {
+	switch attribute {
+	case "mode":
+		return r.info.Mode()
+	case "size":
+		return r.info.Size()
+	case "time":
+		return r.info.ModTime()
+	case "dir":
+		return filepath.Dir(r.path)
+	case "depth":
+		return int64(r.depth)
+	case "ext":
+		return filepath.Ext(r.info.Name())
+	case "name":
+		// TODO: Only show file name, instead of the full path?
+		return r.path
+	case "tar_offset":
+		if offset, ok := tarOffset(r.info); ok {
+			return offset
+		}
+	case "commit":
+		if commit, ok := fileCommit(r.info); ok {
+			return commit
+		}
+	default:
+		if attr, ok := fsqlplugin.LookupAttribute(attribute); ok {
+			return attr.Value(r.path, r.info)
+		}
+	}
+	return nil
+}
+
+// Return the value of the i-th column of the query for this result. The
+// values of the qualified columns of a JOIN are computed (see joinResults).
+func (r result) column(i int, c query.Column) interface{} {
+	if c.Attribute != "" && c.Table == "" {
+		return r.value(c.Attribute)
+	}
+	return r.computed[i]
+}
+
+// Return the formatter for format, or nil if there isn't one. The empty
+// string is the same as "default".
+func lookupFormatter(format string) formatter {
+	if format == "" {
+		format = "default"
+	}
+	return formatters[format]
+}
+
+// Write the results of the query to w in the provided format, with the
+// command line options which alter it.
+func writeResults(w io.Writer, format string, opts *options, q *query.Query, results []result) error {
+	if format == "html" {
+		title := opts.title
+		if title == "" {
+			title = defaultHTMLTitle
+		}
+		return writeHTML(w, q.Columns, results, title, !opts.noStyle)
+	}
+	if format == "tabulate" {
+		width := q.FormatWidth
+		if width == 0 {
+			width = defaultTabulateWidth
+		}
+		return formatTabulate(w, q.Columns, results, width, opts.ascii)
+	}
+
+	return lookupFormatter(format)(w, q.Columns, results)
+}
+
+// Write the number of results to w in the provided format.
+func writeCount(w io.Writer, format string, count int) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(map[string]int{"count": count})
+	}
+
+	_, err := fmt.Fprintln(w, count)
+	return err
+}
+
+// Return the value as it's shown by the text formats, in which NULL is empty.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.Stamp)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Write one tab-separated line per result.
+func formatDefault(w io.Writer, columns []query.Column, results []result) error {
+	return formatDelimited(w, columns, results, "\n")
+}
+
+// Write one tab-separated row per result, each terminated by a NUL byte rather
+// than a newline (like find -print0), since paths may contain newlines.
+func formatMachineReadable(w io.Writer, columns []query.Column, results []result) error {
+	return formatDelimited(w, columns, results, "\x00")
+}
+
+// Write one tab-separated row per result, each followed by terminator.
+func formatDelimited(w io.Writer, columns []query.Column, results []result, terminator string) error {
+	for _, r := range results {
+		if err := writeDelimitedRow(w, columns, r, terminator); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write the tab-separated row of the result, followed by terminator.
+func writeDelimitedRow(w io.Writer, columns []query.Column, r result, terminator string) error {
+	for i, c := range columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+
+		fmt.Fprint(w, formatValue(r.column(i, c)))
+	}
+
+	_, err := fmt.Fprint(w, terminator)
+	return err
+}
+
+// Return the value (of type t) as it's encoded in the JSON output, in which
+// modes are strings and NULL is null.
+func jsonValue(v interface{}, t attributeType) interface{} {
+	switch v := v.(type) {
+	case os.FileMode:
+		return v.String()
+	case string:
+		if v == "" && t.nullable {
+			return nil
+		}
+	}
+	return v
+}
+
+// Write a JSON array with an object per result.
+func formatJSON(w io.Writer, columns []query.Column, results []result) error {
+	rows := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			row[c.Name()] = jsonValue(r.column(i, c), columnType(c))
+		}
+		rows = append(rows, row)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// Escape the cell of a Markdown table, so that pipes and newlines in the value
+// don't end the cell or row.
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", " ")
+
+// Write a GitHub Flavored Markdown table, with a header row of the column
+// names and a row per result. Each column is padded to the same width.
+func formatMarkdown(w io.Writer, columns []query.Column, results []result) error {
+	rows := make([][]string, 0, len(results)+1)
+	widths := make([]int, len(columns))
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = markdownEscaper.Replace(c.Name())
+	}
+	rows = append(rows, header)
+	for _, r := range results {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = markdownEscaper.Replace(formatValue(r.column(i, c)))
+		}
+		rows = append(rows, row)
+	}
+
+	for i := range columns {
+		widths[i] = 3 // The separator row needs at least 3 dashes.
+		for _, row := range rows {
+			if n := utf8.RuneCountInString(row[i]); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	writeRow := func(cells []string) error {
+		line := "|"
+		for i, cell := range cells {
+			line += " " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " |"
+		}
+		_, err := fmt.Fprintln(w, line)
+		return err
+	}
+
+	separator := make([]string, len(columns))
+	for i := range columns {
+		separator[i] = strings.Repeat("-", widths[i])
+	}
+
+	if err := writeRow(rows[0]); err != nil {
+		return err
+	}
+	if err := writeRow(separator); err != nil {
+		return err
+	}
+	for _, row := range rows[1:] {
+		if err := writeRow(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
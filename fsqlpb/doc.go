@@ -0,0 +1,10 @@
+// Package fsqlpb contains the protocol buffer messages and gRPC service for
+// serving queries over gRPC (see `fsql serve -grpc-port`). The Go code is
+// generated from fsql.proto, and is only built with the grpc tag, so that its
+// dependencies aren't required otherwise (go generate adds the build
+// constraint to each generated file).
+package fsqlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fsql.proto
+//go:generate sh -c "printf '//go:build grpc\\n\\n' | cat - fsql.pb.go > fsql.pb.go.tmp && mv fsql.pb.go.tmp fsql.pb.go"
+//go:generate sh -c "printf '//go:build grpc\\n\\n' | cat - fsql_grpc.pb.go > fsql_grpc.pb.go.tmp && mv fsql_grpc.pb.go.tmp fsql_grpc.pb.go"
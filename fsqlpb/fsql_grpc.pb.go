@@ -0,0 +1,134 @@
+//go:build grpc
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: fsql.proto
+
+package fsqlpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Fsql_Query_FullMethodName = "/fsql.Fsql/Query"
+)
+
+// FsqlClient is the client API for Fsql service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Fsql evaluates queries, like `fsql serve` does over HTTP.
+type FsqlClient interface {
+	// Query evaluates the query and streams one result per matched file, in
+	// the order of the query's results.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[QueryResult], error)
+}
+
+type fsqlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFsqlClient(cc grpc.ClientConnInterface) FsqlClient {
+	return &fsqlClient{cc}
+}
+
+func (c *fsqlClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[QueryResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Fsql_ServiceDesc.Streams[0], Fsql_Query_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[QueryRequest, QueryResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fsql_QueryClient = grpc.ServerStreamingClient[QueryResult]
+
+// FsqlServer is the server API for Fsql service.
+// All implementations must embed UnimplementedFsqlServer
+// for forward compatibility.
+//
+// Fsql evaluates queries, like `fsql serve` does over HTTP.
+type FsqlServer interface {
+	// Query evaluates the query and streams one result per matched file, in
+	// the order of the query's results.
+	Query(*QueryRequest, grpc.ServerStreamingServer[QueryResult]) error
+	mustEmbedUnimplementedFsqlServer()
+}
+
+// UnimplementedFsqlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFsqlServer struct{}
+
+func (UnimplementedFsqlServer) Query(*QueryRequest, grpc.ServerStreamingServer[QueryResult]) error {
+	return status.Error(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedFsqlServer) mustEmbedUnimplementedFsqlServer() {}
+func (UnimplementedFsqlServer) testEmbeddedByValue()              {}
+
+// UnsafeFsqlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FsqlServer will
+// result in compilation errors.
+type UnsafeFsqlServer interface {
+	mustEmbedUnimplementedFsqlServer()
+}
+
+func RegisterFsqlServer(s grpc.ServiceRegistrar, srv FsqlServer) {
+	// If the following call panics, it indicates UnimplementedFsqlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Fsql_ServiceDesc, srv)
+}
+
+func _Fsql_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FsqlServer).Query(m, &grpc.GenericServerStream[QueryRequest, QueryResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Fsql_QueryServer = grpc.ServerStreamingServer[QueryResult]
+
+// Fsql_ServiceDesc is the grpc.ServiceDesc for Fsql service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Fsql_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fsql.Fsql",
+	HandlerType: (*FsqlServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Query",
+			Handler:       _Fsql_Query_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "fsql.proto",
+}
@@ -0,0 +1,113 @@
+// Package fsqlplugin lets code outside of fsql register virtual filesystems
+// and attributes, which may then be used in queries like the built-in ones.
+//
+// Plugins are Go plugins (built with go build -buildmode=plugin) in
+// ~/.fsql/plugins, which fsql loads when it starts. Each plugin registers its
+// filesystems and attributes in an init function:
+//
+//	func init() {
+//		fsqlplugin.RegisterAttribute("is_test_file", fsqlplugin.AttributeFunc(
+//			func(path string, info os.FileInfo) string { ... }))
+//	}
+package fsqlplugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// VFS is a (virtual) filesystem which sources with a registered scheme are
+// walked in.
+type VFS interface {
+	// Walk the file tree rooted at root, calling fn for each file or
+	// directory in the tree (including root), like filepath.Walk.
+	Walk(root string, fn filepath.WalkFunc) error
+
+	// Stat returns the information of the file at path.
+	Stat(path string) (os.FileInfo, error)
+
+	// Open opens the file at path for reading.
+	Open(path string) (io.ReadCloser, error)
+}
+
+// VFSFactory returns the filesystem which the source uri is walked in.
+type VFSFactory func(uri string) (VFS, error)
+
+// AttributeProvider computes the value of an attribute for each file.
+type AttributeProvider interface {
+	// Value returns the attribute's value for the file at path, or the empty
+	// string if it's NULL.
+	Value(path string, info os.FileInfo) string
+}
+
+// AttributeFunc is an AttributeProvider which calls the function.
+type AttributeFunc func(path string, info os.FileInfo) string
+
+// Value returns f(path, info).
+func (f AttributeFunc) Value(path string, info os.FileInfo) string {
+	return f(path, info)
+}
+
+var (
+	mu         sync.RWMutex
+	factories  = make(map[string]VFSFactory)
+	attributes = make(map[string]AttributeProvider)
+)
+
+// RegisterVFS registers the filesystem of sources with the scheme (e.g. ftp
+// for ftp://host/path). It panics if the scheme is already registered, or
+// factory is nil.
+func RegisterVFS(scheme string, factory func(uri string) (VFS, error)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if factory == nil {
+		panic("fsqlplugin: RegisterVFS factory is nil")
+	}
+	if _, ok := factories[scheme]; ok {
+		panic(fmt.Sprintf("fsqlplugin: RegisterVFS called twice for scheme %s", scheme))
+	}
+	factories[scheme] = factory
+}
+
+// RegisterAttribute registers the attribute name, which may then be selected,
+// ordered by, and compared (as a string) in conditions. It panics if the name
+// is already an attribute, or attr is nil.
+func RegisterAttribute(name string, attr AttributeProvider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if attr == nil {
+		panic("fsqlplugin: RegisterAttribute provider is nil")
+	}
+	if query.IsAttribute(name) {
+		panic(fmt.Sprintf("fsqlplugin: RegisterAttribute called for existing attribute %s", name))
+	}
+	attributes[name] = attr
+	query.RegisterAttribute(name)
+}
+
+// LookupVFS returns the factory registered for the scheme, and false if there
+// isn't one.
+func LookupVFS(scheme string) (VFSFactory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	factory, ok := factories[scheme]
+	return factory, ok
+}
+
+// LookupAttribute returns the provider registered for the attribute name, and
+// false if there isn't one.
+func LookupAttribute(name string) (AttributeProvider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	attr, ok := attributes[name]
+	return attr, ok
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// An fsVFS is a filesystem which implements fs.FS (e.g. an fstest.MapFS, an
+// embed.FS, or a *zip.Reader), which sources are walked in when it's the
+// query's options' FS. The paths of an fs.FS are slash-separated and relative
+// to its root, so each path is made relative to the root first (e.g. /data
+// and ./data are both data), but the paths of the files which are walked
+// begin with the source as it was written.
+//
+// Paths never lead outside of the filesystem: those which begin with .. are
+// invalid, and symbolic links (in a filesystem which has them, like an
+// os.DirFS) are never followed, since they may point anywhere.
+type fsVFS struct {
+	fsys fs.FS
+	opts query.QueryOptions
+}
+
+// Return the path of the file p in an fs.FS.
+func fsPath(p string) string {
+	p = strings.TrimPrefix(path.Clean(filepath.ToSlash(p)), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// Return the path of the file p in the filesystem, or an error if it's outside
+// of the filesystem, or one of the directories above it is a symbolic link.
+func (f fsVFS) resolve(p string) (string, error) {
+	name := fsPath(p)
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("%s is outside of the filesystem", p)
+	}
+
+	for i := strings.Index(name, "/"); i >= 0; i = nextSlash(name, i) {
+		if info, err := fs.Lstat(f.fsys, name[:i]); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s: %s is a symbolic link, which isn't followed", p, name[:i])
+		}
+	}
+	return name, nil
+}
+
+// Return the index of the first slash in s after i, or -1 if there isn't one.
+func nextSlash(s string, i int) int {
+	if j := strings.Index(s[i+1:], "/"); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// A root which is a symbolic link is passed to fn, but not walked (unlike
+// fs.WalkDir, which walks its target).
+func (f fsVFS) Walk(root string, fn filepath.WalkFunc) error {
+	base, err := f.resolve(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if info, err := fs.Lstat(f.fsys, base); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		err := fn(root, fsFileInfo{FileInfo: info, fsys: f.fsys, path: base}, nil)
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	return fs.WalkDir(f.fsys, base, func(p string, d fs.DirEntry, err error) error {
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, base), "/")
+		if base == "." && p != "." {
+			rel = p
+		}
+		name := filepath.Join(root, filepath.FromSlash(rel))
+
+		var info os.FileInfo
+		if d != nil {
+			var infoErr error
+			if info, infoErr = d.Info(); infoErr != nil && err == nil {
+				err = infoErr
+			}
+		}
+		if info != nil {
+			info = fsFileInfo{FileInfo: info, fsys: f.fsys, path: p}
+		}
+		if err := fn(name, info, err); err != nil || info == nil || !info.IsDir() {
+			return err
+		}
+
+		// The walk is limited to MaxDepth levels below root.
+		if f.opts.MaxDepth > 0 && rel != "" && strings.Count(rel, "/")+1 >= f.opts.MaxDepth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// Stat doesn't follow a symbolic link, like os.Lstat.
+func (f fsVFS) Stat(p string) (os.FileInfo, error) {
+	name, err := f.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Lstat(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return fsFileInfo{FileInfo: info, fsys: f.fsys, path: name}, nil
+}
+
+func (f fsVFS) Open(p string) (io.ReadCloser, error) {
+	name, err := f.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return f.fsys.Open(name)
+}
+
+// An fsFileInfo is the information of a file in an fs.FS, which also reads the
+// file's contents from it (see compare.ContentsInfo), since it may not be on
+// the local filesystem. Only regular files' contents are read, so a symbolic
+// link's target never is.
+type fsFileInfo struct {
+	os.FileInfo
+	fsys fs.FS
+	path string
+}
+
+func (f fsFileInfo) Contents() ([]byte, error) {
+	if !f.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "read", Path: f.path, Err: fs.ErrInvalid}
+	}
+	return fs.ReadFile(f.fsys, f.path)
+}
+
+// Evaluate the query, along with each of its CTEs, with each of its sources
+// without a scheme walked in fsys rather than the local filesystem, and return
+// its results.
+func evaluateFS(fsys fs.FS, q *query.Query) ([]result, error) {
+	qopts, _, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return nil, err
+	}
+	qopts.FS = fsys
+
+	if err := checkSources(q, qopts); err != nil {
+		return nil, err
+	}
+	return evaluateWith(context.Background(), q, qopts, nil)
+}
+
+// Evaluate the query in the directory root of an embedded filesystem (see
+// evaluateFS), so that its sources are relative to root. An embed.FS doesn't
+// have the files whose names begin with . or _ (unless they're embedded with
+// the all: prefix), so they're never walked.
+func evaluateEmbedFS(efs embed.FS, root string, q *query.Query) ([]result, error) {
+	fsys, err := fs.Sub(efs, fsPath(root))
+	if err != nil {
+		return nil, err
+	}
+	return evaluateFS(fsys, q)
+}
@@ -0,0 +1,170 @@
+//go:build gcs
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["gs"] = newGCSVFS
+}
+
+// An objectIterator iterates over the objects of a listing, like
+// *storage.ObjectIterator (which fetches them a page at a time).
+type objectIterator interface {
+	Next() (*storage.ObjectAttrs, error)
+}
+
+// The GCS operations used by a gcsVFS, which are implemented by gcsClient.
+type gcsAPI interface {
+	Objects(ctx context.Context, bucket string, q *storage.Query) objectIterator
+	Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error)
+	NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error)
+}
+
+// A gcsClient implements gcsAPI with a *storage.Client.
+type gcsClient struct {
+	client *storage.Client
+}
+
+func (c gcsClient) Objects(ctx context.Context, bucket string, q *storage.Query) objectIterator {
+	return c.client.Bucket(bucket).Objects(ctx, q)
+}
+
+func (c gcsClient) Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error) {
+	return c.client.Bucket(bucket).Object(object).Attrs(ctx)
+}
+
+func (c gcsClient) NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	return c.client.Bucket(bucket).Object(object).NewReader(ctx)
+}
+
+// A gcsVFS is the Google Cloud Storage buckets, in which paths are URLs of the
+// form gs://bucket/object. The slash-separated components of each object's
+// name are directories (e.g. the object a/b.csv is the file b.csv in the
+// directory a).
+type gcsVFS struct {
+	client gcsAPI
+	opts   query.QueryOptions
+}
+
+// Return the GCS filesystem, authenticated with the application default
+// credentials (e.g. the GOOGLE_APPLICATION_CREDENTIALS environment variable,
+// or gcloud auth application-default login).
+func newGCSVFS(src string, opts query.QueryOptions) (vfs, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &gcsVFS{client: gcsClient{client: client}, opts: opts}, nil
+}
+
+// Return the information of the object attrs, with its name relative to the
+// directory with the prefix.
+func gcsInfo(attrs *storage.ObjectAttrs, prefix string) objectInfo {
+	return objectInfo{
+		name:    path.Base(strings.TrimPrefix(attrs.Name, prefix)),
+		size:    attrs.Size,
+		modTime: attrs.Updated,
+	}
+}
+
+// Stat returns the information of the object at p, or of the directory at p
+// if there isn't one, but there are objects below it.
+func (g *gcsVFS) Stat(p string) (os.FileInfo, error) {
+	bucket, object, err := parseBucketPath(p)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	if object != "" {
+		if attrs, err := g.client.Attrs(ctx, bucket, object); err == nil {
+			return gcsInfo(attrs, ""), nil
+		}
+	}
+
+	_, err = g.client.Objects(ctx, bucket, &storage.Query{Prefix: bucketPrefix(object)}).Next()
+	if object == "" {
+		if err != nil && err != iterator.Done {
+			return nil, err
+		}
+		return objectInfo{name: bucket, dir: true}, nil
+	}
+	if err == iterator.Done {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return objectInfo{name: path.Base(object), dir: true}, nil
+}
+
+// Walk the objects below root. Only the objects below root are listed (by
+// their prefix), and every page of the listing is read before the first
+// object is walked, since the directories aren't listed.
+func (g *gcsVFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := g.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	bucket, object, err := parseBucketPath(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	root = strings.TrimSuffix(root, "/")
+	if !info.IsDir() {
+		return walkListing(root, info, nil, g.opts, fn)
+	}
+
+	prefix := bucketPrefix(object)
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "Updated"}); err != nil {
+		return fn(root, info, err)
+	}
+
+	listing := make(map[string]os.FileInfo)
+	it := g.client.Objects(context.Background(), bucket, q)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fn(root, info, err)
+		}
+
+		name := strings.TrimPrefix(attrs.Name, prefix)
+
+		// Objects whose names end with a slash are (empty) directories.
+		if strings.HasSuffix(name, "/") {
+			if name = strings.TrimSuffix(name, "/"); name != "" {
+				listing[name] = impliedDir(path.Base(name))
+			}
+			continue
+		}
+		listing[name] = gcsInfo(attrs, prefix)
+	}
+
+	return walkListing(root, info, listing, g.opts, fn)
+}
+
+// Open returns the contents of the object at p.
+func (g *gcsVFS) Open(p string) (io.ReadCloser, error) {
+	bucket, object, err := parseBucketPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return g.client.NewReader(context.Background(), bucket, object)
+}
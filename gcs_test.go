@@ -0,0 +1,148 @@
+//go:build gcs
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The number of objects in each page of a fakeGCS listing.
+const fakeGCSPageSize = 100
+
+// A fakeGCS is a single bucket of objects, mapping each name to its size.
+type fakeGCS struct {
+	names    []string
+	sizes    map[string]int64
+	modified time.Time
+
+	prefixes []string // The prefix of each listing.
+	pages    int      // The number of pages fetched by all listings.
+}
+
+func newFakeGCS(sizes map[string]int64, modified time.Time) *fakeGCS {
+	g := &fakeGCS{sizes: sizes, modified: modified}
+	for name := range sizes {
+		g.names = append(g.names, name)
+	}
+	sort.Strings(g.names)
+	return g
+}
+
+func (g *fakeGCS) attrs(name string) *storage.ObjectAttrs {
+	return &storage.ObjectAttrs{Name: name, Size: g.sizes[name], Updated: g.modified}
+}
+
+func (g *fakeGCS) Objects(ctx context.Context, bucket string, q *storage.Query) objectIterator {
+	g.prefixes = append(g.prefixes, q.Prefix)
+
+	var matches []string
+	for _, name := range g.names {
+		if strings.HasPrefix(name, q.Prefix) {
+			matches = append(matches, name)
+		}
+	}
+	return &fakeObjectIterator{gcs: g, names: matches}
+}
+
+func (g *fakeGCS) Attrs(ctx context.Context, bucket, object string) (*storage.ObjectAttrs, error) {
+	if _, ok := g.sizes[object]; !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return g.attrs(object), nil
+}
+
+func (g *fakeGCS) NewReader(ctx context.Context, bucket, object string) (io.ReadCloser, error) {
+	size, ok := g.sizes[object]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(make([]byte, size))), nil
+}
+
+// A fakeObjectIterator fetches the objects of a listing a page at a time.
+type fakeObjectIterator struct {
+	gcs   *fakeGCS
+	names []string
+	page  []string
+}
+
+func (it *fakeObjectIterator) Next() (*storage.ObjectAttrs, error) {
+	if len(it.page) == 0 {
+		if len(it.names) == 0 {
+			return nil, iterator.Done
+		}
+		n := fakeGCSPageSize
+		if n > len(it.names) {
+			n = len(it.names)
+		}
+		it.page, it.names = it.names[:n], it.names[n:]
+		it.gcs.pages++
+	}
+
+	name := it.page[0]
+	it.page = it.page[1:]
+	return it.gcs.attrs(name), nil
+}
+
+func TestGCS(t *testing.T) {
+	modified := time.Date(2021, time.June, 7, 8, 9, 10, 0, time.UTC)
+	sizes := map[string]int64{"other/logs-x": 1, "logs/2021/z.json": 3}
+	for i := 0; i < 250; i++ {
+		sizes[fmt.Sprintf("logs/logs-%03d", i)] = int64(i)
+	}
+	client := newFakeGCS(sizes, modified)
+
+	previous := vfsProviders["gs"]
+	vfsProviders["gs"] = func(src string, opts query.QueryOptions) (vfs, error) {
+		return &gcsVFS{client: client, opts: opts}, nil
+	}
+	defer func() { vfsProviders["gs"] = previous }()
+
+	lines, err := runLines("SELECT name FROM gs://bucket/logs WHERE name LIKE logs-%", &options{count: true})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if lines[0] != "250" {
+		t.Fatalf("\nExpected %d objects\n     Got %s", 250, lines[0])
+	}
+	if client.pages < 3 {
+		t.Fatalf("\nExpected at least 3 pages to be fetched\n     Got %d", client.pages)
+	}
+	for _, prefix := range client.prefixes {
+		if prefix != "logs/" {
+			t.Fatalf("\nExpected only objects with the prefix logs/ to be listed\n     Got %q", prefix)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := run("SELECT name, size, time FROM gs://bucket/logs/2021", &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name": "gs://bucket/logs/2021/z.json",
+		"size": 3.0,
+		"time": modified.Format(time.RFC3339),
+	}
+	if len(rows) != 2 || !reflect.DeepEqual(rows[1], expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows)
+	}
+}
@@ -0,0 +1,174 @@
+//go:build git
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["git"] = newGitVFS
+}
+
+// A gitVFS is the tree of HEAD's commit in a Git repository, in which paths
+// are URLs of the form git:///path/to/repo.git/file. The time of each file is
+// the time of the last commit which changed it, and its commit is that
+// commit's hash.
+type gitVFS struct {
+	root  string // The source of the repository, e.g. git:///path/to/repo.git.
+	tree  *object.Tree
+	files map[string]os.FileInfo // The files of the tree, by their paths.
+	opts  query.QueryOptions
+}
+
+// Return the filesystem of the Git repository at the source's path (either a
+// bare repository or a working tree), which is git:// followed by the path.
+func newGitVFS(src string, opts query.QueryOptions) (vfs, error) {
+	root := strings.TrimSuffix(src, "/")
+	dir := root[len("git://"):]
+
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening Git repository %s: %v", dir, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("resolving HEAD of %s: %v", dir, err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gitVFS{root: root, tree: tree, files: make(map[string]os.FileInfo), opts: opts}
+	if err := g.readCommits(repo, commit); err != nil {
+		return nil, fmt.Errorf("reading history of %s: %v", dir, err)
+	}
+	return g, nil
+}
+
+// Read the files of HEAD's tree, along with the last commit which changed
+// each of them, by walking the history from head (following the first parent
+// of merges) until every file's commit is found.
+func (g *gitVFS) readCommits(repo *git.Repository, head *object.Commit) error {
+	sizes := make(map[string]int64)
+	err := g.tree.Files().ForEach(func(f *object.File) error {
+		sizes[f.Name] = f.Size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	found := func(name string, c *object.Commit) {
+		size, ok := sizes[name]
+		if _, seen := g.files[name]; !ok || seen {
+			return
+		}
+		g.files[name] = commitInfo{
+			FileInfo: objectInfo{name: path.Base(name), size: size, modTime: c.Committer.When},
+			commit:   c.Hash.String(),
+		}
+	}
+
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash})
+	if err != nil {
+		return err
+	}
+	return commits.ForEach(func(c *object.Commit) error {
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+
+		if c.NumParents() == 0 {
+			return tree.Files().ForEach(func(f *object.File) error {
+				found(f.Name, c)
+				return nil
+			})
+		}
+
+		parent, err := c.Parent(0)
+		if err != nil {
+			return err
+		}
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			found(change.To.Name, c)
+		}
+
+		if len(g.files) == len(sizes) {
+			return storer.ErrStop
+		}
+		return nil
+	})
+}
+
+// Return the path of p in the tree, without leading or trailing slashes.
+func (g *gitVFS) name(p string) string {
+	return strings.Trim(strings.TrimPrefix(p, g.root), "/")
+}
+
+func (g *gitVFS) Stat(p string) (os.FileInfo, error) {
+	name := g.name(p)
+	if name == "" {
+		return impliedDir(path.Base(g.root)), nil
+	}
+	if info, ok := g.files[name]; ok {
+		return info, nil
+	}
+	for file := range g.files {
+		if strings.HasPrefix(file, name+"/") {
+			return impliedDir(path.Base(name)), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (g *gitVFS) Open(p string) (io.ReadCloser, error) {
+	f, err := g.tree.File(g.name(p))
+	if err != nil {
+		return nil, err
+	}
+	return f.Reader()
+}
+
+// Walk the files of the tree below root.
+func (g *gitVFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := g.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	root = strings.TrimSuffix(root, "/")
+
+	prefix := bucketPrefix(g.name(root))
+	listing := make(map[string]os.FileInfo)
+	for name, info := range g.files {
+		if strings.HasPrefix(name, prefix) {
+			listing[strings.TrimPrefix(name, prefix)] = info
+		}
+	}
+	return walkListing(root, info, listing, g.opts, fn)
+}
@@ -0,0 +1,120 @@
+//go:build git
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestGit(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit the files, and return the commit's hash.
+	commit := func(when time.Time, files map[string]string) string {
+		for name, contents := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := wt.Add(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		hash, err := wt.Commit("commit", &git.CommitOptions{
+			Author: &object.Signature{Name: "fsql", Email: "fsql@example.com", When: when},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash.String()
+	}
+
+	first := commit(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{
+		"main.go":        "package main",
+		"README.md":      "# fsql",
+		"query/lexer.go": "package query",
+	})
+	second := commit(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), map[string]string{
+		"main.go":         "package main\n\nfunc main() {}",
+		"query/parser.go": "package query",
+	})
+
+	src := "git://" + dir
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			query: "SELECT name, size, commit FROM " + src + " WHERE name LIKE %.go",
+			expected: []string{
+				src + "/main.go\t28\t" + second,
+				src + "/query/lexer.go\t13\t" + first,
+				src + "/query/parser.go\t13\t" + second,
+			},
+		},
+		{
+			query:    "SELECT name, time FROM " + src + " WHERE time < 'Jan 15 2024 00 00' AND file IS reg",
+			expected: []string{src + "/README.md\tJan  1 00:00:00", src + "/query/lexer.go\tJan  1 00:00:00"},
+		},
+		{
+			query:    "SELECT name FROM " + src + "/query",
+			expected: []string{src + "/query", src + "/query/lexer.go", src + "/query/parser.go"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	// The files should be the same as git's own listing of HEAD's tree.
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	out, err := exec.Command("git", "-C", dir, "ls-tree", "-r", "HEAD", "--name-only").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Fields(string(out))
+	sort.Strings(expected)
+
+	actual, err := runLines("SELECT name FROM "+src+" WHERE file IS reg", &options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range actual {
+		actual[i] = strings.TrimPrefix(actual[i], src+"/")
+	}
+	sort.Strings(actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+}
@@ -0,0 +1,153 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kshvmdn/fsql/fsqlpb"
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	grpcServe = serveGRPC
+}
+
+// The gRPC service, which evaluates queries within the same limits as the
+// HTTP server.
+type grpcServer struct {
+	fsqlpb.UnimplementedFsqlServer
+	s *server
+}
+
+// Serve the gRPC service on addr.
+func serveGRPC(addr string, s *server) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return newGRPCServer(s).Serve(lis)
+}
+
+// Create a gRPC server with the service registered.
+func newGRPCServer(s *server) *grpc.Server {
+	gs := grpc.NewServer()
+	fsqlpb.RegisterFsqlServer(gs, &grpcServer{s: s})
+	return gs
+}
+
+// Evaluate the query and send each of its results as a separate message. If
+// there are more than maxResults results, the rest aren't sent. The results
+// of a query which may be streamed (see canStream) are sent as soon as
+// they're found, rather than once they've all been.
+func (g *grpcServer) Query(req *fsqlpb.QueryRequest, stream fsqlpb.Fsql_QueryServer) error {
+	q, qopts, err := g.s.prepare(req.Query)
+	if err != nil {
+		return grpcError(err)
+	}
+	if q.Raise != nil {
+		return nil
+	}
+
+	if !canStream(q) {
+		q, results, _, err := g.s.evaluate(stream.Context(), q, qopts)
+		if err != nil {
+			return grpcError(err)
+		}
+		for _, r := range results {
+			if err := stream.Send(newQueryResult(q.Columns, r)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(stream.Context(), g.s.timeout)
+	defer cancel()
+
+	release, err := g.s.acquire(ctx)
+	if err != nil {
+		return grpcError(err)
+	}
+	defer release()
+
+	limit := g.s.maxResults
+	if q.Limit >= 0 && q.Limit < limit {
+		limit = q.Limit
+	}
+	skipped, sent := 0, 0
+	evalErr := streamWith(ctx, q, qopts, nil, func(r result) bool {
+		if err != nil || sent >= limit {
+			return false
+		}
+		if skipped < q.Offset {
+			skipped++
+			return true
+		}
+
+		if err = stream.Send(newQueryResult(q.Columns, r)); err != nil {
+			return false
+		}
+		sent++
+		return sent < limit
+	})
+	if err != nil {
+		return err
+	}
+	if evalErr != nil {
+		return grpcError(evalErr)
+	}
+	// The results which were sent before the query timed out are incomplete.
+	if ctx.Err() != nil && sent < limit {
+		return grpcError(errTimeout)
+	}
+
+	return nil
+}
+
+// Convert an error of server.run to a gRPC status.
+func grpcError(err error) error {
+	switch err {
+	case errTooManyQueries:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errTimeout:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// Convert the result to a message, with the same values as the json format.
+func newQueryResult(columns []query.Column, r result) *fsqlpb.QueryResult {
+	msg := &fsqlpb.QueryResult{Values: make([]*fsqlpb.Value, 0, len(columns))}
+
+	for i, c := range columns {
+		value := &fsqlpb.Value{Name: c.Name()}
+
+		switch v := r.column(i, c).(type) {
+		case os.FileMode:
+			value.Kind = &fsqlpb.Value_StringValue{StringValue: v.String()}
+		case time.Time:
+			value.Kind = &fsqlpb.Value_StringValue{StringValue: v.Format(time.RFC3339Nano)}
+		case string:
+			if v != "" || !columnType(c).nullable {
+				value.Kind = &fsqlpb.Value_StringValue{StringValue: v}
+			}
+		case int64:
+			value.Kind = &fsqlpb.Value_IntValue{IntValue: v}
+		case int:
+			value.Kind = &fsqlpb.Value_IntValue{IntValue: int64(v)}
+		}
+
+		msg.Values = append(msg.Values, value)
+	}
+
+	return msg
+}
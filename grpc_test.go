@@ -0,0 +1,104 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/kshvmdn/fsql/fsqlpb"
+)
+
+func TestGRPC(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "aaa", "b": "b", "c.md": "cc"})
+
+	lis := bufconn.Listen(1 << 20)
+	gs := newGRPCServer(newServer(time.Minute, 2, 1))
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Receive each of the results of the query, as the lines of the default
+	// format.
+	receive := func(input string) ([]string, error) {
+		stream, err := fsqlpb.NewFsqlClient(conn).Query(context.Background(),
+			&fsqlpb.QueryRequest{Query: input})
+		if err != nil {
+			return nil, err
+		}
+
+		lines := make([]string, 0)
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return lines, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			row := ""
+			for i, v := range msg.Values {
+				if i > 0 {
+					row += "\t"
+				}
+				switch kind := v.Kind.(type) {
+				case *fsqlpb.Value_StringValue:
+					row += kind.StringValue
+				case *fsqlpb.Value_IntValue:
+					row += fmt.Sprint(kind.IntValue)
+				}
+			}
+			lines = append(lines, row)
+		}
+	}
+
+	// The results must match those of the same query on the command line,
+	// whether they're sorted (and sent once they've all been found) or
+	// streamed, up to the server's maximum.
+	for _, input := range []string{
+		"SELECT name, size, ext FROM " + dir + " WHERE file IS reg ORDER BY size LIMIT 2",
+		"SELECT name, size, ext FROM " + dir + " WHERE file IS reg AND name <> b",
+		"SELECT name FROM " + dir + " WHERE file IS reg LIMIT 1 OFFSET 1",
+		"SELECT name FROM " + dir + " WHERE file IS reg QUALIFY size > 1",
+	} {
+		actual, err := receive(input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+		}
+		expected, err := runLines(input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected, actual)
+		}
+	}
+
+	actual, err := receive("SELECT name FROM " + dir + " WHERE file IS reg")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(actual) != 2 {
+		t.Fatalf("\nExpected the first 2 results\n     Got %q", actual)
+	}
+
+	if _, err := receive("SELECT name FROM . WHERE size >"); err == nil {
+		t.Fatalf("\nExpected error for an invalid query")
+	}
+}
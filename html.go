@@ -0,0 +1,79 @@
+package main
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The title of the HTML page when -title isn't set.
+const defaultHTMLTitle = "fsql"
+
+// The page written by the html format. The template escapes every value, so
+// file names can't inject markup.
+var htmlTemplate = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8"/>
+<title>{{.Title}}</title>
+{{- if .Style}}
+<style>
+table { border-collapse: collapse; font-family: sans-serif; font-size: 14px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; }
+th { background: #f4f4f4; }
+tbody tr:nth-child(even) { background: #fafafa; }
+</style>
+{{- end}}
+</head>
+<body>
+<table>
+<thead>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+</thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+</body>
+</html>
+`))
+
+// Write an HTML page with a table of the results (with the default title and
+// style).
+func formatHTML(w io.Writer, columns []query.Column, results []result) error {
+	return writeHTML(w, columns, results, defaultHTMLTitle, true)
+}
+
+// Write an HTML page with the title, and a table with a header row of the
+// column names and a row per result. If style is set, the page includes a
+// stylesheet for the table.
+func writeHTML(w io.Writer, columns []query.Column, results []result, title string,
+	style bool) error {
+	data := struct {
+		Title  string
+		Style  bool
+		Header []string
+		Rows   [][]string
+	}{
+		Title:  title,
+		Style:  style,
+		Header: make([]string, 0, len(columns)),
+		Rows:   make([][]string, 0, len(results)),
+	}
+
+	for _, c := range columns {
+		data.Header = append(data.Header, c.Name())
+	}
+	for _, r := range results {
+		row := make([]string, 0, len(columns))
+		for i, c := range columns {
+			row = append(row, formatValue(r.column(i, c)))
+		}
+		data.Rows = append(data.Rows, row)
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The name of the file which a source's name index (see nameIndex) is stored
+// in, at the root of the source.
+const indexFile = ".fsql_index"
+
+// The number of bits of a bloom filter per element, and the number of hashes
+// of each element, which give a false positive rate of about 1%.
+const (
+	bloomBitsPerElement = 10
+	bloomHashes         = 7
+)
+
+// A bloomFilter is a set of strings which may report that it contains a string
+// it doesn't (a false positive), but never that it doesn't contain one it does.
+type bloomFilter struct {
+	Bits []uint64 `json:"bits"`
+}
+
+// Return an empty filter sized for n strings.
+func newBloomFilter(n int) *bloomFilter {
+	words := (n*bloomBitsPerElement + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+	return &bloomFilter{Bits: make([]uint64, words)}
+}
+
+// Return the positions of the bits of s, by double hashing the two halves of
+// its FNV-1a hash.
+func (f *bloomFilter) positions(s string) [bloomHashes]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	var positions [bloomHashes]uint64
+	m := uint64(len(f.Bits)) * 64
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % m
+	}
+	return positions
+}
+
+// Add s to the filter.
+func (f *bloomFilter) add(s string) {
+	for _, n := range f.positions(s) {
+		f.Bits[n/64] |= 1 << (n % 64)
+	}
+}
+
+// Return false iff the filter doesn't contain s.
+func (f *bloomFilter) mayContain(s string) bool {
+	for _, n := range f.positions(s) {
+		if f.Bits[n/64]&(1<<(n%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Return the distinct trigrams of the lowercased s (none if it's shorter than
+// three bytes). Names are lowercased so that an index may also be used by
+// case-insensitive queries.
+func trigrams(s string) []string {
+	s = strings.ToLower(s)
+	seen := make(map[string]bool)
+	grams := make([]string, 0)
+	for i := 0; i+3 <= len(s); i++ {
+		if gram := s[i : i+3]; !seen[gram] {
+			seen[gram] = true
+			grams = append(grams, gram)
+		}
+	}
+	return grams
+}
+
+// A nameIndex is the index of a source directory (created by REBUILD INDEX),
+// which has a bloom filter of the trigrams of the names of each file below
+// each of its directories, so that a directory whose files can't satisfy a
+// condition on their names isn't walked. The directories are keyed by their
+// paths relative to the source.
+type nameIndex struct {
+	Dirs map[string]*indexedDir `json:"dirs"`
+
+	// The directories which have changed since the index was built (or which
+	// contain one that has), which mustn't be skipped.
+	stale map[string]bool
+}
+
+// An indexedDir is the modification time of a directory at the time it was
+// indexed, and the trigrams of the names of the files below it.
+type indexedDir struct {
+	ModTime int64        `json:"mtime"`
+	Names   *bloomFilter `json:"names"`
+}
+
+// Build the index of the source directory and write it to its indexFile.
+func rebuildIndex(src string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot index %s: not a directory", src)
+	}
+
+	index := &nameIndex{Dirs: make(map[string]*indexedDir)}
+	if _, err := index.add(src, "."); err != nil {
+		return err
+	}
+
+	// Writing the index changes the modification time of the source (when the
+	// file is created), so the time is updated after it's written, and the
+	// index rewritten in place.
+	path := filepath.Join(src, indexFile)
+	for i := 0; i < 2; i++ {
+		contents, err := json.Marshal(index)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			return err
+		}
+		if info, err = os.Stat(src); err != nil {
+			return err
+		}
+		index.Dirs["."].ModTime = info.ModTime().UnixNano()
+	}
+	return nil
+}
+
+// Index the directory at path (rel in the source), and each directory below
+// it, and return the trigrams of the names of the files below it. Symbolic
+// links aren't followed.
+func (index *nameIndex) add(path, rel string) (map[string]bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	names, err := readDirNames(path)
+	if err != nil {
+		return nil, err
+	}
+
+	grams := make(map[string]bool)
+	for _, name := range names {
+		for _, gram := range trigrams(name) {
+			grams[gram] = true
+		}
+
+		child := filepath.Join(path, name)
+		if childInfo, err := os.Lstat(child); err != nil || !childInfo.IsDir() {
+			continue
+		}
+		childGrams, err := index.add(child, filepath.Join(rel, name))
+		if err != nil {
+			return nil, err
+		}
+		for gram := range childGrams {
+			grams[gram] = true
+		}
+	}
+
+	filter := newBloomFilter(len(grams))
+	for gram := range grams {
+		filter.add(gram)
+	}
+	index.Dirs[rel] = &indexedDir{ModTime: info.ModTime().UnixNano(), Names: filter}
+	return grams, nil
+}
+
+// Read the index of the source directory, and find the directories which have
+// changed since it was built. It returns nil if the source has no index.
+func readIndex(src string) (*nameIndex, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(src, indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	index := new(nameIndex)
+	if err := json.Unmarshal(contents, index); err != nil {
+		return nil, fmt.Errorf("invalid index %s: %v", filepath.Join(src, indexFile), err)
+	}
+	if index.Dirs["."] == nil {
+		return nil, fmt.Errorf("invalid index %s: the source isn't indexed", filepath.Join(src, indexFile))
+	}
+
+	// A file which is added to, removed from, or renamed in a directory
+	// changes its modification time, which makes each of the directories
+	// above it stale too.
+	index.stale = make(map[string]bool)
+	for rel, dir := range index.Dirs {
+		if dir.Names == nil || len(dir.Names.Bits) == 0 {
+			return nil, fmt.Errorf("invalid index %s: %s has no filter", filepath.Join(src, indexFile), rel)
+		}
+		info, err := os.Lstat(filepath.Join(src, rel))
+		if err == nil && info.IsDir() && info.ModTime().UnixNano() == dir.ModTime {
+			continue
+		}
+		for ; !index.stale[rel]; rel = filepath.Dir(rel) {
+			index.stale[rel] = true
+			if rel == "." {
+				break
+			}
+		}
+	}
+	return index, nil
+}
+
+// Return the index of the source which the query's condition may use, or nil
+// if there's no index, or nothing it could skip. Only sources on the local
+// filesystem (rather than opts.FS) may be indexed, and the index isn't used
+// when symbolic links are followed, since the files they link to aren't
+// indexed.
+func queryIndex(q *query.Query, src string, opts query.QueryOptions) (*nameIndex, [][]string) {
+	if sourceScheme(src) != "" || opts.FollowSymlinks || opts.FS != nil {
+		return nil, nil
+	}
+	patterns := namePatterns(q.ConditionTree)
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	index, err := readIndex(src)
+	if err != nil || index == nil {
+		return nil, nil
+	}
+	return index, patterns
+}
+
+// Return the trigrams of each name which is required by the condition tree: a
+// name which every file that satisfies it must contain (with name CONTAINS or
+// =), since it's one of the conditions which are combined with AND. Names
+// with fewer than three bytes can't be looked up, so they're left out.
+func namePatterns(root *query.ConditionNode) [][]string {
+	if root == nil {
+		return nil
+	}
+	if root.Condition == nil {
+		if root.Type != query.And {
+			return nil
+		}
+		return append(namePatterns(root.Left), namePatterns(root.Right)...)
+	}
+
+	c := root.Condition
+	if c.Attribute != "name" || c.Argument != "" || c.Negate ||
+		c.Comparator != query.Contains && c.Comparator != query.Equals {
+		return nil
+	}
+	if grams := trigrams(c.Value); len(grams) > 0 {
+		return [][]string{grams}
+	}
+	return nil
+}
+
+// Return true iff none of the files below the directory at path (in the
+// source src) may contain each of the patterns' trigrams, so that it needn't
+// be walked. A directory which isn't indexed, or which is stale, is walked.
+func (index *nameIndex) skips(src, path string, patterns [][]string) bool {
+	rel, err := filepath.Rel(src, path)
+	if err != nil || index.stale[rel] {
+		return false
+	}
+	dir, ok := index.Dirs[rel]
+	if !ok {
+		return false
+	}
+
+	for _, grams := range patterns {
+		for _, gram := range grams {
+			if !dir.Names.mayContain(gram) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Rebuild the index of each of the query's sources (for REBUILD INDEX).
+func rebuildIndexes(q *query.Query) error {
+	for _, src := range q.Sources["include"] {
+		if sourceScheme(src) != "" {
+			return errors.New("REBUILD INDEX can only index local directories")
+		}
+		if err := rebuildIndex(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
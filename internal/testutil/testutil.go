@@ -0,0 +1,169 @@
+// Package testutil provides an in-memory filesystem for fsql's tests, so that
+// they don't depend on the state of the filesystem they're run on.
+package testutil
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultModTime is the modification time of the files of a MockVFS which
+// don't have one.
+var DefaultModTime = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// MockFile is a file of a MockVFS. Its Size is the length of its Contents
+// unless it's set, its Mode is 0644 (or os.ModeDir|0755 for a directory)
+// unless it's set, and its ModTime is DefaultModTime unless it's set. Sys is
+// the value of its information's Sys method (e.g. its owner, as a
+// *syscall.Stat_t).
+type MockFile struct {
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	Sys      interface{}
+	Contents string
+}
+
+// MockVFS is an in-memory file tree, which maps the path of each file to the
+// file. Directories may be files of the tree (with os.ModeDir set in their
+// Mode), or are implied by the paths of the files they contain.
+type MockVFS map[string]MockFile
+
+// FileInfo is the information of a file or directory of a MockVFS.
+type FileInfo struct {
+	name string
+	file MockFile
+}
+
+// Name returns the base name of the file.
+func (i FileInfo) Name() string { return i.name }
+
+// Size returns the file's Size, or the length of its contents.
+func (i FileInfo) Size() int64 {
+	if i.file.Size == 0 {
+		return int64(len(i.file.Contents))
+	}
+	return i.file.Size
+}
+
+// Mode returns the file's Mode, or its default mode.
+func (i FileInfo) Mode() os.FileMode {
+	switch i.file.Mode {
+	case os.ModeDir:
+		return os.ModeDir | 0755
+	case 0:
+		return 0644
+	}
+	return i.file.Mode
+}
+
+// ModTime returns the file's ModTime, or DefaultModTime.
+func (i FileInfo) ModTime() time.Time {
+	if i.file.ModTime.IsZero() {
+		return DefaultModTime
+	}
+	return i.file.ModTime
+}
+
+// IsDir returns true iff the file is a directory.
+func (i FileInfo) IsDir() bool { return i.file.Mode&os.ModeDir != 0 }
+
+// Sys returns the file's Sys.
+func (i FileInfo) Sys() interface{} { return i.file.Sys }
+
+// Contents returns the file's contents, so that they needn't be opened.
+func (i FileInfo) Contents() ([]byte, error) {
+	return []byte(i.file.Contents), nil
+}
+
+// Stat returns the information of the file at path, or os.ErrNotExist if
+// there's no such file or directory.
+func (m MockVFS) Stat(path string) (os.FileInfo, error) {
+	path = filepath.Clean(path)
+	if file, ok := m[path]; ok {
+		return FileInfo{name: filepath.Base(path), file: file}, nil
+	}
+	for name := range m {
+		if strings.HasPrefix(name, path+string(filepath.Separator)) || path == string(filepath.Separator) {
+			return FileInfo{name: filepath.Base(path), file: MockFile{Mode: os.ModeDir}}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// Open opens the file at path for reading its contents.
+func (m MockVFS) Open(path string) (io.ReadCloser, error) {
+	file, ok := m[filepath.Clean(path)]
+	if !ok || file.Mode.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(file.Contents)), nil
+}
+
+// Walk the file tree rooted at root, calling fn for each file or directory in
+// the tree (including root) in lexical order, like filepath.Walk.
+func (m MockVFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := m.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err := m.walk(filepath.Clean(root), info, fn, m.children()); err != filepath.SkipDir {
+		return err
+	}
+	return nil
+}
+
+func (m MockVFS) walk(path string, info os.FileInfo, fn filepath.WalkFunc, children map[string][]string) error {
+	if err := fn(path, info, nil); err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	for _, name := range children[path] {
+		child := filepath.Join(path, name)
+		childInfo, _ := m.Stat(child)
+		if err := m.walk(child, childInfo, fn, children); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the sorted names of the files and directories directly inside each
+// directory of the tree, by the directory's path.
+func (m MockVFS) children() map[string][]string {
+	sets := make(map[string]map[string]bool)
+	for name := range m {
+		for path := name; filepath.Dir(path) != path; path = filepath.Dir(path) {
+			dir := filepath.Dir(path)
+			if sets[dir] == nil {
+				sets[dir] = make(map[string]bool)
+			}
+			sets[dir][filepath.Base(path)] = true
+		}
+	}
+
+	children := make(map[string][]string, len(sets))
+	for dir, set := range sets {
+		names := make([]string, 0, len(set))
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		children[dir] = names
+	}
+	return children
+}
@@ -0,0 +1,96 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	m := MockVFS{
+		"/data/b.txt":       {Contents: "bb"},
+		"/data/a.txt":       {Contents: "a"},
+		"/data/a/z.go":      {},
+		"/data/a/lib/x.go":  {},
+		"/data/empty":       {Mode: os.ModeDir},
+		"/data/c/skipped/y": {},
+		"/other/file":       {},
+	}
+
+	// The walk is in the same order every time, however the map is ordered.
+	for i := 0; i < 10; i++ {
+		paths := make([]string, 0)
+		err := m.Walk("/data", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			if info.Name() == "skipped" {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+
+		expected := []string{
+			"/data", "/data/a", "/data/a/lib", "/data/a/lib/x.go", "/data/a/z.go", "/data/a.txt",
+			"/data/b.txt", "/data/c", "/data/c/skipped", "/data/empty",
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected, paths)
+		}
+	}
+
+	if err := m.Walk("/missing", func(path string, info os.FileInfo, err error) error { return err }); !os.IsNotExist(err) {
+		t.Fatalf("\nExpected a not exist error\n     Got %v", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	m := MockVFS{
+		"/data/a.txt":  {Contents: "aaa", Mode: 0600},
+		"/data/big":    {Size: 1 << 20},
+		"/data/sub/x":  {},
+		"/data/subdir": {Mode: os.ModeDir | 0700},
+	}
+
+	type Case struct {
+		path string
+		size int64
+		mode os.FileMode
+	}
+
+	cases := []Case{
+		{"/data/a.txt", 3, 0600},
+		{"/data/big", 1 << 20, 0644},
+		{"/data/sub", 0, os.ModeDir | 0755},
+		{"/data/subdir/", 0, os.ModeDir | 0700},
+	}
+
+	for _, c := range cases {
+		info, err := m.Stat(c.path)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if info.Size() != c.size || info.Mode() != c.mode || !info.ModTime().Equal(DefaultModTime) {
+			t.Fatalf("%s\nExpected %d %v %v\n     Got %d %v %v", c.path, c.size, c.mode, DefaultModTime,
+				info.Size(), info.Mode(), info.ModTime())
+		}
+	}
+
+	f, err := m.Open("/data/a.txt")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	defer f.Close()
+	if contents, err := ioutil.ReadAll(f); err != nil || string(contents) != "aaa" {
+		t.Fatalf("\nExpected %q\n     Got %q %v", "aaa", contents, err)
+	}
+	if _, err := m.Open("/data/sub"); !os.IsNotExist(err) {
+		t.Fatalf("\nExpected a not exist error\n     Got %v", err)
+	}
+}
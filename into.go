@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A destination writer writes the value of each column for each result to the
+// destination of an INTO clause.
+type destinationWriter func(dest *query.Destination, columns []query.Column, results []result) error
+
+// The writer for each INTO format. Formats which require third-party
+// dependencies are only registered when built with their build tag (e.g. the
+// sqlite format in sqlite.go).
+var destinationWriters = map[string]destinationWriter{}
+
+// The build tag which registers each format that isn't built by default.
+var destinationTags = map[string]string{
+	"sqlite":  "sqlite",
+	"parquet": "parquet",
+	"arrow":   "arrow",
+}
+
+// Write the results of the query to the destination of its INTO clause.
+func writeDestination(q *query.Query, results []result) error {
+	writer, ok := destinationWriters[q.Into.Format]
+	if !ok {
+		if tag, ok := destinationTags[q.Into.Format]; ok {
+			return fmt.Errorf("INTO %s requires building with -tags %s",
+				q.Into.Format, tag)
+		}
+		return fmt.Errorf("unknown INTO format: %s", q.Into.Format)
+	}
+
+	return writer(q.Into, q.Columns, results)
+}
+
+// Replace each of the query's sources (and those of its CTEs) which is a
+// variable (e.g. @dir) with the variable's value, and return a function which
+// restores the variables, so that the query may be run again (after GOTO)
+// with their values at that time.
+func bindVariables(q *query.Query, variables map[string]string) (func(), error) {
+	restores := make([]func(), 0)
+	unbind := func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+
+	for _, cte := range q.With {
+		restore, err := bindVariables(cte.Query, variables)
+		if err != nil {
+			unbind()
+			return nil, err
+		}
+		restores = append(restores, restore)
+	}
+	for _, sources := range q.Sources {
+		for i, src := range sources {
+			if !strings.HasPrefix(src, "@") {
+				continue
+			}
+			value, ok := variables[src[1:]]
+			if !ok {
+				unbind()
+				return nil, fmt.Errorf("variable %s isn't set", src)
+			}
+			sources, i, src := sources, i, src
+			sources[i] = value
+			restores = append(restores, func() { sources[i] = src })
+		}
+	}
+	return unbind, nil
+}
+
+// Set the variable of the query's INTO @variable clause to the value of its
+// single column for the first of the results (as it's shown by the default
+// format), or unset it if there are none, so that the queries which use it
+// fail rather than using an earlier value.
+func setVariable(q *query.Query, results []result, variables map[string]string) {
+	if len(results) == 0 {
+		delete(variables, q.Into.Variable)
+		return
+	}
+	variables[q.Into.Variable] = formatValue(results[0].column(0, q.Columns[0]))
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A joined pair of results of the JOIN's left and right sources. The right
+// result is nil for a file of a LEFT JOIN's left source which isn't in any
+// pair.
+type joinedPair [2]*result
+
+// Return the results of the query's JOIN (see query.JoinClause) which satisfy
+// the WHERE clause, sorted by the ORDER BY clause. The value of each of the
+// query's qualified columns is computed from its source's file, and is nil
+// when there isn't one. Each pair is compared with a nested loop, so the JOIN
+// takes time proportional to the product of the sizes of its sources.
+func joinResults(ctx context.Context, q *query.Query, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress, plan *queryPlan) []result {
+	compareFn := compareWith(qopts)
+	join := q.Join
+
+	var sides [2][]result
+	for i, src := range q.Sources["include"] {
+		sides[i] = evaluateSource(ctx, q, src, tables, qopts, prog, plan)
+	}
+
+	// The time spent evaluating a LATERAL subquery is recorded in its own
+	// plan, rather than in the JOIN's.
+	var lateralTime time.Duration
+
+	// Whether each file of the right source is in any pair, for a RIGHT or
+	// FULL JOIN.
+	matchedRight := make([]bool, len(sides[1]))
+
+	start := time.Now()
+	pairs := make([]joinedPair, 0)
+	for i := range sides[0] {
+		if ctx.Err() != nil {
+			break
+		}
+
+		left := &sides[0][i]
+		if join.Lateral != nil {
+			lateralStart := time.Now()
+			sides[1] = evaluate(ctx, correlate(join.Lateral, join.Aliases[0], left), tables, qopts, prog,
+				plan.subqueryPlan())
+			lateralTime += time.Since(lateralStart)
+		}
+
+		matched := false
+		for j := range sides[1] {
+			pair := joinedPair{left, &sides[1][j]}
+			if evaluateJoined(join, join.On, pair, compareFn) {
+				pairs = append(pairs, pair)
+				matched = true
+				if join.Lateral == nil {
+					matchedRight[j] = true
+				}
+			}
+		}
+		if !matched && (join.Type == "left" || join.Type == "full") {
+			pairs = append(pairs, joinedPair{left, nil})
+		}
+	}
+	if join.Type == "right" || join.Type == "full" {
+		for j, matched := range matchedRight {
+			if !matched {
+				pairs = append(pairs, joinedPair{nil, &sides[1][j]})
+			}
+		}
+	}
+	plan.node(joinStep, "").record(len(pairs), time.Since(start)-lateralTime)
+
+	start = time.Now()
+	filtered := pairs[:0]
+	for _, pair := range pairs {
+		if evaluateJoined(join, q.ConditionTree, pair, compareFn) {
+			filtered = append(filtered, pair)
+		}
+	}
+	plan.node(filterStep, "").record(len(filtered), time.Since(start))
+
+	start = time.Now()
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return compareJoined(join, q.OrderBy, filtered[i], filtered[j]) < 0
+	})
+	plan.node(sortStep, "").record(len(filtered), time.Since(start))
+
+	results := make([]result, 0, len(filtered))
+	for _, pair := range filtered {
+		// The file of a result is its left source's, unless it only has a
+		// file of the right source.
+		r := result{computed: make([]interface{}, len(q.Columns))}
+		if first := pair[0]; first != nil || pair[1] != nil {
+			if first == nil {
+				first = pair[1]
+			}
+			r.path, r.info = first.path, first.info
+		}
+		for i, c := range q.Columns {
+			switch {
+			case c.Coalesce != nil:
+				if side, attribute := pair.coalesce(join, c.Coalesce); side != nil {
+					r.computed[i] = side.value(attribute)
+				}
+			case c.Table != "":
+				if side := pair.side(join, c.Table); side != nil {
+					r.computed[i] = side.value(c.Attribute)
+				}
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// Return the result of the first of the qualified attributes (of COALESCE)
+// whose source has a file in the pair, along with the unqualified attribute,
+// or nil if none of them do.
+func (pair joinedPair) coalesce(join *query.JoinClause, attributes []string) (*result, string) {
+	for _, qualified := range attributes {
+		alias, attribute := query.SplitQualified(qualified)
+		if side := pair.side(join, alias); side != nil {
+			return side, attribute
+		}
+	}
+	return nil, ""
+}
+
+// Evaluate the source of the query's JOIN on its own, as if it were the only
+// source of the query, with neither its conditions nor its limit.
+func evaluateSource(ctx context.Context, q *query.Query, src string, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress, plan *queryPlan) []result {
+	side := &query.Query{
+		Sources:        map[string][]string{"include": {src}, "exclude": q.Sources["exclude"]},
+		Values:         q.Values,
+		NestedArchives: q.NestedArchives,
+		Limit:          -1,
+	}
+	var sidePlan *queryPlan
+	if plan != nil {
+		key := planKey{step: scanStep, source: src}
+		sidePlan = &queryPlan{nodes: map[planKey]*planNode{key: plan.node(scanStep, src)}}
+	}
+	return evaluate(ctx, side, tables, qopts, prog, sidePlan)
+}
+
+// Return the results (of the left source of the query's SEMI JOIN) which are
+// in at least one pair which satisfies the ON condition, or those which
+// aren't in any for an ANTI JOIN, in order. When the ON condition only
+// compares the attributes of both sources for equality, the values of the
+// right source's files are hashed, so that each result is looked up once
+// rather than compared with each of them. Otherwise, each result is compared
+// with the right source's files until one satisfies the ON condition.
+func semiJoinResults(ctx context.Context, q *query.Query, results []result, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress, plan *queryPlan) []result {
+	compareFn := compareWith(qopts)
+	join := q.Join
+
+	var right []result
+	switch {
+	case join.Subquery != nil:
+		right = evaluate(ctx, join.Subquery, tables, qopts, prog, plan.subqueryPlan())
+	case join.Lateral == nil:
+		right = evaluateSource(ctx, q, q.Sources["include"][1], tables, qopts, prog, plan)
+	}
+
+	var subqueryTime time.Duration
+	start := time.Now()
+
+	keys, ok := equalityKeys(join)
+	var hashed map[string]bool
+	if ok && join.Lateral == nil {
+		hashed = make(map[string]bool, len(right))
+		for i := range right {
+			if key, ok := joinKey(join, joinedPair{nil, &right[i]}, keys, 1); ok {
+				hashed[key] = true
+			}
+		}
+	}
+
+	kept := make([]result, 0, len(results))
+	for i := range results {
+		if ctx.Err() != nil {
+			break
+		}
+
+		left := &results[i]
+		if join.Lateral != nil {
+			subqueryStart := time.Now()
+			right = evaluate(ctx, correlate(join.Lateral, join.Aliases[0], left), tables, qopts, prog,
+				plan.subqueryPlan())
+			subqueryTime += time.Since(subqueryStart)
+		}
+
+		matched := false
+		if hashed != nil {
+			key, ok := joinKey(join, joinedPair{left, nil}, keys, 0)
+			matched = ok && hashed[key]
+		} else {
+			for j := range right {
+				if evaluateJoined(join, join.On, joinedPair{left, &right[j]}, compareFn) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched == (join.Type == "semi") {
+			kept = append(kept, *left)
+		}
+	}
+	plan.node(joinStep, "").record(len(kept), time.Since(start)-subqueryTime)
+	return kept
+}
+
+// Return the pairs of attributes (of the left and right sources, in that
+// order) which the JOIN's ON condition compares for equality, and true iff
+// ON only compares them (with AND).
+func equalityKeys(join *query.JoinClause) ([][2]string, bool) {
+	if join.On == nil {
+		return nil, false
+	}
+
+	keys := make([][2]string, 0)
+	var collect func(root *query.ConditionNode) bool
+	collect = func(root *query.ConditionNode) bool {
+		if root.Condition == nil {
+			return root.Type == query.And && collect(root.Left) && collect(root.Right)
+		}
+
+		c := root.Condition
+		if c.Negate || c.Argument != "" || c.Comparator != query.Equals && c.Comparator != query.Is {
+			return false
+		}
+		alias, _ := query.SplitQualified(c.Attribute)
+		other, _ := query.SplitQualified(c.Value)
+		switch {
+		case alias == join.Aliases[0] && other == join.Aliases[1]:
+			keys = append(keys, [2]string{c.Attribute, c.Value})
+		case alias == join.Aliases[1] && other == join.Aliases[0]:
+			keys = append(keys, [2]string{c.Value, c.Attribute})
+		default:
+			return false
+		}
+		return true
+	}
+	return keys, collect(join.On)
+}
+
+// Return the values of the pair's side (0 for left, 1 for right) of each of
+// the keys, as a single string, and false if any of them is NULL (which never
+// equals any value).
+func joinKey(join *query.JoinClause, pair joinedPair, keys [][2]string, side int) (string, bool) {
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		v, _ := pair.value(join, key[side])
+		if v == nil {
+			return "", false
+		}
+		// Times are compared to the nanosecond, rather than as they're
+		// formatted.
+		if t, ok := v.(time.Time); ok {
+			v = t.UnixNano()
+		}
+		values = append(values, formatValue(v))
+	}
+	return strings.Join(values, "\x00"), true
+}
+
+// Return a copy of the LATERAL subquery in which each condition whose value is
+// an attribute qualified with alias (e.g. name IS a.name) compares with the
+// value of that attribute for the result r. As when comparing two attributes
+// of a JOIN, IS is the same as =.
+func correlate(lateral *query.Query, alias string, r *result) *query.Query {
+	correlated := *lateral
+	correlated.ConditionTree = correlateTree(lateral.ConditionTree, alias, r)
+	return &correlated
+}
+
+func correlateTree(root *query.ConditionNode, alias string, r *result) *query.ConditionNode {
+	if root == nil {
+		return nil
+	}
+
+	node := *root
+	if root.Condition != nil {
+		condition := *root.Condition
+		if outer, attribute := query.SplitQualified(condition.Value); outer == alias {
+			condition.Value = correlatedValue(r, attribute)
+			if condition.Comparator == query.Is {
+				condition.Comparator = query.Equals
+			}
+		}
+		node.Condition = &condition
+	}
+	node.Left = correlateTree(root.Left, alias, r)
+	node.Right = correlateTree(root.Right, alias, r)
+	return &node
+}
+
+// Return the value of the attribute for the result as a condition's value. The
+// value of name is the file's name (as it's compared by a condition), rather
+// than its path.
+func correlatedValue(r *result, attribute string) string {
+	switch attribute {
+	case "name":
+		return r.info.Name()
+	case "time":
+		return r.info.ModTime().Format(query.TimeLayout)
+	}
+	return formatValue(r.value(attribute))
+}
+
+// Return the result of the pair from the source aliased alias.
+func (pair joinedPair) side(join *query.JoinClause, alias string) *result {
+	if alias == join.Aliases[0] {
+		return pair[0]
+	}
+	return pair[1]
+}
+
+// Return the pair's value of the qualified attribute, and whether it's an
+// attribute of one of the JOIN's sources. The value of name is the file's
+// name (as it's compared by a condition), rather than its path, and the value
+// is nil when the source has no file in the pair.
+func (pair joinedPair) value(join *query.JoinClause, attribute string) (interface{}, bool) {
+	alias, attribute := query.SplitQualified(attribute)
+	if alias != join.Aliases[0] && alias != join.Aliases[1] {
+		return nil, false
+	}
+
+	side := pair.side(join, alias)
+	if side == nil {
+		return nil, true
+	}
+	if attribute == "name" {
+		return side.info.Name(), true
+	}
+	return side.value(attribute), true
+}
+
+// Evaluate the condition tree (either the ON or the WHERE clause) for the
+// pair. A condition whose value is itself a qualified attribute (e.g. a.name =
+// b.name) compares the values of both attributes, other conditions are
+// evaluated like the WHERE clause, for the file of the attribute's source.
+// Neither is satisfied when the source has no file in the pair, except by IS
+// NULL.
+func evaluateJoined(join *query.JoinClause, root *query.ConditionNode, pair joinedPair,
+	compareFn func(query.Condition, string, os.FileInfo) bool) bool {
+	return root.Evaluate(nil, func(c query.Condition, _ os.FileInfo) bool {
+		alias, attribute := query.SplitQualified(c.Attribute)
+		side := pair.side(join, alias)
+
+		if other, ok := pair.value(join, c.Value); ok {
+			v, _ := pair.value(join, c.Attribute)
+			if v == nil || other == nil {
+				return false
+			}
+			retval := compareJoinedValues(c.Comparator, v, other)
+			if c.Negate {
+				return !retval
+			}
+			return retval
+		}
+
+		if side == nil {
+			return cmp.Nullable(c, "", false)
+		}
+		c.Attribute = attribute
+		return compareFn(c, side.path, side.info)
+	})
+}
+
+// Compare the values of two attributes with the comparator: as numbers if
+// they're both sizes, as times if they're both times, and as strings
+// otherwise. IS is the same as =.
+func compareJoinedValues(comparator query.TokenType, a, b interface{}) bool {
+	if comparator == query.Is {
+		comparator = query.Equals
+	}
+
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Numeric(comparator, a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return cmp.Time(comparator, a, b)
+		}
+	}
+	return cmp.Alpha(comparator, formatValue(a), formatValue(b))
+}
+
+// Compare the pairs a and b by each of the qualified orderings (or COALESCE of
+// them) in turn. A source without a file in the pair is ordered first.
+func compareJoined(join *query.JoinClause, orderBy []query.Ordering, a, b joinedPair) int {
+	for _, ordering := range orderBy {
+		alias, attribute := query.SplitQualified(ordering.Attribute)
+		x, y := a.side(join, alias), b.side(join, alias)
+		if ordering.Coalesce != nil {
+			x, attribute = a.coalesce(join, ordering.Coalesce)
+			y, _ = b.coalesce(join, ordering.Coalesce)
+		}
+
+		var c int
+		switch {
+		case x == nil && y == nil:
+			c = 0
+		case x == nil:
+			c = -1
+		case y == nil:
+			c = 1
+		default:
+			c = compareResults(attribute, *x, *y)
+		}
+
+		if ordering.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/fsqlplugin"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Read the contents of the file at path. Tests replace it to count the files
+// which are read.
+var readFile = ioutil.ReadFile
+
+// A lazyFileInfo is the information of a file whose conditions are being
+// evaluated, along with its expensive values: its contents, and the values of
+// functions and plugin attributes. Each is only computed when it's first
+// needed, and at most once, so a condition which is short-circuited never
+// computes them, and conditions which need the same value share it.
+type lazyFileInfo struct {
+	os.FileInfo
+	path  string
+	depth int
+
+	// The largest file whose contents are read (0 for no limit), and where
+	// each larger one is logged (see query.QueryOptions).
+	maxSize int64
+	skipLog io.Writer
+
+	contentsOnce sync.Once
+	contents     []byte
+	contentsErr  error
+
+	mu     sync.Mutex
+	values map[string]*lazyValue
+}
+
+// A lazyValue is a value of a lazyFileInfo, which is computed once. It's NULL
+// unless ok is set.
+type lazyValue struct {
+	once  sync.Once
+	value string
+	ok    bool
+}
+
+// Wrap the information of the file at path (depth levels below its source) in
+// a lazyFileInfo, so that its values are only computed once while its
+// conditions are evaluated with the options.
+func newLazyFileInfo(path string, info os.FileInfo, depth int, opts query.QueryOptions) os.FileInfo {
+	if info == nil {
+		return nil
+	}
+	return &lazyFileInfo{FileInfo: info, path: path, depth: depth, maxSize: opts.MaxFileSize, skipLog: opts.SkipLog,
+		values: make(map[string]*lazyValue)}
+}
+
+// Depth returns how many levels the file is below its source (see
+// compare.DepthInfo).
+func (l *lazyFileInfo) Depth() int {
+	return l.depth
+}
+
+// Contents returns the contents of the file, which are read the first time
+// they're needed (see compare.ContentsInfo), from the file's filesystem if its
+// information has them. They aren't read if the file is larger than maxSize
+// (compare.ErrSkipped is returned instead).
+func (l *lazyFileInfo) Contents() ([]byte, error) {
+	l.contentsOnce.Do(func() {
+		if l.maxSize > 0 && l.Size() > l.maxSize {
+			l.contentsErr = cmp.ErrSkipped
+			if l.skipLog != nil {
+				fmt.Fprintf(l.skipLog, "skipped %s: %d bytes is larger than -max-file-size\n", l.path, l.Size())
+			}
+			return
+		}
+		if c, ok := l.FileInfo.(cmp.ContentsInfo); ok {
+			l.contents, l.contentsErr = c.Contents()
+		} else {
+			l.contents, l.contentsErr = readFile(l.path)
+		}
+	})
+	return l.contents, l.contentsErr
+}
+
+// Return the value of key, which is computed by fn the first time it's needed.
+func (l *lazyFileInfo) value(key string, fn func() (string, bool)) (string, bool) {
+	l.mu.Lock()
+	v, ok := l.values[key]
+	if !ok {
+		v = new(lazyValue)
+		l.values[key] = v
+	}
+	l.mu.Unlock()
+
+	v.once.Do(func() { v.value, v.ok = fn() })
+	return v.value, v.ok
+}
+
+// Return the value of the condition's function for the file at path, which is
+// only computed once (for each of its arguments) if info is a lazyFileInfo.
+func functionValue(condition query.Condition, path string, info os.FileInfo) (string, bool) {
+	fn := func() (string, bool) { return cmp.Function(condition, path) }
+	if l, ok := info.(*lazyFileInfo); ok {
+		return l.value(condition.Attribute+"("+condition.Argument+")"+strconv.Quote(condition.Target), fn)
+	}
+	return fn()
+}
+
+// Return the value of the plugin's attribute for the file at path, which is
+// only computed once if info is a lazyFileInfo (the plugin is passed the
+// file's original information).
+func attributeValue(name string, attr fsqlplugin.AttributeProvider, path string, info os.FileInfo) string {
+	if l, ok := info.(*lazyFileInfo); ok {
+		v, _ := l.value(name, func() (string, bool) { return attr.Value(path, l.FileInfo), true })
+		return v
+	}
+	return attr.Value(path, info)
+}
+
+// Return the relative cost of evaluating the condition: reading the file's
+// contents is the most expensive, then calling a function or a plugin
+// attribute (which may do anything), and comparing an attribute is free.
+func conditionCost(c *query.Condition) int {
+	switch {
+	case c.Attribute == query.ContainsText.String():
+		return 3
+	case cmp.IsFunction(*c):
+		return 2
+	case c.Attribute == "name" || c.Attribute == "size" || c.Attribute == "time" || c.Attribute == "file" ||
+		c.Attribute == "dir" || c.Attribute == "depth":
+		return 0
+	}
+	return 2
+}
+
+// Return a copy of the condition tree in which the operands of each AND and
+// OR are reordered so that the cheaper one (see conditionCost) is evaluated
+// first, and the other is short-circuited whenever the cheaper one decides
+// the result. The conditions have no side effects, so this doesn't change
+// which files satisfy the tree. It also returns the tree's total cost.
+func cheapestFirst(root *query.ConditionNode) (*query.ConditionNode, int) {
+	if root == nil {
+		return nil, 0
+	}
+	if root.Condition != nil {
+		return root, conditionCost(root.Condition)
+	}
+
+	left, leftCost := cheapestFirst(root.Left)
+	right, rightCost := cheapestFirst(root.Right)
+	if rightCost < leftCost {
+		left, right = right, left
+	}
+	return &query.ConditionNode{Type: root.Type, Left: left, Right: right}, leftCost + rightCost
+}
@@ -1,61 +1,269 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/fsqlplugin"
 	"github.com/kshvmdn/fsql/query"
 )
 
-const (
-	version = "0.1.1"
+const version = "0.1.1"
 
-	uBYTE     = 1.0
-	uKILOBYTE = 1024 * uBYTE
-	uMEGABYTE = 1024 * uKILOBYTE
-	uGIGABYTE = 1024 * uMEGABYTE
-)
+// Options represent the command line options which alter how a query is
+// evaluated or how its results are shown.
+type options struct {
+	sortBy    string
+	reverse   bool
+	count     bool
+	format    string
+	progress  bool
+	file      string
+	benchmark int
+	simulate  bool
+
+	noCrossDevice bool
+
+	// The largest file whose contents are read (e.g. by CONTAINS_TEXT), 0 for
+	// no limit, and whether each larger file is logged to stderr.
+	maxFileSize int64
+	verbose     bool
+
+	// The variables set by INTO @variable, by name, which persist between the
+	// queries of a query file. It's nil for a single query.
+	variables map[string]string
+
+	saveBaseline string
+	diff         string
+	jsonSchema   bool
 
-// Used to track which paths we've seen to avoid revisiting a directory.
-var seen = make(map[string]bool, 0)
+	machineReadable bool
+
+	// Options of writing each group of results to a file.
+	outputDir      string
+	groupBy        string
+	outputTemplate string
+
+	// Options of the html format.
+	title   string
+	noStyle bool
+
+	// Whether the tabulate format's borders are drawn with ASCII characters.
+	ascii bool
+
+	// Options of the result cache (see resultCache).
+	cache    bool
+	cacheDir string
+	cacheTTL time.Duration
+}
 
-// Read the command line arguments for the query.
-func readFlags() string {
+// Read the command line arguments for the query and its options.
+func readFlags() (string, *options) {
 	flag.Usage = func() {
-		fmt.Printf("usage: %s [options] query\n", os.Args[0])
+		fmt.Printf("usage: %s [options] query\n       %s [options] -file path\n       %s serve [-addr host] [-port n] [-allow-origin origins]\n"+
+			"       %s index build|rebuild|info dir\n       %s recover complete|rollback\n", os.Args[0], os.Args[0],
+			os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 
+	opts := new(options)
 	versionPtr := flag.Bool("version", false, "print version and exit")
-	flag.Parse()
+	defineFlags(flag.CommandLine, opts)
+
+	flags, args := splitArgs(flag.CommandLine, os.Args[1:])
+	flag.CommandLine.Parse(flags)
 
 	if *versionPtr {
 		fmt.Printf("fsql v%v\n", version)
 		os.Exit(0)
 	}
 
-	if len(flag.Args()) == 0 {
+	if (len(args) == 0) == (opts.file == "") {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if len(flag.Args()) > 1 {
-		return strings.Join(flag.Args(), " ")
+	if len(args) == 0 {
+		return "", opts
+	}
+
+	if len(args) > 1 {
+		return strings.Join(args, " "), opts
+	}
+
+	return args[0], opts
+}
+
+// Define the query options on fs, storing their values in opts.
+func defineFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.sortBy, "sort-by", "", "sort results by `attribute` (same as ORDER BY)")
+	fs.BoolVar(&opts.reverse, "reverse", false, "sort results in descending order (requires -sort-by)")
+	fs.BoolVar(&opts.count, "count", false, "print the number of results instead of the results")
+	fs.StringVar(&opts.format, "format", "default", "output `format` (default, json, markdown, html, xml, tabulate, compact, toml, or yaml)")
+	fs.StringVar(&opts.file, "file", "", "run each of the semicolon-separated queries in the file at `path`")
+	fs.BoolVar(&opts.progress, "progress", false, "show the number of files visited on stderr (only when stderr is a terminal)")
+	fs.StringVar(&opts.saveBaseline, "save-baseline", "", "save the paths of the results to the baseline file at `path`")
+	fs.StringVar(&opts.diff, "diff", "", "print the results added (+) or removed (-) since the baseline file at `path` was saved")
+	fs.BoolVar(&opts.machineReadable, "machine-readable", false, "terminate each result with a NUL byte instead of a newline (like find -print0)")
+	fs.StringVar(&opts.title, "title", "", "the page `title` of the html format (default \"fsql\")")
+	fs.BoolVar(&opts.noStyle, "no-style", false, "don't include a stylesheet in the html format")
+	fs.BoolVar(&opts.ascii, "ascii", false, "draw the borders of the tabulate format with -, |, and + rather than box-drawing characters")
+	fs.BoolVar(&opts.jsonSchema, "json-schema", false, "print the JSON Schema of the results in the json format instead of the results")
+	fs.StringVar(&opts.outputDir, "output-dir", "", "write the results of each group (see -group-by) to a CSV file in `dir`")
+	fs.StringVar(&opts.groupBy, "group-by", "", "group the results written to -output-dir by `attribute`")
+	fs.StringVar(&opts.outputTemplate, "output-filename-template", defaultOutputTemplate,
+		"the `template` of the name of each group's file in -output-dir")
+	fs.IntVar(&opts.benchmark, "benchmark", 0, "run the query `n` times and print its timing (in Go benchmark format) instead of the results")
+	fs.BoolVar(&opts.noCrossDevice, "no-cross-device", false, "don't walk directories on a different device than their source (like find -xdev)")
+	fs.Func("max-file-size", "don't read the contents of files larger than `size` (e.g. 10mb), whose CONTAINS_TEXT is NULL", func(s string) error {
+		size, err := cmp.ParseSize(s)
+		if err == nil && size <= 0 {
+			err = errors.New("must be positive")
+		}
+		opts.maxFileSize = size
+		return err
+	})
+	fs.BoolVar(&opts.verbose, "verbose", false, "print each file whose contents aren't read (see -max-file-size) on stderr")
+	fs.BoolVar(&opts.simulate, "simulate", false, "print the I/O operations the query performs (without reading any file's contents) instead of the results")
+	fs.BoolVar(&opts.cache, "cache", false, "read the output from the cache in -cache-dir if it's cached, and cache it otherwise")
+	fs.StringVar(&opts.cacheDir, "cache-dir", defaultCacheDir(), "the `dir` of the result cache (see -cache)")
+	fs.DurationVar(&opts.cacheTTL, "cache-ttl", 5*time.Minute, "how long the cached output of a query is kept for (see -cache)")
+}
+
+// Separate the flags defined in fs from the rest of args, so that options may
+// be provided either before or after the query. Arguments which look like
+// flags but aren't defined (e.g. the `-.git` in `FROM ., -.git`) are left as
+// part of the query, except for -h and -help (which the flag package handles
+// by showing the usage).
+func splitArgs(fs *flag.FlagSet, args []string) (flags []string, rest []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return flags, append(rest, args[i+1:]...)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if len(name) == len(arg) || len(arg)-len(name) > 2 {
+			rest = append(rest, arg)
+			continue
+		}
+
+		hasValue := false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, hasValue = name[:j], true
+		}
+
+		f := fs.Lookup(name)
+		if f == nil && (name == "h" || name == "help") && !hasValue {
+			flags = append(flags, arg)
+			continue
+		}
+		if f == nil {
+			rest = append(rest, arg)
+			continue
+		}
+
+		flags = append(flags, arg)
+		if b, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		}); ok && b.IsBoolFlag() {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+
+	return flags, rest
+}
+
+// Apply the command line options to the parsed query.
+func applyOptions(q *query.Query, opts *options) error {
+	if opts.sortBy == "" {
+		if opts.reverse {
+			return errors.New("-reverse requires -sort-by")
+		}
+		return nil
+	}
+
+	if len(q.OrderBy) > 0 {
+		return errors.New("-sort-by cannot be used with an ORDER BY clause")
+	}
+
+	if !query.IsAttribute(opts.sortBy) {
+		return &query.ErrUnknownToken{Raw: opts.sortBy}
 	}
 
-	return flag.Args()[0]
+	q.OrderBy = []query.Ordering{{Attribute: opts.sortBy, Desc: opts.reverse}}
+	return nil
+}
+
+// Return the function used to evaluate each condition for the file at a path
+// with the provided options.
+func compareWith(opts query.QueryOptions) func(query.Condition, string, os.FileInfo) bool {
+	if !opts.CaseInsensitive {
+		return compare
+	}
+
+	// The conditions of a CASE expression are also case-insensitive.
+	var compareFn func(query.Condition, string, os.FileInfo) bool
+	compareFn = func(condition query.Condition, path string, file os.FileInfo) bool {
+		if condition.Case != nil {
+			return cmp.Value(condition, evaluateCase(condition.Case, path, file, compareFn))
+		}
+		if condition.Attribute != "name" && condition.Attribute != "contains_text" {
+			return compare(condition, path, file)
+		}
+
+		var retval bool
+		if condition.Attribute == "contains_text" {
+			var ok bool
+			if retval, ok = cmp.ContainsText(path, file, condition.Argument, true); !ok {
+				return false
+			}
+		} else if condition.Comparator == query.RLike {
+			retval = cmp.Alpha(condition.Comparator, file.Name(), "(?i)"+condition.Value)
+		} else {
+			retval = cmp.Alpha(condition.Comparator, strings.ToLower(file.Name()),
+				strings.ToLower(condition.Value))
+		}
+
+		if condition.Negate {
+			return !retval
+		}
+		return retval
+	}
+	return compareFn
 }
 
 // Runs the appropriate cmp method for the provided condition.
-func compare(condition query.Condition, file os.FileInfo) bool {
+func compare(condition query.Condition, path string, file os.FileInfo) bool {
+	if condition.Case != nil {
+		return cmp.Value(condition, evaluateCase(condition.Case, path, file, compare))
+	}
+	if condition.Expression != nil {
+		return cmp.Expression(condition, evaluateExpression(condition.Expression, fileResult(path, file).value))
+	}
+	if cmp.IsFunction(condition) {
+		v, ok := functionValue(condition, path, file)
+		return cmp.Nullable(condition, v, ok)
+	}
+
 	var retval bool
 
 	switch condition.Attribute {
@@ -63,32 +271,14 @@ func compare(condition query.Condition, file os.FileInfo) bool {
 		retval = cmp.Alpha(condition.Comparator, file.Name(), condition.Value)
 
 	case "size":
-		mult := uBYTE
-
-		if len(condition.Value) > 2 {
-			unit := strings.ToLower(condition.Value[len(condition.Value)-2:])
-			switch unit {
-			case "kb":
-				mult = uKILOBYTE
-			case "mb":
-				mult = uMEGABYTE
-			case "gb":
-				mult = uGIGABYTE
-			}
-
-			if mult > 1 {
-				condition.Value = condition.Value[:len(condition.Value)-2]
-			}
-		}
-
-		size, err := strconv.ParseFloat(condition.Value, 64)
+		size, err := cmp.ParseSize(condition.Value)
 		if err != nil {
 			return false
 		}
-		retval = cmp.Numeric(condition.Comparator, file.Size(), int64(size*mult))
+		retval = cmp.Numeric(condition.Comparator, file.Size(), size)
 
 	case "time":
-		t, err := time.Parse("Jan 02 2006 15 04", condition.Value)
+		t, err := cmp.ParseTime(condition.Value)
 		if err != nil {
 			return false
 		}
@@ -96,6 +286,27 @@ func compare(condition query.Condition, file os.FileInfo) bool {
 
 	case "file":
 		retval = cmp.File(condition.Comparator, file, condition.Value)
+
+	case "dir":
+		retval = cmp.Alpha(condition.Comparator, filepath.Dir(path), condition.Value)
+
+	case "depth":
+		depth, err := strconv.ParseInt(condition.Value, 10, 64)
+		if err != nil {
+			return false
+		}
+		retval = cmp.Numeric(condition.Comparator, cmp.Depth(file), depth)
+
+	case "contains_text":
+		var ok bool
+		if retval, ok = cmp.ContainsText(path, file, condition.Argument, false); !ok {
+			return false
+		}
+
+	default:
+		if attr, ok := fsqlplugin.LookupAttribute(condition.Attribute); ok {
+			retval = cmp.Alpha(condition.Comparator, attributeValue(condition.Attribute, attr, path, file), condition.Value)
+		}
 	}
 
 	if condition.Negate {
@@ -116,62 +327,755 @@ func containsAny(exclusions []string, path string) bool {
 	return false
 }
 
-func main() {
-	input := readFlags()
+// A result represents a single file matched by the query.
+type result struct {
+	path string
+	info os.FileInfo
+
+	// How many levels the file is below the source it was found in.
+	depth int
+
+	// Values of the query's computed (i.e. non-attribute) columns, indexed
+	// by column.
+	computed []interface{}
+}
+
+// Compares a and b by attribute, returning a negative number when a is ordered
+// before b, a positive number when a is ordered after b, and zero otherwise.
+func compareResults(attribute string, a, b result) int {
+	switch attribute {
+	case "name":
+		return strings.Compare(a.info.Name(), b.info.Name())
+
+	case "dir":
+		return strings.Compare(filepath.Dir(a.path), filepath.Dir(b.path))
+
+	case "depth":
+		return a.depth - b.depth
 
+	case "ext":
+		return strings.Compare(filepath.Ext(a.info.Name()), filepath.Ext(b.info.Name()))
+
+	case "size":
+		return compareInt(a.info.Size(), b.info.Size())
+
+	case "time":
+		return compareInt(a.info.ModTime().UnixNano(), b.info.ModTime().UnixNano())
+
+	case "mode":
+		return compareInt(int64(a.info.Mode()), int64(b.info.Mode()))
+
+	case "tar_offset":
+		// Files which aren't members of a TAR archive are ordered first.
+		aOffset, aOK := tarOffset(a.info)
+		bOffset, bOK := tarOffset(b.info)
+		if aOK != bOK {
+			if aOK {
+				return 1
+			}
+			return -1
+		}
+		return compareInt(aOffset, bOffset)
+
+	case "commit":
+		// Files which aren't in a Git repository have an empty commit, so
+		// they're ordered first.
+		aCommit, _ := fileCommit(a.info)
+		bCommit, _ := fileCommit(b.info)
+		return strings.Compare(aCommit, bCommit)
+
+	default:
+		if attr, ok := fsqlplugin.LookupAttribute(attribute); ok {
+			return strings.Compare(attr.Value(a.path, a.info), attr.Value(b.path, b.info))
+		}
+	}
+
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Compares a and b by each of the orderings in turn, returning a negative
+// number when a is ordered before b, a positive number when a is ordered after
+// b, and zero otherwise.
+func compareOrdering(orderBy []query.Ordering, a, b result) int {
+	for _, ordering := range orderBy {
+		c := compareResults(ordering.Attribute, a, b)
+		if ordering.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// Sort the results in place by each of the provided orderings.
+func sortResults(results []result, orderBy []query.Ordering) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return compareOrdering(orderBy, results[i], results[j]) < 0
+	})
+}
+
+// Parse and run the query and write its results to w. Progress, if enabled, is written
+// to errw.
+func run(input string, opts *options, w, errw io.Writer) error {
 	q, err := query.RunParser(input)
 	if err != nil {
-		if err == io.ErrUnexpectedEOF {
-			log.Fatal("Unexpected end of line")
+		return err
+	}
+
+	return runQuery(q, opts, w, errw)
+}
+
+// Run each query of a query file in order, writing a header before the
+// results of each query.
+func runFile(input string, opts *options, w, errw io.Writer) error {
+	file, err := query.RunFileParser(input)
+	if err != nil {
+		return err
+	}
+
+	session := *opts
+	session.variables = make(map[string]string)
+	opts = &session
+
+	labels := make(map[string]int)
+	for i, q := range file.Queries {
+		if q.Label != "" {
+			labels[q.Label] = i
 		}
-		log.Fatal(err)
 	}
 
-	for _, src := range q.Sources["include"] {
-		filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-			if path == "." || path == ".." || err != nil {
-				return nil
+	// The failed assertions are reported together, after each of the queries
+	// has been run (or along with the error which stops the file). The queries
+	// are run in order, except that a GOTO (whose IF condition is true)
+	// continues at its label's position, so each statement that's run is a
+	// step, and there may be at most maxSteps.
+	failures := make([]string, 0)
+	stop := func(err error) error {
+		return errors.New(strings.Join(append(failures, err.Error()), "\n"))
+	}
+	shown := false
+	for i, steps := 0, 1; i < len(file.Queries); i, steps = i+1, steps+1 {
+		q := file.Queries[i]
+		if steps > maxSteps {
+			return stop(fmt.Errorf("query %d: stopped after %d steps, since GOTO may be looping forever", i+1, maxSteps))
+		}
+		if q.Label != "" {
+			continue
+		}
+		if q.Goto != "" {
+			jump, err := jumps(q, opts)
+			if err != nil {
+				return stop(fmt.Errorf("query %d: %v", i+1, err))
+			}
+			if jump {
+				label, ok := labels[q.Goto]
+				if !ok {
+					return stop(fmt.Errorf("query %d: label %s isn't defined", i+1, q.Goto))
+				}
+				i = label
 			}
+			continue
+		}
 
-			if _, ok := seen[path]; ok {
-				return nil
+		if shown {
+			fmt.Fprintln(w)
+		}
+		shown = true
+		fmt.Fprintf(w, "==> query %d <==\n", i+1)
+
+		if err := runQuery(q, opts, w, errw); err != nil {
+			var failed *assertionError
+			if errors.As(err, &failed) {
+				failures = append(failures, fmt.Sprintf("query %d: %v", i+1, err))
+				continue
 			}
-			seen[path] = true
+			return stop(fmt.Errorf("query %d: %v", i+1, err))
+		}
+	}
 
-			// If this path is excluded or the condition is false, return.
-			if containsAny(q.Sources["exclude"], path) ||
-				!q.ConditionTree.Evaluate(info, compare) {
-				return nil
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// Evaluate the query, along with each of its CTEs, with the provided options
+// and return its results, or an error if one of its expressions overflows.
+func evaluateWith(ctx context.Context, q *query.Query, qopts query.QueryOptions,
+	prog *progress) (results []result, err error) {
+	defer recoverEvaluation(&err)
+
+	// Materialize each CTE, in order, so that it may be used as a source by
+	// the CTEs and query which follow it.
+	tables := make(map[string][]result, len(q.With))
+	for _, cte := range q.With {
+		tables[cte.Name] = evaluate(ctx, cte.Query, tables, qopts, prog, nil)
+	}
+	return evaluate(ctx, q, tables, qopts, prog, nil), nil
+}
+
+// Evaluate the query with the provided options and return its sorted results.
+// Sources which name one of tables are read from that table rather than the
+// filesystem. If plan isn't nil, the results and time taken by each step of
+// the evaluation are recorded in it. The walk stops as soon as ctx is done, in
+// which case the results are incomplete (callers must check ctx.Err()).
+func evaluate(ctx context.Context, q *query.Query, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress, plan *queryPlan) []result {
+	return evaluateEach(ctx, q, tables, qopts, prog, plan, nil)
+}
+
+// Evaluate the query like evaluate, but if emit isn't nil, pass it each file
+// which satisfies the condition as soon as it's found, rather than keeping it
+// to return, so the query mustn't need each of its results at once (see
+// canStream).
+func evaluateEach(ctx context.Context, q *query.Query, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress, plan *queryPlan, emit func(result)) []result {
+	compareFn := compareWith(qopts)
+	tree, _ := cheapestFirst(q.ConditionTree)
+	pushed := pushDownPredicates(q.ConditionTree)
+	quantified := evaluateQuantified(ctx, q.ConditionTree, tables, qopts, prog)
+
+	// Used to track which paths we've seen to avoid revisiting a directory.
+	// A single source which is walked never has the same path twice, so
+	// they're only tracked when there are others.
+	var seen map[string]bool
+	results := make([]result, 0)
+
+	// How many results the current source produced, and how long was spent
+	// evaluating the condition in total.
+	var scanned int
+	var filterTime time.Duration
+
+	// The text index of the current source, which rules out the files that
+	// can't satisfy a CONTAINS_TEXT condition without reading them.
+	var texts *textFilter
+
+	// Add the result iff it isn't excluded and satisfies the condition.
+	add := func(r result) {
+		if containsAny(q.Sources["exclude"], r.path) {
+			return
+		}
+		scanned++
+
+		start := time.Now()
+		info := newLazyFileInfo(r.path, r.info, r.depth, qopts)
+		ok := tree.Evaluate(info, func(c query.Condition, info os.FileInfo) bool {
+			if c.Attribute == "contains_text" && texts.excludes(c.Argument, r.path, info) {
+				return c.Negate
 			}
+			if c.Subquery != nil {
+				return compareQuantified(c, quantified[c.Subquery], r.path, info, compareFn)
+			}
+			return compareFn(c, r.path, info)
+		})
+		filterTime += time.Since(start)
+		if !ok {
+			return
+		}
+		computeExpressions(q.Columns, &r, info, compareFn)
 
-			if q.HasAttribute("mode") {
-				fmt.Printf("%s", info.Mode())
-				if q.HasAttribute("size", "time", "name") {
-					fmt.Print("\t")
-				}
+		if emit != nil {
+			if ctx.Err() == nil {
+				emit(r)
 			}
+			return
+		}
+		results = append(results, r)
+	}
+
+	// Add the result (like add) iff it hasn't been seen yet.
+	visit := func(r result) {
+		if seen != nil {
+			if _, ok := seen[r.path]; ok {
+				return
+			}
+			seen[r.path] = true
+		}
+		add(r)
+	}
+
+	// The sources of a JOIN are each evaluated on their own, then joined,
+	// except for a SEMI or ANTI JOIN, which filters the results of its left
+	// source (see semiJoinResults).
+	sources := q.Sources["include"]
+	if q.Join != nil && q.Join.Semi() {
+		sources = sources[:1]
+	} else if q.Join != nil {
+		sources = nil
+		results = joinResults(ctx, q, tables, qopts, prog, plan)
+	}
+	if len(sources) > 1 || len(sources) == 1 && tables[sources[0]] != nil {
+		seen = make(map[string]bool)
+	}
+
+	for _, src := range sources {
+		start, filterStart := time.Now(), filterTime
+		scanned = 0
 
-			if q.HasAttribute("size") {
-				fmt.Printf("%d", info.Size())
-				if q.HasAttribute("time", "name") {
-					fmt.Print("\t")
+		if values, ok := q.Values[src]; ok {
+			// The rows of a VALUES table are each added, even when they have
+			// the same name.
+			for _, row := range values.Rows {
+				info := objectInfo{size: row.Size, modTime: row.ModTime}
+				if row.Name != "" {
+					info.name = filepath.Base(row.Name)
 				}
+				add(result{path: row.Name, info: info})
 			}
+		} else if table, ok := tables[src]; ok {
+			// Results of a table don't form a tree, so each of them is
+			// sampled individually with either method.
+			// The table's computed values are indexed by the CTE's
+			// columns, so they're dropped.
+			for _, r := range table {
+				if q.Sample == nil || sampled(q.Sample) {
+					visit(result{path: r.path, info: r.info, depth: r.depth})
+				}
+			}
+		} else if fsys, err := sourceVFS(src, qopts); err == nil {
+			index, patterns := queryIndex(q, src, qopts)
+			if qopts.FS == nil {
+				texts = newTextFilter(q, src)
+			}
+			walkSource(fsys, src, q.NestedArchives, qopts, func(path string, info os.FileInfo, err error) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if path == "." || path == ".." || err != nil {
+					return nil
+				}
 
-			if q.HasAttribute("time") {
-				fmt.Printf("%s", info.ModTime().Format(time.Stamp))
-				if q.HasAttribute("name") {
-					fmt.Print("\t")
+				// With the SYSTEM method, each directory below the source is
+				// either walked or skipped entirely, with the BERNOULLI
+				// method, each file is either kept or skipped.
+				if q.Sample != nil {
+					if q.Sample.Method == "bernoulli" && !sampled(q.Sample) {
+						return nil
+					}
+					if q.Sample.Method == "system" && info.IsDir() && path != src &&
+						!sampled(q.Sample) {
+						return filepath.SkipDir
+					}
 				}
+
+				prog.increment()
+				depth := depthBelow(src, path)
+				visit(result{path: path, info: info, depth: depth})
+
+				// The index's filters show which directories have no files
+				// whose names could satisfy the condition, and the pushed
+				// down predicates which have no files that could be where
+				// the condition requires.
+				if index != nil && info.IsDir() && index.skips(src, path, patterns) {
+					return filepath.SkipDir
+				}
+				if info.IsDir() && pushed.skips(path, depth) {
+					return filepath.SkipDir
+				}
+				return nil
+			})
+			texts.close()
+			texts = nil
+		}
+
+		plan.node(scanStep, src).record(scanned, time.Since(start)-(filterTime-filterStart))
+	}
+	if q.Join == nil || q.Join.Semi() {
+		plan.node(filterStep, "").record(len(results), filterTime)
+	}
+	if q.Join != nil && q.Join.Semi() {
+		results = semiJoinResults(ctx, q, results, tables, qopts, prog, plan)
+	}
+
+	if q.Unpivot != nil {
+		start := time.Now()
+		results = unpivotResults(q, results)
+		plan.node(unpivotStep, "").record(len(results), time.Since(start))
+	}
+
+	if isGrouped(q) {
+		start := time.Now()
+		results = aggregateResults(q, results, qopts)
+		plan.node(aggregateStep, "").record(len(results), time.Since(start))
+	}
+
+	if q.Having != nil {
+		start := time.Now()
+		results = filterColumns(q, q.Having, results, compareFn)
+		plan.node(havingStep, "").record(len(results), time.Since(start))
+	}
+
+	start := time.Now()
+	computeWindows(q.Columns, results)
+	plan.node(windowStep, "").record(len(results), time.Since(start))
+
+	if q.Qualify != nil {
+		start = time.Now()
+		results = filterColumns(q, q.Qualify, results, compareFn)
+		plan.node(qualifyStep, "").record(len(results), time.Since(start))
+	}
+
+	// The results of a JOIN are already sorted by their qualified attributes.
+	if q.Join == nil || q.Join.Semi() {
+		start = time.Now()
+		sortResults(results, q.OrderBy)
+		plan.node(sortStep, "").record(len(results), time.Since(start))
+	}
+
+	if q.Distinct {
+		start = time.Now()
+		results = distinctResults(results, q)
+		plan.node(distinctStep, "").record(len(results), time.Since(start))
+	}
+
+	start = time.Now()
+	if q.Offset > 0 {
+		if q.Offset > len(results) {
+			results = results[:0]
+		} else {
+			results = results[q.Offset:]
+		}
+	}
+	if q.Limit >= 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	plan.node(limitStep, "").record(len(results), time.Since(start))
+
+	start = time.Now()
+	numberResults(q.Columns, results)
+	plan.node(selectStep, "").record(len(results), time.Since(start))
+
+	return results
+}
+
+// Source of randomness used to sample the results of a TABLESAMPLE clause.
+var sampleRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// Return true if the next file (or directory) should be kept by the sample.
+func sampled(sample *query.TableSample) bool {
+	return sampleRand.Float64()*100 < sample.Percent
+}
+
+// Return the results which satisfy the condition tree of the query's HAVING or
+// QUALIFY clause. Conditions on a computed column (e.g. an aggregate or window
+// function) compare its value, others are evaluated like the WHERE clause.
+func filterColumns(q *query.Query, root *query.ConditionNode, results []result,
+	compareFn func(query.Condition, string, os.FileInfo) bool) []result {
+	columns := make(map[string]int, len(q.Columns))
+	for i := len(q.Columns) - 1; i >= 0; i-- {
+		if q.Columns[i].Attribute == "" {
+			columns[q.Columns[i].Name()] = i
+		}
+	}
+
+	filtered := make([]result, 0, len(results))
+	for _, r := range results {
+		ok := root.Evaluate(r.info, func(c query.Condition, info os.FileInfo) bool {
+			if i, ok := columns[c.Attribute]; ok {
+				return cmp.Value(c, r.computed[i])
 			}
+			return compareFn(c, r.path, info)
+		})
+		if ok {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// Return the first of each group of results which share the same values for
+// each of the query's DISTINCT ON attributes, or for each of its columns.
+func distinctResults(results []result, q *query.Query) []result {
+	seen := make(map[string]bool, len(results))
+	distinct := make([]result, 0, len(results))
 
-			if q.HasAttribute("name") {
-				// TODO: Only show file name, instead of the full path?
-				fmt.Printf("%s", path)
+	for _, r := range results {
+		values := make([]string, 0, len(q.Columns))
+		if q.DistinctOn != nil {
+			for _, attribute := range q.DistinctOn {
+				values = append(values, fmt.Sprint(r.value(attribute)))
+			}
+		} else {
+			for i, c := range q.Columns {
+				values = append(values, fmt.Sprint(r.column(i, c)))
 			}
+		}
 
-			fmt.Printf("\n")
-			return nil
-		})
+		key := strings.Join(values, "\x00")
+		if !seen[key] {
+			seen[key] = true
+			distinct = append(distinct, r)
+		}
+	}
+
+	return distinct
+}
+
+// Evaluate the parsed query and write its results to w.
+func runQuery(q *query.Query, opts *options, w, errw io.Writer) error {
+	if q.Label != "" || q.Goto != "" {
+		return errors.New("LABEL and GOTO require a query file (-file)")
+	}
+	if q.Raise != nil {
+		return runRaise(q.Raise, opts.variables, errw)
+	}
+	if err := applyOptions(q, opts); err != nil {
+		return err
+	}
+
+	format := opts.format
+	if q.Format != "" {
+		format = q.Format
+	}
+	if opts.machineReadable {
+		if format != "" && format != "default" {
+			return fmt.Errorf("-machine-readable cannot be used with -format %s", format)
+		}
+		format = "machine-readable"
+	}
+
+	if lookupFormatter(format) == nil {
+		if tag, ok := formatTags[format]; ok {
+			return fmt.Errorf("format %s requires building with -tags %s", format, tag)
+		}
+		return fmt.Errorf("unknown format: %s", format)
+	}
+	if !cmp.CanNormalize() && usesNormalize(q) {
+		return errors.New("NORMALIZE requires building with -tags norm")
+	}
+
+	qopts, warnings, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return err
+	}
+	qopts.NoCrossDevice = opts.noCrossDevice
+	qopts.MaxFileSize = opts.maxFileSize
+	if opts.verbose {
+		qopts.SkipLog = errw
+	}
+
+	unbind, err := bindVariables(q, opts.variables)
+	if err != nil {
+		return err
+	}
+	defer unbind()
+	if q.Into != nil && q.Into.Variable != "" && opts.variables == nil {
+		return fmt.Errorf("INTO @%s requires a query file (-file), since variables only persist between its queries",
+			q.Into.Variable)
+	}
+	for _, warning := range append(q.Warnings, warnings...) {
+		fmt.Fprintf(errw, "warning: %s\n", warning)
+	}
+
+	if q.Assert != nil {
+		return runAssert(q.Assert, qopts, opts.variables)
+	}
+
+	if err := checkSources(q, qopts); err != nil {
+		return err
+	}
+
+	if q.RebuildIndex {
+		return rebuildIndexes(q)
+	}
+
+	if q.Operation != nil {
+		return runOperation(q, qopts)
+	}
+
+	var groups *groupWriter
+	if opts.outputDir != "" || opts.groupBy != "" {
+		if groups, err = newGroupWriter(opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.jsonSchema {
+		if q.Pivot {
+			return errors.New("-json-schema cannot be used with PIVOT, since its columns depend on the results")
+		}
+		return writeSchema(w, q, opts.count)
+	}
+
+	if q.Explain {
+		return runExplain(q, qopts, w)
+	}
+
+	if opts.benchmark != 0 {
+		return runBenchmark(q, qopts, opts.benchmark, w)
+	}
+
+	if opts.simulate {
+		return runSimulate(q, qopts, w)
+	}
+
+	// The output is only cached when it's written to w, and none of the sources
+	// is remote.
+	var cache *resultCache
+	var key string
+	if opts.cache && q.Into == nil && groups == nil && opts.diff == "" && opts.saveBaseline == "" {
+		cache = &resultCache{dir: opts.cacheDir, ttl: opts.cacheTTL}
+		var ok bool
+		if key, ok = cache.key(q, format, opts); !ok {
+			cache = nil
+		} else if output, ok := cache.get(key); ok {
+			_, err := w.Write(output)
+			return err
+		}
+	}
+
+	out := w
+	var output bytes.Buffer
+	if cache != nil {
+		out = io.MultiWriter(w, &output)
+	}
+
+	var prog *progress
+	if opts.progress {
+		prog = startProgress(errw, progressInterval)
+	}
+
+	// Unless the results are needed all at once, each is written as soon as
+	// it's found, so that they're never all kept in memory.
+	terminator, ok := streamTerminators[format]
+	if ok && !opts.count && q.Into == nil && groups == nil && opts.diff == "" && opts.saveBaseline == "" &&
+		canStream(q) {
+		err = streamResults(q, qopts, prog, out, terminator)
+		prog.stop()
+		if err == nil && cache != nil {
+			cache.save(key, output.Bytes(), errw)
+		}
+		return err
+	}
+
+	results, err := evaluateWith(context.Background(), q, qopts, prog)
+	prog.stop()
+	if err != nil {
+		return err
+	}
+
+	if q.Pivot {
+		if q, results, err = pivotResults(q, results); err != nil {
+			return err
+		}
+	}
+
+	if q.Into != nil && q.Into.Variable != "" {
+		setVariable(q, results, opts.variables)
+		return nil
+	}
+	if q.Into != nil {
+		return writeDestination(q, results)
+	}
+
+	if groups != nil {
+		return groups.write(q.Columns, results)
+	}
+
+	if opts.diff != "" || opts.saveBaseline != "" {
+		return runDiff(results, opts, w)
+	}
+
+	if opts.count {
+		err = writeCount(out, format, len(results))
+	} else {
+		err = writeResults(out, format, opts, q, results)
+	}
+
+	if err == nil && cache != nil {
+		cache.save(key, output.Bytes(), errw)
+	}
+	return err
+}
+
+// Return true iff any of the conditions of the query (or of its CTEs and
+// subqueries) calls NORMALIZE.
+func usesNormalize(q *query.Query) bool {
+	if q == nil {
+		return false
+	}
+	for _, cte := range q.With {
+		if usesNormalize(cte.Query) {
+			return true
+		}
+	}
+	if q.Join != nil && (usesNormalize(q.Join.Lateral) || usesNormalize(q.Join.Subquery) ||
+		treeUsesNormalize(q.Join.On)) {
+		return true
+	}
+	return treeUsesNormalize(q.ConditionTree) || treeUsesNormalize(q.Having) || treeUsesNormalize(q.Qualify)
+}
+
+func treeUsesNormalize(root *query.ConditionNode) bool {
+	if root == nil {
+		return false
+	}
+	if c := root.Condition; c != nil {
+		if c.Case != nil {
+			for _, branch := range c.Case.Branches {
+				if treeUsesNormalize(branch.When) {
+					return true
+				}
+			}
+		}
+		return c.Attribute == query.Normalize.String() || usesNormalize(c.Exists) || usesNormalize(c.Subquery)
+	}
+	return treeUsesNormalize(root.Left) || treeUsesNormalize(root.Right)
+}
+
+func main() {
+	if err := loadPlugins(pluginDir()); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		log.Fatal(runServe(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "index" {
+		if err := runIndex(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "recover" {
+		if err := runRecover(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	warnInterrupted(walDir(), os.Stderr)
+	input, opts := readFlags()
+	opts.progress = opts.progress && isTerminal(os.Stderr)
+
+	var err error
+	if opts.file != "" {
+		var contents []byte
+		if contents, err = ioutil.ReadFile(opts.file); err == nil {
+			err = runFile(string(contents), opts, os.Stdout, os.Stderr)
+		}
+	} else {
+		err = run(input, opts, os.Stdout, os.Stderr)
+	}
+
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			log.Fatal("Unexpected end of line")
+		}
+		log.Fatal(err)
 	}
 }
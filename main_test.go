@@ -1 +1,5473 @@
 package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"testing/quick"
+	"time"
+	"unicode/utf8"
+
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/internal/testutil"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Create a temporary directory containing a file for each entry in files,
+// mapping the file's name to its contents.
+func createTree(t testing.TB, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Create an in-memory tree (see testutil.MockVFS) containing a file for each
+// entry in files, mapping the file's name to its contents, and walk the
+// sources of the queries run by the test in it (so a later tree replaces it).
+// It returns the tree's root.
+// Tests which don't depend on the local filesystem (e.g. on symbolic links,
+// or on the files which are read) use it rather than createTree.
+func createMockTree(t *testing.T, files map[string]string) string {
+	root := filepath.Join(string(filepath.Separator), "fsql", t.Name())
+	m := make(testutil.MockVFS, len(files)+1)
+	m[root] = testutil.MockFile{Mode: os.ModeDir}
+	for name, contents := range files {
+		m[filepath.Join(root, name)] = testutil.MockFile{Contents: contents}
+	}
+	useVFS(t, m)
+	return root
+}
+
+// Walk the sources of the queries run by the test in fsys, rather than the
+// local filesystem.
+func useVFS(t testing.TB, fsys vfs) {
+	previous := sourceVFS
+	sourceVFS = func(string, query.QueryOptions) (vfs, error) { return fsys, nil }
+	t.Cleanup(func() { sourceVFS = previous })
+}
+
+// Run the query against dir and return each line of output.
+func runLines(input string, opts *options) ([]string, error) {
+	var buf bytes.Buffer
+	err := run(input, opts, &buf, ioutil.Discard)
+	output := strings.TrimSuffix(buf.String(), "\n")
+	if output == "" {
+		return []string{}, err
+	}
+	return strings.Split(output, "\n"), err
+}
+
+func TestSortBy(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a": "aaa",
+		"b": "b",
+		"c": "cc",
+	})
+	input := "SELECT size FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		opts     *options
+		expected []string
+	}
+
+	cases := []Case{
+		{&options{sortBy: "size"}, []string{"1", "2", "3"}},
+		{&options{sortBy: "size", reverse: true}, []string{"3", "2", "1"}},
+		{&options{sortBy: "name", reverse: true}, []string{"2", "1", "3"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(input, c.opts)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if strings.Join(actual, ",") != strings.Join(c.expected, ",") {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestSortByErrors(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a": "a"})
+
+	type Case struct {
+		input string
+		opts  *options
+	}
+
+	cases := []Case{
+		{"SELECT name FROM " + dir + " ORDER BY size", &options{sortBy: "size"}},
+		{"SELECT name FROM " + dir, &options{reverse: true}},
+		{"SELECT name FROM " + dir, &options{sortBy: "foo"}},
+	}
+
+	for _, c := range cases {
+		if _, err := runLines(c.input, c.opts); err == nil {
+			t.Fatalf("\nExpected error for %q with %+v", c.input, *c.opts)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	fs := flag.NewFlagSet("fsql", flag.ContinueOnError)
+	opts := new(options)
+	defineFlags(fs, opts)
+
+	flags, rest := splitArgs(fs, []string{
+		"SELECT", "name", "FROM", ".,", "-.git", "--sort-by", "size", "--reverse",
+	})
+	if err := fs.Parse(flags); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := "SELECT name FROM ., -.git"
+	if actual := strings.Join(rest, " "); actual != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, actual)
+	}
+	if opts.sortBy != "size" || !opts.reverse {
+		t.Fatalf("\nExpected {sortBy: size, reverse: true}\n     Got %+v", *opts)
+	}
+
+	// The help flags are parsed (which shows the usage), rather than being
+	// part of the query.
+	for _, arg := range []string{"-h", "-help", "--help"} {
+		fs := flag.NewFlagSet("fsql", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		defineFlags(fs, new(options))
+
+		flags, rest := splitArgs(fs, []string{arg})
+		if len(rest) != 0 {
+			t.Fatalf("\nExpected %s to be a flag\n     Got %q", arg, rest)
+		}
+		if err := fs.Parse(flags); err != flag.ErrHelp {
+			t.Fatalf("\nExpected %v for %s\n     Got %v", flag.ErrHelp, arg, err)
+		}
+	}
+}
+
+func TestKeywordNames(t *testing.T) {
+	keywords := []string{"by", "order", "from", "top", "first", "values", "case", "end"}
+	files := map[string]string{"a": "a"}
+	for _, keyword := range keywords {
+		files[keyword] = keyword
+	}
+	dir := createMockTree(t, files)
+
+	for _, keyword := range keywords {
+		input := "SELECT name FROM " + dir + " WHERE name = " + keyword
+		lines, err := runLines(input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+		}
+		if expected := []string{filepath.Join(dir, keyword)}; !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("\nExpected %q for %q\n     Got %q", expected, input, lines)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "a",
+		"b.go":     "b",
+		"sub/c.go": "c",
+		"sub/d.py": "d",
+	})
+	input := "SELECT all FROM " + dir + " WHERE name LIKE %.go"
+
+	lines, err := runLines(input, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	type Case struct {
+		input    string
+		opts     *options
+		expected string
+	}
+
+	cases := []Case{
+		{input, &options{count: true}, "3"},
+		{input, &options{count: true, format: "json"}, `{"count":3}`},
+		{input + " ORDER BY size DESC", &options{count: true}, "3"},
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("\nExpected 3 lines\n     Got %d", len(lines))
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, c.opts)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if len(actual) != 1 || actual[0] != c.expected {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a": "aaa", "b": "b"})
+
+	var buf bytes.Buffer
+	err := run("SELECT name, size FROM "+dir+" WHERE file IS reg ORDER BY name",
+		&options{format: "json"}, &buf, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	var actual []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+
+	expected := []map[string]interface{}{
+		{"name": filepath.Join(dir, "a"), "size": 3.0},
+		{"name": filepath.Join(dir, "b"), "size": 1.0},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	if err := run("SELECT name FROM "+dir, &options{format: "foo"}, &buf, ioutil.Discard); err == nil {
+		t.Fatalf("\nExpected error for unknown format")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"a":     "a",
+		"b":     "b",
+		"sub/c": "c",
+	})
+
+	var stdout, stderr bytes.Buffer
+	err := run("SELECT name FROM "+dir, &options{progress: true}, &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	// The root directory, sub, and each of the 3 files.
+	expected := "\r  5 files\n"
+	if !strings.HasSuffix(stderr.String(), expected) {
+		t.Fatalf("\nExpected suffix %q\n     Got %q", expected, stderr.String())
+	}
+
+	if err := run("SELECT name FROM "+dir, &options{}, &stdout, &stderr); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if !strings.HasSuffix(stderr.String(), expected) {
+		t.Fatalf("\nExpected no progress without -progress\n     Got %q", stderr.String())
+	}
+}
+
+func TestProgressSpinner(t *testing.T) {
+	var buf syncBuffer
+	prog := startProgress(&buf, time.Millisecond)
+	prog.increment()
+	prog.increment()
+	time.Sleep(10 * time.Millisecond)
+	prog.stop()
+
+	if !regexp.MustCompile(`\r[|/\-\\] 2 files`).MatchString(buf.String()) {
+		t.Fatalf("\nExpected spinner with count\n     Got %q", buf.String())
+	}
+}
+
+// A bytes.Buffer which is safe to write to from multiple goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunFile(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.py": "b",
+		"c.md": "c",
+	})
+	input := "SELECT name FROM " + dir + " WHERE name LIKE %.go;\n" +
+		"SELECT name FROM " + dir + " WHERE name LIKE %.py;\n" +
+		"SELECT name FROM " + dir + "\n  WHERE name LIKE %.md;\n"
+
+	var buf bytes.Buffer
+	if err := runFile(input, &options{}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := "==> query 1 <==\n" + filepath.Join(dir, "a.go") + "\n\n" +
+		"==> query 2 <==\n" + filepath.Join(dir, "b.py") + "\n\n" +
+		"==> query 3 <==\n" + filepath.Join(dir, "c.md") + "\n"
+	if buf.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, buf.String())
+	}
+}
+
+func TestVariables(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":             "a",
+		"proj/go.mod":      "module proj",
+		"proj/main.go":     "main",
+		"proj/sub/util.go": "util",
+		"proj/README.md":   "readme",
+	})
+
+	input := "SELECT dir INTO @root FROM " + dir + " WHERE name = go.mod LIMIT 1;\n" +
+		"SELECT name FROM @root WHERE name LIKE %.go"
+	var buf bytes.Buffer
+	if err := runFile(input, &options{}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := "==> query 1 <==\n\n==> query 2 <==\n" +
+		filepath.Join(dir, "proj", "main.go") + "\n" + filepath.Join(dir, "proj", "sub", "util.go") + "\n"
+	if buf.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, buf.String())
+	}
+
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		{"SELECT name FROM @root", "query 1: variable @root isn't set"},
+		// A query with no results unsets its variable.
+		{"SELECT dir INTO @root FROM " + dir + " WHERE name = go.mod;\n" +
+			"SELECT dir INTO @root FROM " + dir + " WHERE name = go.sum;\n" +
+			"SELECT name FROM @root", "query 3: variable @root isn't set"},
+	}
+
+	for _, c := range cases {
+		err := runFile(c.input, &options{}, ioutil.Discard, ioutil.Discard)
+		if err == nil || err.Error() != c.expected {
+			t.Fatalf("\nExpected %s\n     Got %v", c.expected, err)
+		}
+	}
+
+	// Variables don't persist between single queries.
+	if _, err := runLines("SELECT dir INTO @root FROM "+dir, &options{}); err == nil {
+		t.Fatalf("\nExpected an error for INTO @root without a query file")
+	}
+}
+
+func TestAssert(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "aaaa",
+		"b.go":     "b",
+		"sub/c.py": "cc",
+	})
+
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		{"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.go) = 2", ""},
+		{"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE size > 10) = 0 MESSAGE 'No large files'", ""},
+		{"ASSERT (SELECT MEDIAN(size) FROM " + dir + ") <= 4", ""},
+		{"ASSERT (SELECT name FROM " + dir + " WHERE name LIKE %.py) = '" + filepath.Join(dir, "sub", "c.py") + "'", ""},
+		{"ASSERT (SELECT name FROM " + dir + " WHERE name = d.go) = ''", ""},
+		{"PRAGMA case_sensitive = false; ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.GO) = 2", ""},
+		{
+			"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.go) = 0 MESSAGE 'No Go files allowed'",
+			"assertion failed: No Go files allowed",
+		},
+		{
+			"ASSERT (SELECT COUNT(*) FROM " + dir + ") < 2",
+			`assertion failed: expected < 2, got "5"`,
+		},
+		// Values which aren't both numbers are compared as strings.
+		{
+			"ASSERT (SELECT name FROM " + dir + " WHERE name = a.go) > '" + filepath.Join(dir, "b.go") + "'",
+			fmt.Sprintf("assertion failed: expected > %s, got %q", filepath.Join(dir, "b.go"), filepath.Join(dir, "a.go")),
+		},
+	}
+
+	for _, c := range cases {
+		_, err := runLines(c.input, &options{})
+		if c.expected == "" && err != nil || c.expected != "" && (err == nil || err.Error() != c.expected) {
+			t.Fatalf("\nExpected %q for %q\n     Got %v", c.expected, c.input, err)
+		}
+	}
+
+	// Each of the assertions of a file is checked, and the failures are
+	// reported together.
+	input := "ASSERT (SELECT COUNT(*) FROM " + dir + ") = 0 MESSAGE 'first';\n" +
+		"ASSERT (SELECT COUNT(*) FROM " + dir + ") = 5 MESSAGE 'second';\n" +
+		"SELECT name FROM " + dir + " WHERE name = b.go;\n" +
+		"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.go) <> 2 MESSAGE 'third'"
+	var buf bytes.Buffer
+	err := runFile(input, &options{}, &buf, ioutil.Discard)
+	expected := "query 1: assertion failed: first\nquery 4: assertion failed: third"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("\nExpected %q\n     Got %v", expected, err)
+	}
+	if output := buf.String(); !strings.Contains(output, "==> query 3 <==\n"+filepath.Join(dir, "b.go")+"\n") {
+		t.Fatalf("\nExpected the results of query 3\n     Got %q", output)
+	}
+
+	// The failures before an error which stops the file are reported with it.
+	input = "ASSERT (SELECT COUNT(*) FROM " + dir + ") = 0 MESSAGE 'first';\n" +
+		"ASSERT (SELECT COUNT(*) FROM " + dir + ") = 0 MESSAGE 'second';\n" +
+		"RAISE ERROR 'stop';\n" +
+		"ASSERT (SELECT COUNT(*) FROM " + dir + ") = 0 MESSAGE 'third'"
+	err = runFile(input, &options{}, ioutil.Discard, ioutil.Discard)
+	expected = "query 1: assertion failed: first\nquery 2: assertion failed: second\nquery 3: ERROR: stop"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("\nExpected %q\n     Got %v", expected, err)
+	}
+}
+
+func TestGoto(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"go.mod":                     "module a",
+		"level.txt":                  "0",
+		"sub/level.txt":              "1",
+		"sub/sub/level.txt":          "2",
+		"sub/sub/sub/level.txt":      "3",
+		"sub/sub/sub/other/examples": "",
+	})
+
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		// A forward GOTO skips the queries before its label.
+		{
+			"SELECT name FROM " + dir + " WHERE name = go.mod;\n" +
+				"IF (SELECT COUNT(*) FROM " + dir + " WHERE name = go.mod) > 0 GOTO has_go_mod;\n" +
+				"SELECT name FROM " + dir + " WHERE name = level.txt;\n" +
+				"LABEL has_go_mod;\n" +
+				"IF (SELECT COUNT(*) FROM " + dir + " WHERE name = go.sum) > 0 GOTO has_go_sum;\n" +
+				"SELECT COUNT(*) FROM " + dir + " WHERE name = level.txt;\n" +
+				"LABEL has_go_sum",
+			"==> query 1 <==\n" + filepath.Join(dir, "go.mod") + "\n\n==> query 6 <==\n4\n",
+		},
+		// A backward GOTO repeats the queries after its label (with the
+		// variables' values at the time), until its condition is false.
+		{
+			"SELECT dir INTO @d FROM " + dir + " WHERE name = go.mod;\n" +
+				"LABEL descend;\n" +
+				"SELECT name FROM @d WHERE name = level.txt AND depth = 1;\n" +
+				"IF (SELECT COUNT(*) FROM @d WHERE name = sub AND depth = 1) = 0 GOTO done;\n" +
+				"SELECT name INTO @d FROM @d WHERE name = sub AND depth = 1;\n" +
+				"GOTO descend;\n" +
+				"LABEL done",
+			"==> query 1 <==\n\n" +
+				"==> query 3 <==\n" + filepath.Join(dir, "level.txt") + "\n\n==> query 5 <==\n\n" +
+				"==> query 3 <==\n" + filepath.Join(dir, "sub", "level.txt") + "\n\n==> query 5 <==\n\n" +
+				"==> query 3 <==\n" + filepath.Join(dir, "sub", "sub", "level.txt") + "\n\n==> query 5 <==\n\n" +
+				"==> query 3 <==\n" + filepath.Join(dir, "sub", "sub", "sub", "level.txt") + "\n",
+		},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := runFile(c.input, &options{}, &buf, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if buf.String() != c.expected {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, buf.String())
+		}
+	}
+
+	errorCases := []Case{
+		// A GOTO which loops forever is stopped.
+		{
+			"LABEL again;\nSELECT name FROM " + dir + " WHERE name = go.mod;\nGOTO again",
+			"query 3: stopped after 1000 steps, since GOTO may be looping forever",
+		},
+		// Undefined labels are only an error once their GOTO is run.
+		{
+			"IF (SELECT COUNT(*) FROM " + dir + ") = 0 GOTO nowhere;\nGOTO nowhere",
+			"query 2: label nowhere isn't defined",
+		},
+		{"LABEL a;\nLABEL a", "label a is defined more than once"},
+	}
+
+	for _, c := range errorCases {
+		err := runFile(c.input, &options{}, ioutil.Discard, ioutil.Discard)
+		if err == nil || err.Error() != c.expected {
+			t.Fatalf("\nExpected %s\n     Got %v", c.expected, err)
+		}
+	}
+
+	// Labels only name positions in a query file.
+	if _, err := runLines("GOTO top", &options{}); err == nil {
+		t.Fatalf("\nExpected an error for GOTO without a query file")
+	}
+}
+
+// Run fsql's main with -file and the path in FSQL_TEST_MAIN_FILE, when the
+// test binary is run by runMainFile.
+func TestMainFile(t *testing.T) {
+	path := os.Getenv("FSQL_TEST_MAIN_FILE")
+	if path == "" {
+		t.Skip("only run by runMainFile")
+	}
+	os.Args = []string{"fsql", "-file", path}
+	flag.CommandLine = flag.NewFlagSet("fsql", flag.ExitOnError)
+	main()
+	os.Exit(0)
+}
+
+// Run the query file with fsql's main, in a new process (running the test
+// binary), and return its exit code and what it wrote to stdout and stderr.
+func runMainFile(t *testing.T, input string) (int, string, string) {
+	path := filepath.Join(t.TempDir(), "queries.fsql")
+	if err := ioutil.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMainFile$")
+	cmd.Env = append(os.Environ(), "FSQL_TEST_MAIN_FILE="+path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+
+	code := 0
+	if exit, ok := err.(*exec.ExitError); ok {
+		code = exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return code, stdout.String(), stderr.String()
+}
+
+func TestAssertExitCode(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "a", "b.go": "b"})
+
+	type Case struct {
+		assertions string
+		code       int
+		message    string
+	}
+
+	cases := []Case{
+		{"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.go) = 2", 0, ""},
+		{"ASSERT (SELECT COUNT(*) FROM " + dir + " WHERE name LIKE %.go) = 0 MESSAGE 'No files allowed'", 1,
+			"query 1: assertion failed: No files allowed"},
+	}
+
+	for _, c := range cases {
+		code, _, stderr := runMainFile(t, c.assertions)
+		if code != c.code || !strings.Contains(stderr, c.message) {
+			t.Fatalf("\nExpected exit code %d and %q\n     Got %d and %q", c.code, c.message, code, stderr)
+		}
+	}
+}
+
+func TestRaise(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "aaaa", "b.go": "b", "c.py": "cc"})
+
+	type Case struct {
+		input  string
+		code   int
+		stderr string
+	}
+
+	count := "SELECT COUNT(*) INTO @count FROM " + dir + " WHERE name LIKE %.go;\n"
+	cases := []Case{
+		{count + `RAISE NOTICE "Go files: " || @count`, 0, "NOTICE: Go files: 2\n"},
+		{count + `RAISE WARNING 'Found ' || @count || ' Go files'`, 0, "WARNING: Found 2 Go files\n"},
+		{count + `RAISE ERROR "Found " || @count || " Go files";` + "\nSELECT name FROM " + dir, 1,
+			"query 2: ERROR: Found 2 Go files\n"},
+		{"RAISE NOTICE @missing", 1, "query 1: variable @missing isn't set\n"},
+	}
+
+	for _, c := range cases {
+		code, stdout, stderr := runMainFile(t, c.input)
+		if code != c.code || !strings.HasSuffix(stderr, c.stderr) {
+			t.Fatalf("\nExpected exit code %d and %q\n     Got %d and %q", c.code, c.stderr, code, stderr)
+		}
+		// The queries after RAISE ERROR aren't run.
+		if strings.Contains(stdout, "==> query 3 <==") {
+			t.Fatalf("\nExpected no output for query 3\n     Got %q", stdout)
+		}
+	}
+
+	// A RAISE statement may also be run on its own.
+	var stderr bytes.Buffer
+	if err := run("RAISE WARNING 'careful'", &options{}, ioutil.Discard, &stderr); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if stderr.String() != "WARNING: careful\n" {
+		t.Fatalf("\nExpected %q\n     Got %q", "WARNING: careful\n", stderr.String())
+	}
+}
+
+func TestCaseExpressions(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"empty.go": "",
+		"tiny.go":  "a",
+		"small.md": "aaaaaaaaaa",
+		"large.md": strings.Repeat("a", 100),
+	})
+	from := " FROM " + dir + " WHERE name LIKE %.% ORDER BY name"
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// Each branch is reachable, and ELSE is the result when none match.
+		{
+			`SELECT name, CASE WHEN size < 5 THEN "tiny" WHEN size < 50 THEN "small" ELSE "large" END AS category` + from,
+			[]string{path("empty.go") + "\ttiny", path("large.md") + "\tlarge", path("small.md") + "\tsmall", path("tiny.go") + "\ttiny"},
+		},
+		// Without ELSE, the result is NULL when none match.
+		{
+			"SELECT name, CASE size WHEN 0 THEN empty WHEN 1 THEN one END" + from,
+			[]string{path("empty.go") + "\tempty", path("large.md") + "\t", path("small.md") + "\t", path("tiny.go") + "\tone"},
+		},
+		// Nested CASE expressions, in both the results and the conditions.
+		{
+			"SELECT CASE WHEN name LIKE %.go THEN CASE WHEN size = 0 THEN empty ELSE go END " +
+				"WHEN CASE WHEN name LIKE %.md THEN doc END = doc THEN markdown END, name" + from,
+			[]string{"empty\t" + path("empty.go"), "markdown\t" + path("large.md"), "markdown\t" + path("small.md"), "go\t" + path("tiny.go")},
+		},
+		// CASE in the WHERE clause.
+		{
+			"SELECT name FROM " + dir + " WHERE CASE WHEN size > 5 THEN big ELSE little END = big ORDER BY name",
+			[]string{path("large.md"), path("small.md")},
+		},
+		{
+			"SELECT name FROM " + dir + " WHERE name LIKE %.% AND CASE size WHEN 0 THEN empty WHEN 1 THEN one END IS NULL ORDER BY name",
+			[]string{path("large.md"), path("small.md")},
+		},
+		{
+			"SELECT name FROM " + dir + " WHERE NOT CASE WHEN name LIKE %.go THEN go ELSE other END = go AND name LIKE %.% ORDER BY name",
+			[]string{path("large.md"), path("small.md")},
+		},
+		// The conditions of CASE follow PRAGMA case_sensitive.
+		{
+			"PRAGMA case_sensitive = false; SELECT CASE WHEN name LIKE %.GO THEN go ELSE other END" + from,
+			[]string{"go", "other", "other", "go"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestIif(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"big.go":   strings.Repeat("a", 100),
+		"small.go": "a",
+		"small.md": "a",
+	})
+	from := " FROM " + dir + " WHERE name LIKE %.% ORDER BY name"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{`SELECT IIF(size > 10, "big", "small")` + from, []string{"big", "small", "small"}},
+		{`SELECT IIF(size > 10 AND name LIKE %.md, "big", "other")` + from, []string{"other", "other", "other"}},
+		// Either argument may be a nested IIF (or CASE).
+		{
+			"SELECT IIF(size > 10, big, IIF(name LIKE %.go, small_go, CASE WHEN size = 1 THEN one END))" + from,
+			[]string{"big", "small_go", "one"},
+		},
+		{
+			"SELECT name FROM " + dir + " WHERE IIF(name LIKE %.go, go, other) = go AND size < 10",
+			[]string{filepath.Join(dir, "small.go")},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// Both arguments are checked, even when the condition means that one of
+	// them is never the result.
+	for _, input := range []string{
+		"SELECT IIF(size >= 0, big, CASE foo WHEN 0 THEN a END)" + from,
+		"SELECT IIF(size < 0, IIF(size, a, b), small)" + from,
+	} {
+		if _, err := runLines(input, &options{}); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestNullIf(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "",
+		"b.go":     "bb",
+		"sub/c.md": "",
+	})
+	from := " FROM " + dir + " WHERE name LIKE %.% ORDER BY name"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// NULL when the attribute is equal to the value, the attribute otherwise.
+		{"SELECT NULLIF(size, 0)" + from, []string{"", "2", ""}},
+		{"SELECT NULLIF(size, 2)" + from, []string{"0", "", "0"}},
+		{"SELECT NULLIF(name, a.go)" + from, []string{"", filepath.Join(dir, "b.go"), filepath.Join(dir, "sub", "c.md")}},
+		{"SELECT NULLIF(dir, '" + dir + "')" + from, []string{"", "", filepath.Join(dir, "sub")}},
+		// COALESCE replaces the NULL results with a default.
+		{"SELECT COALESCE(NULLIF(size, 0), 1)" + from, []string{"1", "2", "1"}},
+		{"SELECT COALESCE(NULLIF(size, 0), NULLIF(name, a.go), none)" + from, []string{"none", "2", filepath.Join(dir, "sub", "c.md")}},
+		{"SELECT name FROM " + dir + " WHERE NULLIF(size, 0) IS NULL AND name LIKE %.% ORDER BY name", []string{
+			filepath.Join(dir, "a.go"), filepath.Join(dir, "sub", "c.md"),
+		}},
+		{"SELECT name FROM " + dir + " WHERE NULLIF(size, 0) > 1", []string{filepath.Join(dir, "b.go")}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// The values keep the attribute's type.
+	var buf bytes.Buffer
+	if err := run("SELECT NULLIF(size, 0) AS size"+from, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{"size": nil}, {"size": 2.0}, {"size": nil}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.txt":     "aaaaaa",
+		"b.txt":     "bbbbbbbbbb",
+		"sub/c.txt": "",
+	})
+	from := " FROM " + dir + " WHERE name LIKE %.% ORDER BY name"
+	where := "SELECT name FROM " + dir + " WHERE name LIKE %.% AND "
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// * / % are evaluated before + -.
+		{"SELECT size + 2 * 3" + from, []string{"12", "16", "6"}},
+		{"SELECT (size + 2) * 3, size - 1 - 2" + from, []string{"24\t3", "36\t7", "6\t-3"}},
+		{"SELECT -size + 1, - -size" + from, []string{"-5\t6", "-9\t10", "1\t0"}},
+		// Integers are divided as integers, and otherwise as floats.
+		{"SELECT size / 4, size / 4.0, size % 4, size % 2.5" + from, []string{"1\t1.5\t2\t1", "2\t2.5\t2\t0", "0\t0\t0\t0"}},
+		// Division by zero is NULL.
+		{"SELECT 60 / size, size % 0, size / 0.0" + from, []string{"10\t\t", "6\t\t", "\t\t"}},
+		{"SELECT name, size * 2 AS double" + from, []string{
+			filepath.Join(dir, "a.txt") + "\t12", filepath.Join(dir, "b.txt") + "\t20", filepath.Join(dir, "sub", "c.txt") + "\t0",
+		}},
+		// In WHERE, expressions are compared with numbers (or sizes).
+		{where + "size * 2 > 15", []string{filepath.Join(dir, "b.txt")}},
+		{where + "size * 100 >= 0.5kb", []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")}},
+		{where + "-size < -8", []string{filepath.Join(dir, "b.txt")}},
+		{where + "size % 5 = 0 AND size + 0 > 0", []string{filepath.Join(dir, "b.txt")}},
+		{where + "60 / size IS NULL", []string{filepath.Join(dir, "sub", "c.txt")}},
+		// Chained comparisons compare each adjacent pair.
+		{where + "5 < size < 8", []string{filepath.Join(dir, "a.txt")}},
+		{where + "size * 2 > 12 >= size", []string{filepath.Join(dir, "b.txt")}},
+		{where + "NOT 0 < size <= 6", []string{filepath.Join(dir, "b.txt"), filepath.Join(dir, "sub", "c.txt")}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := run("SELECT size / 4 AS i, size / 4.0 AS f, size / 0 AS n"+from, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"i": 1.0, "f": 1.5, "n": nil}
+	if !reflect.DeepEqual(rows[0], expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows[0])
+	}
+
+	// The smallest integer is an integer, but an integer which overflows is
+	// an error, rather than wrapping around or becoming a float.
+	actual, err := runLines("SELECT -9223372036854775808, 9223372036854775807 - 1"+from, &options{})
+	if err != nil || len(actual) == 0 || actual[0] != "-9223372036854775808\t9223372036854775806" {
+		t.Fatalf("\nExpected -9223372036854775808\t9223372036854775806\n     Got %v %v", actual, err)
+	}
+	for _, input := range []string{
+		"SELECT -9223372036854775808 / -1" + from,
+		"SELECT size + 9223372036854775807" + from,
+		"SELECT size - 9223372036854775807 - 2" + from,
+		"SELECT size * 4611686018427387904" + from,
+		"SELECT -(-9223372036854775807 - 1)" + from,
+		"SELECT size + 9223372036854775808" + from,
+		where + "size * 4611686018427387904 > 0",
+		where + "NOT size * 4611686018427387904 > 0",
+		"EXPLAIN ANALYZE " + where + "size * 4611686018427387904 > 0",
+	} {
+		_, err := runLines(input, &options{})
+		if !errors.Is(err, cmp.ErrOverflow) {
+			t.Fatalf("\nExpected an overflow for %q\n     Got %v", input, err)
+		}
+	}
+
+	big := createMockTree(t, map[string]string{"small": "s", "big": strings.Repeat("b", 600*1024)})
+	actual, err = runLines("SELECT name FROM "+big+" WHERE size * 2 > 1MB", &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := []string{filepath.Join(big, "big")}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"a.sh":  strings.Repeat("a", 2048),
+		"b.txt": strings.Repeat("b", 5000),
+		"c.txt": "",
+	})
+	modes := map[string]os.FileMode{"a.sh": 0755, "b.txt": 0644, "c.txt": 0640}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := " FROM " + dir + " WHERE file IS reg ORDER BY name"
+	where := "SELECT name FROM " + dir + " WHERE file IS reg AND "
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT mode & 0777, mode & 0x1ff" + from, []string{"493\t493", "420\t420", "416\t416"}},
+		{"SELECT ~0, ~size, 1 << 10, size >> 1" + from, []string{"-1\t-2049\t1024\t1024", "-1\t-5001\t1024\t2500", "-1\t-1\t1024\t0"}},
+		// Shifts are evaluated after arithmetic, and negative shifts are
+		// NULL.
+		{"SELECT (size >> 10) * 8, size >> 10 * 8, 1 << -1" + from, []string{"16\t0\t", "32\t0\t", "0\t0\t"}},
+		// ~ before shifts before & before ^ before |.
+		{"SELECT 1 | 6 ^ 3 & 5, ~1 << 1, 2 ^ 3 | 4" + from, []string{"7\t-4\t5", "7\t-4\t5", "7\t-4\t5"}},
+		{where + "mode & 0644 = 0644", []string{filepath.Join(dir, "a.sh"), filepath.Join(dir, "b.txt")}},
+		{where + "mode & 0111 <> 0", []string{filepath.Join(dir, "a.sh")}},
+		{where + "mode & 0777 = 0640", []string{filepath.Join(dir, "c.txt")}},
+		{where + "~0 = -1 AND 1 << 10 = 1024 AND size >> 12 = 1", []string{filepath.Join(dir, "b.txt")}},
+		{where + "~ 0 = -1 AND mode & 07 = 0", []string{filepath.Join(dir, "c.txt")}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestQuantified(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"logs/a.log": "aaa",
+		"logs/b.log": "bbbbb",
+		"src/x.go":   "xx",
+		"src/y.go":   "yyyy",
+		"src/z.go":   "zzzzzzz",
+		"src/a.log":  "",
+	})
+	logs := filepath.Join(dir, "logs")
+	where := "SELECT name FROM " + filepath.Join(dir, "src") + " WHERE file IS reg AND "
+	src := func(names ...string) []string {
+		paths := make([]string, 0, len(names))
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, "src", name))
+		}
+		return paths
+	}
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{where + "size > ALL (SELECT size FROM " + logs + " WHERE name LIKE %.log) ORDER BY name", src("z.go")},
+		{where + "size > ANY (SELECT size FROM " + logs + " WHERE name LIKE %.log) ORDER BY name", src("y.go", "z.go")},
+		{where + "size <= SOME (SELECT size FROM " + logs + " WHERE name LIKE %.log) ORDER BY name", src("a.log", "x.go", "y.go")},
+		{where + "NOT size < ALL (SELECT size FROM " + logs + " WHERE name LIKE %.log) ORDER BY name", src("y.go", "z.go")},
+		{where + "size = ANY (SELECT size FROM " + dir + " WHERE name LIKE %.log) ORDER BY name", src("a.log")},
+		{where + "size <> ALL (SELECT size FROM " + logs + " WHERE file IS reg) AND size > 0 ORDER BY name", src("x.go", "y.go", "z.go")},
+		{where + "name = ANY (SELECT name FROM " + logs + ") ORDER BY name", src("a.log")},
+
+		// ALL of no values is satisfied, while ANY of them isn't.
+		{where + "size > ALL (SELECT size FROM " + logs + " WHERE name LIKE %.txt) ORDER BY name", src("a.log", "x.go", "y.go", "z.go")},
+		{where + "size > ANY (SELECT size FROM " + logs + " WHERE name LIKE %.txt)", []string{}},
+		{where + "size = ANY (SELECT size FROM " + logs + " WHERE name LIKE %.txt)", []string{}},
+		{where + "NOT size > ANY (SELECT size FROM " + logs + " WHERE name LIKE %.txt) ORDER BY name", src("a.log", "x.go", "y.go", "z.go")},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// MAX and MIN are NULL when there are no files.
+	actual, err := runLines("SELECT MAX(size), MIN(size) FROM "+logs+" WHERE file IS reg", &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := []string{"5\t3"}; !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+	var buf bytes.Buffer
+	if err := run("SELECT MAX(size) FROM "+logs+" WHERE name = none", &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := `"max(size)": null`; !strings.Contains(buf.String(), expected) {
+		t.Fatalf("\nExpected %s\n     Got %s", expected, buf.String())
+	}
+}
+
+func TestPhonetic(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"colour.txt": "",
+		"color.md":   "",
+		"collar.go":  "",
+		"cooler":     "",
+		"robert.go":  "",
+		"Rupert":     "",
+		"knight.txt": "",
+		"night.txt":  "",
+		"nite":       "",
+		"other":      "",
+	})
+	where := "SELECT name FROM " + dir + " WHERE file IS reg AND "
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	// The codes of each file's name are computed without its extension.
+	cases := []Case{
+		{where + "SOUNDEX(name) = SOUNDEX('colour') ORDER BY name", []string{"collar.go", "color.md", "colour.txt", "cooler"}},
+		{where + "SOUNDEX(name) = R163 ORDER BY name", []string{"Rupert", "robert.go"}},
+		{where + "METAPHONE(name) = METAPHONE('nyte') ORDER BY name", []string{"knight.txt", "night.txt", "nite"}},
+		{where + "METAPHONE(name) = METAPHONE(colour) AND NOT name LIKE %.md ORDER BY name", []string{"collar.go", "colour.txt", "cooler"}},
+		{where + "NOT SOUNDEX(name) = C460 AND NOT METAPHONE(name) = NT ORDER BY name", []string{"Rupert", "other", "robert.go"}},
+		// IS only compares a code with NULL.
+		{where + "SOUNDEX(name) IS SOUNDEX('colour') OR METAPHONE(name) IS NT", nil},
+	}
+
+	for _, c := range cases {
+		lines, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected := make([]string, 0, len(c.expected))
+		for _, name := range c.expected {
+			expected = append(expected, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, lines)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	type Case struct {
+		a, b     string
+		distance int
+		ratio    float64
+	}
+
+	cases := []Case{
+		{"kitten", "sitting", 3, 3.0 / 7},
+		{"sitting", "kitten", 3, 3.0 / 7},
+		{"", "abc", 3, 1},
+		{"abc", "", 3, 1},
+		{"", "", 0, 0},
+		{"readme", "readme", 0, 0},
+		{"flaw", "lawn", 2, 0.5},
+		{"README", "readme", 6, 1},
+		{"héllo", "hello", 1, 0.2},
+	}
+
+	for _, c := range cases {
+		if actual := cmp.Levenshtein(c.a, c.b); actual != c.distance {
+			t.Fatalf("\nExpected %d for %q and %q\n     Got %d", c.distance, c.a, c.b, actual)
+		}
+		if actual := cmp.LevenshteinRatio(c.a, c.b); math.Abs(actual-c.ratio) > 1e-9 {
+			t.Fatalf("\nExpected %v for %q and %q\n     Got %v", c.ratio, c.a, c.b, actual)
+		}
+	}
+
+	dir := createMockTree(t, map[string]string{
+		"readme":      "",
+		"readme.md":   "",
+		"README.md":   "",
+		"raedme.md":   "",
+		"docs/readme": "",
+		"main.go":     "",
+	})
+	where := "SELECT name FROM " + dir + " WHERE file IS reg AND "
+	paths := func(names ...string) []string {
+		expected := make([]string, 0, len(names))
+		for _, name := range names {
+			expected = append(expected, filepath.Join(dir, name))
+		}
+		return expected
+	}
+
+	queries := []struct {
+		input    string
+		expected []string
+	}{
+		{where + `LEVENSHTEIN(name, "readme") < 3 ORDER BY name`, paths("docs/readme", "readme")},
+		{where + `LEVENSHTEIN(name, "readme.md") <= 3 ORDER BY name`, paths("raedme.md", "docs/readme", "readme", "readme.md")},
+		{where + `NOT LEVENSHTEIN(name, readme) > 0 AND LEVENSHTEIN(dir, "` + dir + `") = 0 ORDER BY name`, paths("readme")},
+		{where + `LEVENSHTEIN_RATIO(name, "readme.md") < 0.5 AND LEVENSHTEIN(name, readme.md) > 0 ORDER BY name`, paths("raedme.md", "docs/readme", "readme")},
+	}
+	for _, c := range queries {
+		lines, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, lines)
+		}
+	}
+}
+
+func TestNormalizeRequiresTag(t *testing.T) {
+	if cmp.CanNormalize() {
+		t.Skip("built with -tags norm (see normalize_test.go)")
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . WHERE NORMALIZE(name, NFC) = a",
+		"SELECT name FROM . WHERE depth = 1 AND NOT EXISTS (SELECT name FROM . WHERE NORMALIZE(dir, NFD) = a)",
+		"WITH a AS (SELECT name FROM . WHERE NORMALIZE(name, NFKC) = a) SELECT name FROM a",
+	} {
+		if _, err := runLines(input, &options{}); err == nil || !strings.Contains(err.Error(), "-tags norm") {
+			t.Fatalf("\nExpected an error for %q\n     Got %v", input, err)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "aaaa",
+		"b.go":     "b",
+		"sub/c.go": "cccccc",
+		"d.py":     "dddddddd",
+	})
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"WITH gofiles AS (SELECT * FROM " + dir + " WHERE name LIKE %.go) " +
+				"SELECT size FROM gofiles WHERE size > 2 ORDER BY size",
+			[]string{"4", "6"},
+		},
+		{
+			"WITH gofiles AS (SELECT * FROM " + dir + " WHERE name LIKE %.go), " +
+				"big AS (SELECT * FROM gofiles WHERE size > 2) " +
+				"SELECT size FROM big WHERE size < 5",
+			[]string{"4"},
+		},
+		{
+			"WITH gofiles AS (SELECT * FROM " + dir + " WHERE name LIKE %.go) " +
+				"SELECT size FROM gofiles, " + dir + " WHERE name LIKE %.py",
+			[]string{"8"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"x/a": "a",
+		"x/b": "bbb",
+		"y/c": "cc",
+		"y/d": "ddddd",
+		"y/e": "eee",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT DISTINCT ON (dir) size" + from + " ORDER BY size DESC", []string{"5", "3"}},
+		{"SELECT DISTINCT ON (dir) size" + from + " ORDER BY size", []string{"1", "2"}},
+		{
+			"SELECT DISTINCT ON (dir) size, name" + from + " ORDER BY size DESC",
+			[]string{"5\t" + filepath.Join(dir, "y", "d"), "3\t" + filepath.Join(dir, "x", "b")},
+		},
+		{"SELECT DISTINCT size" + from + " ORDER BY size", []string{"1", "2", "3", "5"}},
+		{"SELECT DISTINCT ON (size) dir" + from + " ORDER BY dir, size", []string{
+			filepath.Join(dir, "x"), filepath.Join(dir, "x"), filepath.Join(dir, "y"), filepath.Join(dir, "y"),
+		}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// Without ORDER BY, which result is kept is unspecified, but repeated
+	// runs must agree.
+	input := "SELECT DISTINCT ON (dir) name" + from
+	first, _ := runLines(input, &options{})
+	second, _ := runLines(input, &options{})
+	if len(first) != 2 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("\nExpected the same 2 results\n     Got %v and %v", first, second)
+	}
+}
+
+func TestWindowFunctions(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "aaaaa",
+		"b.go": "bbb",
+		"c.go": "ccc",
+		"d.go": "d",
+		"e.py": "ee",
+		"f.py": "fffffff",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT size, ROW_NUMBER() OVER (PARTITION BY ext ORDER BY size DESC) AS rn," +
+				" RANK() OVER (PARTITION BY ext ORDER BY size DESC), DENSE_RANK() OVER w" + from +
+				" WINDOW w AS (PARTITION BY ext ORDER BY size DESC) ORDER BY ext, size DESC",
+			[]string{
+				"5\t1\t1\t1", "3\t2\t2\t2", "3\t3\t2\t2", "1\t4\t4\t3",
+				"7\t1\t1\t1", "2\t2\t2\t2",
+			},
+		},
+		{
+			"SELECT ROW_NUMBER() OVER (ORDER BY size), size" + from + " ORDER BY size",
+			[]string{"1\t1", "2\t2", "3\t3", "4\t3", "5\t5", "6\t7"},
+		},
+		{
+			"SELECT RANK() OVER (), DENSE_RANK() OVER (PARTITION BY ext)" + from,
+			[]string{"1\t1", "1\t1", "1\t1", "1\t1", "1\t1", "1\t1"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// ROW_NUMBER is unique within each partition, even for peers.
+	lines, err := runLines("SELECT ext, ROW_NUMBER() OVER (PARTITION BY ext)"+from, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if seen[line] {
+			t.Fatalf("\nExpected unique row numbers per partition\n     Got %v", lines)
+		}
+		seen[line] = true
+	}
+}
+
+func TestDistributionWindowFunctions(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bb",
+		"c.go": "ccc",
+		"d.go": "ddd",
+		"e.go": "eeeee",
+		"f.py": "ffff",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT size, PERCENT_RANK() OVER w, CUME_DIST() OVER w" + from +
+				" AND name LIKE %.go WINDOW w AS (ORDER BY size) ORDER BY size",
+			[]string{"1\t0\t0.2", "2\t0.25\t0.4", "3\t0.5\t0.8", "3\t0.5\t0.8", "5\t1\t1"},
+		},
+		{
+			"SELECT size, PERCENT_RANK() OVER (ORDER BY size DESC)" + from + " AND name LIKE %.go ORDER BY size",
+			[]string{"1\t1", "2\t0.75", "3\t0.25", "3\t0.25", "5\t0"},
+		},
+		{
+			"SELECT size, PERCENT_RANK() OVER w, CUME_DIST() OVER w" + from +
+				" AND name LIKE %.py WINDOW w AS (PARTITION BY ext ORDER BY size)",
+			[]string{"4\t0\t1"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestOffsetWindowFunctions(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bb",
+		"c.go": "ccc",
+		"d.py": "dddd",
+		"e.py": "eeeee",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT size, LAG(size) OVER w, LEAD(size, 2) OVER w" + from + " WINDOW w AS (ORDER BY size)",
+			[]string{"1\t\t3", "2\t1\t4", "3\t2\t5", "4\t3\t", "5\t4\t"},
+		},
+		{
+			"SELECT size, LAG(size, 1) OVER (PARTITION BY ext ORDER BY size)" + from + " ORDER BY size",
+			[]string{"1\t", "2\t1", "3\t2", "4\t", "5\t4"},
+		},
+		{
+			"SELECT size, LAG(size, 0) OVER (), LEAD(size, 0) OVER ()" + from + " ORDER BY size",
+			[]string{"1\t1\t1", "2\t2\t2", "3\t3\t3", "4\t4\t4", "5\t5\t5"},
+		},
+		{
+			"SELECT size, LAG(size, 5) OVER (), LEAD(size, 10) OVER ()" + from + " ORDER BY size",
+			[]string{"1\t\t", "2\t\t", "3\t\t", "4\t\t", "5\t\t"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	// Missing values are null in the JSON output.
+	var buf bytes.Buffer
+	input := "SELECT LEAD(ext) OVER (ORDER BY size) AS next" + from + " AND size < 3"
+	if err := run(input, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+	expected := []map[string]interface{}{{"next": ".go"}, {"next": nil}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows)
+	}
+}
+
+func TestValueWindowFunctions(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bbbb",
+		"c.go": "cc",
+		"d.py": "ddddddd",
+		"e.py": "eee",
+		"f.md": "ffffff",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT ext, size, FIRST_VALUE(size) OVER w AS biggest, LAST_VALUE(size) OVER w AS smallest" + from +
+				" WINDOW w AS (PARTITION BY ext ORDER BY size DESC) ORDER BY ext, size",
+			[]string{
+				".go\t1\t4\t1", ".go\t2\t4\t1", ".go\t4\t4\t1",
+				".md\t6\t6\t6",
+				".py\t3\t7\t3", ".py\t7\t7\t3",
+			},
+		},
+		{
+			"SELECT size, FIRST_VALUE(ext) OVER (ORDER BY size), LAST_VALUE(ext) OVER (ORDER BY size)" + from +
+				" ORDER BY size DESC LIMIT 2",
+			[]string{"7\t.go\t.py", "6\t.go\t.py"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestNtile(t *testing.T) {
+	files := make(map[string]string)
+	for i := 1; i <= 9; i++ {
+		files[fmt.Sprintf("%d.txt", i)] = strings.Repeat("a", i)
+	}
+	dir := createMockTree(t, files)
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT NTILE(4) OVER (ORDER BY size) AS quartile" + from + " AND size < 9 ORDER BY size",
+			[]string{"1", "1", "2", "2", "3", "3", "4", "4"},
+		},
+		{
+			"SELECT NTILE(4) OVER (ORDER BY size) AS quartile" + from + " ORDER BY size",
+			[]string{"1", "1", "1", "2", "2", "3", "3", "4", "4"},
+		},
+		{
+			"SELECT NTILE(1) OVER (ORDER BY size)" + from + " ORDER BY size",
+			[]string{"1", "1", "1", "1", "1", "1", "1", "1", "1"},
+		},
+		{
+			"SELECT NTILE(5) OVER (ORDER BY size DESC)" + from + " AND size < 4 ORDER BY size",
+			[]string{"3", "2", "1"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	if _, err := runLines("SELECT NTILE(0) OVER ()"+from, &options{}); err == nil {
+		t.Fatalf("\nExpected an error for NTILE(0)")
+	}
+}
+
+func TestRowNumber(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "aaaaa",
+		"b.go": "bbb",
+		"c.go": "cccccccc",
+		"d.go": "d",
+		"e.py": "ee",
+		"f.py": "fffffff",
+		"g.py": "gggg",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT ROW_NUMBER(), size" + from + " ORDER BY size DESC",
+			[]string{"1\t8", "2\t7", "3\t5", "4\t4", "5\t3", "6\t2", "7\t1"},
+		},
+		{
+			"SELECT ROW_NUMBER(), size" + from + " ORDER BY size DESC LIMIT 5",
+			[]string{"1\t8", "2\t7", "3\t5", "4\t4", "5\t3"},
+		},
+		{
+			"SELECT DISTINCT ext, ROW_NUMBER() AS n" + from + " ORDER BY ext",
+			[]string{".go\t1", ".py\t2"},
+		},
+		{
+			"WITH big AS (SELECT size" + from + " AND size > 3) SELECT ROW_NUMBER(), size FROM big ORDER BY size",
+			[]string{"1\t4", "2\t5", "3\t7", "4\t8"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestPragma(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"Foo.txt":   "",
+		"bar.txt":   "",
+		"x/foo.go":  "",
+		"x/y/z.txt": "",
+	})
+	for target, link := range map[string]string{"x": "link", ".": "x/y/up"} {
+		if err := os.Symlink(filepath.Join(dir, target), filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT name" + from + " AND name CONTAINS foo", []string{
+			filepath.Join(dir, "x", "foo.go"),
+		}},
+		{"PRAGMA case_sensitive = false; SELECT name" + from + " AND name CONTAINS FOO", []string{
+			filepath.Join(dir, "Foo.txt"), filepath.Join(dir, "x", "foo.go"),
+		}},
+		{"PRAGMA case_sensitive = false; SELECT name" + from + " AND name RLIKE ^foo", []string{
+			filepath.Join(dir, "Foo.txt"), filepath.Join(dir, "x", "foo.go"),
+		}},
+		{"PRAGMA max_depth = 1; SELECT name" + from + " ORDER BY name", []string{
+			filepath.Join(dir, "Foo.txt"), filepath.Join(dir, "bar.txt"),
+		}},
+		{"PRAGMA follow_symlinks = true; PRAGMA max_depth = 2; SELECT name" + from + " ORDER BY name", []string{
+			filepath.Join(dir, "Foo.txt"), filepath.Join(dir, "bar.txt"),
+			filepath.Join(dir, "link", "foo.go"), filepath.Join(dir, "x", "foo.go"),
+		}},
+		{"PRAGMA follow_symlinks = true; SELECT name" + from + " ORDER BY name", []string{
+			filepath.Join(dir, "Foo.txt"), filepath.Join(dir, "bar.txt"),
+			filepath.Join(dir, "link", "foo.go"), filepath.Join(dir, "x", "foo.go"),
+			filepath.Join(dir, "link", "y", "z.txt"), filepath.Join(dir, "x", "y", "z.txt"),
+		}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	var errw bytes.Buffer
+	err := run("PRAGMA foo = bar SELECT name"+from, &options{}, ioutil.Discard, &errw)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := "warning: unknown pragma: foo\n"; errw.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, errw.String())
+	}
+
+	for _, input := range []string{
+		"PRAGMA max_depth = 0; SELECT name" + from,
+		"PRAGMA case_sensitive = maybe; SELECT name" + from,
+	} {
+		if err := run(input, &options{}, ioutil.Discard, ioutil.Discard); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestTableSample(t *testing.T) {
+	const dirs, filesPerDir, runs, percent = 20, 10, 100, 25.0
+
+	files := make(map[string]string, dirs*filesPerDir)
+	for i := 0; i < dirs; i++ {
+		for j := 0; j < filesPerDir; j++ {
+			files[fmt.Sprintf("%d/%d", i, j)] = ""
+		}
+	}
+	dir := createMockTree(t, files)
+	previous := sampleRand
+	sampleRand = rand.New(rand.NewSource(1))
+	t.Cleanup(func() { sampleRand = previous })
+
+	type Case struct {
+		method string
+		n      int // How many items (files or directories) are sampled.
+		size   int // How many results each sampled item contributes.
+	}
+
+	cases := []Case{
+		{"BERNOULLI", dirs * filesPerDir, 1},
+		{"SYSTEM", dirs, filesPerDir},
+	}
+
+	for _, c := range cases {
+		input := fmt.Sprintf("SELECT name FROM %s TABLESAMPLE %s (%g PERCENT) WHERE file IS reg",
+			dir, c.method, percent)
+
+		total := 0
+		for i := 0; i < runs; i++ {
+			lines, err := runLines(input, &options{})
+			if err != nil {
+				t.Fatalf("\nExpected no error\n     Got %v", err)
+			}
+			if len(lines)%c.size != 0 {
+				t.Fatalf("\nExpected a multiple of %d results\n     Got %d", c.size, len(lines))
+			}
+			total += len(lines) / c.size
+		}
+
+		// The total follows a binomial distribution, so it should be within 2
+		// standard deviations of the mean.
+		p := percent / 100
+		mean := float64(runs*c.n) * p
+		sd := math.Sqrt(float64(runs*c.n) * p * (1 - p))
+		if math.Abs(float64(total)-mean) > 2*sd {
+			t.Fatalf("\nExpected %s total within %g of %g\n     Got %d", c.method, 2*sd, mean, total)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . TABLESAMPLE (10 PERCENT)",
+		"SELECT name FROM . TABLESAMPLE RANDOM (10 PERCENT)",
+		"SELECT name FROM . TABLESAMPLE SYSTEM (110 PERCENT)",
+		"SELECT name FROM . TABLESAMPLE SYSTEM (10)",
+	} {
+		if _, err := runLines(input, &options{}); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	dir := createTree(t, map[string]string{"a": "a", "sub/b": "b"})
+
+	lines, err := runLines("SELECT name FROM "+dir, &options{benchmark: 10})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("\nExpected 1 line\n     Got %q", lines)
+	}
+
+	// The format read by benchstat: the name, the number of runs, then pairs
+	// of values and units.
+	re := regexp.MustCompile(`^Benchmark\w+\t(\d+)(\t\d+ [\w/-]+)+$`)
+	match := re.FindStringSubmatch(lines[0])
+	if match == nil {
+		t.Fatalf("\nExpected a Go benchmark line\n     Got %q", lines[0])
+	}
+	if match[1] != "9" {
+		t.Fatalf("\nExpected 9 runs\n     Got %s", match[1])
+	}
+
+	expected := "BenchmarkFSQL\t4\t25 ns/op\t10 min-ns/op\t40 max-ns/op\t40 p99-ns/op"
+	if actual := formatBenchmark([]time.Duration{40, 10, 30, 20}); actual != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, actual)
+	}
+
+	if _, err := runLines("SELECT name FROM "+dir, &options{benchmark: 1}); err == nil {
+		t.Fatalf("\nExpected error for a single run")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 5; i++ {
+		files[fmt.Sprintf("d%d/a.txt", i)] = "tmp"
+		files[fmt.Sprintf("d%d/sub/b.go", i)] = "package b"
+	}
+	dir := createTree(t, files)
+	lstats, reads := countLstats(t), countReads(t)
+
+	type Case struct {
+		where    string
+		expected string
+	}
+
+	cases := []Case{
+		{"name LIKE %.txt", "lstat calls: 22\ndirectory reads: 11\ncontent reads: 0"},
+		{"depth <= 1", "lstat calls: 7\ndirectory reads: 1\ncontent reads: 0"},
+		{"file IS reg AND CONTAINS_TEXT(tmp)", "lstat calls: 22\ndirectory reads: 11\ncontent reads: 10"},
+	}
+
+	for _, c := range cases {
+		input := "SELECT name FROM " + dir + " WHERE " + c.where
+
+		*lstats, *reads = 0, 0
+		results, err := runLines(input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		walked, read := *lstats, *reads
+
+		*lstats, *reads = 0, 0
+		lines, err := runLines(input, &options{simulate: true})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if actual := strings.Join(lines, "\n"); actual != c.expected {
+			t.Fatalf("%q\nExpected %q\n     Got %q", c.where, c.expected, actual)
+		}
+
+		// The simulation counts the same walk (and the Stat of the source),
+		// but doesn't read any contents itself.
+		var simulated ioCounts
+		fmt.Sscanf(c.expected, "lstat calls: %d\ndirectory reads: %d\ncontent reads: %d",
+			&simulated.lstats, &simulated.dirReads, &simulated.contentReads)
+		if simulated.lstats != walked+1 || simulated.contentReads != read || simulated.lstats < len(results) {
+			t.Fatalf("%q\nExpected %d files walked and %d read for %d results\n     Got %+v",
+				c.where, walked, read, len(results), simulated)
+		}
+		if *reads != 0 {
+			t.Fatalf("\nExpected no files read when simulating\n     Got %d", *reads)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "a", "b.go": "b", "c.py": "c"})
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	input := "SELECT name FROM " + dir + " WHERE name LIKE %.go"
+
+	lines, err := runLines(input, &options{saveBaseline: path})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(lines) != 0 {
+		t.Fatalf("\nExpected no output\n     Got %q", lines)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "d.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err = runLines(input, &options{diff: path})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := []string{"- " + filepath.Join(dir, "a.go"), "+ " + filepath.Join(dir, "d.go")}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, lines)
+	}
+
+	// Saving the new baseline while diffing against it leaves nothing to diff
+	// on the next run.
+	if _, err := runLines(input, &options{diff: path, saveBaseline: path}); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if lines, _ = runLines(input, &options{diff: path}); len(lines) != 0 {
+		t.Fatalf("\nExpected no differences\n     Got %q", lines)
+	}
+
+	if _, err := runLines(input, &options{diff: filepath.Join(dir, "c.py")}); err == nil {
+		t.Fatalf("\nExpected error for an invalid baseline")
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	opts := &options{cache: true, cacheDir: t.TempDir(), cacheTTL: time.Minute}
+	input := "SELECT size FROM " + dir + " WHERE name LIKE %.txt ORDER BY name"
+
+	// The modification times of the top two levels of the tree are what the
+	// cached outputs are invalidated by, so they're set by the test.
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	times := map[string]time.Time{dir: mtime, filepath.Join(dir, "sub"): mtime}
+	setTimes := func(path string, mtime time.Time) {
+		times[path] = mtime
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Update the contents of a file, without changing the directories' times.
+	update := func(path, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for path, mtime := range times {
+			setTimes(path, mtime)
+		}
+	}
+	update("a.txt", "a")
+
+	type Case struct {
+		update   func()
+		expected []string
+	}
+
+	cases := []Case{
+		{func() {}, []string{"1", "1"}},
+		// The files aren't walked while the output is cached, so changing
+		// one isn't noticed until the times of its directories change.
+		{func() { update("a.txt", "aa") }, []string{"1", "1"}},
+		{func() { setTimes(dir, mtime.Add(time.Second)) }, []string{"2", "1"}},
+		{func() { update("sub/b.txt", "bb") }, []string{"2", "1"}},
+		{func() { setTimes(filepath.Join(dir, "sub"), mtime.Add(time.Second)) }, []string{"2", "2"}},
+		// An expired entry is read again.
+		{func() { update("a.txt", "aaa"); opts.cacheTTL = 0 }, []string{"3", "2"}},
+	}
+
+	for _, c := range cases {
+		c.update()
+		lines, err := runLines(input, opts)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, lines)
+		}
+	}
+
+	// The output of a query with a TABLESAMPLE clause isn't cached.
+	opts.cacheTTL = time.Minute
+	entries, _ := ioutil.ReadDir(opts.cacheDir)
+	if _, err := runLines("SELECT name FROM "+dir+" TABLESAMPLE BERNOULLI (100 PERCENT)", opts); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if after, _ := ioutil.ReadDir(opts.cacheDir); len(after) != len(entries) {
+		t.Fatalf("\nExpected %d cached outputs\n     Got %d", len(entries), len(after))
+	}
+}
+
+func TestCacheRelativeSource(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	opts := &options{cache: true, cacheDir: t.TempDir(), cacheTTL: time.Minute}
+	input := "SELECT name FROM sub WHERE file IS reg"
+
+	// The same relative source is run from two directories, whose sub
+	// directories have the same modification time (and so the same tag, but
+	// for their paths).
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		dir := createTree(t, map[string]string{"sub/" + name: ""})
+		if err := os.Chtimes(filepath.Join(dir, "sub"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		lines, err := runLines(input, opts)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if expected := []string{"sub/" + name}; !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected, lines)
+		}
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 5; j++ {
+			files[fmt.Sprintf("d%02d/sub/file%d.txt", i, j)] = ""
+		}
+	}
+	files["d07/sub/needle.go"] = ""
+	files["d13/Needle.txt"] = ""
+	dir := createTree(t, files)
+
+	// Return the results of a query (other than the index itself), and the
+	// number of files visited.
+	evaluateInput := func(input string) ([]string, int) {
+		q, err := query.RunParser(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qopts, _, err := query.NewQueryOptions(q.Pragmas)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog := new(progress)
+		results, err := evaluateWith(context.Background(), q, qopts, prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths := make([]string, 0)
+		for _, r := range results {
+			if r.path != filepath.Join(dir, indexFile) {
+				paths = append(paths, r.path)
+			}
+		}
+		sort.Strings(paths)
+		return paths, prog.current()
+	}
+
+	inputs := []string{
+		"SELECT name FROM " + dir + " WHERE name CONTAINS needle",
+		"SELECT name FROM " + dir + " WHERE name = needle.go",
+		"SELECT name FROM " + dir + " WHERE name CONTAINS file AND name CONTAINS dle",
+		"SELECT name FROM " + dir + " WHERE name CONTAINS needle OR name CONTAINS d13",
+		"SELECT name FROM " + dir + " WHERE NOT name CONTAINS file",
+		"SELECT name FROM " + dir + " WHERE name CONTAINS d0",
+		"PRAGMA case_sensitive = false SELECT name FROM " + dir + " WHERE name CONTAINS NEEDLE",
+	}
+	expected := make([][]string, len(inputs))
+	visited := make([]int, len(inputs))
+	for i, input := range inputs {
+		expected[i], visited[i] = evaluateInput(input)
+	}
+
+	if _, err := runLines("REBUILD INDEX FROM "+dir, &options{}); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	// The index has no false negatives, so the results are the same, but
+	// fewer files are visited when the index can skip directories.
+	for i, input := range inputs {
+		paths, n := evaluateInput(input)
+		if !reflect.DeepEqual(paths, expected[i]) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected[i], paths)
+		}
+		if i < 3 && n >= visited[i] {
+			t.Fatalf("\nExpected fewer than %d files visited for %q\n     Got %d", visited[i], input, n)
+		}
+	}
+
+	// Files added since the index was built are still found, since their
+	// directories are stale.
+	path := filepath.Join(dir, "d03", "sub", "needle.txt")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := evaluateInput(inputs[0])
+	if i := sort.SearchStrings(paths, path); i == len(paths) || paths[i] != path {
+		t.Fatalf("\nExpected %s in %q", path, paths)
+	}
+
+	if _, err := runLines("REBUILD INDEX FROM "+filepath.Join(dir, "d00", "sub", "file0.txt"), &options{}); err == nil {
+		t.Fatalf("\nExpected error for indexing a file")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		f.add(strconv.Itoa(i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 2000; i++ {
+		if !f.mayContain(strconv.Itoa(i)) && i < 1000 {
+			t.Fatalf("\nExpected %d in the filter", i)
+		}
+		if f.mayContain(strconv.Itoa(i)) && i >= 1000 {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Fatalf("\nExpected at most 50 false positives\n     Got %d", falsePositives)
+	}
+}
+
+// A jsonTextIndex is a text index stored in a JSON file, which stands in for
+// the SQLite database when the tests aren't built with -tags sqlite.
+type jsonTextIndex struct {
+	path     string
+	Files    map[string]indexedText
+	Trigrams map[string][]string // The paths of the files with each trigram.
+	Info     *textIndexInfo
+}
+
+func (j *jsonTextIndex) add(path string, f indexedText, trigrams []string) error {
+	j.Files[path] = f
+	for _, gram := range trigrams {
+		j.Trigrams[gram] = append(j.Trigrams[gram], path)
+	}
+	return nil
+}
+
+func (j *jsonTextIndex) close(info *textIndexInfo) error {
+	if info == nil {
+		return nil
+	}
+	j.Info = info
+	contents, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(j.path, contents, 0644)
+}
+
+func (j *jsonTextIndex) files() (map[string]indexedText, error) { return j.Files, nil }
+
+func (j *jsonTextIndex) candidates(trigrams []string) (map[string]bool, error) {
+	counts := make(map[string]int)
+	for _, gram := range trigrams {
+		for _, path := range j.Trigrams[gram] {
+			counts[path]++
+		}
+	}
+	candidates := make(map[string]bool)
+	for path, n := range counts {
+		candidates[path] = n == len(trigrams)
+	}
+	return candidates, nil
+}
+
+func (j *jsonTextIndex) info() (*textIndexInfo, error) { return j.Info, nil }
+
+func (j *jsonTextIndex) Close() error { return nil }
+
+func TestTextIndex(t *testing.T) {
+	if createTextIndex == nil {
+		createTextIndex = func(path string) (textIndexWriter, error) {
+			return &jsonTextIndex{path: path, Files: map[string]indexedText{}, Trigrams: map[string][]string{}}, nil
+		}
+		openTextIndex = func(path string) (textIndex, error) {
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			j := new(jsonTextIndex)
+			return j, json.Unmarshal(contents, j)
+		}
+		defer func() { createTextIndex, openTextIndex = nil, nil }()
+	}
+
+	dir := createTree(t, map[string]string{
+		"a.txt":     "hello TODO world",
+		"b.txt":     "nothing",
+		"sub/c.go":  "// todo: lowercase",
+		"sub/d.bin": "TODO\x00",
+		"e.txt":     "TO",
+	})
+
+	// Return the sorted names of the results of a query (other than the
+	// index itself).
+	names := func(input string) []string {
+		lines, err := runLines(input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		paths := make([]string, 0)
+		for _, line := range lines {
+			if line != filepath.Join(dir, textIndexFile) {
+				paths = append(paths, strings.TrimPrefix(line, dir+"/"))
+			}
+		}
+		sort.Strings(paths)
+		return paths
+	}
+
+	from := " FROM " + dir + " WHERE "
+	inputs := []string{
+		"SELECT name" + from + "contains_text(TODO)",
+		"SELECT name" + from + "contains_text(todo)",
+		"SELECT name" + from + "NOT contains_text(TODO) AND file IS reg",
+		"PRAGMA case_sensitive = false SELECT name" + from + "contains_text(todo)",
+		"SELECT name" + from + "contains_text(TO)",
+		"SELECT name" + from + "contains_text(world) OR name = b.txt",
+	}
+	expected := make([][]string, len(inputs))
+	for i, input := range inputs {
+		expected[i] = names(input)
+	}
+	if !reflect.DeepEqual(expected[0], []string{"a.txt"}) {
+		t.Fatalf("\nExpected %q\n     Got %q", []string{"a.txt"}, expected[0])
+	}
+
+	run := func(args ...string) string {
+		var buf bytes.Buffer
+		if err := runIndex(args, &buf); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		return buf.String()
+	}
+	if output := run("build", dir); !strings.HasSuffix(output, "files: 5\n") {
+		t.Fatalf("\nExpected 5 files indexed\n     Got %q", output)
+	}
+	if err := runIndex([]string{"build", dir}, ioutil.Discard); err == nil {
+		t.Fatalf("\nExpected error for building an existing index")
+	}
+
+	// The index finds the same files as reading each of them.
+	for i, input := range inputs {
+		if paths := names(input); !reflect.DeepEqual(paths, expected[i]) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected[i], paths)
+		}
+	}
+
+	// A file which isn't a candidate isn't read, so a change which keeps its
+	// time and size isn't noticed.
+	path := filepath.Join(dir, "b.txt")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("TODO..."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if paths := names(inputs[0]); !reflect.DeepEqual(paths, []string{"a.txt"}) {
+		t.Fatalf("\nExpected %q\n     Got %q", []string{"a.txt"}, paths)
+	}
+
+	// A file which has changed since it was indexed is read.
+	later := info.ModTime().Add(time.Second)
+	for name, contents := range map[string]string{"a.txt": "hello world", "e.txt": "TODO"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if paths := names(inputs[0]); !reflect.DeepEqual(paths, []string{"e.txt"}) {
+		t.Fatalf("\nExpected %q\n     Got %q", []string{"e.txt"}, paths)
+	}
+
+	// Rebuilding the index reads each of the files again.
+	run("rebuild", dir)
+	if paths := names(inputs[0]); !reflect.DeepEqual(paths, []string{"b.txt", "e.txt"}) {
+		t.Fatalf("\nExpected %q\n     Got %q", []string{"b.txt", "e.txt"}, paths)
+	}
+	if output := run("info", dir); !strings.HasPrefix(output, "built: ") || !strings.HasSuffix(output, "files: 5\n") {
+		t.Fatalf("\nExpected the index's metadata\n     Got %q", output)
+	}
+
+	for _, args := range [][]string{{"build"}, {"drop", dir}, {"info", filepath.Join(dir, "sub")}, {"build", path}} {
+		if err := runIndex(args, ioutil.Discard); err == nil {
+			t.Fatalf("\nExpected error for %q", args)
+		}
+	}
+}
+
+// Count the files read while the test runs (see readFile).
+func countReads(tb testing.TB) *int {
+	reads := new(int)
+	readFile = func(path string) ([]byte, error) {
+		*reads++
+		return ioutil.ReadFile(path)
+	}
+	tb.Cleanup(func() { readFile = ioutil.ReadFile })
+	return reads
+}
+
+func TestLazyConditions(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 10; i++ {
+		files[fmt.Sprintf("%d.txt", i)] = fmt.Sprintf("TODO %d", i)
+	}
+	dir := createTree(t, files)
+	reads := countReads(t)
+
+	type Case struct {
+		where    string
+		expected []string
+		reads    int
+	}
+
+	cases := []Case{
+		// The cheap condition is evaluated first, wherever it is.
+		{"name = 3.txt AND contains_text(TODO)", []string{"3.txt"}, 1},
+		{"contains_text(TODO) AND name = 3.txt", []string{"3.txt"}, 1},
+		{"contains_text(TODO) OR file IS reg", []string{"0.txt", "1.txt", "2.txt"}, 0},
+		// Each file is read at most once, however many conditions need it.
+		{"contains_text(TODO) AND contains_text(5)", []string{"5.txt"}, 10},
+		{"file IS reg AND (contains_text(1) OR contains_text(2))", []string{"1.txt", "2.txt"}, 10},
+	}
+
+	for _, c := range cases {
+		*reads = 0
+		lines, err := runLines("SELECT name FROM "+dir+" WHERE "+c.where+" ORDER BY name LIMIT 3", &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		for i, line := range lines {
+			lines[i] = filepath.Base(line)
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, lines)
+		}
+		if *reads != c.reads {
+			t.Fatalf("\nExpected %d files read for %q\n     Got %d", c.reads, c.where, *reads)
+		}
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"small.txt": "TODO",
+		"large.txt": "TODO " + strings.Repeat("x", 2<<20),
+	})
+	reads := countReads(t)
+
+	type Case struct {
+		where    string
+		expected []string
+	}
+
+	// The large file's CONTAINS_TEXT is NULL, which satisfies neither it nor
+	// its negation, but it's still a result of other conditions.
+	cases := []Case{
+		{"contains_text(TODO)", []string{"small.txt"}},
+		{"file IS reg AND NOT contains_text(TODO)", []string{}},
+		{"contains_text(TODO) OR name = large.txt", []string{"large.txt", "small.txt"}},
+		{"file IS reg AND (contains_text(xxx) OR NOT contains_text(xxx))", []string{"small.txt"}},
+	}
+
+	for _, pragma := range []string{"", "PRAGMA case_sensitive = false "} {
+		for _, c := range cases {
+			*reads = 0
+			var w, errw bytes.Buffer
+			input := pragma + "SELECT name FROM " + dir + " WHERE " + c.where + " ORDER BY name"
+			if err := run(input, &options{maxFileSize: 1 << 20}, &w, &errw); err != nil {
+				t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+			}
+			lines := []string{}
+			for _, line := range strings.Fields(w.String()) {
+				lines = append(lines, filepath.Base(line))
+			}
+			if !reflect.DeepEqual(lines, c.expected) {
+				t.Fatalf("\nExpected %q for %q\n     Got %q", c.expected, input, lines)
+			}
+			if *reads > 1 {
+				t.Fatalf("\nExpected only small.txt read for %q\n     Got %d files", input, *reads)
+			}
+			if errw.Len() != 0 {
+				t.Fatalf("\nExpected nothing logged without -verbose\n     Got %q", errw.String())
+			}
+		}
+	}
+
+	// With -verbose, each skipped file is logged once.
+	var w, errw bytes.Buffer
+	input := "SELECT name FROM " + dir + " WHERE contains_text(TODO) OR contains_text(xxx)"
+	if err := run(input, &options{maxFileSize: 1 << 20, verbose: true}, &w, &errw); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := "skipped " + filepath.Join(dir, "large.txt") + ": 2097157 bytes is larger than -max-file-size\n"
+	if errw.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, errw.String())
+	}
+
+	// The size may have a unit, and must be positive.
+	fs := flag.NewFlagSet("fsql", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	opts := new(options)
+	defineFlags(fs, opts)
+	if err := fs.Parse([]string{"-max-file-size", "1mb"}); err != nil || opts.maxFileSize != 1<<20 {
+		t.Fatalf("\nExpected a max file size of 1mb\n     Got %d (%v)", opts.maxFileSize, err)
+	}
+	for _, size := range []string{"0", "-1", "big"} {
+		if err := fs.Parse([]string{"-max-file-size", size}); err == nil {
+			t.Fatalf("\nExpected error for -max-file-size %s", size)
+		}
+	}
+}
+
+// Compare the files read by CONTAINS_TEXT when it's combined with a condition
+// which few files satisfy, in either order. Since the cheaper condition is
+// evaluated first, both read a single file rather than each of them.
+func BenchmarkLazyConditions(b *testing.B) {
+	files := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("%d.txt", i)] = "TODO"
+	}
+	dir := createTree(b, files)
+
+	for _, where := range []string{"name = 7.txt AND contains_text(TODO)", "contains_text(TODO) AND name = 7.txt"} {
+		b.Run(where, func(b *testing.B) {
+			reads := countReads(b)
+			for i := 0; i < b.N; i++ {
+				if _, err := runLines("SELECT name FROM "+dir+" WHERE "+where, &options{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(*reads)/float64(b.N), "reads/op")
+		})
+	}
+}
+
+// Return a tree (see testutil.MockVFS) of n files and directories below root,
+// which is the same for each n, for the evaluator's benchmarks. There are 10
+// directories below root, with 10 subdirectories each, and the files are
+// spread among them with a mix of extensions, sizes and modification times.
+func benchTree(root string, n int) testutil.MockVFS {
+	m := testutil.MockVFS{root: {Mode: os.ModeDir}}
+	var dirs []string
+	for i := 0; i < 10; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", i))
+		m[dir] = testutil.MockFile{Mode: os.ModeDir}
+		for j := 0; j < 10; j++ {
+			sub := filepath.Join(dir, fmt.Sprintf("s%d", j))
+			m[sub] = testutil.MockFile{Mode: os.ModeDir}
+			dirs = append(dirs, dir, sub)
+		}
+	}
+
+	exts := []string{".go", "_test.go", ".md", ".txt", ".json"}
+	for i := 0; len(m) < n; i++ {
+		name := fmt.Sprintf("%d%s", i, exts[i%len(exts)])
+		m[filepath.Join(dirs[i%len(dirs)], name)] = testutil.MockFile{
+			Size:    int64(i*37%5000 + 1),
+			ModTime: testutil.DefaultModTime.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	return m
+}
+
+// Benchmark evaluating the query (which is only parsed once) against a tree
+// of n files (see benchTree).
+func benchmarkEvaluator(b *testing.B, n int, where string) {
+	root := filepath.Join(string(filepath.Separator), "fsql", "bench")
+	useVFS(b, benchTree(root, n))
+	q, err := query.RunParser("SELECT name, size FROM " + root + " WHERE " + where)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		evaluateWith(context.Background(), q, query.QueryOptions{}, nil)
+	}
+}
+
+func BenchmarkEvaluatorSimple(b *testing.B) {
+	benchmarkEvaluator(b, 1000, "name LIKE %.go")
+}
+
+func BenchmarkEvaluatorComplex(b *testing.B) {
+	benchmarkEvaluator(b, 10000, "file IS reg AND (name LIKE %.go OR name LIKE %.md) "+
+		"AND NOT name LIKE %_test.go AND size >= 1kb AND time > 'Jan 02 2017 00 00'")
+}
+
+// Count the calls to lstat (one per file walked) until the test ends.
+func countLstats(tb testing.TB) *int {
+	calls := new(int)
+	lstat = func(path string) (os.FileInfo, error) {
+		*calls++
+		return os.Lstat(path)
+	}
+	tb.Cleanup(func() { lstat = os.Lstat })
+	return calls
+}
+
+func TestPushDown(t *testing.T) {
+	files := make(map[string]string)
+	for _, top := range []string{"a", "b", "c"} {
+		for i := 0; i < 5; i++ {
+			files[fmt.Sprintf("%s/%d/deep/file.txt", top, i)] = ""
+		}
+		files[top+"/top.txt"] = ""
+	}
+	dir := createTree(t, files)
+	lstats := countLstats(t)
+
+	// The same condition OR a condition which no file satisfies can't be
+	// pushed down, so it walks each file.
+	wheres := []string{
+		"depth <= 1",
+		"depth < 3 AND file IS reg",
+		"depth = 2",
+		"dir BEGINSWITH " + filepath.Join(dir, "a"),
+		"dir = " + filepath.Join(dir, "b", "3") + " AND name = deep",
+		"dir BEGINSWITH " + filepath.Join(dir, "c", "2") + " AND depth <= 3",
+	}
+	for _, where := range wheres {
+		run := func(where string) ([]string, int) {
+			*lstats = 0
+			lines, err := runLines("SELECT name, depth FROM "+dir+" WHERE "+where+" ORDER BY name", &options{})
+			if err != nil {
+				t.Fatalf("\nExpected no error\n     Got %v", err)
+			}
+			return lines, *lstats
+		}
+
+		expected, walked := run(where + " OR name = none")
+		lines, pushed := run(where)
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected, lines)
+		}
+		if len(lines) == 0 || pushed >= walked {
+			t.Fatalf("\nExpected fewer than %d files walked for %q\n     Got %d (%d results)",
+				walked, where, pushed, len(lines))
+		}
+	}
+
+	// NOT and OR aren't pushed down.
+	lines, err := runLines("SELECT depth FROM "+dir+" WHERE NOT depth > 0 OR name = deep ORDER BY depth", &options{})
+	if expected := []string{"0", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3", "3"}; err != nil ||
+		!reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v %v", expected, lines, err)
+	}
+}
+
+func TestCanStream(t *testing.T) {
+	type Case struct {
+		input    string
+		expected bool
+	}
+
+	cases := []Case{
+		{"SELECT name FROM . WHERE size > 10 LIMIT 5", true},
+		{"WITH t AS (SELECT * FROM .) SELECT name FROM t", true},
+		{"SELECT name FROM . ORDER BY name", false},
+		{"SELECT DISTINCT dir FROM .", false},
+		{"SELECT dir, COUNT(*) FROM . GROUP BY dir", false},
+		{"SELECT name, ROW_NUMBER() FROM .", false},
+		{"SELECT name FROM . QUALIFY size > 10", false},
+	}
+
+	for _, c := range cases {
+		q, err := query.RunParser(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := canStream(q); actual != c.expected {
+			t.Fatalf("%q\nExpected %v\n     Got %v", c.input, c.expected, actual)
+		}
+	}
+
+	// QUALIFY filters the results in the formats which are usually streamed,
+	// like it does in the others.
+	dir := createMockTree(t, map[string]string{"small": "s", "large": strings.Repeat("l", 20)})
+	input := "SELECT name FROM " + dir + " WHERE file IS reg QUALIFY size > 10"
+	for _, opts := range []*options{{}, {machineReadable: true}, {format: "json"}} {
+		var buf bytes.Buffer
+		if err := run(input, opts, &buf, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if output := buf.String(); !strings.Contains(output, "large") || strings.Contains(output, "small") {
+			t.Fatalf("\nExpected only large with %+v\n     Got %q", *opts, output)
+		}
+	}
+}
+
+// A streamWriter records how many files had been walked when it was first
+// written to, and the most memory that was in use (after collecting garbage)
+// at every hundredth row it was written.
+type streamWriter struct {
+	lstats           *int
+	rows             int
+	firstWrite       int
+	heapAlloc, start uint64
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.firstWrite == 0 {
+		s.firstWrite = *s.lstats
+	}
+	if bytes.HasSuffix(p, []byte("\n")) {
+		if s.rows%100 == 0 {
+			runtime.GC()
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > s.heapAlloc {
+				s.heapAlloc = stats.HeapAlloc
+			}
+		}
+		s.rows++
+	}
+	return len(p), nil
+}
+
+func TestStreaming(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		for j := 0; j < 125; j++ {
+			files[fmt.Sprintf("dir%02d/file%03d", i, j)] = ""
+		}
+	}
+	dir := createTree(t, files)
+	lstats := countLstats(t)
+
+	run := func(input string) *streamWriter {
+		*lstats = 0
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+
+		w := &streamWriter{lstats: lstats, start: stats.HeapAlloc}
+		if err := run(input, &options{}, w, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if w.rows != 5000 {
+			t.Fatalf("\nExpected 5000 rows\n     Got %d", w.rows)
+		}
+		return w
+	}
+
+	// The first result is written as soon as it's walked, rather than after
+	// the walk.
+	streamed := run("SELECT name, size FROM " + dir + " WHERE file IS reg")
+	if streamed.firstWrite > 5 {
+		t.Fatalf("\nExpected the first result before the walk (of %d files)\n     Got it after %d files",
+			*lstats, streamed.firstWrite)
+	}
+
+	// Sorting the results needs all of them at once, so they're only written
+	// after the walk (and using memory for each of them).
+	buffered := run("SELECT name, size FROM " + dir + " WHERE file IS reg ORDER BY name")
+	if buffered.firstWrite != *lstats {
+		t.Fatalf("\nExpected the first result after the walk (of %d files)\n     Got it after %d files",
+			*lstats, buffered.firstWrite)
+	}
+
+	streamedHeap := int64(streamed.heapAlloc) - int64(streamed.start)
+	bufferedHeap := int64(buffered.heapAlloc) - int64(buffered.start)
+	if streamedHeap*4 > bufferedHeap {
+		t.Fatalf("\nExpected much less memory than %d bytes streaming\n     Got %d bytes", bufferedHeap, streamedHeap)
+	}
+
+	// OFFSET and LIMIT are applied as the results are streamed, and the walk
+	// stops at the LIMIT.
+	*lstats = 0
+	lines, err := runLines("SELECT name FROM "+dir+" WHERE name LIKE file% LIMIT 2 OFFSET 3 ROWS", &options{})
+	if expected := []string{filepath.Join(dir, "dir00", "file003"), filepath.Join(dir, "dir00", "file004")}; err != nil ||
+		!reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q %v", expected, lines, err)
+	}
+	if *lstats > 10 {
+		t.Fatalf("\nExpected the walk to stop at the LIMIT\n     Got %d files walked", *lstats)
+	}
+}
+
+func TestTop(t *testing.T) {
+	files := make(map[string]string)
+	for i := 1; i <= 8; i++ {
+		files[fmt.Sprintf("%d", i)] = strings.Repeat("a", i)
+	}
+	dir := createMockTree(t, files)
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	lines, err := runLines("SELECT TOP 5 name"+from, &options{})
+	if err != nil || len(lines) != 5 {
+		t.Fatalf("\nExpected 5 results\n     Got %v %v", lines, err)
+	}
+
+	lines, err = runLines("SELECT TOP 3 size"+from+" ORDER BY size DESC", &options{})
+	if expected := []string{"8", "7", "6"}; err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v %v", expected, lines, err)
+	}
+
+	// LIMIT takes precedence over TOP, with a warning.
+	var out, errw bytes.Buffer
+	if err := run("SELECT TOP 3 size"+from+" ORDER BY size LIMIT 2", &options{}, &out, &errw); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if out.String() != "1\n2\n" {
+		t.Fatalf("\nExpected 2 results\n     Got %q", out.String())
+	}
+	if expected := "warning: TOP 3 is overridden by LIMIT 2\n"; errw.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, errw.String())
+	}
+}
+
+func TestFetch(t *testing.T) {
+	files := make(map[string]string)
+	for i := 1; i <= 8; i++ {
+		files[fmt.Sprintf("%d", i)] = strings.Repeat("a", i)
+	}
+	dir := createMockTree(t, files)
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT size" + from + " ORDER BY size FETCH FIRST 3 ROWS ONLY", []string{"1", "2", "3"}},
+		{"SELECT size" + from + " ORDER BY size DESC FETCH NEXT 1 ROW ONLY", []string{"8"}},
+		{"SELECT size" + from + " ORDER BY size OFFSET 2 ROWS FETCH NEXT 3 ROWS ONLY", []string{"3", "4", "5"}},
+		{"SELECT size" + from + " ORDER BY size LIMIT 2 OFFSET 6", []string{"7", "8"}},
+		{"SELECT size" + from + " ORDER BY size OFFSET 7 ROWS", []string{"8"}},
+		{"SELECT size" + from + " ORDER BY size OFFSET 10 ROWS", []string{}},
+		{"SELECT size" + from + " ORDER BY size FETCH FIRST 0 ROWS ONLY", []string{}},
+	}
+
+	for _, c := range cases {
+		lines, err := runLines(c.query, &options{})
+		if err != nil || !reflect.DeepEqual(lines, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v %v", c.expected, lines, err)
+		}
+	}
+
+	// FETCH without ORDER BY is allowed, with a warning.
+	var out, errw bytes.Buffer
+	if err := run("SELECT size"+from+" FETCH FIRST 2 ROWS ONLY", &options{}, &out, &errw); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Fatalf("\nExpected 2 results\n     Got %q", out.String())
+	}
+	expected := "warning: FETCH without ORDER BY returns the first results in the order they're walked in\n"
+	if errw.String() != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, errw.String())
+	}
+}
+
+func TestQualify(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bb",
+		"c.go": "ccc",
+		"d.go": "dddd",
+		"e.py": "eeeee",
+		"f.py": "ffffff",
+		"g.md": "ggggggg",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// The 2 largest files of each extension.
+		{
+			"SELECT size, ROW_NUMBER() OVER (PARTITION BY ext ORDER BY size DESC) AS rn" + from +
+				" QUALIFY rn <= 2 ORDER BY size",
+			[]string{"3\t2", "4\t1", "5\t2", "6\t1", "7\t1"},
+		},
+		// The largest 50% of the files.
+		{
+			"SELECT size, PERCENT_RANK() OVER (ORDER BY size DESC) AS pr" + from +
+				" QUALIFY pr <= 0.5 ORDER BY size",
+			[]string{"4\t0.5", "5\t0.3333333333333333", "6\t0.16666666666666666", "7\t0"},
+		},
+		{
+			"SELECT size, RANK() OVER (ORDER BY size) AS r" + from + " QUALIFY r = 1 OR r > 6",
+			[]string{"1\t1", "7\t7"},
+		},
+		// QUALIFY can also compare attributes.
+		{
+			"SELECT size, ROW_NUMBER() OVER (PARTITION BY ext ORDER BY size DESC) AS rn" + from +
+				" QUALIFY rn = 1 AND size > 6",
+			[]string{"7\t1"},
+		},
+		// HAVING filters the groups by their aggregate functions.
+		{
+			"SELECT ext, COUNT(*) AS n" + from + " GROUP BY ext HAVING n >= 2",
+			[]string{".go\t4", ".py\t2"},
+		},
+		{
+			"SELECT ext, MEDIAN(size) AS m" + from + " GROUP BY ext HAVING m > 5 OR m < 2.5",
+			[]string{".md\t7", ".py\t5.5"},
+		},
+		// The extensions with at least 2 of the 3 largest files.
+		{
+			"WITH largest AS (SELECT name, ROW_NUMBER() OVER (ORDER BY size DESC) AS rn" + from +
+				" QUALIFY rn <= 3) SELECT ext, COUNT(*) AS n FROM largest GROUP BY ext HAVING n >= 2",
+			[]string{".py\t2"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestUnpivot(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.py": "bb",
+		"c.go": "ccc",
+	})
+
+	lines, err := runLines("SELECT size, attr_name, attr_value FROM "+dir+
+		" UNPIVOT (attr_value FOR attr_name IN (size, ext)) WHERE file IS reg ORDER BY size", &options{})
+	expected := []string{
+		"1\tsize\t1", "1\text\t.go",
+		"2\tsize\t2", "2\text\t.py",
+		"3\tsize\t3", "3\text\t.go",
+	}
+	if err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v %v", expected, lines, err)
+	}
+
+	// There's a result per attribute for each result of the CTE.
+	lines, err = runLines("WITH go AS (SELECT name FROM "+dir+" WHERE name LIKE %.go) "+
+		"SELECT k, v FROM go UNPIVOT (v FOR k IN (size, mode, time))", &options{})
+	if err != nil || len(lines) != 2*3 {
+		t.Fatalf("\nExpected %d results\n     Got %v %v", 2*3, lines, err)
+	}
+
+	type Case struct {
+		input    string
+		expected []map[string]interface{}
+	}
+
+	// The values keep their attribute's type, unless the attributes have
+	// different types.
+	cases := []Case{
+		{
+			"SELECT k, v FROM " + dir + " UNPIVOT (v FOR k IN (size, tar_offset)) WHERE name LIKE %.py",
+			[]map[string]interface{}{{"k": "size", "v": 2.0}, {"k": "tar_offset", "v": nil}},
+		},
+		{
+			"SELECT k, v FROM " + dir + " UNPIVOT (v FOR k IN (size, ext)) WHERE name LIKE %.py",
+			[]map[string]interface{}{{"k": "size", "v": "2"}, {"k": "ext", "v": ".py"}},
+		},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := run(c.input, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+			t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(rows, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, rows)
+		}
+	}
+}
+
+func TestValues(t *testing.T) {
+	from := ` FROM (VALUES ("a.go", 300), ("b.py", 100), ("c.go", 200), ("d.md", 400), ("c.go", 50)) AS t(name, size)`
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT name" + from, []string{"a.go", "b.py", "c.go", "d.md", "c.go"}},
+		{"SELECT name" + from + " WHERE size > 150 AND name LIKE %.go", []string{"a.go", "c.go"}},
+		{"SELECT name, size" + from + " ORDER BY size DESC LIMIT 2", []string{"d.md\t400", "a.go\t300"}},
+		{"SELECT ext, COUNT(*), MEDIAN(size)" + from + " GROUP BY ext", []string{".go\t3\t200", ".md\t1\t400", ".py\t1\t100"}},
+		{"SELECT name FROM (VALUES (a), (b)) AS t(name) WHERE file IS reg", []string{"a", "b"}},
+		{
+			`SELECT name, time FROM (VALUES ("a", "Jan 02 2020 10 00"), ("b", "Mar 04 2021 12 30")) AS t(name, time)` +
+				` WHERE time > "Feb 01 2020 00 00"`,
+			[]string{"b\tMar  4 12:30:00"},
+		},
+		{
+			"WITH big AS (SELECT name" + from + " WHERE size >= 300) SELECT name FROM big ORDER BY name",
+			[]string{"a.go", "d.md"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestGenerateSeries(t *testing.T) {
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT size FROM GENERATE_SERIES(0, 1000, 250)", []string{"0", "250", "500", "750", "1000"}},
+		{"SELECT size FROM GENERATE_SERIES(1, 3)", []string{"1", "2", "3"}},
+		{"SELECT size FROM GENERATE_SERIES(0, 10, 4)", []string{"0", "4", "8"}},
+		{"SELECT size FROM GENERATE_SERIES(10, -5, -5)", []string{"10", "5", "0", "-5"}},
+		{"SELECT size FROM GENERATE_SERIES(1, 10) WHERE size > 5 AND size <= 7", []string{"6", "7"}},
+		{"SELECT size FROM GENERATE_SERIES(1, 10, 2) WHERE file IS reg ORDER BY size DESC LIMIT 2", []string{"9", "7"}},
+		{"SELECT COUNT(*) FROM GENERATE_SERIES(1, 100)", []string{"100"}},
+		{"SELECT COUNT(*) FROM GENERATE_SERIES(5, 1)", []string{"0"}},
+		{"SELECT name FROM GENERATE_SERIES(-1, 1)", []string{"series://-1,1,1/-1", "series://-1,1,1/0", "series://-1,1,1/1"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT size FROM GENERATE_SERIES(1, 10, 0)",
+		"SELECT size FROM series://1,10,0",
+		"SELECT size FROM series://1,10",
+	} {
+		if _, err := runLines(input, &options{}); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a/x.go":  "x",
+		"a/y.go":  "yy",
+		"a/z.txt": "zzz",
+		"b/x.go":  "xxxx",
+		"b/z.txt": "zzzzz",
+	})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			"SELECT l.name, r.name, r.size FROM " + a + " AS l JOIN " + b + " AS r ON l.name = r.name ORDER BY l.name",
+			[]string{a + "/x.go\t" + b + "/x.go\t4", a + "/z.txt\t" + b + "/z.txt\t5"},
+		},
+		{
+			"SELECT l.name, r.name FROM " + a + " AS l INNER JOIN " + b + " AS r ON l.name = r.name AND r.size > 4",
+			[]string{a + "/z.txt\t" + b + "/z.txt"},
+		},
+		{
+			"SELECT l.name, r.size FROM " + a + " AS l LEFT JOIN " + b + " AS r ON l.name = r.name WHERE l.file IS reg ORDER BY l.name",
+			[]string{a + "/x.go\t4", a + "/y.go\t", a + "/z.txt\t5"},
+		},
+		{
+			"SELECT l.name FROM " + a + " AS l LEFT OUTER JOIN " + b + " AS r ON l.name = r.name WHERE r.name IS NULL",
+			[]string{a, a + "/y.go"},
+		},
+		{
+			"SELECT l.size, r.size FROM " + a + " AS l CROSS JOIN " + b + " AS r WHERE l.file IS reg AND r.file IS reg ORDER BY l.size, r.size DESC",
+			[]string{"1\t5", "1\t4", "2\t5", "2\t4", "3\t5", "3\t4"},
+		},
+		{
+			"SELECT t.name, l.name FROM (VALUES (\"x.go\"), (\"w.go\")) AS t(name) JOIN " + a + " AS l ON t.name = l.name",
+			[]string{"x.go\t" + a + "/x.go"},
+		},
+		{
+			"SELECT l.name, r.name FROM " + a + " AS l JOIN " + a + " AS r ON l.size < r.size WHERE l.name LIKE %.go AND r.name LIKE %.go",
+			[]string{a + "/x.go\t" + a + "/y.go"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+
+	// A CROSS JOIN has a result per pair of files.
+	lines, err := runLines("SELECT l.name, r.name FROM "+a+" AS l CROSS JOIN "+b+" AS r", &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(lines) != 4*3 {
+		t.Fatalf("\nExpected %d results\n     Got %d", 4*3, len(lines))
+	}
+}
+
+func TestFullJoin(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a/both.go":  "a",
+		"a/left.go":  "aa",
+		"b/both.go":  "bbb",
+		"b/right.go": "bbbb",
+	})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// Files in both sources are in a single result, files in either
+		// have NULLs for the other source.
+		{
+			"SELECT COALESCE(l.name, r.name), l.size, r.size FROM " + a + " AS l FULL OUTER JOIN " + b +
+				" AS r ON l.name IS r.name WHERE l.file IS reg OR r.file IS reg ORDER BY COALESCE(l.name, r.name)",
+			[]string{a + "/both.go\t1\t3", a + "/left.go\t2\t", b + "/right.go\t\t4"},
+		},
+		{
+			"SELECT l.name, r.name FROM " + a + " AS l FULL JOIN " + b + " AS r ON l.name = r.name WHERE l.name IS NULL",
+			[]string{"\t" + b, "\t" + b + "/right.go"},
+		},
+		{
+			"SELECT COALESCE(r.name, l.name) FROM " + a + " AS l RIGHT JOIN " + b +
+				" AS r ON l.name = r.name WHERE r.file IS reg ORDER BY COALESCE(r.name, l.name) DESC",
+			[]string{b + "/right.go", b + "/both.go"},
+		},
+		{
+			"SELECT COALESCE(l.size, r.size) AS size FROM " + a + " AS l FULL JOIN " + b +
+				" AS r ON l.name = r.name WHERE l.file IS reg OR r.file IS reg ORDER BY COALESCE(l.size, r.size) DESC",
+			[]string{"4", "2", "1"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestLateral(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a/x.go":  "x",
+		"a/y.go":  "yy",
+		"a/z.txt": "zzz",
+		"b/x.go":  "xxxx",
+		"b/y.go":  "yy",
+		"b/w.go":  "www",
+	})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// Each file of a is compared with the file of b with the same name,
+		// and a/z.txt (which has none) isn't in the results.
+		{
+			"SELECT l.name, r.name FROM " + a + " AS l, LATERAL (SELECT name FROM " + b + " WHERE name IS l.name) AS r ORDER BY l.name",
+			[]string{a + "/x.go\t" + b + "/x.go", a + "/y.go\t" + b + "/y.go"},
+		},
+		// The subquery's LIMIT applies to its results for each file of a.
+		{
+			"SELECT l.name, r.size FROM " + a + " AS l CROSS JOIN LATERAL (SELECT size FROM " + b +
+				" WHERE file IS reg AND size > l.size ORDER BY size LIMIT 1) AS r WHERE l.file IS reg ORDER BY l.name",
+			[]string{a + "/x.go\t2", a + "/y.go\t3", a + "/z.txt\t4"},
+		},
+		{
+			"SELECT l.name, r.name FROM " + a + " AS l LEFT JOIN LATERAL (SELECT name FROM " + b +
+				" WHERE name IS l.name) AS r WHERE l.file IS reg ORDER BY l.name",
+			[]string{a + "/x.go\t" + b + "/x.go", a + "/y.go\t" + b + "/y.go", a + "/z.txt\t"},
+		},
+		{
+			"SELECT l.name FROM " + a + " AS l JOIN LATERAL (SELECT size FROM " + b + " WHERE file IS reg AND size = l.size) AS r ON r.size > 2",
+			[]string{a + "/z.txt"},
+		},
+		{
+			"SELECT l.name FROM " + a + " AS l, LATERAL (SELECT name FROM " + b + " WHERE file IS reg AND size > 100) AS r",
+			[]string{},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestSemiJoin(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a/x.go":       "x",
+		"a/y.go":       "yy",
+		"a/z.txt":      "zzz",
+		"b/x.go":       "xxxx",
+		"b/sub/x.go":   "x",
+		"b/sub/z.txt":  "zzzzz",
+		"b/other/w.go": "w",
+	})
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		// a/x.go has the name of two files of b, but is only kept once.
+		{
+			"SELECT name FROM " + a + " AS l SEMI JOIN " + b + " AS r ON l.name = r.name ORDER BY name",
+			[]string{a + "/x.go", a + "/z.txt"},
+		},
+		{
+			"SELECT l.name FROM " + a + " AS l SEMI JOIN " + b + " AS r ON l.size < r.size AND r.file IS reg WHERE l.file IS reg ORDER BY l.name",
+			[]string{a + "/x.go", a + "/y.go", a + "/z.txt"},
+		},
+		{
+			"SELECT name FROM " + a + " AS l ANTI JOIN " + b + " AS r ON l.name = r.name",
+			[]string{a, a + "/y.go"},
+		},
+		{
+			"SELECT name FROM " + a + " AS l ANTI JOIN " + b + " AS r ON l.size = r.size AND r.file IS reg WHERE file IS reg",
+			[]string{a + "/y.go", a + "/z.txt"},
+		},
+		{
+			"SELECT COUNT(*) FROM " + a + " AS l SEMI JOIN " + b + " AS r ON l.name = r.name",
+			[]string{"2"},
+		},
+		// EXISTS and NOT EXISTS are rewritten to SEMI and ANTI JOINs.
+		{
+			"SELECT name FROM " + a + " WHERE EXISTS (SELECT 1 FROM " + b + " WHERE name IS " + a + ".name) ORDER BY name",
+			[]string{a + "/x.go", a + "/z.txt"},
+		},
+		{
+			"SELECT name FROM " + a + " WHERE file IS reg AND NOT EXISTS (SELECT 1 FROM " + b + " WHERE name IS " + a + ".name)",
+			[]string{a + "/y.go"},
+		},
+		{
+			"SELECT name FROM " + a + " WHERE EXISTS (SELECT name FROM " + b + " WHERE name IS " + a + ".name AND size > 4)",
+			[]string{a + "/z.txt"},
+		},
+		// A condition which can't be separated from the subquery's others is
+		// compared for each file.
+		{
+			"SELECT name FROM " + a + " WHERE file IS reg AND EXISTS (SELECT 1 FROM " + b +
+				" WHERE file IS reg AND (name IS " + a + ".name OR size = " + a + ".size)) ORDER BY name",
+			[]string{a + "/x.go", a + "/z.txt"},
+		},
+		{
+			"SELECT name FROM " + a + " WHERE EXISTS (SELECT 1 FROM " + b + " WHERE name LIKE %.md)",
+			[]string{},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a": "a",
+		"b": "bb",
+		"c": "ccc",
+		"d": "dddd",
+	})
+	input := "SELECT name FROM " + dir + " WHERE file IS reg ORDER BY size DESC LIMIT 2"
+
+	lines, err := runLines("EXPLAIN "+input, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := []string{
+		"Select: name",
+		"  Limit: 2",
+		"    Sort: size DESC",
+		`      Filter: ({attribute: file, comparator: is, value: "reg", negate: false})`,
+		"        Walk: " + dir,
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, lines)
+	}
+
+	lines, err = runLines("EXPLAIN ANALYZE "+input, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(lines) != len(expected)+1 {
+		t.Fatalf("\nExpected %d lines\n     Got %q", len(expected)+1, lines)
+	}
+
+	results, _ := runLines(input, &options{})
+	re := regexp.MustCompile(`^\s*(\w+): .* \(rows=(\d+) time=(\S+)\)$`)
+	rows := map[string]string{
+		"Select": strconv.Itoa(len(results)),
+		"Limit":  "2",
+		"Sort":   "4",
+		"Filter": "4",
+		"Walk":   "5", // The root directory, along with each file.
+	}
+	for _, line := range lines[:len(lines)-1] {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("\nExpected an annotated plan node\n     Got %q", line)
+		}
+		if match[2] != rows[match[1]] {
+			t.Fatalf("\nExpected %s rows=%s\n     Got %q", match[1], rows[match[1]], line)
+		}
+		if match[3] == "0s" {
+			t.Fatalf("\nExpected non-zero time\n     Got %q", line)
+		}
+	}
+
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, "Execution time: ") || last == "Execution time: 0s" {
+		t.Fatalf("\nExpected non-zero execution time\n     Got %q", last)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a.go": "a", "b": "bb"})
+	input := "SELECT name, size, ext, time, ROW_NUMBER() OVER () AS n, CUME_DIST() OVER () AS d FROM " + dir
+
+	var buf bytes.Buffer
+	if err := run(input, &options{jsonSchema: true}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	var s struct {
+		Schema string `json:"$schema"`
+		Type   string `json:"type"`
+		Items  struct {
+			Type       string                            `json:"type"`
+			Properties map[string]map[string]interface{} `json:"properties"`
+			Required   []string                          `json:"required"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+	if s.Schema != "http://json-schema.org/draft-07/schema#" || s.Type != "array" ||
+		s.Items.Type != "object" {
+		t.Fatalf("\nExpected a draft 7 schema of an array of objects\n     Got %s", buf.String())
+	}
+	if expected := []string{"name", "size", "ext", "time", "n", "d"}; !reflect.DeepEqual(s.Items.Required, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, s.Items.Required)
+	}
+
+	types := map[string]string{
+		"name": `"string"`,
+		"size": `"integer"`,
+		"ext":  `["string","null"]`,
+		"time": `"string"`,
+		"n":    `"integer"`,
+		"d":    `"number"`,
+	}
+	for name, expected := range types {
+		actual, _ := json.Marshal(s.Items.Properties[name]["type"])
+		if string(actual) != expected {
+			t.Fatalf("\nExpected %s type %s\n     Got %s", name, expected, actual)
+		}
+	}
+
+	// Each value of the json output must match its type in the schema.
+	buf.Reset()
+	if err := run(input, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+	for _, row := range rows {
+		for name, value := range row {
+			var actual string
+			switch v := value.(type) {
+			case nil:
+				actual = "null"
+			case string:
+				actual = "string"
+			case float64:
+				actual = "number"
+				if v == math.Trunc(v) && types[name] != `"number"` {
+					actual = "integer"
+				}
+			}
+			if actual == "" || !strings.Contains(types[name], `"`+actual+`"`) {
+				t.Fatalf("\nExpected %s of type %s\n     Got %v", name, types[name], value)
+			}
+		}
+	}
+}
+
+// A fake os.FileInfo with the provided metadata.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) Mode() os.FileMode  { return f.mode }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+func (f fileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fileInfo) Sys() interface{}   { return nil }
+
+func TestCompile(t *testing.T) {
+	queries := []string{
+		"SELECT name FROM .",
+		"SELECT name FROM . WHERE name = main.go",
+		"SELECT name FROM . WHERE name <> main.go AND size > 1kb",
+		"SELECT name FROM . WHERE name LIKE %.go OR name LIKE READ% OR name LIKE %test%",
+		"SELECT name FROM . WHERE name RLIKE '^[a-m].*[.](go|md)$'",
+		"SELECT name FROM . WHERE name ~ /^(main|readme)[._]/i AND NOT name ~ /\\.txt$/",
+		"SELECT name FROM . WHERE SOUNDEX(name) = SOUNDEX(maine) OR NOT METAPHONE(name) <> METAPHONE(rydme)",
+		"SELECT name FROM . WHERE LEVENSHTEIN(name, main.go) <= 2 OR NOT LEVENSHTEIN_RATIO(name, readme.md) > 0.5",
+		"SELECT name FROM . WHERE name CONTAINS test AND NOT file IS dir",
+		"SELECT name FROM . WHERE size >= 1mb OR (size < 100 AND file IS reg)",
+		"SELECT name FROM . WHERE size <= 2048 AND NOT size = 0",
+		"SELECT name FROM . WHERE time > 'Apr 01 2017 00 00' AND time <= 'Jun 01 2017 00 00'",
+		"SELECT name FROM . WHERE NOT size > foo OR time < 'Jan 01 2017 00 00'",
+		"PRAGMA case_sensitive = false; SELECT name FROM . WHERE name LIKE readme% OR name RLIKE G$",
+		"SELECT name FROM . WHERE (file IS dir OR name LIKE %.md) AND NOT name = LICENSE",
+		"SELECT name FROM . WHERE CASE WHEN size < 1mb THEN small WHEN name LIKE %.go THEN go ELSE other END = go",
+		"SELECT name FROM . WHERE NOT CASE WHEN file IS dir THEN dir END = dir OR CASE WHEN size > 2mb THEN large END IS NULL",
+		"SELECT name FROM . WHERE CASE WHEN name LIKE %.md THEN CASE WHEN size > 1mb THEN big ELSE doc END END <> doc",
+		"PRAGMA case_sensitive = false; SELECT name FROM . WHERE CASE WHEN name = readme.md THEN README END = README",
+		"SELECT name FROM . WHERE IIF(name LIKE %.go, go, other) = go AND size < 2mb",
+		"SELECT name FROM . WHERE NOT IIF(file IS dir, IIF(name = docs, docs, dir), file) = dir",
+		"SELECT name FROM . WHERE size / 1024 > 1000 AND size % 2 = 0",
+		"SELECT name FROM . WHERE (size + depth) * 2 - 1 >= 2mb OR NOT size * 1.5 < 3000000",
+		"SELECT name FROM . WHERE mode & 0700 = 0700 OR size >> 20 = 1",
+		"SELECT name FROM . WHERE size / (size % 3) IS NULL AND NOT -size < -1mb",
+	}
+	names := []string{"main.go", "main_test.go", "README.md", "readme.txt", "LICENSE",
+		"vendor", "Makefile", "parser.GO", "docs", "a.md"}
+	base := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	random := rand.New(rand.NewSource(1))
+	samples := make([]fileInfo, 1000)
+	for i := range samples {
+		samples[i] = fileInfo{
+			name:    names[random.Intn(len(names))],
+			size:    random.Int63n(3 << 20),
+			modTime: base.Add(time.Duration(random.Int63n(int64(365 * 24 * time.Hour)))),
+		}
+		if random.Intn(4) == 0 {
+			samples[i].mode = os.ModeDir | 0755
+		}
+	}
+
+	for _, input := range queries {
+		fn, err := cmp.Compile(input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+		}
+
+		q, _ := query.RunParser(input)
+		qopts, _, _ := query.NewQueryOptions(q.Pragmas)
+		compareFn := compareWith(qopts)
+
+		for _, info := range samples {
+			expected := q.ConditionTree.Evaluate(info, func(c query.Condition, fi os.FileInfo) bool {
+				return compareFn(c, info.name, fi)
+			})
+			if actual := fn(info.name, info); actual != expected {
+				t.Fatalf("\nExpected %t for %+v with %q\n     Got %t", expected, info, input, actual)
+			}
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . WHERE",
+		"SELECT name FROM . WHERE name RLIKE (",
+		"SELECT name FROM . WHERE size >",
+		"PRAGMA max_depth = -1; SELECT name FROM .",
+	} {
+		if fn, err := cmp.Compile(input); err == nil || fn != nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+
+	// Comparisons with ALL or ANY can't be compiled, since their subqueries
+	// must be evaluated first, nor can the clauses which filter the files
+	// along with the rest of the query (EXISTS is rewritten to a JOIN).
+	for _, input := range []string{
+		"SELECT name FROM . WHERE size > ALL (SELECT size FROM . WHERE name LIKE %.md)",
+		"SELECT name FROM . WHERE name = a.md OR NOT size = ANY (SELECT size FROM .)",
+		"SELECT name FROM . WHERE NOT EXISTS (SELECT * FROM /tmp)",
+		"SELECT name FROM . WHERE size > 1 AND EXISTS (SELECT * FROM /tmp WHERE name IS ..name)",
+		"SELECT a.name FROM . AS a JOIN /tmp AS b ON a.name = b.name",
+		"WITH t AS (SELECT * FROM .) SELECT name FROM t WHERE size > 1",
+		"SELECT ext, COUNT(*) FROM . GROUP BY ext HAVING ext = .go",
+		"SELECT name FROM . WHERE size > 1 QUALIFY size > 2",
+	} {
+		if _, err := cmp.Compile(input); !errors.Is(err, cmp.ErrUnsupported) {
+			t.Fatalf("\nExpected %v for %q\n     Got %v", cmp.ErrUnsupported, input, err)
+		}
+	}
+}
+
+func TestMachineReadable(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a": "a", "new\nline": "bb"})
+	input := "SELECT name, size FROM " + dir + " WHERE file IS reg ORDER BY name"
+
+	var buf bytes.Buffer
+	if err := run(input, &options{machineReadable: true}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\x00"), "\x00")
+	expected := []string{filepath.Join(dir, "a") + "\t1", filepath.Join(dir, "new\nline") + "\t2"}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, rows)
+	}
+
+	// Without -machine-readable, the newline in the name can't be told apart
+	// from the newline which separates results.
+	lines, err := runLines(input, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("\nExpected 3 lines for 2 results\n     Got %q", lines)
+	}
+
+	if _, err := runLines(input, &options{machineReadable: true, format: "json"}); err == nil {
+		t.Fatalf("\nExpected error with -format json")
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := createTree(t, map[string]string{"a": "aaa", "b": "b", "c": "cc"})
+	s := newServer(time.Minute, 2, 1)
+	s.origins = []string{"http://localhost:3000"}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	postFrom := func(origin, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/query", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	post := func(body string) *http.Response {
+		return postFrom("", body)
+	}
+
+	resp := postFrom("http://localhost:3000", `{"query": "SELECT name, size FROM `+dir+` WHERE file IS reg ORDER BY size"}`)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("\nExpected status 200\n     Got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "http://localhost:3000" {
+		t.Fatalf("\nExpected CORS headers\n     Got %v", resp.Header)
+	}
+	if resp.Header.Get("X-Fsql-Truncated") != "true" {
+		t.Fatalf("\nExpected X-Fsql-Truncated header\n     Got %v", resp.Header)
+	}
+
+	var actual []map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&actual); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+	expected := []map[string]interface{}{
+		{"name": filepath.Join(dir, "b"), "size": 1.0},
+		{"name": filepath.Join(dir, "c"), "size": 2.0},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	// The message of a RAISE NOTICE is only logged.
+	notice := post(`{"query": "RAISE NOTICE 'hello'"}`)
+	body, _ := ioutil.ReadAll(notice.Body)
+	notice.Body.Close()
+	if notice.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "[]" {
+		t.Fatalf("\nExpected status 200 with []\n     Got %d with %s", notice.StatusCode, body)
+	}
+
+	// Other origins aren't allowed to read the results.
+	other := postFrom("http://example.com", `{"query": "SELECT name FROM `+dir+`"}`)
+	other.Body.Close()
+	if other.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("\nExpected no CORS headers for another origin\n     Got %v", other.Header)
+	}
+
+	// Queries may be preceded by pragmas, and use CTEs.
+	for _, body := range []string{
+		`{"query": "PRAGMA max_depth = 1; PRAGMA case_sensitive = false SELECT name FROM ` + dir + `"}`,
+		`{"query": "WITH t AS (SELECT * FROM ` + dir + `) SELECT name FROM t"}`,
+	} {
+		resp := post(body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("\nExpected status 200 for %s\n     Got %d", body, resp.StatusCode)
+		}
+	}
+
+	// Any other statement is rejected, including those which change files.
+	archive := t.TempDir()
+	for _, body := range []string{
+		`{"query": "SELECT name FROM . WHERE size >"}`,
+		`{"query": "EXPLAIN SELECT name FROM ."}`,
+		`{"query": "MOVE FROM ` + dir + ` TO ` + archive + `"}`,
+		`{"query": "PRAGMA max_depth = 1; COPY FROM ` + dir + ` TO ` + archive + `"}`,
+		`{"query": "DELETE FROM ` + dir + `"}`,
+		`{"query": "SELECT name FROM ` + dir + ` INTO '` + filepath.Join(archive, "files") + `' FORMAT json"}`,
+		`{"query": "REBUILD INDEX FROM ` + dir + `"}`,
+		`{"query": "ASSERT (SELECT COUNT(*) FROM ` + dir + `) = 0"}`,
+		`{"query": "SELECT name FROM @dir"}`,
+		`{"query": "RAISE ERROR 'stop'"}`,
+		`{"query": "LABEL again"}`,
+		`{"query": "GOTO again"}`,
+		`{"query": `,
+	} {
+		resp := post(body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("\nExpected status 400 for %s\n     Got %d", body, resp.StatusCode)
+		}
+	}
+	if files := listFiles(t, dir); !reflect.DeepEqual(files, []string{"a", "b", "c"}) {
+		t.Fatalf("\nExpected the files to be unchanged\n     Got %v", files)
+	}
+	if files := listFiles(t, archive); len(files) != 0 {
+		t.Fatalf("\nExpected no files to be written\n     Got %v", files)
+	}
+
+	// A query which can't complete in time is rejected, rather than returning
+	// incomplete results.
+	slow := httptest.NewServer(newServer(time.Nanosecond, 2, 1).handler())
+	defer slow.Close()
+	resp, err := http.Post(slow.URL+"/query", "application/json",
+		strings.NewReader(`{"query": "SELECT name FROM `+dir+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("\nExpected status 503\n     Got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("\nExpected status 200\n     Got %d", resp.StatusCode)
+	}
+}
+
+func TestInto(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a": "a"})
+	path := filepath.Join(t.TempDir(), "files")
+
+	if _, err := runLines("SELECT name FROM "+dir+" INTO '"+path+"' FORMAT foo", &options{}); err == nil {
+		t.Fatalf("\nExpected error for an unknown format")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("\nExpected %s not to be created\n     Got %v", path, err)
+	}
+}
+
+// Return the paths (relative to dir) of the regular files below dir, in order.
+func listFiles(t *testing.T, dir string) []string {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestFileOperations(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := createTree(t, map[string]string{"a.tmp": "a", "c.txt": "c", "sub/b.tmp": "b"})
+	archive := t.TempDir()
+
+	type Case struct {
+		input    string
+		dir      []string
+		archive  []string
+		contains string
+	}
+
+	cases := []Case{
+		{"MOVE FROM " + dir + " WHERE name LIKE %.tmp TO " + archive, []string{"c.txt"},
+			[]string{"a.tmp", "b.tmp"}, ""},
+		{"COPY FROM " + archive + " WHERE name = a.tmp TO " + dir, []string{"a.tmp", "c.txt"},
+			[]string{"a.tmp", "b.tmp"}, ""},
+		// Nothing is changed unless each of the files can be.
+		{"COPY FROM " + archive + " TO " + dir, []string{"a.tmp", "c.txt"}, []string{"a.tmp", "b.tmp"},
+			"already exists"},
+		{"MOVE FROM " + dir + " TO " + filepath.Join(dir, "c.txt"), []string{"a.tmp", "c.txt"},
+			[]string{"a.tmp", "b.tmp"}, "not a directory"},
+		{"DELETE FROM " + archive + " WHERE name LIKE %.tmp", []string{"a.tmp", "c.txt"}, []string{}, ""},
+	}
+
+	for _, c := range cases {
+		_, err := runLines(c.input, &options{})
+		if c.contains == "" && err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if c.contains != "" && (err == nil || !strings.Contains(err.Error(), c.contains)) {
+			t.Fatalf("\nExpected error containing %q for %q\n     Got %v", c.contains, c.input, err)
+		}
+		if actual := listFiles(t, dir); !reflect.DeepEqual(actual, c.dir) {
+			t.Fatalf("\nExpected %v in %s after %q\n     Got %v", c.dir, dir, c.input, actual)
+		}
+		if actual := listFiles(t, archive); !reflect.DeepEqual(actual, c.archive) {
+			t.Fatalf("\nExpected %v in %s after %q\n     Got %v", c.archive, archive, c.input, actual)
+		}
+	}
+
+	// The write-ahead log of each statement is removed once it's complete.
+	if logs, err := readWALs(walDir()); err != nil || len(logs) != 0 {
+		t.Fatalf("\nExpected no write-ahead logs\n     Got %v (%v)", logs, err)
+	}
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// The second rename fails, interrupting the MOVE after its first file.
+	interrupt := func() {
+		renames := 0
+		renameFile = func(from, to string) error {
+			if renames++; renames == 2 {
+				return errors.New("interrupted")
+			}
+			return os.Rename(from, to)
+		}
+	}
+	t.Cleanup(func() { renameFile = os.Rename })
+
+	type Case struct {
+		command string
+		dir     []string
+		archive []string
+	}
+
+	for _, c := range []Case{
+		{"complete", []string{}, []string{"a.tmp", "b.tmp", "c.tmp"}},
+		{"rollback", []string{"a.tmp", "b.tmp", "c.tmp"}, []string{}},
+	} {
+		dir := createTree(t, map[string]string{"a.tmp": "a", "b.tmp": "b", "c.tmp": "c"})
+		archive := t.TempDir()
+		input := "MOVE FROM " + dir + " WHERE name LIKE %.tmp TO " + archive
+
+		interrupt()
+		if _, err := runLines(input, &options{}); err == nil || !strings.Contains(err.Error(), "interrupted") {
+			t.Fatalf("\nExpected the MOVE to be interrupted\n     Got %v", err)
+		}
+		renameFile = os.Rename
+
+		// The log identifies the files which are left.
+		logs, err := readWALs(walDir())
+		if err != nil || len(logs) != 1 {
+			t.Fatalf("\nExpected a write-ahead log\n     Got %v (%v)", logs, err)
+		}
+		remaining := make([]string, 0)
+		for _, step := range logs[0].remaining() {
+			remaining = append(remaining, filepath.Base(step.From))
+		}
+		if expected := []string{"b.tmp", "c.tmp"}; !reflect.DeepEqual(remaining, expected) {
+			t.Fatalf("\nExpected the remaining files %v\n     Got %v", expected, remaining)
+		}
+
+		var warning bytes.Buffer
+		warnInterrupted(walDir(), &warning)
+		expected := "warning: a MOVE of 3 files was interrupted after 1 of its 3 steps"
+		if !strings.HasPrefix(warning.String(), expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", expected, warning.String())
+		}
+
+		if err := runRecover([]string{c.command}, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if actual := listFiles(t, dir); !reflect.DeepEqual(actual, c.dir) {
+			t.Fatalf("\nExpected %v in %s after %s\n     Got %v", c.dir, dir, c.command, actual)
+		}
+		if actual := listFiles(t, archive); !reflect.DeepEqual(actual, c.archive) {
+			t.Fatalf("\nExpected %v in %s after %s\n     Got %v", c.archive, archive, c.command, actual)
+		}
+		if logs, _ := readWALs(walDir()); len(logs) != 0 {
+			t.Fatalf("\nExpected the write-ahead log to be removed\n     Got %v", logs)
+		}
+	}
+}
+
+func TestAtomicMove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(func() { renameFile = os.Rename })
+	names := []string{"a.tmp", "b.tmp", "c.tmp", "d.tmp"}
+
+	type Case struct {
+		n       int // The rename which fails, or 0.
+		crossed bool
+	}
+
+	// The first four renames move the files to their temporary names, and
+	// the next four to their names. When the files are on different devices,
+	// each of the first four is a copy instead.
+	for _, c := range []Case{{3, false}, {6, false}, {0, true}, {3, true}} {
+		files := make(map[string]string)
+		for _, name := range names {
+			files[name] = name
+		}
+		dir := createTree(t, files)
+		archive := t.TempDir()
+
+		renames := 0
+		renameFile = func(from, to string) error {
+			renames++
+			if renames == c.n {
+				return errors.New("injected")
+			}
+			if c.crossed && strings.HasPrefix(from, dir) {
+				return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+			}
+			return os.Rename(from, to)
+		}
+
+		_, err := runLines("MOVE FROM "+dir+" WHERE name LIKE %.tmp TO "+archive+" ATOMIC", &options{})
+		expectedDir, expectedArchive := names, []string{}
+		if c.n == 0 {
+			expectedDir, expectedArchive = []string{}, names
+			if err != nil {
+				t.Fatalf("\nExpected no error\n     Got %v", err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), "injected") {
+			t.Fatalf("\nExpected the injected error after %d renames\n     Got %v", c.n, err)
+		}
+
+		if actual := listFiles(t, dir); !reflect.DeepEqual(actual, expectedDir) {
+			t.Fatalf("\nExpected %v in %s after %d renames\n     Got %v", expectedDir, dir, c.n, actual)
+		}
+		if actual := listFiles(t, archive); !reflect.DeepEqual(actual, expectedArchive) {
+			t.Fatalf("\nExpected %v in %s after %d renames\n     Got %v", expectedArchive, archive, c.n, actual)
+		}
+		for _, name := range expectedDir {
+			if contents, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(contents) != name {
+				t.Fatalf("\nExpected %s to be restored\n     Got %q", name, contents)
+			}
+		}
+		if logs, _ := readWALs(walDir()); len(logs) != 0 {
+			t.Fatalf("\nExpected the write-ahead log to be removed\n     Got %v", logs)
+		}
+	}
+}
+
+// Split a row of a Markdown table into its (trimmed and unescaped) cells, or
+// return nil if it isn't a valid row.
+func markdownCells(row string) []string {
+	if !strings.HasPrefix(row, "|") || !strings.HasSuffix(row, "|") || strings.HasSuffix(row, "\\|") {
+		return nil
+	}
+
+	cells := make([]string, 0)
+	cell := ""
+	for i := 1; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell += "|"
+			i++
+		case row[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell))
+			cell = ""
+		default:
+			cell += string(row[i])
+		}
+	}
+	return cells
+}
+
+func TestFormatMarkdown(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"a|b": "aaa", "c": "c"})
+
+	lines, err := runLines("SELECT name, size AS bytes FROM "+dir+" WHERE file IS reg ORDER BY size",
+		&options{format: "markdown"})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	rows := make([][]string, 0, len(lines))
+	for _, line := range lines {
+		cells := markdownCells(line)
+		if len(cells) != 2 || len(line) != len(lines[0]) {
+			t.Fatalf("\nExpected an aligned row with 2 cells\n     Got %q", line)
+		}
+		rows = append(rows, cells)
+	}
+
+	expected := [][]string{
+		{"name", "bytes"},
+		{strings.Repeat("-", len(rows[1][0])), "-----"},
+		{filepath.Join(dir, "c"), "1"},
+		{filepath.Join(dir, "a|b"), "3"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, rows)
+	}
+	if !strings.Contains(lines[3], `a\|b`) {
+		t.Fatalf("\nExpected an escaped pipe\n     Got %q", lines[3])
+	}
+}
+
+func TestFormatTabulate(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.txt":                            "aaa",
+		strings.Repeat("long", 30) + ".go": "",
+	})
+	from := " FROM " + dir + " WHERE file IS reg ORDER BY size"
+
+	// Each line is exactly WIDTH columns wide, and long values are truncated.
+	for _, width := range []int{40, 80, 200} {
+		lines, err := runLines(fmt.Sprintf("SELECT name, size, mode%s FORMAT TABULATE WIDTH %d", from, width), &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if len(lines) != 6 {
+			t.Fatalf("\nExpected 6 lines\n     Got %q", lines)
+		}
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n != width {
+				t.Fatalf("\nExpected a line of width %d\n     Got %d: %q", width, n, line)
+			}
+		}
+		if width < 200 && !strings.Contains(lines[3], "…") {
+			t.Fatalf("\nExpected a truncated value\n     Got %q", lines[3])
+		}
+	}
+
+	lines, err := runLines("SELECT name, size"+from+" FORMAT TABULATE WIDTH 60", &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	// The size column is as wide as its name, and the name column takes the
+	// rest of the width.
+	path := filepath.Join(dir, "a.txt")
+	expected := "│ " + path + strings.Repeat(" ", 60-len("size")-7-len(path)) + " │ 3    │"
+	for i, border := range []string{"┌┬┐", "│││", "├┼┤", "│││", "│││", "└┴┘"} {
+		line, corners := []rune(lines[i]), []rune(border)
+		if line[0] != corners[0] || !strings.ContainsRune(lines[i][1:], corners[1]) || line[len(line)-1] != corners[2] {
+			t.Fatalf("\nExpected a line drawn with %s\n     Got %q", border, lines[i])
+		}
+	}
+	if lines[4] != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, lines[4])
+	}
+
+	// A single column takes the whole width, and the borders may be ASCII.
+	lines, err = runLines("SELECT size"+from+" FORMAT tabulate WIDTH 30", &options{ascii: true})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expectedLines := []string{
+		"+" + strings.Repeat("-", 28) + "+",
+		"| size" + strings.Repeat(" ", 23) + "|",
+		"+" + strings.Repeat("-", 28) + "+",
+		"| 0" + strings.Repeat(" ", 26) + "|",
+		"| 3" + strings.Repeat(" ", 26) + "|",
+		"+" + strings.Repeat("-", 28) + "+",
+	}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Fatalf("\nExpected %q\n     Got %q", expectedLines, lines)
+	}
+
+	// The -format flag uses the default width.
+	lines, err = runLines("SELECT size"+from, &options{format: "tabulate"})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if n := utf8.RuneCountInString(lines[0]); n != 80 {
+		t.Fatalf("\nExpected a line of width 80\n     Got %d", n)
+	}
+
+	if _, err := runLines("SELECT name, size"+from+" FORMAT tabulate WIDTH 8", &options{}); err == nil {
+		t.Fatalf("\nExpected an error for a width too narrow for the columns")
+	}
+}
+
+func TestFormatCompact(t *testing.T) {
+	names := []string{"a b", "c$d", "e`f", `g\h`, "i'j", `k"l`, "m!n", "o*", "~p", "plain.go"}
+	files := make(map[string]string, len(names))
+	for _, name := range names {
+		files[name] = ""
+	}
+	dir := createMockTree(t, files)
+
+	var buf bytes.Buffer
+	if err := run("SELECT name FROM "+dir+" WHERE file IS reg ORDER BY name COMPACT", &options{}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	output := buf.String()
+	if strings.Count(output, "\n") != 1 || !strings.Contains(output, "'"+filepath.Join(dir, "a b")+"'") ||
+		!strings.Contains(output, " "+filepath.Join(dir, "plain.go")+" ") {
+		t.Fatalf("\nExpected a single line with quoted names\n     Got %q", output)
+	}
+
+	// Each of the words the shell reads from the output is one of the names.
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+	out, err := exec.Command("sh", "-c", "for f in "+strings.TrimSpace(output)+"; do echo \"$f\"; done").Output()
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := make([]string, 0, len(names))
+	for _, name := range names {
+		expected = append(expected, filepath.Join(dir, name))
+	}
+	sort.Strings(expected)
+	if actual := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, actual)
+	}
+
+	// Each column of each result is a word.
+	lines, err := runLines("SELECT name, size FROM "+dir+" WHERE name = 'a b'", &options{format: "compact"})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := []string{"'" + filepath.Join(dir, "a b") + "' 0"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, lines)
+	}
+}
+
+func TestFormatHTML(t *testing.T) {
+	dir := createMockTree(t, map[string]string{"<script>alert(1)</script>": "aaa", "c": "c"})
+	input := "SELECT name, size FROM " + dir + " WHERE file IS reg ORDER BY size"
+
+	type Case struct {
+		opts  *options
+		title string
+		style bool
+	}
+
+	cases := []Case{
+		{opts: &options{format: "html"}, title: "<title>fsql</title>", style: true},
+		{opts: &options{format: "html", title: "a & b", noStyle: true}, title: "<title>a &amp; b</title>"},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := run(input, c.opts, &buf, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		output := buf.String()
+
+		if strings.Contains(output, "<script>") {
+			t.Fatalf("\nExpected the file name to be escaped\n     Got %s", output)
+		}
+		if !strings.Contains(output, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+			t.Fatalf("\nExpected an escaped file name\n     Got %s", output)
+		}
+		if !strings.Contains(output, c.title) {
+			t.Fatalf("\nExpected %s\n     Got %s", c.title, output)
+		}
+		if style := strings.Contains(output, "<style>"); style != c.style {
+			t.Fatalf("\nExpected style %t\n     Got %t", c.style, style)
+		}
+
+		// The page is written as well-formed markup, so it's checked with a
+		// strict XML decoder (skipping the doctype).
+		dec := xml.NewDecoder(strings.NewReader(output))
+		rows := 0
+		for {
+			tok, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("\nExpected well-formed HTML\n     Got %v", err)
+			}
+			if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "tr" {
+				rows++
+			}
+		}
+		if rows != 3 {
+			t.Fatalf("\nExpected %d rows\n     Got %d", 3, rows)
+		}
+	}
+}
+
+func TestFormatXML(t *testing.T) {
+	dir := createMockTree(t, map[string]string{`a&b<"c">.txt`: "aaa", "d": "d"})
+
+	var buf bytes.Buffer
+	input := "SELECT name, size AS bytes FROM " + dir + " WHERE file IS reg ORDER BY size"
+	if err := run(input, &options{format: "xml"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	// The standard library has no XSD validator, so the document is checked
+	// against the structure of the schema instead: a <results> root with any
+	// number of <file> elements, each with a <name> string and <bytes> integer.
+	var doc struct {
+		XMLName xml.Name `xml:"results"`
+		Files   []struct {
+			Fields []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("\nExpected well-formed XML\n     Got %v", err)
+	}
+
+	expected := [][]string{
+		{"name", filepath.Join(dir, "d"), "bytes", "1"},
+		{"name", filepath.Join(dir, `a&b<"c">.txt`), "bytes", "3"},
+	}
+	actual := make([][]string, 0, len(doc.Files))
+	for _, f := range doc.Files {
+		var fields []string
+		for _, field := range f.Fields {
+			fields = append(fields, field.XMLName.Local, field.Value)
+		}
+		actual = append(actual, fields)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, actual)
+	}
+	for _, fields := range actual {
+		if _, err := strconv.ParseInt(fields[3], 10, 64); err != nil {
+			t.Fatalf("\nExpected an integer\n     Got %q", fields[3])
+		}
+	}
+	if !strings.Contains(buf.String(), "a&amp;b&lt;&#34;c&#34;&gt;.txt") {
+		t.Fatalf("\nExpected an escaped file name\n     Got %s", buf.String())
+	}
+}
+
+func TestXMLName(t *testing.T) {
+	type Case struct {
+		name     string
+		expected string
+	}
+
+	cases := []Case{
+		{name: "size", expected: "size"},
+		{name: "row-number.2", expected: "row-number.2"},
+		{name: "a b:c", expected: "a_b_c"},
+		{name: "2size", expected: "_2size"},
+		{name: "XMLData", expected: "_XMLData"},
+		{name: "", expected: "_"},
+	}
+
+	for _, c := range cases {
+		actual := xmlName(c.name)
+		if actual != c.expected {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+// Return a ZIP archive containing a member for each entry in files, mapping
+// the member's name to its contents. Names ending with a slash are
+// directories.
+func createZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZip(t *testing.T) {
+	inner := createZip(t, map[string]string{"lib/a.go": "aa"})
+	archive := createZip(t, map[string]string{
+		"main.go":        strings.Repeat("a", 1000),
+		"src/util.go":    "util",
+		"src/deep/x.txt": "x",
+		"docs/":          "",
+		"inner.zip":      string(inner),
+	})
+	dir := createTree(t, map[string]string{"archive.zip": string(archive), "archive.bin": string(archive)})
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	zipPath := filepath.Join(dir, "archive.zip")
+	cases := []Case{
+		{
+			input: "SELECT name, size FROM " + zipPath + " WHERE file IS reg",
+			expected: []string{
+				zipPath + "!/inner.zip\t" + strconv.Itoa(len(inner)),
+				zipPath + "!/main.go\t1000",
+				zipPath + "!/src/deep/x.txt\t1",
+				zipPath + "!/src/util.go\t4",
+			},
+		},
+		{
+			input: "SELECT name FROM " + zipPath + " WHERE file IS dir",
+			expected: []string{
+				zipPath + "!/docs",
+				zipPath + "!/src",
+				zipPath + "!/src/deep",
+			},
+		},
+		{
+			input: "SELECT name FROM " + filepath.Join(dir, "archive.bin") + " WHERE name LIKE %.go",
+			expected: []string{
+				filepath.Join(dir, "archive.bin") + "!/main.go",
+				filepath.Join(dir, "archive.bin") + "!/src/util.go",
+			},
+		},
+		{
+			input: "SELECT name FROM " + zipPath + " INCLUDE NESTED ARCHIVES WHERE name LIKE %.go",
+			expected: []string{
+				zipPath + "!/inner.zip!/lib/a.go",
+				zipPath + "!/main.go",
+				zipPath + "!/src/util.go",
+			},
+		},
+		{
+			input:    "PRAGMA max_depth = 1; SELECT name FROM " + zipPath + " WHERE name LIKE %.go",
+			expected: []string{zipPath + "!/main.go"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestTar(t *testing.T) {
+	modified := time.Date(2015, time.March, 4, 10, 30, 0, 0, time.UTC)
+	inner := createZip(t, map[string]string{"a.go": "aa"})
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, hdr := range []*tar.Header{
+		{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modified},
+		{Name: "docs/a.txt", Mode: 0644, Size: 3, ModTime: modified},
+		{Name: "src/pkg/main.go", Mode: 0644, Size: 10, ModTime: modified.Add(time.Hour)},
+		{Name: "inner.zip", Mode: 0644, Size: int64(len(inner)), ModTime: modified},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		contents := strings.Repeat("x", int(hdr.Size))
+		if hdr.Name == "inner.zip" {
+			contents = string(inner)
+		}
+		if _, err := io.WriteString(tw, contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(archive.Bytes())
+	zw.Close()
+
+	files := map[string]string{"backup.tar": archive.String(), "backup.tar.gz": gz.String()}
+	// The standard library can't write bzip2, so .tar.bz2 archives are only
+	// tested when the bzip2 command is available.
+	if _, err := exec.LookPath("bzip2"); err == nil {
+		cmd := exec.Command("bzip2", "-c")
+		cmd.Stdin = bytes.NewReader(archive.Bytes())
+		bz, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files["backup.tar.bz2"] = string(bz)
+	}
+	dir := createTree(t, files)
+
+	for name := range files {
+		src := filepath.Join(dir, name)
+
+		type Case struct {
+			input    string
+			expected []string
+		}
+
+		cases := []Case{
+			{
+				input: "SELECT name, size FROM " + src,
+				expected: []string{
+					src + "!/docs\t0",
+					src + "!/docs/a.txt\t3",
+					src + "!/src\t0",
+					src + "!/src/pkg\t0",
+					src + "!/src/pkg/main.go\t10",
+					src + "!/inner.zip\t" + strconv.Itoa(len(inner)),
+				},
+			},
+			{
+				input:    "SELECT name, tar_offset FROM " + src + " WHERE name = a.txt",
+				expected: []string{src + "!/docs/a.txt\t1024"},
+			},
+			{
+				input:    "SELECT name FROM " + src + " WHERE file IS reg AND time = 'Mar 04 2015 11 30'",
+				expected: []string{src + "!/src/pkg/main.go"},
+			},
+			{
+				input:    "SELECT name FROM " + src + " INCLUDE NESTED ARCHIVES WHERE name LIKE %.go",
+				expected: []string{src + "!/src/pkg/main.go", src + "!/inner.zip!/a.go"},
+			},
+		}
+
+		for _, c := range cases {
+			actual, err := runLines(c.input, &options{})
+			if err != nil {
+				t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+			}
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := run("SELECT tar_offset FROM "+dir+" WHERE name = backup.tar", &options{}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if buf.String() != "\n" {
+		t.Fatalf("\nExpected an empty (NULL) offset\n     Got %q", buf.String())
+	}
+}
+
+func TestVFS(t *testing.T) {
+	archive := createZip(t, map[string]string{"b.go": "bb"})
+	useVFS(t, testutil.MockVFS{
+		"/data/a.txt":        {Contents: "aaa"},
+		"/data/src/main.go":  {Contents: "package main"},
+		"/data/src/lib/x.go": {Contents: "x"},
+		"/data/archive.zip":  {Contents: string(archive)},
+	})
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			input: "SELECT name, size FROM /data",
+			expected: []string{
+				"/data\t0",
+				"/data/a.txt\t3",
+				"/data/archive.zip\t" + strconv.Itoa(len(archive)),
+				"/data/src\t0",
+				"/data/src/lib\t0",
+				"/data/src/lib/x.go\t1",
+				"/data/src/main.go\t12",
+			},
+		},
+		{
+			input:    "SELECT name FROM /data WHERE name LIKE %.go",
+			expected: []string{"/data/src/lib/x.go", "/data/src/main.go"},
+		},
+		{
+			input:    "SELECT name FROM /data TABLESAMPLE SYSTEM (0 PERCENT) WHERE file IS reg",
+			expected: []string{"/data/a.txt", "/data/archive.zip"},
+		},
+		{
+			input:    "SELECT name, size FROM /data/archive.zip",
+			expected: []string{"/data/archive.zip!/b.go\t2"},
+		},
+		{
+			input:    "SELECT name FROM /missing",
+			expected: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %q\n     Got %q", c.expected, actual)
+		}
+	}
+}
+
+func TestEvaluateDirFS(t *testing.T) {
+	outside := createTree(t, map[string]string{"secret.txt": "secret", "inner/x": "secret"})
+	root := createTree(t, map[string]string{"sub/a.txt": "public", "b.txt": "public"})
+	for name, target := range map[string]string{
+		"dirlink":  outside,
+		"filelink": filepath.Join(outside, "secret.txt"),
+		"sub/up":   "../..",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := os.DirFS(root)
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT name FROM sub", []string{"sub", "sub/a.txt", "sub/up"}},
+		{"SELECT name FROM /sub WHERE file IS reg", []string{"/sub/a.txt"}},
+		{"SELECT name FROM sub/../sub WHERE file IS reg", []string{"sub/a.txt"}},
+		// Symbolic links are walked as files, and never followed.
+		{"SELECT name FROM .", []string{"b.txt", "dirlink", "filelink", "sub", "sub/a.txt", "sub/up"}},
+		{"SELECT name FROM . WHERE CONTAINS_TEXT(secret)", []string{}},
+		{"SELECT name FROM dirlink", []string{"dirlink"}},
+		{"SELECT name FROM filelink WHERE CONTAINS_TEXT(secret)", []string{}},
+	}
+
+	for _, c := range cases {
+		q, err := query.RunParser(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := evaluateFS(fsys, q)
+		if err != nil {
+			t.Fatalf("%q\nExpected no error\n     Got %v", c.input, err)
+		}
+		paths := make([]string, 0, len(results))
+		for _, r := range results {
+			paths = append(paths, r.path)
+		}
+		sort.Strings(paths)
+		if !reflect.DeepEqual(paths, c.expected) {
+			t.Fatalf("%q\nExpected %q\n     Got %q", c.input, c.expected, paths)
+		}
+	}
+
+	// Paths outside of the filesystem, and those through a symbolic link,
+	// are errors rather than being walked.
+	for _, src := range []string{"..", "../" + filepath.Base(outside), "sub/../..", "dirlink/inner", "sub/up/b.txt"} {
+		q, err := query.RunParser("SELECT name FROM " + src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results, err := evaluateFS(fsys, q); err == nil {
+			t.Fatalf("%q\nExpected error\n     Got %d results", src, len(results))
+		}
+	}
+}
+
+// The fixture of TestEvaluateEmbedFS, with and without the files whose names
+// begin with . or _.
+var (
+	//go:embed testdata/embed
+	embedFixture embed.FS
+
+	//go:embed all:testdata/embed
+	embedAllFixture embed.FS
+)
+
+func TestEvaluateEmbedFS(t *testing.T) {
+	type Case struct {
+		efs      embed.FS
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			efs:      embedFixture,
+			input:    "SELECT name, size FROM .",
+			expected: []string{"a.txt 6", "sub 0", "sub/b.go 12"},
+		},
+		{
+			efs:      embedFixture,
+			input:    "SELECT name FROM sub WHERE CONTAINS_TEXT(package)",
+			expected: []string{"sub/b.go"},
+		},
+		{
+			efs:      embedAllFixture,
+			input:    "SELECT name FROM . WHERE file IS reg",
+			expected: []string{".hidden", "_build/out.txt", "_draft.txt", "a.txt", "sub/b.go"},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := query.RunParser(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := evaluateEmbedFS(c.efs, "testdata/embed", q)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+
+		actual := make([]string, 0, len(results))
+		for _, r := range results {
+			values := make([]string, len(q.Columns))
+			for i, col := range q.Columns {
+				values[i] = fmt.Sprint(r.column(i, col))
+			}
+			actual = append(actual, strings.Join(values, " "))
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("%q\nExpected %q\n     Got %q", c.input, c.expected, actual)
+		}
+	}
+
+	q, err := query.RunParser("SELECT name FROM .")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := evaluateEmbedFS(embedFixture, "../outside", q); err == nil {
+		t.Fatalf("\nExpected error for a root outside of the filesystem")
+	}
+}
+
+func TestEvaluateFS(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := createZip(t, map[string]string{"b.go": "bb"})
+	fsys := fstest.MapFS{
+		"data/a.txt":       {Data: []byte("TODO"), Mode: 0600, ModTime: modTime},
+		"data/src/main.go": {Data: []byte("package main"), Mode: 0644, ModTime: modTime},
+		"data/.hidden":     {Data: []byte("x"), Mode: 0644, ModTime: modTime},
+		"archive.zip":      {Data: archive},
+	}
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			input: "SELECT name, size, mode, time FROM data WHERE file IS reg",
+			expected: []string{
+				"data/.hidden 1 -rw-r--r-- " + modTime.String(),
+				"data/a.txt 4 -rw------- " + modTime.String(),
+				"data/src/main.go 12 -rw-r--r-- " + modTime.String(),
+			},
+		},
+		{
+			input:    "SELECT name FROM /data WHERE name LIKE %.go OR CONTAINS_TEXT(TODO)",
+			expected: []string{"/data/a.txt", "/data/src/main.go"},
+		},
+		{
+			input:    "PRAGMA max_depth = 1; SELECT name FROM . WHERE depth > 0",
+			expected: []string{"archive.zip", "data"},
+		},
+		{
+			input:    "SELECT name, size FROM archive.zip",
+			expected: []string{"archive.zip!/b.go 2"},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := query.RunParser(c.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := evaluateFS(fsys, q)
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+
+		actual := make([]string, 0, len(results))
+		for _, r := range results {
+			values := make([]string, len(q.Columns))
+			for i, col := range q.Columns {
+				values[i] = fmt.Sprint(r.column(i, col))
+			}
+			actual = append(actual, strings.Join(values, " "))
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("%q\nExpected %q\n     Got %q", c.input, c.expected, actual)
+		}
+	}
+
+	// Any fs.FS may be walked, e.g. a ZIP archive's.
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := query.RunParser("SELECT name FROM . WHERE name LIKE %.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := evaluateFS(zr, q)
+	if err != nil || len(results) != 1 || results[0].path != "b.go" {
+		t.Fatalf("\nExpected b.go\n     Got %v %v", results, err)
+	}
+}
+
+func TestWalkListing(t *testing.T) {
+	listing := map[string]os.FileInfo{
+		"a.csv":       objectInfo{name: "a.csv", size: 1},
+		"b/c.csv":     objectInfo{name: "c.csv", size: 2},
+		"b/d/e.csv":   objectInfo{name: "e.csv", size: 3},
+		"f/g.csv":     objectInfo{name: "g.csv", size: 4},
+		"empty":       impliedDir("empty"),
+		"f/h/i/j.csv": objectInfo{name: "j.csv", size: 5},
+	}
+	root := objectInfo{name: "bucket", dir: true}
+
+	type Case struct {
+		opts     query.QueryOptions
+		skip     string
+		expected []string
+	}
+
+	cases := []Case{
+		{expected: []string{
+			"s3://bucket", "s3://bucket/a.csv", "s3://bucket/b", "s3://bucket/b/c.csv", "s3://bucket/b/d",
+			"s3://bucket/b/d/e.csv", "s3://bucket/empty", "s3://bucket/f", "s3://bucket/f/g.csv",
+			"s3://bucket/f/h", "s3://bucket/f/h/i", "s3://bucket/f/h/i/j.csv",
+		}},
+		{skip: "s3://bucket/f", expected: []string{
+			"s3://bucket", "s3://bucket/a.csv", "s3://bucket/b", "s3://bucket/b/c.csv", "s3://bucket/b/d",
+			"s3://bucket/b/d/e.csv", "s3://bucket/empty", "s3://bucket/f",
+		}},
+		{opts: query.QueryOptions{MaxDepth: 1}, expected: []string{
+			"s3://bucket", "s3://bucket/a.csv", "s3://bucket/b", "s3://bucket/empty", "s3://bucket/f",
+		}},
+	}
+
+	for _, c := range cases {
+		var actual []string
+		err := walkListing("s3://bucket", root, listing, c.opts, func(path string, info os.FileInfo, err error) error {
+			actual = append(actual, path)
+			if path == c.skip {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+// A fakeWebDAV is a WebDAV server of the files (by their paths, without a
+// leading slash, to their contents), which were all modified at the same time.
+// It requires the user to authenticate with basic or digest auth, if auth is
+// set.
+type fakeWebDAV struct {
+	files    map[string]string
+	modified time.Time
+	auth     string
+	user     string
+	password string
+}
+
+func (f *fakeWebDAV) authorized(r *http.Request) bool {
+	switch f.auth {
+	case "basic":
+		user, password, ok := r.BasicAuth()
+		return ok && user == f.user && password == f.password
+	case "digest":
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Digest ") {
+			return false
+		}
+		p := parseAuthParams(header[len("Digest "):])
+		ha1 := md5Hex(f.user + ":fsql:" + f.password)
+		ha2 := md5Hex(r.Method + ":" + r.URL.RequestURI())
+		expected := md5Hex(ha1 + ":nonce:" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+		return p["username"] == f.user && p["opaque"] == "opaque" && p["response"] == expected
+	}
+	return true
+}
+
+func (f *fakeWebDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.authorized(r) {
+		if f.auth == "basic" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fsql"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Digest realm="fsql", nonce="nonce", qop="auth", opaque="opaque"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	name := strings.Trim(r.URL.Path, "/")
+	if r.Method == "GET" {
+		if contents, ok := f.files[name]; ok {
+			io.WriteString(w, contents)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The files and directories at or directly below name.
+	entries := make(map[string]bool)
+	for file := range f.files {
+		if file == name {
+			entries[file] = false
+		} else if name == "" || strings.HasPrefix(file, name+"/") {
+			entries[name] = true
+			if r.Header.Get("Depth") == "1" {
+				rest := strings.TrimPrefix(file[len(name):], "/")
+				child := strings.TrimPrefix(name+"/"+strings.SplitN(rest, "/", 2)[0], "/")
+				entries[child] = entries[child] || child != file
+			}
+		}
+	}
+	if len(entries) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:">`)
+	for entry, dir := range entries {
+		href := (&url.URL{Path: "/" + entry}).EscapedPath()
+		modified := f.modified.Format(http.TimeFormat)
+		if dir {
+			fmt.Fprintf(w, `<D:response><D:href>%s/</D:href>`+
+				`<D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype>`+
+				`<D:getlastmodified>%s</D:getlastmodified></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`+
+				`<D:propstat><D:prop><D:getcontentlength/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`+
+				`</D:response>`, href, modified)
+		} else {
+			fmt.Fprintf(w, `<D:response><D:href>%s</D:href>`+
+				`<D:propstat><D:prop><D:resourcetype/><D:getcontentlength>%d</D:getcontentlength>`+
+				`<D:getlastmodified>%s</D:getlastmodified></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`+
+				`</D:response>`, href, len(f.files[entry]), modified)
+		}
+	}
+	fmt.Fprint(w, `</D:multistatus>`)
+}
+
+func TestWebDAV(t *testing.T) {
+	archive := createZip(t, map[string]string{"e.txt": "eeeee"})
+	dav := &fakeWebDAV{
+		files: map[string]string{
+			"documents/a.txt":          "aaa",
+			"documents/my notes/b.md":  "bb",
+			"documents/reports/c.csv":  "c",
+			"documents/reports/d.zip":  string(archive),
+			"other/f.txt":              "ffff",
+			"documents/reports/g/h.md": "",
+		},
+		modified: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		user:     "user",
+		password: "secret",
+	}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	type Case struct {
+		auth     string
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			query: "SELECT name, size FROM webdav://" + host + "/documents WHERE file IS reg",
+			expected: []string{
+				"webdav://" + host + "/documents/a.txt\t3",
+				"webdav://" + host + "/documents/my notes/b.md\t2",
+				"webdav://" + host + "/documents/reports/c.csv\t1",
+				"webdav://" + host + "/documents/reports/d.zip\t" + strconv.Itoa(len(archive)),
+				"webdav://" + host + "/documents/reports/g/h.md\t0",
+			},
+		},
+		{
+			query:    "SELECT name, size FROM webdav://" + host + "/documents/reports/d.zip",
+			expected: []string{"webdav://" + host + "/documents/reports/d.zip!/e.txt\t5"},
+		},
+		{
+			auth:  "basic",
+			query: "SELECT name FROM webdav://user:secret@" + host + "/documents WHERE file IS dir",
+			expected: []string{
+				"webdav://user:secret@" + host + "/documents",
+				"webdav://user:secret@" + host + "/documents/my notes",
+				"webdav://user:secret@" + host + "/documents/reports",
+				"webdav://user:secret@" + host + "/documents/reports/g",
+			},
+		},
+		{
+			auth:     "digest",
+			query:    "SELECT name, time FROM webdav://user:secret@" + host + "/ WHERE time > 'Jan 01 2024 00 00' AND name LIKE %.txt",
+			expected: []string{"webdav://user:secret@" + host + "/documents/a.txt\tMar  1 12:00:00", "webdav://user:secret@" + host + "/other/f.txt\tMar  1 12:00:00"},
+		},
+		{
+			auth:     "digest",
+			query:    "SELECT name FROM webdav://user:wrong@" + host + "/documents",
+			expected: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		dav.auth = c.auth
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	// Errors listing the source are reported rather than ignored by Stat.
+	fsys, err := newWebDAVVFS("webdav://user:wrong@"+host+"/documents", query.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "PROPFIND http://" + host + "/documents: 401 Unauthorized"
+	if _, err := fsys.Stat("webdav://user:wrong@" + host + "/documents"); err == nil || err.Error() != expected {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, err)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	if out, err := exec.Command("go", "env", "CGO_ENABLED").Output(); err != nil ||
+		strings.TrimSpace(string(out)) != "1" {
+		t.Skip("plugins require cgo")
+	}
+
+	dir := t.TempDir()
+	build := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=plugin",
+		"-o", filepath.Join(dir, "testfile.so"), "./testdata/plugins/testfile")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the plugin: %v\n%s", err, out)
+	}
+	if err := loadPlugins(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	src := createTree(t, map[string]string{
+		"main.go":         "",
+		"main_test.go":    "",
+		"query/a.go":      "",
+		"query/b_test.go": "",
+	})
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			query: "SELECT name, is_test_file FROM " + src + " WHERE file IS reg ORDER BY name",
+			expected: []string{
+				src + "/query/a.go\tfalse",
+				src + "/query/b_test.go\ttrue",
+				src + "/main.go\tfalse",
+				src + "/main_test.go\ttrue",
+			},
+		},
+		{
+			query:    "SELECT name FROM " + src + " WHERE is_test_file = true",
+			expected: []string{src + "/main_test.go", src + "/query/b_test.go"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	if err := loadPlugins(filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":       "a",
+		"b.go":       "bb",
+		"src/c.js":   "ccc",
+		"src/d":      "dddd",
+		"src/e/f.go": "eeeee",
+	})
+	input := "SELECT name, size FROM " + dir + " WHERE file IS reg ORDER BY size"
+
+	// Return the rows of each CSV file in the directory, by file name.
+	readGroups := func(out string) map[string][][]string {
+		files, err := ioutil.ReadDir(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups := make(map[string][][]string, len(files))
+		for _, f := range files {
+			contents, err := ioutil.ReadFile(filepath.Join(out, f.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			groups[f.Name()] = rows
+		}
+		return groups
+	}
+
+	out := filepath.Join(t.TempDir(), "groups")
+	err := run(input, &options{outputDir: out, groupBy: "ext", outputTemplate: defaultOutputTemplate},
+		ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][][]string{
+		"go.csv": {
+			{"name", "size"},
+			{dir + "/a.go", "1"}, {dir + "/b.go", "2"}, {dir + "/src/e/f.go", "5"},
+		},
+		"js.csv": {{"name", "size"}, {dir + "/src/c.js", "3"}},
+		"_.csv":  {{"name", "size"}, {dir + "/src/d", "4"}},
+	}
+	if actual := readGroups(out); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	// Directories are sanitized, rather than creating files in other
+	// directories.
+	out = filepath.Join(t.TempDir(), "groups")
+	err = run(input, &options{outputDir: out, groupBy: "dir", outputTemplate: "dir-{{.Key}}.csv"},
+		ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := sanitizeFilename(dir)
+	expected = map[string][][]string{
+		"dir-" + key + ".csv":       {{"name", "size"}, {dir + "/a.go", "1"}, {dir + "/b.go", "2"}},
+		"dir-" + key + "_src.csv":   {{"name", "size"}, {dir + "/src/c.js", "3"}, {dir + "/src/d", "4"}},
+		"dir-" + key + "_src_e.csv": {{"name", "size"}, {dir + "/src/e/f.go", "5"}},
+	}
+	if actual := readGroups(out); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	type Case struct {
+		opts     *options
+		expected string
+	}
+
+	cases := []Case{
+		{&options{groupBy: "ext"}, "-group-by requires -output-dir"},
+		{&options{outputDir: out}, "-output-dir requires -group-by"},
+		{&options{outputDir: out, groupBy: "ext", outputTemplate: "{{.Key"},
+			`invalid -output-filename-template: template: filename:1: unclosed action`},
+		{&options{outputDir: out, groupBy: "ext", outputTemplate: "{{.Value}}/x.csv"},
+			`invalid file name for group "": "/x.csv"`},
+		{&options{outputDir: out, groupBy: "ext", outputTemplate: "all.csv"},
+			`groups "" and ".go" have the same file name all.csv`},
+	}
+
+	for _, c := range cases {
+		err := run(input, c.opts, ioutil.Discard, ioutil.Discard)
+		if err == nil || err.Error() != c.expected {
+			t.Fatalf("%+v\nExpected %v\n     Got %v", c.opts, c.expected, err)
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "a",
+		"b.go":     "bb",
+		"c.js":     "ccc",
+		"src/d.go": "dddd",
+		"src/e":    "eeeee",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT ext, COUNT(*)" + from + " GROUP BY ext", []string{"\t1", ".go\t3", ".js\t1"}},
+		{"SELECT ext, COUNT(*)" + from + " GROUP BY ext ORDER BY ext DESC", []string{".js\t1", ".go\t3", "\t1"}},
+		{"SELECT COUNT(*), COUNT(ext)" + from, []string{"5\t5"}},
+		{"SELECT COUNT(*)" + from + " AND name LIKE %.xyz", []string{"0"}},
+		{"SELECT ext, COUNT(*)" + from + " GROUP BY ext LIMIT 1", []string{"\t1"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bb",
+		"c.go": "ccc",
+		"d.go": "dddd",
+		"e.go": "eeeee",
+		"f.md": "f",
+		"g.md": "gg",
+		"h.md": "gggg",
+		"i.md": "iiiii",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT MEDIAN(size)" + from + " AND name LIKE %.go", []string{"3"}},
+		{"SELECT MEDIAN(size)" + from + " AND name LIKE %.md", []string{"3"}},
+		{"SELECT MEDIAN(size)" + from + " AND name LIKE %.xyz", []string{"0"}},
+		{"SELECT MEDIAN(size)" + from + " AND size < 3", []string{"1.5"}},
+		{"SELECT ext, MEDIAN(size), COUNT(*)" + from + " GROUP BY ext", []string{".go\t3\t5", ".md\t3\t4"}},
+		{"SELECT MEDIAN(tar_offset)" + from, []string{"0"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	// The median of an even number of files is a number, the mean of the
+	// middle two sizes.
+	results := []result{
+		{info: fileInfo{size: 1}}, {info: fileInfo{size: 2}}, {info: fileInfo{size: 4}}, {info: fileInfo{size: 5}},
+	}
+	fn := &query.AggregateFunction{Type: query.Median, Attribute: "size"}
+	if actual := computeAggregate(fn, results, []int{0, 1, 2, 3}, query.QueryOptions{}); actual != 3.0 {
+		t.Fatalf("\nExpected 3.0\n     Got %#v", actual)
+	}
+}
+
+func TestStddev(t *testing.T) {
+	files := make(map[string]string)
+	for i, size := range []int{2, 4, 4, 4, 5, 5, 7, 9} {
+		files[fmt.Sprintf("%d.go", i)] = strings.Repeat("a", size)
+	}
+	files["a.md"] = "aaa"
+	dir := createMockTree(t, files)
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT STDDEV(size), VARIANCE(size)" + from + " AND name LIKE %.go", []string{"2\t4"}},
+		{"SELECT STDDEV_SAMP(size)" + from + " AND name LIKE %.go", []string{"2.138089935299395"}},
+		{
+			"SELECT ext, STDDEV(size), VARIANCE(size), STDDEV_SAMP(size)" + from + " GROUP BY ext",
+			[]string{".go\t2\t4\t2.138089935299395", ".md\t0\t0\t"},
+		},
+		{"SELECT STDDEV(size), VARIANCE(size), STDDEV_SAMP(size)" + from + " AND name LIKE %.xyz", []string{"0\t0\t"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	// The variance of values which are far from 0, but close together.
+	if _, variance := welfordVariance([]int64{1e12 + 4, 1e12 + 7, 1e12 + 13, 1e12 + 16}); variance != 22.5 {
+		t.Fatalf("\nExpected a variance of 22.5\n     Got %v", variance)
+	}
+}
+
+func TestStringAgg(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "aaa",
+		"b.go":     "b",
+		"c.md":     "cc",
+		"src/d.go": "dddd",
+		"src/e.md": "eeeee",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{`SELECT STRING_AGG(size, ", ")` + from, []string{"3, 1, 2, 4, 5"}},
+		{`SELECT STRING_AGG(size, "," ORDER BY size DESC)` + from, []string{"5,4,3,2,1"}},
+		{`SELECT ext, STRING_AGG(size, "-" ORDER BY size)` + from + " GROUP BY ext", []string{".go\t1-3-4", ".md\t2-5"}},
+		{`SELECT STRING_AGG(ext, "" ORDER BY ext DESC, size)` + from + " AND size < 4", []string{".md.go.go"}},
+		{`SELECT STRING_AGG(size, ", ")` + from + " AND size > 4", []string{"5"}},
+		{`SELECT STRING_AGG(size, ", "), COUNT(*)` + from + " AND name LIKE %.xyz", []string{"\t0"}},
+		{`SELECT STRING_AGG(tar_offset, ", "), COUNT(*)` + from, []string{"\t5"}},
+		{
+			`PRAGMA string_agg_max_length = 7; SELECT ext, STRING_AGG(size, ", " ORDER BY size)` + from + " GROUP BY ext",
+			[]string{".go\t1, 3, 4", ".md\t2, 5"},
+		},
+		{
+			`PRAGMA string_agg_max_length = 4; SELECT STRING_AGG(size, ", " ORDER BY size)` + from,
+			[]string{"1, 2..."},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+}
+
+func TestArrayAgg(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "aaa",
+		"b.go":     "b",
+		"c":        "cc",
+		"src/d.go": "dddd",
+		"src/e.md": "eeeee",
+	})
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT ARRAY_AGG(size)" + from, []string{"[3,1,2,4,5]"}},
+		{"SELECT ARRAY_AGG(size ORDER BY size DESC)" + from, []string{"[5,4,3,2,1]"}},
+		{"SELECT ARRAY_AGG(ext ORDER BY ext)" + from, []string{`[null,".go",".go",".go",".md"]`}},
+		{"SELECT ext, ARRAY_AGG(size ORDER BY size)" + from + " GROUP BY ext", []string{
+			"\t[2]", ".go\t[1,3,4]", ".md\t[5]",
+		}},
+		{"SELECT ARRAY_AGG(size), COUNT(*)" + from + " AND name LIKE %.xyz", []string{"[]\t0"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	// Each group's value is a JSON array (as a string) of its files.
+	var buf bytes.Buffer
+	input := "SELECT dir, ARRAY_AGG(name), ARRAY_AGG(mode)" + from + " GROUP BY dir"
+	if err := run(input, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("\nExpected valid JSON\n     Got %v", err)
+	}
+	counts := map[string]int{dir: 3, filepath.Join(dir, "src"): 2}
+	for _, row := range rows {
+		var names, modes []string
+		if err := json.Unmarshal([]byte(row["array_agg(name)"].(string)), &names); err != nil {
+			t.Fatalf("\nExpected a JSON array of names\n     Got %v", row["array_agg(name)"])
+		}
+		if err := json.Unmarshal([]byte(row["array_agg(mode)"].(string)), &modes); err != nil {
+			t.Fatalf("\nExpected a JSON array of modes\n     Got %v", row["array_agg(mode)"])
+		}
+		if len(names) != counts[row["dir"].(string)] || len(modes) != len(names) {
+			t.Fatalf("\nExpected %d names and modes\n     Got %v and %v", counts[row["dir"].(string)], names, modes)
+		}
+	}
+}
+
+func TestBitAggregates(t *testing.T) {
+	dir := createTree(t, map[string]string{
+		"a.go": "a",
+		"b.go": "bb",
+		"c.go": "cccc",
+		"d.md": "dddddd",
+		"e.md": "eeeeeee",
+	})
+	modes := map[string]os.FileMode{"a.go": 0644, "b.go": 0640, "c.go": 0600, "d.md": 0644, "e.md": 0644}
+	for name, mode := range modes {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := " FROM " + dir + " WHERE file IS reg"
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT BIT_AND(mode)" + from + " AND name LIKE %.go", []string{os.FileMode(0600).String()}},
+		{"SELECT BIT_OR(mode)" + from + " AND size <> 2", []string{os.FileMode(0644).String()}},
+		{"SELECT BIT_XOR(mode)" + from + " AND name LIKE %.md", []string{os.FileMode(0).String()}},
+		{"SELECT BIT_AND(size), BIT_OR(size), BIT_XOR(size)" + from + " AND name LIKE %.md", []string{"6\t7\t1"}},
+		{
+			"SELECT ext, BIT_AND(mode), BIT_OR(mode)" + from + " GROUP BY ext",
+			[]string{".go\t-rw-------\t-rw-r--r--", ".md\t-rw-r--r--\t-rw-r--r--"},
+		},
+		{"SELECT BIT_OR(mode), COUNT(*)" + from + " AND name LIKE %.xyz", []string{"\t0"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatalf("%s\nExpected no error\n     Got %v", c.query, err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "a", "b.go": "bb", "c.md": "ccc"})
+	input := "SELECT CHECKSUM(name, size, time) FROM " + dir + " WHERE file IS reg"
+
+	first, err := runLines(input, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	second, _ := runLines(input, &options{})
+	if len(first) != 1 || len(first[0]) != 64 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("\nExpected the same SHA-256 checksum\n     Got %v and %v", first, second)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "d.go"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if third, _ := runLines(input, &options{}); reflect.DeepEqual(first, third) {
+		t.Fatalf("\nExpected the checksum to change after adding a file\n     Got %v", third)
+	}
+
+	// The same files, walked in a different order.
+	a := createTree(t, map[string]string{"a.go": "a", "b.go": "bb", "c.md": "ccc"})
+	b := createTree(t, map[string]string{"a.md": "ccc", "b.go": "bb", "c.go": "a"})
+	checksums := make([]string, 0, 2)
+	for _, dir := range []string{a, b} {
+		lines, err := runLines("SELECT CHECKSUM(size, ext) FROM "+dir+" WHERE file IS reg", &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+		checksums = append(checksums, lines[0])
+	}
+	if checksums[0] != checksums[1] {
+		t.Fatalf("\nExpected the same checksum regardless of order\n     Got %v", checksums)
+	}
+
+	lines, err := runLines("SELECT ext, CHECKSUM(size) FROM "+a+" WHERE file IS reg GROUP BY ext", &options{})
+	if err != nil || len(lines) != 2 || lines[0][len(".go\t"):] == lines[1][len(".md\t"):] {
+		t.Fatalf("\nExpected a different checksum per group\n     Got %v %v", lines, err)
+	}
+}
+
+func TestChecksumAgg(t *testing.T) {
+	// The same sizes, walked in a different order, and a different set.
+	dir := createMockTree(t, map[string]string{
+		"a/a.go": "a", "a/b.go": "bb", "a/c.md": "ccc",
+		"b/a.md": "ccc", "b/b.go": "bb", "b/c.go": "a",
+		"c/a.go": "a", "c/b.go": "bb",
+	})
+	checksums := func(column string) []string {
+		var values []string
+		for _, src := range []string{"a", "b", "c"} {
+			lines, err := runLines("SELECT "+column+" FROM "+filepath.Join(dir, src)+" WHERE file IS reg", &options{})
+			if err != nil || len(lines) != 1 {
+				t.Fatalf("\nExpected a checksum\n     Got %v %v", lines, err)
+			}
+			values = append(values, lines[0])
+		}
+		return values
+	}
+
+	crc := crc32.ChecksumIEEE([]byte("1")) ^ crc32.ChecksumIEEE([]byte("2")) ^ crc32.ChecksumIEEE([]byte("3"))
+	sorted := sha256.Sum256([]byte("1\x002\x003\x00"))
+
+	type Case struct {
+		column   string
+		expected []string
+	}
+
+	cases := []Case{
+		// The order the files are walked in doesn't change the checksum.
+		{"CHECKSUM_AGG(size)", []string{fmt.Sprintf("%08x", crc), fmt.Sprintf("%08x", crc)}},
+		{"CHECKSUM_AGG(size ORDER BY size)", []string{hex.EncodeToString(sorted[:]), hex.EncodeToString(sorted[:])}},
+	}
+
+	for _, c := range cases {
+		actual := checksums(c.column)
+		if !reflect.DeepEqual(actual[:2], c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual[:2])
+		}
+		if actual[2] == actual[0] {
+			t.Fatalf("\nExpected a different checksum for a different set of files\n     Got %v", actual)
+		}
+	}
+
+	// Ordering the same values differently changes the checksum.
+	if actual := checksums("CHECKSUM_AGG(size ORDER BY name)"); actual[0] == actual[1] {
+		t.Fatalf("\nExpected a different checksum for a different order\n     Got %v", actual)
+	}
+
+	// The query's ORDER BY (without GROUP BY) orders the values the same way.
+	from := " FROM " + filepath.Join(dir, "a") + " WHERE file IS reg"
+	expected, err := runLines("SELECT CHECKSUM_AGG(size ORDER BY name DESC)"+from, &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	lines, err := runLines("SELECT CHECKSUM_AGG(size)"+from+" ORDER BY name DESC", &options{})
+	if err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v %v", expected, lines, err)
+	}
+}
+
+func TestPivot(t *testing.T) {
+	dir := createMockTree(t, map[string]string{
+		"a.go":     "a",
+		"b.go":     "bb",
+		"c.js":     "ccc",
+		"src/d.go": "dddd",
+		"src/e":    "eeeee",
+	})
+	input := "SELECT ext, COUNT(*) FROM " + dir + " WHERE file IS reg GROUP BY ext PIVOT"
+
+	var buf bytes.Buffer
+	if err := run(input, &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	var actual []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{{"NULL": 1.0, ".go": 3.0, ".js": 1.0}}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	lines, err := runLines(input, &options{format: "markdown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := markdownCells(lines[0]); !reflect.DeepEqual(header, []string{"NULL", ".go", ".js"}) {
+		t.Fatalf("\nExpected columns NULL, .go, .js\n     Got %v", header)
+	}
+
+	lines, err = runLines("SELECT ext, COUNT(*) FROM "+dir+" WHERE name LIKE %.xyz GROUP BY ext PIVOT", &options{})
+	if err != nil || len(lines) != 0 {
+		t.Fatalf("\nExpected no results\n     Got %v %v", lines, err)
+	}
+
+	// Each file has a group of its own, which is more columns than a row
+	// may have.
+	files := make(map[string]string, maxPivotColumns+1)
+	for i := 0; i <= maxPivotColumns; i++ {
+		files["f"+strconv.Itoa(i)] = ""
+	}
+	many := createMockTree(t, files)
+	err = run("SELECT name, COUNT(*) FROM "+many+" WHERE file IS reg GROUP BY name PIVOT", &options{},
+		ioutil.Discard, ioutil.Discard)
+	expectedErr := "PIVOT of 1001 groups exceeds the maximum of 1000 columns"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("\nExpected %v\n     Got %v", expectedErr, err)
+	}
+}
+
+// A quickCase is a random tree of files below root, and a random condition on
+// its files, which TestQuickConditions evaluates.
+type quickCase struct {
+	root      string
+	files     testutil.MockVFS
+	condition quickCondition
+}
+
+// A quickCondition is a condition of a query's WHERE clause, which is also
+// evaluated directly on the files of a quickCase (see quickCondition.matches).
+type quickCondition struct {
+	attribute  string
+	comparator string
+	value      string
+	negate     bool
+}
+
+var (
+	quickDirs      = []string{"d", "e", "de", "ed"}
+	quickFiles     = []string{"a", "b", "ab", "ba", "a.go", "b.go", "ab.txt", "Ab"}
+	quickAlpha     = []string{"=", "<>", "LIKE", "CONTAINS", "BEGINSWITH"}
+	quickNumeric   = []string{"=", "<>", ">", "<", ">=", "<="}
+	quickEpoch     = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	quickTimeSteps = 8
+)
+
+func (quickCase) Generate(rand *rand.Rand, size int) reflect.Value {
+	root := "/fsql/TestQuickConditions"
+	c := quickCase{root: root, files: testutil.MockVFS{root: {Mode: os.ModeDir, ModTime: quickEpoch}}}
+
+	// Each file is up to 3 directories below root, and the times of the files
+	// are a few minutes apart, so that many of them are equal.
+	randomTime := func() time.Time {
+		return quickEpoch.Add(time.Duration(rand.Intn(quickTimeSteps)) * time.Minute)
+	}
+	var paths []string
+	for i := rand.Intn(size + 1); i >= 0; i-- {
+		dir := root
+		for j := rand.Intn(4); j > 0; j-- {
+			dir = filepath.Join(dir, quickDirs[rand.Intn(len(quickDirs))])
+			if _, ok := c.files[dir]; !ok {
+				c.files[dir] = testutil.MockFile{Mode: os.ModeDir, ModTime: randomTime()}
+			}
+		}
+		path := filepath.Join(dir, quickFiles[rand.Intn(len(quickFiles))])
+		c.files[path] = testutil.MockFile{Size: rand.Int63n(3) * rand.Int63n(2048), ModTime: randomTime()}
+		paths = append(paths, path)
+	}
+
+	// The values of the conditions are mostly those of the tree's files, so
+	// that the conditions match some (but not all) of them.
+	path := paths[rand.Intn(len(paths))]
+	file := c.files[path]
+	cond := &c.condition
+	cond.negate = rand.Intn(4) == 0
+	switch cond.attribute = []string{"name", "dir", "size", "time", "depth", "file"}[rand.Intn(6)]; cond.attribute {
+	case "name", "dir":
+		value := filepath.Base(path)
+		if cond.attribute == "dir" {
+			value = filepath.Dir(path)
+		}
+		cond.comparator = quickAlpha[rand.Intn(len(quickAlpha))]
+		if rand.Intn(3) == 0 {
+			// A substring of the value.
+			i := rand.Intn(len(value))
+			value = value[i : i+1+rand.Intn(len(value)-i)]
+		}
+		if cond.comparator == "LIKE" {
+			value = []string{"%" + value, value + "%", "%" + value + "%"}[rand.Intn(3)]
+		}
+		cond.value = value
+
+	case "size":
+		cond.comparator = quickNumeric[rand.Intn(len(quickNumeric))]
+		cond.value = strconv.FormatInt(file.Size+int64(rand.Intn(3)-1), 10)
+		if rand.Intn(4) == 0 {
+			cond.value = strconv.Itoa(rand.Intn(3)) + "kb"
+		}
+
+	case "time":
+		cond.comparator = quickNumeric[rand.Intn(len(quickNumeric))]
+		cond.value = randomTime().Format(query.TimeLayout)
+
+	case "depth":
+		cond.comparator = quickNumeric[rand.Intn(len(quickNumeric))]
+		cond.value = strconv.Itoa(rand.Intn(5))
+
+	case "file":
+		cond.comparator = "IS"
+		cond.value = []string{"dir", "reg"}[rand.Intn(2)]
+	}
+
+	return reflect.ValueOf(c)
+}
+
+// Describe the case briefly when quick.Check reports it (the test logs the
+// files which were expected to match, and those which did).
+func (c quickCase) GoString() string {
+	return fmt.Sprintf("%d files below %s WHERE %s", len(c.files), c.root, c.condition)
+}
+
+func (c quickCondition) String() string {
+	s := fmt.Sprintf("%s %s '%s'", c.attribute, c.comparator, c.value)
+	if c.negate {
+		return "NOT " + s
+	}
+	return s
+}
+
+// Return true iff the file at path, which is below root, satisfies the
+// condition, comparing the fields of the file directly.
+func (c quickCondition) matches(root, path string, file testutil.MockFile) bool {
+	alpha := func(a string) bool {
+		switch c.comparator {
+		case "=":
+			return a == c.value
+		case "<>":
+			return a != c.value
+		case "CONTAINS":
+			return strings.Contains(a, c.value)
+		case "BEGINSWITH":
+			return strings.HasPrefix(a, c.value)
+		}
+		pattern := strings.Trim(c.value, "%")
+		switch {
+		case strings.HasPrefix(c.value, "%") && strings.HasSuffix(c.value, "%"):
+			return strings.Contains(a, pattern)
+		case strings.HasPrefix(c.value, "%"):
+			return strings.HasSuffix(a, pattern)
+		}
+		return strings.HasPrefix(a, pattern)
+	}
+	numeric := func(a, b int64) bool {
+		switch c.comparator {
+		case "=":
+			return a == b
+		case "<>":
+			return a != b
+		case ">":
+			return a > b
+		case "<":
+			return a < b
+		case ">=":
+			return a >= b
+		}
+		return a <= b
+	}
+
+	var match bool
+	switch c.attribute {
+	case "name":
+		match = alpha(filepath.Base(path))
+	case "dir":
+		match = alpha(filepath.Dir(path))
+	case "size":
+		size, _ := strconv.ParseInt(strings.TrimSuffix(c.value, "kb"), 10, 64)
+		if strings.HasSuffix(c.value, "kb") {
+			size *= 1024
+		}
+		match = numeric(file.Size, size)
+	case "time":
+		t, _ := time.Parse(query.TimeLayout, c.value)
+		match = numeric(file.ModTime.Unix(), t.Unix())
+	case "depth":
+		depth, _ := strconv.ParseInt(c.value, 10, 64)
+		rel := strings.TrimPrefix(path, root)
+		match = numeric(int64(strings.Count(rel, "/")), depth)
+	case "file":
+		match = (c.value == "dir") == (file.Mode&os.ModeDir != 0)
+	}
+	return match != c.negate
+}
+
+func TestQuickConditions(t *testing.T) {
+	previous := sourceVFS
+	defer func() { sourceVFS = previous }()
+
+	f := func(c quickCase) bool {
+		sourceVFS = func(string, query.QueryOptions) (vfs, error) { return c.files, nil }
+
+		input := fmt.Sprintf("SELECT name FROM %s WHERE %s", c.root, c.condition)
+		actual, err := runLines(input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+		}
+		expected := []string{}
+		for path, file := range c.files {
+			if c.condition.matches(c.root, path, file) {
+				expected = append(expected, path)
+			}
+		}
+		sort.Strings(actual)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Logf("\nQuery %q\nExpected %v\n     Got %v", input, expected, actual)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build norm
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	// The name of the file is in NFD, in which é is e followed by a combining
+	// acute accent, while the values are in NFC, in which it's a single rune.
+	nfd, nfc := "cafe\u0301", "caf\u00e9"
+	ligature := "\ufb01le"
+	dir := createTree(t, map[string]string{nfd: "", "cafe": "", ligature: ""})
+	where := "SELECT name FROM " + dir + " WHERE file IS reg AND "
+
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{where + `NORMALIZE(name, "NFC") IS "` + nfc + `"`, []string{nfd}},
+		{where + `NORMALIZE(name, nfc) = "` + nfc + `"`, []string{nfd}},
+		{where + `NORMALIZE(name, NFD) = "` + nfd + `"`, []string{nfd}},
+		{where + `NORMALIZE(name, NFD) = "` + nfc + `"`, nil},
+		{where + `NORMALIZE(name, NFKD) = "` + nfd + `"`, []string{nfd}},
+		{where + `NOT NORMALIZE(name, NFC) = "` + nfc + `" ORDER BY name`, []string{"cafe", ligature}},
+
+		// NFKC and NFKD also replace compatibility characters, e.g. the ﬁ
+		// ligature with f and i.
+		{where + `NORMALIZE(name, NFKC) = file`, []string{ligature}},
+		{where + `NORMALIZE(name, NFC) = file`, nil},
+
+		// Without NORMALIZE, the names are compared as they are.
+		{where + `name IS "` + nfc + `"`, nil},
+		{where + `name = "` + nfc + `"`, nil},
+		{where + `name = "` + nfd + `"`, []string{nfd}},
+	}
+
+	for _, c := range cases {
+		lines, err := runLines(c.input, &options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected := make([]string, 0, len(c.expected))
+		for _, name := range c.expected {
+			expected = append(expected, filepath.Join(dir, name))
+		}
+		if !reflect.DeepEqual(lines, expected) {
+			t.Fatalf("\nExpected %q for %q\n     Got %q", expected, c.input, lines)
+		}
+	}
+}
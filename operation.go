@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The functions which change the files of a DELETE, MOVE, or COPY statement,
+// which the tests replace to interrupt one.
+var (
+	renameFile = os.Rename
+	removeFile = os.Remove
+)
+
+// A fileStep is one of the steps of a DELETE, MOVE, MOVE ATOMIC, or COPY
+// statement (its Op), which deletes the file From, or moves or copies it to
+// To.
+type fileStep struct {
+	Op   string `json:"op,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Take the step.
+func (s fileStep) apply() error {
+	switch s.Op {
+	case "DELETE":
+		return removeFile(s.From)
+	case "MOVE", "MOVE ATOMIC":
+		return moveFile(s.From, s.To)
+	default:
+		return copyFile(s.From, s.To)
+	}
+}
+
+// Undo the step, which was taken. A deleted file can't be restored.
+func (s fileStep) undo() error {
+	switch s.Op {
+	case "DELETE":
+		return fmt.Errorf("cannot restore %s, which was deleted", s.From)
+	case "MOVE", "MOVE ATOMIC":
+		return moveFile(s.To, s.From)
+	default:
+		return removeFile(s.To)
+	}
+}
+
+// Return true iff the files show that the step was taken. A step may be taken
+// without being recorded in the write-ahead log, when the statement is
+// interrupted in between.
+func (s fileStep) taken() bool {
+	_, errFrom := os.Lstat(s.From)
+	_, errTo := os.Lstat(s.To)
+	switch s.Op {
+	case "DELETE":
+		return os.IsNotExist(errFrom)
+	case "MOVE", "MOVE ATOMIC":
+		return os.IsNotExist(errFrom) && errTo == nil
+	default:
+		return errTo == nil
+	}
+}
+
+// Run the DELETE, MOVE, or COPY statement: evaluate its query, and delete,
+// move, or copy each of the regular files it matches. Directories are
+// skipped, so that the files below one aren't also changed as part of it.
+// Each of the steps is recorded in a write-ahead log before any of them is
+// taken (see wal.go), so that an interrupted statement can be completed or
+// rolled back with fsql recover. When a step of MOVE ... ATOMIC fails, the
+// steps it took are rolled back before the error is returned.
+func runOperation(q *query.Query, qopts query.QueryOptions) error {
+	for _, src := range q.Sources["include"] {
+		if sourceScheme(src) != "" {
+			return fmt.Errorf("%s can only use local directories", q.Operation.Type)
+		}
+	}
+
+	steps, err := planOperation(q, qopts)
+	if err != nil || len(steps) == 0 {
+		return err
+	}
+	if q.Operation.Atomic {
+		if steps, err = atomicSteps(steps); err != nil {
+			return err
+		}
+	}
+
+	wal, err := createWAL(walDir(), steps)
+	if err != nil {
+		return err
+	}
+	for i, step := range steps {
+		if err := step.apply(); err != nil && q.Operation.Atomic {
+			l := &interruptedLog{steps: steps, taken: i}
+			if rollbackErr := l.rollback(); rollbackErr != nil {
+				wal.close()
+				return fmt.Errorf("MOVE failed (%v), and rolling it back failed too (see fsql recover): %w", err,
+					rollbackErr)
+			}
+			if removeErr := wal.remove(); removeErr != nil {
+				return removeErr
+			}
+			return fmt.Errorf("MOVE was rolled back: %w", err)
+		} else if err != nil {
+			wal.close()
+			return fmt.Errorf("%s stopped after %d of %d files (see fsql recover): %w", q.Operation.Type, i,
+				len(steps), err)
+		}
+		if err := wal.done(i); err != nil {
+			wal.close()
+			return err
+		}
+	}
+	return wal.remove()
+}
+
+// Return the steps of the statement, after checking that each of them can be
+// taken: the target of MOVE or COPY must be a directory, which none of the
+// files are already in, and no two of the files may have the same name.
+func planOperation(q *query.Query, qopts query.QueryOptions) ([]fileStep, error) {
+	op := q.Operation
+	verb := strings.ToLower(op.Type)
+	target := ""
+	if op.Type != "DELETE" {
+		info, err := os.Stat(op.Target)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("cannot %s files to %s: not a directory", verb, op.Target)
+		}
+		if target, err = filepath.Abs(op.Target); err != nil {
+			return nil, err
+		}
+	}
+
+	results, err := evaluateWith(context.Background(), q, qopts, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]fileStep, 0, len(results))
+	seen := make(map[string]string)
+	for _, r := range results {
+		// Archive members (and the files of other filesystems) aren't local
+		// files, so they can't be changed.
+		if info, err := os.Lstat(r.path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		from, err := filepath.Abs(r.path)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[from]; ok {
+			continue
+		}
+
+		step := fileStep{Op: op.Type, From: from}
+		if target != "" {
+			step.To = filepath.Join(target, filepath.Base(from))
+			if other, ok := seen[step.To]; ok {
+				return nil, fmt.Errorf("cannot %s both %s and %s to %s", verb, other, from, op.Target)
+			}
+			if _, err := os.Lstat(step.To); err == nil {
+				return nil, fmt.Errorf("cannot %s %s to %s: %s already exists", verb, from, op.Target, step.To)
+			}
+			seen[step.To] = from
+		}
+		seen[from] = from
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Return the steps of MOVE ... ATOMIC, which moves the files in two phases:
+// each of them to a temporary name in the target directory, and then, once
+// they're all there, each to its name. Since the second phase only renames
+// files within a directory, the pairs of files which are moved between
+// devices are all in place first.
+func atomicSteps(steps []fileStep) ([]fileStep, error) {
+	staged := make([]fileStep, 0, 2*len(steps))
+	for _, step := range steps {
+		temp := filepath.Join(filepath.Dir(step.To), fmt.Sprintf(".%s.fsql-%d", filepath.Base(step.To), os.Getpid()))
+		if _, err := os.Lstat(temp); err == nil {
+			return nil, fmt.Errorf("cannot move %s to %s: %s already exists", step.From, temp, temp)
+		}
+		staged = append(staged, fileStep{Op: "MOVE ATOMIC", From: step.From, To: temp})
+	}
+	for i, step := range steps {
+		staged = append(staged, fileStep{Op: "MOVE ATOMIC", From: staged[i].To, To: step.To})
+	}
+	return staged, nil
+}
+
+// Move the file from one path to another by renaming it or, when they're on
+// different devices (which a file can't be renamed between), by copying it
+// and then removing it. If it can't be removed, the copy is.
+func moveFile(from, to string) error {
+	err := renameFile(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyFile(from, to); err != nil {
+		return err
+	}
+	if err := removeFile(from); err != nil {
+		os.Remove(to)
+		return err
+	}
+	return nil
+}
+
+// Copy the file, with its permissions and modification time, from one path to
+// another. It's written to a temporary file next to the other path first, so
+// that an interrupted copy never leaves part of the file there.
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := ioutil.TempFile(filepath.Dir(to), "."+filepath.Base(to)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(dst.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Chtimes(dst.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), to)
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The default template of the name of each group's file in the output
+// directory.
+const defaultOutputTemplate = "{{.Key}}.csv"
+
+// A groupWriter writes the results of each group (of the results with the
+// same value of an attribute) to a CSV file of its own in a directory.
+type groupWriter struct {
+	dir      string
+	groupBy  string
+	template *template.Template
+}
+
+// The data of the template of a group's file name.
+type groupData struct {
+	Key   string // The group's value, sanitized for use in a file name.
+	Value string // The group's value, as shown by the default format.
+}
+
+// Return the writer of the -output-dir and -group-by options.
+func newGroupWriter(opts *options) (*groupWriter, error) {
+	if opts.outputDir == "" {
+		return nil, errors.New("-group-by requires -output-dir")
+	}
+	if opts.groupBy == "" {
+		return nil, errors.New("-output-dir requires -group-by")
+	}
+	if !query.IsAttribute(opts.groupBy) {
+		return nil, &query.ErrUnknownToken{Raw: opts.groupBy}
+	}
+
+	text := opts.outputTemplate
+	if text == "" {
+		text = defaultOutputTemplate
+	}
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -output-filename-template: %v", err)
+	}
+
+	return &groupWriter{dir: opts.outputDir, groupBy: opts.groupBy, template: tmpl}, nil
+}
+
+// Write the results of each group to its file, with a header row of the
+// column names and a row per result (in order). Groups are written in order
+// of their values.
+func (g *groupWriter) write(columns []query.Column, results []result) error {
+	groups := make(map[string][]result)
+	for _, r := range results {
+		value := formatValue(r.value(g.groupBy))
+		groups[value] = append(groups[value], r)
+	}
+
+	values := make([]string, 0, len(groups))
+	for value := range groups {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	// Different values may have the same file name once they're sanitized,
+	// in which case one group's file would overwrite the other's.
+	names := make(map[string]string, len(values))
+	for _, value := range values {
+		name, err := g.filename(value)
+		if err != nil {
+			return err
+		}
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("groups %q and %q have the same file name %s", other, value, name)
+		}
+		names[name] = value
+	}
+
+	if err := os.MkdirAll(g.dir, 0755); err != nil {
+		return err
+	}
+	for name, value := range names {
+		if err := writeCSV(filepath.Join(g.dir, name), columns, groups[value]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the name of the file of the group with the value.
+func (g *groupWriter) filename(value string) (string, error) {
+	var buf bytes.Buffer
+	if err := g.template.Execute(&buf, groupData{Key: sanitizeFilename(value), Value: value}); err != nil {
+		return "", fmt.Errorf("invalid -output-filename-template: %v", err)
+	}
+
+	name := buf.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid file name for group %q: %q", value, name)
+	}
+	return name, nil
+}
+
+// Return the value with each character which isn't a letter, digit, dash,
+// underscore, or dot replaced by an underscore, and without leading dots (so
+// e.g. the extension .go is go, and a/b is a_b). Empty values are _.
+func sanitizeFilename(value string) string {
+	sanitized := strings.TrimLeft(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, value), ".")
+
+	if sanitized == "" {
+		return "_"
+	}
+	return sanitized
+}
+
+// Write the results to a new CSV file at path, with a header row of the
+// column names and a row per result.
+func writeCSV(path string, columns []query.Column, results []result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		row[i] = c.Name()
+	}
+	w.Write(row)
+
+	for _, r := range results {
+		for i, c := range columns {
+			row[i] = formatValue(r.column(i, c))
+		}
+		w.Write(row)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
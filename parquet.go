@@ -0,0 +1,135 @@
+//go:build parquet
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// How many goroutines write each Parquet file.
+const parquetParallelism = 4
+
+func init() {
+	destinationWriters["parquet"] = writeParquet
+}
+
+// Return the name of the Go field which holds the column's values (which must
+// be exported), e.g. Row_number for row_number.
+func parquetFieldName(c query.Column) string {
+	name := c.Name()
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// Return the Parquet schema tag of the column. Times are stored as INT64
+// microseconds since the Unix epoch (in UTC).
+func parquetTag(c query.Column) string {
+	tag := fmt.Sprintf("name=%s, inname=%s", c.Name(), parquetFieldName(c))
+
+	t := columnType(c)
+	switch {
+	case t.jsonType == "integer":
+		tag += ", type=INT64"
+	case t.jsonType == "number":
+		tag += ", type=DOUBLE"
+	case t.format == "date-time":
+		tag += ", type=INT64, convertedtype=TIMESTAMP_MICROS"
+	default:
+		tag += ", type=BYTE_ARRAY, convertedtype=UTF8"
+	}
+
+	if t.nullable {
+		return tag + ", repetitiontype=OPTIONAL"
+	}
+	return tag + ", repetitiontype=REQUIRED"
+}
+
+// Return the Parquet schema of the columns, in the JSON form read by
+// writer.NewJSONWriter.
+func parquetSchema(columns []query.Column) (string, error) {
+	type field struct {
+		Tag string
+	}
+	schema := struct {
+		Tag    string
+		Fields []field
+	}{Tag: "name=parquet_go_root, repetitiontype=REQUIRED"}
+
+	for _, c := range columns {
+		schema.Fields = append(schema.Fields, field{Tag: parquetTag(c)})
+	}
+
+	b, err := json.Marshal(schema)
+	return string(b), err
+}
+
+// Return the Parquet value of the i-th column of the query for the result.
+func parquetValue(r result, i int, c query.Column) interface{} {
+	switch v := r.column(i, c).(type) {
+	case os.FileMode:
+		return v.String()
+	case time.Time:
+		return v.UnixNano() / int64(time.Microsecond)
+	case string:
+		if v == "" && columnType(c).nullable {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Write the results to a new snappy-compressed Parquet file at dest.Path, with
+// a column for each of the query's columns.
+func writeParquet(dest *query.Destination, columns []query.Column, results []result) error {
+	if dest.Replace {
+		return fmt.Errorf("INTO OR REPLACE isn't supported for parquet")
+	}
+
+	schema, err := parquetSchema(columns)
+	if err != nil {
+		return err
+	}
+
+	fw, err := local.NewLocalFileWriter(dest.Path)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	pw, err := writer.NewJSONWriter(schema, fw, parquetParallelism)
+	if err != nil {
+		return err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+
+	for _, r := range results {
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			row[c.Name()] = parquetValue(r, i, c)
+		}
+
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err := pw.Write(string(b)); err != nil {
+			return err
+		}
+	}
+
+	if err := pw.WriteStop(); err != nil {
+		return err
+	}
+	return fw.Close()
+}
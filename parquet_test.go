@@ -0,0 +1,61 @@
+//go:build parquet
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestParquet(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "aaa", "b": "b", "sub/c.md": "cc"})
+	path := filepath.Join(t.TempDir(), "files.parquet")
+	input := "SELECT name, size, ext FROM " + dir + " WHERE file IS reg ORDER BY size"
+
+	if _, err := runLines(input+" INTO '"+path+"' FORMAT PARQUET", &options{}); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	if n := pr.GetNumRows(); n != 3 {
+		t.Fatalf("\nExpected 3 rows\n     Got %d", n)
+	}
+
+	rows, err := pr.ReadByNumber(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []map[string]interface{}
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]interface{}{
+		{"Name": filepath.Join(dir, "b"), "Size": 1.0, "Ext": nil},
+		{"Name": filepath.Join(dir, "sub", "c.md"), "Size": 2.0, "Ext": ".md"},
+		{"Name": filepath.Join(dir, "a.go"), "Size": 3.0, "Ext": ".go"},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+)
+
+// Return the directory which plugins are loaded from, ~/.fsql/plugins, or the
+// empty string if there's no home directory.
+func pluginDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".fsql", "plugins")
+}
+
+// Load each Go plugin (*.so) in dir, in order of their names. Plugins register
+// their filesystems and attributes with the fsqlplugin package when they're
+// loaded.
+func loadPlugins(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("loading plugin %s: %v", path, err)
+		}
+	}
+	return nil
+}
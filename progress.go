@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the progress indicator is redrawn.
+const progressInterval = 100 * time.Millisecond
+
+var spinner = []rune{'|', '/', '-', '\\'}
+
+// A progress indicator which shows a spinner and the number of files visited
+// so far. The count is incremented by the walker and periodically written by
+// a separate goroutine. A nil *progress is valid and does nothing.
+type progress struct {
+	w     io.Writer
+	mu    sync.Mutex
+	count int
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Start a progress indicator which is redrawn on w every interval.
+func startProgress(w io.Writer, interval time.Duration) *progress {
+	p := &progress{w: w, done: make(chan struct{})}
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for i := 0; ; i++ {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(p.w, "\r%c %d files", spinner[i%len(spinner)], p.current())
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// Record that another file was visited.
+func (p *progress) increment() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	p.count++
+	p.mu.Unlock()
+}
+
+func (p *progress) current() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
+
+// Stop redrawing the indicator and write the final count.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+
+	close(p.done)
+	p.wg.Wait()
+	fmt.Fprintf(p.w, "\r  %d files\n", p.current())
+}
+
+// Return true iff f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The walkPredicates of a query are the conditions on where a file is (its
+// depth and directory) which every file that satisfies the query's condition
+// must satisfy, so they're pushed down into the walk of each source: the
+// directories below which no file could satisfy them aren't walked. The
+// condition is still evaluated for each file which is walked.
+type walkPredicates struct {
+	// The deepest level a file may be at, or -1 if it may be at any level.
+	maxDepth int
+
+	// The prefixes which a file's directory must begin with (with dir
+	// BEGINSWITH), and the directories it must be in (with dir =).
+	dirPrefixes []string
+	dirs        []string
+}
+
+// Return the predicates of the condition tree which may be pushed down into
+// the walk (see walkPredicates), or nil if there are none. Only conditions
+// which are combined with AND, and which aren't negated, are pushed down.
+func pushDownPredicates(root *query.ConditionNode) *walkPredicates {
+	p := &walkPredicates{maxDepth: -1}
+	p.add(root)
+	if p.maxDepth < 0 && len(p.dirPrefixes) == 0 && len(p.dirs) == 0 {
+		return nil
+	}
+	return p
+}
+
+// Add the conditions of the tree which may be pushed down to p.
+func (p *walkPredicates) add(root *query.ConditionNode) {
+	if root == nil {
+		return
+	}
+	if root.Condition == nil {
+		if root.Type == query.And {
+			p.add(root.Left)
+			p.add(root.Right)
+		}
+		return
+	}
+
+	c := root.Condition
+	if c.Negate || c.Argument != "" || c.Subquery != nil {
+		return
+	}
+	switch c.Attribute {
+	case "depth":
+		n, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return
+		}
+		switch c.Comparator {
+		case query.LessThan:
+			n--
+		case query.LessThanEquals, query.Equals:
+		default:
+			return
+		}
+		if n < 0 {
+			n = 0
+		}
+		if p.maxDepth < 0 || n < p.maxDepth {
+			p.maxDepth = n
+		}
+
+	case "dir":
+		switch c.Comparator {
+		case query.BeginsWith:
+			p.dirPrefixes = append(p.dirPrefixes, c.Value)
+		case query.Equals:
+			p.dirs = append(p.dirs, c.Value)
+		}
+	}
+}
+
+// Return true iff no file below the directory at path (which is depth levels
+// below its source) can satisfy the predicates, so that it needn't be walked.
+// Each file below it is in path or one of its subdirectories, so its
+// directory begins with path.
+func (p *walkPredicates) skips(path string, depth int) bool {
+	if p == nil {
+		return false
+	}
+	if p.maxDepth >= 0 && depth >= p.maxDepth {
+		return true
+	}
+	for _, prefix := range p.dirPrefixes {
+		if !strings.HasPrefix(path, prefix) && !strings.HasPrefix(prefix, path) {
+			return true
+		}
+	}
+	for _, dir := range p.dirs {
+		if !strings.HasPrefix(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Return a description of the predicates, for EXPLAIN.
+func (p *walkPredicates) String() string {
+	conditions := make([]string, 0)
+	if p.maxDepth >= 0 {
+		conditions = append(conditions, fmt.Sprintf("depth <= %d", p.maxDepth))
+	}
+	for _, prefix := range p.dirPrefixes {
+		conditions = append(conditions, fmt.Sprintf("dir BEGINSWITH %q", prefix))
+	}
+	for _, dir := range p.dirs {
+		conditions = append(conditions, fmt.Sprintf("dir = %q", dir))
+	}
+	return "pruning " + strings.Join(conditions, " AND ")
+}
+
+// Return how many levels the file at path is below the source src. The members
+// of an archive are below the archive, as if it were a directory.
+func depthBelow(src, path string) int {
+	rel, err := filepath.Rel(src, strings.Replace(path, archiveSeparator, string(filepath.Separator), -1))
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Evaluate the subquery of each comparison with ALL or ANY in the condition
+// tree, and return the values of their columns (other than NULL values), by
+// subquery. Each subquery is evaluated once, before the files it's compared
+// with are walked, since it doesn't depend on them.
+func evaluateQuantified(ctx context.Context, root *query.ConditionNode, tables map[string][]result,
+	qopts query.QueryOptions, prog *progress) map[*query.Query][]string {
+	values := make(map[*query.Query][]string)
+	var visit func(*query.ConditionNode)
+	visit = func(node *query.ConditionNode) {
+		if node == nil {
+			return
+		}
+		if node.Condition == nil {
+			visit(node.Left)
+			visit(node.Right)
+			return
+		}
+
+		sub := node.Condition.Subquery
+		if sub == nil {
+			return
+		}
+		values[sub] = make([]string, 0)
+		for _, r := range evaluate(ctx, sub, tables, qopts, prog, nil) {
+			if v := r.column(0, sub.Columns[0]); v != nil {
+				values[sub] = append(values[sub], quantifiedValue(sub.Columns[0], v))
+			}
+		}
+	}
+	visit(root)
+	return values
+}
+
+// Return the value of the subquery's column as the value of a condition: times
+// are in the layout of the condition's value, and names are only the file's
+// name (rather than its path), like the name the condition compares.
+func quantifiedValue(c query.Column, v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(query.TimeLayout)
+	}
+	if c.Attribute == "name" && c.Table == "" {
+		return filepath.Base(formatValue(v))
+	}
+	return formatValue(v)
+}
+
+// Return true iff the file satisfies the comparison of its attribute with ALL
+// (or ANY) of the values, each of which is compared by compareFn: with ALL,
+// none of them may fail it, so it's satisfied when there are none, while with
+// ANY, one must satisfy it, so it isn't.
+func compareQuantified(c query.Condition, values []string, path string, file os.FileInfo,
+	compareFn func(query.Condition, string, os.FileInfo) bool) bool {
+	each := query.Condition{Attribute: c.Attribute, Comparator: c.Comparator}
+	retval := c.Quantifier == query.All
+	for _, v := range values {
+		each.Value = v
+		if compareFn(each, path, file) != retval {
+			retval = !retval
+			break
+		}
+	}
+
+	if c.Negate {
+		return !retval
+	}
+	return retval
+}
@@ -6,7 +6,10 @@ import (
 	"io"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // RunParser runs the parser on the input string and returns the parsed AST.
@@ -14,6 +17,19 @@ func RunParser(input string) (*Query, error) {
 	return (&parser{}).parse(input)
 }
 
+// RunFileParser runs the parser on the contents of a query file, in which
+// queries are separated by semicolons, and returns the parsed AST of each
+// query.
+func RunFileParser(input string) (*QueryFile, error) {
+	return (&parser{}).parseFile(input)
+}
+
+// The attributes which are selected by `*` or `all` (or when no attributes are
+// provided), and the order they're shown in.
+var allAttributeOrder = []string{"mode", "size", "time", "name"}
+
+// The attributes which are selected by `*` or `all` (or when no attributes are
+// provided).
 var allAttributes = map[string]bool{
 	"mode": true,
 	"name": true,
@@ -21,255 +37,3465 @@ var allAttributes = map[string]bool{
 	"time": true,
 }
 
-type parser struct {
-	tokenizer *Tokenizer
-	current   *Token
-	expected  TokenType
+// Each of the attributes which may be selected, including those which must be
+// selected explicitly.
+var attributes = map[string]bool{
+	"dir":   true,
+	"depth": true,
+	"ext":   true,
+	"mode":  true,
+	"name":  true,
+	"size":  true,
+	"time":  true,
+
+	"tar_offset": true,
+	"commit":     true,
+}
+
+// The attributes whose values are numbers, which numeric aggregate functions
+// (e.g. MEDIAN) may be computed over.
+var numericAttributes = map[string]bool{
+	"depth":      true,
+	"size":       true,
+	"tar_offset": true,
+}
+
+// The attributes whose values are integers (including the bits of mode), which
+// bitwise aggregate functions (e.g. BIT_OR) may be computed over.
+var integerAttributes = map[string]bool{
+	"depth":      true,
+	"mode":       true,
+	"size":       true,
+	"tar_offset": true,
+}
+
+// IsAttribute returns true iff name is a valid attribute for the SELECT and
+// ORDER BY clauses.
+func IsAttribute(name string) bool {
+	_, ok := attributes[name]
+	return ok
+}
+
+// SplitQualified splits an attribute which is qualified with the alias of a
+// JOIN source (e.g. a.name, or a.file in a condition) into the alias and the
+// attribute. The alias is empty if name isn't a qualified attribute.
+func SplitQualified(name string) (alias, attribute string) {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || !IsAttribute(name[i+1:]) && name[i+1:] != "file" {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// RegisterAttribute adds name to the valid attributes (e.g. for an attribute
+// which is registered by a plugin). It isn't safe to call concurrently with
+// parsing.
+func RegisterAttribute(name string) {
+	attributes[name] = true
+}
+
+// The TokenTypes of the supported window functions.
+var windowFunctions = map[TokenType]bool{
+	RowNumber:   true,
+	Rank:        true,
+	DenseRank:   true,
+	PercentRank: true,
+	CumeDist:    true,
+	Lag:         true,
+	Lead:        true,
+	FirstValue:  true,
+	LastValue:   true,
+	Ntile:       true,
+}
+
+// The TokenTypes of the supported aggregate functions.
+var aggregateFunctions = map[TokenType]bool{
+	Count:       true,
+	Median:      true,
+	Stddev:      true,
+	StddevSamp:  true,
+	Variance:    true,
+	StringAgg:   true,
+	ArrayAgg:    true,
+	BitAnd:      true,
+	BitOr:       true,
+	BitXor:      true,
+	Checksum:    true,
+	ChecksumAgg: true,
+	Max:         true,
+	Min:         true,
+}
+
+// The TokenTypes of the aggregate functions which require a numeric attribute.
+var numericAggregateFunctions = map[TokenType]bool{
+	Median:     true,
+	Stddev:     true,
+	StddevSamp: true,
+	Variance:   true,
+	Max:        true,
+	Min:        true,
+}
+
+// The TokenTypes of the aggregate functions which require an integer
+// attribute.
+var bitwiseAggregateFunctions = map[TokenType]bool{
+	BitAnd: true,
+	BitOr:  true,
+	BitXor: true,
+}
+
+type parser struct {
+	tokenizer *Tokenizer
+	current   *Token
+	expected  TokenType
+
+	// Tokens which have been read from the tokenizer by peekToken, but which
+	// haven't been consumed yet.
+	lookahead []*Token
+}
+
+// Return true when no attributes are provided (regardless of if the SELECT
+// keyword is provided). Returns false otherwise.
+func (p *parser) showAllAttributes(q *Query) (bool, error) {
+	if p.expect(Select) == nil {
+		if p.current == nil {
+			return false, nil
+		}
+
+		if p.current.Type == From || p.current.Type == Where {
+			return true, nil
+		}
+
+		if p.current.Type == Identifier || p.current.Type == Coalesce || conditionals[p.current.Type] ||
+			p.current.Type == Star || p.startsExpression() ||
+			windowFunctions[p.current.Type] || aggregateFunctions[p.current.Type] {
+			return false, nil
+		}
+
+		return false, p.currentError()
+	}
+
+	if p.expect(Top) != nil {
+		n, err := p.parseLimit("TOP")
+		if err != nil {
+			return false, err
+		}
+		q.Limit = n
+	}
+
+	if err := p.parseDistinct(q); err != nil {
+		return false, err
+	}
+
+	// An expression may begin with ~, which is also an identifier.
+	if p.startsExpression() {
+		return false, nil
+	}
+	current := p.expectColumnName()
+	if current == nil {
+		current = p.expect(Coalesce)
+	}
+	if current == nil {
+		current = p.expectIn(conditionals)
+	}
+	if current != nil {
+		p.current = current
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Parse each of the clauses in the input string.
+func (p *parser) parse(input string) (*Query, error) {
+	p.tokenizer = NewTokenizer(input)
+	return p.parseStatement()
+}
+
+// Parse each of the queries in the input string. Empty queries (e.g. a
+// trailing semicolon) are skipped.
+func (p *parser) parseFile(input string) (*QueryFile, error) {
+	p.tokenizer = NewTokenizer(input)
+	file := &QueryFile{Queries: make([]*Query, 0)}
+
+	for {
+		for p.expect(Semicolon) != nil {
+		}
+		if p.current == nil {
+			return file, nil
+		}
+
+		q, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		if q.Label != "" {
+			for _, other := range file.Queries {
+				if other.Label == q.Label {
+					return nil, fmt.Errorf("label %s is defined more than once", q.Label)
+				}
+			}
+		}
+		file.Queries = append(file.Queries, q)
+
+		if p.expect(Semicolon) == nil && p.current != nil {
+			return nil, p.currentError()
+		}
+	}
+}
+
+// Parse a single query, optionally preceded by PRAGMA statements and a WITH
+// clause.
+func (p *parser) parseStatement() (*Query, error) {
+	pragmas := make([]PragmaStatement, 0)
+	for p.expect(Pragma) != nil {
+		pragma, err := p.parsePragma()
+		if err != nil {
+			return nil, err
+		}
+		pragmas = append(pragmas, *pragma)
+		p.expect(Semicolon)
+	}
+
+	if p.expect(Raise) != nil {
+		if len(pragmas) > 0 {
+			return nil, errors.New("PRAGMA cannot be used with RAISE")
+		}
+		return p.parseRaise()
+	}
+
+	if tok := p.expectIn(jumpStatements); tok != nil {
+		if len(pragmas) > 0 {
+			return nil, fmt.Errorf("PRAGMA cannot be used with %s", strings.ToUpper(tok.Raw))
+		}
+		return p.parseJump(tok.Type)
+	}
+
+	// Neither REBUILD nor INDEX is a keyword, so that they may still be used
+	// as source names.
+	if p.expectWord("REBUILD") != nil {
+		q, err := p.parseRebuildIndex()
+		if err != nil {
+			return nil, err
+		}
+		q.Pragmas = pragmas
+		return q, nil
+	}
+
+	// Nor are DELETE, MOVE, or COPY.
+	for _, word := range []string{"DELETE", "MOVE", "COPY"} {
+		if p.expectWord(word) != nil {
+			q, err := p.parseFileOperation(word)
+			if err != nil {
+				return nil, err
+			}
+			q.Pragmas = pragmas
+			return q, nil
+		}
+	}
+
+	if p.expect(Assert) != nil {
+		q, err := p.parseAssert()
+		if err != nil {
+			return nil, err
+		}
+		q.Pragmas = pragmas
+		return q, nil
+	}
+
+	explain, analyze := p.parseExplain()
+
+	if p.expect(With) == nil {
+		q, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		q.Pragmas = pragmas
+		q.Explain, q.Analyze = explain, analyze
+		return q, nil
+	}
+
+	ctes := make([]CTE, 0)
+	if err := p.parseWith(&ctes); err != nil {
+		return nil, err
+	}
+
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	q.With = ctes
+	q.Pragmas = pragmas
+	q.Explain, q.Analyze = explain, analyze
+
+	// Each CTE may only use the CTEs defined before it as a source.
+	for i, cte := range ctes {
+		for _, src := range cte.Query.Sources["include"] {
+			if _, ok := cte.Query.Values[src]; ok {
+				continue
+			}
+			for _, later := range ctes[i:] {
+				if src == later.Name {
+					return nil, &ErrUndefinedCTE{Name: src}
+				}
+			}
+		}
+	}
+
+	if err := checkSourceNames(q, nil); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Return an error if one of the query's sources is a name, rather than a path,
+// which isn't one of the CTEs it can use, so that a misspelled CTE isn't walked
+// as a directory. The query can use the given CTEs and its own; the body of
+// each of its own CTEs can use the given CTEs and the ones defined before it.
+func checkSourceNames(q *Query, ctes []CTE) error {
+	for i, cte := range q.With {
+		if err := checkSourceNames(cte.Query, append(ctes[:len(ctes):len(ctes)], q.With[:i]...)); err != nil {
+			return err
+		}
+	}
+
+	ctes = append(ctes[:len(ctes):len(ctes)], q.With...)
+	for _, src := range q.Sources["include"] {
+		if _, ok := q.Values[src]; ok || !isName(src) {
+			continue
+		}
+		defined := false
+		for _, cte := range ctes {
+			defined = defined || cte.Name == src
+		}
+		if !defined {
+			return fmt.Errorf("CTE %s isn't defined (use ./%s for a directory named %s)", src, src, src)
+		}
+	}
+
+	return nil
+}
+
+// Return true iff the source is a name (e.g. of a CTE, or a directory in the
+// current directory), rather than a path (or a variable).
+func isName(src string) bool {
+	if src == "." || src == ".." || strings.HasPrefix(src, "~") || strings.HasPrefix(src, "@") {
+		return false
+	}
+	return !strings.ContainsAny(src, `/\`)
+}
+
+// Parse a REBUILD INDEX statement (after REBUILD), which is followed by the
+// directories whose indexes are rebuilt, in a FROM clause. Without one, the
+// current directory's index is rebuilt.
+func (p *parser) parseRebuildIndex() (*Query, error) {
+	if p.expectWord("INDEX") == nil {
+		return nil, errors.New("expected INDEX after REBUILD")
+	}
+
+	q := newStatement()
+	q.RebuildIndex = true
+	if p.expect(From) == nil {
+		if p.current != nil && p.current.Type != Semicolon {
+			return nil, p.currentError()
+		}
+		q.Sources["include"] = append(q.Sources["include"], ".")
+		return q, nil
+	}
+
+	if err := p.parseSources(q); err != nil {
+		return nil, err
+	}
+	if len(q.Sources["exclude"]) > 0 || len(q.Values) > 0 {
+		return nil, errors.New("REBUILD INDEX can only index directories")
+	}
+	return q, expandHome(q)
+}
+
+// Parse a DELETE, MOVE, or COPY statement (after its first word, typ): its
+// FROM clause and optional WHERE clause, then for MOVE and COPY, TO and the
+// directory the files are moved or copied into, and for MOVE, optionally
+// ATOMIC. Neither TO nor ATOMIC is a keyword.
+func (p *parser) parseFileOperation(typ string) (*Query, error) {
+	q := newStatement()
+	q.Operation = &FileOperation{Type: typ}
+	if p.expect(From) == nil {
+		return nil, p.currentError()
+	}
+	if err := p.parseSources(q); err != nil {
+		return nil, err
+	}
+	if len(q.Values) > 0 {
+		return nil, fmt.Errorf("%s can only use directories as sources", typ)
+	}
+
+	if p.expect(Where) != nil {
+		root, err := p.parseConditionTree()
+		if err != nil {
+			return nil, err
+		}
+		q.ConditionTree = root
+	}
+
+	if typ != "DELETE" {
+		if p.expectWord("TO") == nil {
+			return nil, fmt.Errorf("expected TO and a directory to %s the files to", strings.ToLower(typ))
+		}
+		target := p.expectName()
+		if target == nil {
+			return nil, p.currentError()
+		}
+		q.Operation.Target = target.Raw
+		q.Operation.Atomic = typ == "MOVE" && p.expectWord("ATOMIC") != nil
+	}
+	err := p.currentError()
+	if p.expect(Identifier) != nil {
+		return nil, err
+	}
+
+	if err := expandHome(q); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(q.Operation.Target, "~") {
+		usr, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		q.Operation.Target = filepath.Join(usr.HomeDir, q.Operation.Target[1:])
+	}
+	return q, nil
+}
+
+// The TokenTypes of the comparators which an ASSERT statement may use.
+var assertComparators = map[TokenType]bool{
+	Equals:            true,
+	NotEquals:         true,
+	GreaterThan:       true,
+	GreaterThanEquals: true,
+	LessThan:          true,
+	LessThanEquals:    true,
+}
+
+// Parse an ASSERT statement (after ASSERT): its comparison, and optionally
+// MESSAGE and the message reported if the assertion fails.
+func (p *parser) parseAssert() (*Query, error) {
+	assertion, err := p.parseComparison("ASSERT")
+	if err != nil {
+		return nil, err
+	}
+	if p.expect(Message) != nil {
+		message := p.expect(Identifier)
+		if message == nil {
+			return nil, p.currentError()
+		}
+		assertion.Message = message.Raw
+	}
+
+	q := newStatement()
+	q.Assert = assertion
+	return q, nil
+}
+
+// The TokenTypes of the statements which control the flow of a query file.
+var jumpStatements = map[TokenType]bool{
+	Label: true,
+	Goto:  true,
+	If:    true,
+}
+
+// Parse a LABEL, GOTO, or IF ... GOTO statement (after the keyword of type
+// t). The condition of IF is a comparison, like that of ASSERT.
+func (p *parser) parseJump(t TokenType) (*Query, error) {
+	q := newStatement()
+	if t == If {
+		condition, err := p.parseComparison("IF")
+		if err != nil {
+			return nil, err
+		}
+		q.If = condition
+		if p.expect(Goto) == nil {
+			return nil, p.currentError()
+		}
+	}
+
+	label := p.expectName()
+	if label == nil {
+		return nil, p.currentError()
+	}
+	if t == Label {
+		q.Label = label.Raw
+	} else {
+		q.Goto = label.Raw
+	}
+	return q, nil
+}
+
+// The TokenTypes of the levels of a RAISE statement.
+var raiseLevels = map[TokenType]bool{
+	Notice:  true,
+	Warning: true,
+	Error:   true,
+}
+
+// Parse a RAISE statement (after RAISE): its level, followed by its message,
+// whose parts (strings and variables) are concatenated with `||`. There's no
+// `||` operator elsewhere, so it's a word, and must be separated from the
+// parts by spaces.
+func (p *parser) parseRaise() (*Query, error) {
+	level := p.expectIn(raiseLevels)
+	if level == nil {
+		return nil, p.currentError()
+	}
+
+	raise := &RaiseStatement{Level: level.Type, Message: make([]MessagePart, 0)}
+	for {
+		if text := p.expect(Identifier); text != nil {
+			raise.Message = append(raise.Message, MessagePart{Text: text.Raw})
+		} else if variable := p.expect(Variable); variable != nil {
+			raise.Message = append(raise.Message, MessagePart{Variable: variable.Raw[1:]})
+		} else {
+			return nil, p.currentError()
+		}
+
+		if p.expectWord("||") == nil {
+			break
+		}
+	}
+
+	q := newStatement()
+	q.Raise = raise
+	return q, nil
+}
+
+// Parse the comparison of a statement (ASSERT or IF): a parenthesized
+// subquery with a single column, and the comparator and value which the
+// column of its first result is compared to.
+func (p *parser) parseComparison(statement string) (*Assertion, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	sub, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if sub.Into != nil {
+		return nil, fmt.Errorf("INTO cannot be used in the subquery of %s", statement)
+	}
+	if sub.Pivot || len(sub.Columns) != 1 {
+		return nil, fmt.Errorf("the subquery of %s must select a single column", statement)
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	comparator := p.expectIn(assertComparators)
+	if comparator == nil {
+		return nil, p.currentError()
+	}
+	value := p.expectName()
+	if value == nil {
+		return nil, p.currentError()
+	}
+	return &Assertion{Query: sub, Comparator: comparator.Type, Value: value.Raw}, nil
+}
+
+// Return an empty query, for a statement which isn't evaluated like one
+// (e.g. REBUILD INDEX).
+func newStatement() *Query {
+	return &Query{
+		Attributes: make(map[string]bool),
+		Columns:    make([]Column, 0),
+		Sources:    map[string][]string{"include": make([]string, 0), "exclude": make([]string, 0)},
+		Limit:      -1,
+	}
+}
+
+// Replace the tilde with the home directory in each source directory of the
+// query. This is only required when the query is wrapped in quotes, since the
+// shell will automatically expand tildes otherwise.
+func expandHome(q *Query) error {
+	usr, err := user.Current()
+	if err != nil {
+		return err
+	}
+	for _, sourceType := range []string{"include", "exclude"} {
+		for i, src := range q.Sources[sourceType] {
+			if strings.Contains(src, "~") {
+				q.Sources[sourceType][i] = filepath.Join(usr.HomeDir, src[1:])
+			}
+		}
+	}
+	return nil
+}
+
+// Parse the optional EXPLAIN keyword, optionally followed by ANALYZE. ANALYZE
+// isn't a keyword, so that it may still be used as a source name.
+func (p *parser) parseExplain() (explain, analyze bool) {
+	if p.expect(Explain) == nil {
+		return false, false
+	}
+
+	if next := p.peekToken(0); next != nil && next.Type == Identifier &&
+		strings.ToUpper(next.Raw) == "ANALYZE" {
+		p.expect(Identifier)
+		return true, true
+	}
+
+	return true, false
+}
+
+// Parse the name and value of a PRAGMA statement.
+func (p *parser) parsePragma() (*PragmaStatement, error) {
+	name := p.expect(Identifier)
+	if name == nil {
+		return nil, p.currentError()
+	}
+
+	if p.expect(Equals) == nil {
+		return nil, p.currentError()
+	}
+
+	value := p.expect(Identifier)
+	if value == nil {
+		return nil, p.currentError()
+	}
+
+	return &PragmaStatement{Name: strings.ToLower(name.Raw), Value: value.Raw}, nil
+}
+
+// Parse the list of named subqueries passed to the WITH clause.
+func (p *parser) parseWith(ctes *[]CTE) error {
+	name := p.expect(Identifier)
+	if name == nil {
+		return p.currentError()
+	}
+
+	if p.expect(As) == nil || p.expect(OpenParen) == nil {
+		return p.currentError()
+	}
+
+	q, err := p.parseQuery()
+	if err != nil {
+		return err
+	}
+	if q.Pivot {
+		return fmt.Errorf("PIVOT cannot be used in CTE %s", name.Raw)
+	}
+
+	if p.expect(CloseParen) == nil {
+		return p.currentError()
+	}
+
+	for _, cte := range *ctes {
+		if cte.Name == name.Raw {
+			return fmt.Errorf("CTE %s is defined more than once", name.Raw)
+		}
+	}
+	*ctes = append(*ctes, CTE{Name: name.Raw, Query: q})
+
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseWith(ctes)
+}
+
+// Parse each of the clauses of a single query, stopping at the end of the
+// input, at the semicolon which ends the query, or at the parenthesis which
+// closes the subquery.
+func (p *parser) parseQuery() (*Query, error) {
+	q := &Query{Limit: -1}
+
+	all, err := p.showAllAttributes(q)
+	if err != nil {
+		return nil, err
+	}
+	if all {
+		q.Attributes = allAttributes
+		q.Columns = make([]Column, 0, len(allAttributeOrder))
+		for _, attribute := range allAttributeOrder {
+			q.Columns = append(q.Columns, Column{Attribute: attribute})
+		}
+	} else {
+		q.Attributes = make(map[string]bool)
+		q.Columns = make([]Column, 0)
+		err := p.parseColumns(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// INTO @variable may be written after the columns (e.g. SELECT dir INTO
+	// @root FROM ...), rather than at the end of the query.
+	if p.expect(Into) != nil {
+		into, err := p.parseInto(q)
+		if err != nil {
+			return nil, err
+		}
+		if into.Variable == "" {
+			return nil, errors.New("only INTO @variable may be used before FROM")
+		}
+		q.Into = into
+	}
+
+	q.Sources = map[string][]string{
+		"include": make([]string, 0),
+		"exclude": make([]string, 0),
+	}
+	if p.expect(From) == nil {
+		err := p.currentError()
+		if p.expect(Identifier) != nil {
+			return nil, err
+		}
+		q.Sources["include"] = append(q.Sources["include"], ".")
+	} else {
+		err := p.parseSources(q)
+		if err != nil {
+			return nil, err
+		}
+		if q.Join, err = p.parseJoin(q); err != nil {
+			return nil, err
+		}
+		if err := expandHome(q); err != nil {
+			return nil, err
+		}
+	}
+
+	// None of INCLUDE, NESTED, or ARCHIVES are keywords, so that they may
+	// still be used as source names.
+	if p.expectWord("INCLUDE") != nil {
+		if p.expectWord("NESTED") == nil || p.expectWord("ARCHIVES") == nil {
+			return nil, errors.New("expected NESTED ARCHIVES after INCLUDE")
+		}
+		q.NestedArchives = true
+	}
+
+	if p.expect(Tablesample) != nil {
+		sample, err := p.parseTableSample()
+		if err != nil {
+			return nil, err
+		}
+		q.Sample = sample
+	}
+
+	if p.expect(Unpivot) != nil {
+		unpivot, err := p.parseUnpivot()
+		if err != nil {
+			return nil, err
+		}
+		q.Unpivot = unpivot
+		for _, attribute := range unpivot.Attributes {
+			q.Attributes[attribute] = true
+		}
+	}
+	if err := resolveUnpivoted(q); err != nil {
+		return nil, err
+	}
+
+	if p.expect(Where) != nil {
+		root, err := p.parseConditionTree()
+		if err != nil {
+			return nil, err
+		}
+		q.ConditionTree = root
+	}
+
+	if p.expect(Group) != nil {
+		if p.expect(By) == nil {
+			return nil, p.currentError()
+		}
+		q.GroupBy = make([]string, 0)
+		if err := p.parseAttributeList(&q.GroupBy); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.expect(Having) != nil {
+		root, err := p.parseConditionTree()
+		if err != nil {
+			return nil, err
+		}
+		q.Having = root
+	}
+
+	q.Pivot = p.expect(Pivot) != nil
+
+	if p.expect(Window) != nil {
+		q.Windows = make(map[string]WindowSpec)
+		if err := p.parseWindows(q.Windows); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replace each reference to a named window with its specification.
+	for _, column := range q.Columns {
+		if column.Window == nil || column.Window.WindowName == "" {
+			continue
+		}
+		spec, ok := q.Windows[column.Window.WindowName]
+		if !ok {
+			return nil, fmt.Errorf("window %s is not defined", column.Window.WindowName)
+		}
+		column.Window.WindowSpec = spec
+	}
+
+	if p.expect(Qualify) != nil {
+		root, err := p.parseConditionTree()
+		if err != nil {
+			return nil, err
+		}
+		q.Qualify = root
+	}
+
+	hasOrder := p.expect(Order) != nil
+	if hasOrder {
+		if p.expect(By) == nil {
+			return nil, p.currentError()
+		}
+		if err := p.parseOrderBy(&q.OrderBy); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rewriteExists(q); err != nil {
+		return nil, err
+	}
+	if err := rewriteQuantified(q); err != nil {
+		return nil, err
+	}
+	if err := checkJoin(q); err != nil {
+		return nil, err
+	}
+	orderAggregates(q)
+	if err := checkGroupBy(q); err != nil {
+		return nil, err
+	}
+	if err := checkColumnConditions(q); err != nil {
+		return nil, err
+	}
+	if err := checkCase(q); err != nil {
+		return nil, err
+	}
+
+	hasLimit := p.expect(Limit) != nil
+	if hasLimit {
+		n, err := p.parseLimit("limit")
+		if err != nil {
+			return nil, err
+		}
+		if q.Limit >= 0 && q.Limit != n {
+			q.Warnings = append(q.Warnings, fmt.Sprintf("TOP %d is overridden by LIMIT %d", q.Limit, n))
+		}
+		q.Limit = n
+	}
+
+	hasOffset := p.expect(Offset) != nil
+	if hasOffset {
+		n, err := p.parseLimit("offset")
+		if err != nil {
+			return nil, err
+		}
+		q.Offset = n
+		if p.expect(Rows) == nil {
+			p.expectWord("ROW")
+		}
+	}
+
+	hasFetch := p.expect(Fetch) != nil
+	if hasFetch {
+		if hasLimit {
+			return nil, errors.New("LIMIT and FETCH cannot be used together")
+		}
+		n, err := p.parseFetch()
+		if err != nil {
+			return nil, err
+		}
+		if q.Limit >= 0 && q.Limit != n {
+			q.Warnings = append(q.Warnings, fmt.Sprintf("TOP %d is overridden by FETCH %d", q.Limit, n))
+		}
+		if !hasOrder {
+			q.Warnings = append(q.Warnings, "FETCH without ORDER BY returns the first results in the order they're walked in")
+		}
+		q.Limit = n
+	}
+
+	// FORMAT isn't a keyword, so that it may still be the name of a source
+	// or an attribute.
+	hasFormat := p.expectWord("FORMAT") != nil
+	if hasFormat {
+		if err := p.parseFormat(q); err != nil {
+			return nil, err
+		}
+	}
+	// Nor is COMPACT, which is a shorthand for FORMAT COMPACT.
+	if p.expectWord("COMPACT") != nil {
+		if hasFormat {
+			return nil, errors.New("COMPACT cannot be used with FORMAT")
+		}
+		q.Format = "compact"
+		hasFormat = true
+	}
+
+	if p.expect(Into) != nil {
+		if q.Into != nil {
+			return nil, errors.New("INTO cannot be used more than once")
+		}
+		into, err := p.parseInto(q)
+		if err != nil {
+			return nil, err
+		}
+		q.Into = into
+		return q, nil
+	}
+	if q.Into != nil && hasFormat {
+		return nil, errors.New("FORMAT and COMPACT cannot be used with INTO")
+	}
+
+	if !hasOrder && !hasLimit && !hasOffset && !hasFetch && !hasFormat {
+		err := p.currentError()
+		if p.expect(Identifier) != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
+
+// An aggregate query without GROUP BY has a single result, so its ORDER BY
+// can't sort its results. Instead, it orders the values of each STRING_AGG,
+// ARRAY_AGG, and CHECKSUM_AGG without an ORDER BY of its own, e.g. SELECT
+// CHECKSUM_AGG(name) FROM . ORDER BY name is the same as SELECT
+// CHECKSUM_AGG(name ORDER BY name) FROM . (if there's no such function, the
+// ORDER BY is left for checkGroupBy to reject).
+func orderAggregates(q *Query) {
+	if len(q.GroupBy) > 0 || len(q.OrderBy) == 0 {
+		return
+	}
+
+	ordered := false
+	for _, c := range q.Columns {
+		fn := c.Aggregate
+		if fn == nil || fn.OrderBy != nil || fn.Type != StringAgg && fn.Type != ArrayAgg && fn.Type != ChecksumAgg {
+			continue
+		}
+		fn.OrderBy = append([]Ordering(nil), q.OrderBy...)
+		ordered = true
+	}
+	if ordered {
+		q.OrderBy = nil
+	}
+}
+
+// Return an error if the query is grouped (by GROUP BY or an aggregate
+// function), but any of its columns or sort keys isn't one of the GROUP BY
+// attributes or an aggregate function, since their values would differ
+// between the files of a group. PIVOT requires a single GROUP BY attribute,
+// along with a single aggregate function.
+func checkGroupBy(q *Query) error {
+	aggregates := 0
+	for _, c := range q.Columns {
+		if c.Aggregate != nil {
+			aggregates++
+		}
+	}
+
+	if q.Pivot {
+		if len(q.GroupBy) != 1 || aggregates != 1 || len(q.Columns) != 2 {
+			return errors.New("PIVOT requires a GROUP BY attribute, and selecting it along with an aggregate function")
+		}
+	}
+	if len(q.GroupBy) == 0 && aggregates == 0 {
+		if q.Having != nil {
+			return errors.New("HAVING requires GROUP BY or an aggregate function")
+		}
+		return nil
+	}
+	if q.Unpivot != nil {
+		return errors.New("UNPIVOT cannot be used with GROUP BY or aggregate functions")
+	}
+
+	grouped := make(map[string]bool, len(q.GroupBy))
+	for _, attribute := range q.GroupBy {
+		grouped[attribute] = true
+	}
+	for _, c := range q.Columns {
+		if c.Window != nil {
+			return errors.New("window functions cannot be used with GROUP BY or aggregate functions")
+		}
+		if c.Case != nil {
+			return errors.New("CASE cannot be used with GROUP BY or aggregate functions")
+		}
+		if c.Expression != nil {
+			return errors.New("arithmetic expressions cannot be used with GROUP BY or aggregate functions")
+		}
+		if c.Attribute != "" && !grouped[c.Attribute] {
+			return fmt.Errorf("%s must be in GROUP BY or an aggregate function", c.Attribute)
+		}
+	}
+	for _, ordering := range q.OrderBy {
+		if !grouped[ordering.Attribute] {
+			return fmt.Errorf("%s must be in GROUP BY to be sorted by", ordering.Attribute)
+		}
+	}
+	for _, root := range []*ConditionNode{q.Having, q.Qualify} {
+		for _, attribute := range conditionAttributes(root) {
+			if !grouped[attribute] && !q.hasColumn(attribute) {
+				return fmt.Errorf("%s must be in GROUP BY or a column to be filtered by", attribute)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse the rest of the UNPIVOT clause (after UNPIVOT), the parenthesized name
+// of the value column, FOR, the name of the name column, IN, and the
+// parenthesized list of attributes. Neither FOR nor IN is a keyword.
+func (p *parser) parseUnpivot() (*UnpivotClause, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	value := p.expect(Identifier)
+	if value == nil {
+		return nil, p.currentError()
+	}
+	if p.expectWord("FOR") == nil {
+		return nil, errors.New("expected FOR after the UNPIVOT value column")
+	}
+	name := p.expect(Identifier)
+	if name == nil {
+		return nil, p.currentError()
+	}
+	if p.expectWord("IN") == nil {
+		return nil, errors.New("expected IN after the UNPIVOT name column")
+	}
+
+	for _, column := range []string{value.Raw, name.Raw} {
+		if IsAttribute(column) {
+			return nil, fmt.Errorf("UNPIVOT column %s cannot be named after an attribute", column)
+		}
+	}
+	if value.Raw == name.Raw {
+		return nil, fmt.Errorf("UNPIVOT columns must have different names, got %s twice", name.Raw)
+	}
+
+	unpivot := &UnpivotClause{ValueColumn: value.Raw, NameColumn: name.Raw}
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	if err := p.parseAttributeList(&unpivot.Attributes); err != nil {
+		return nil, err
+	}
+	if p.expect(CloseParen) == nil || p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+	return unpivot, nil
+}
+
+// Return an error unless each column which isn't an attribute or a function is
+// one of the columns of the query's UNPIVOT clause, which each of them is
+// then set to show.
+func resolveUnpivoted(q *Query) error {
+	for i, c := range q.Columns {
+		if c.Unpivoted == "" {
+			continue
+		}
+		if q.Unpivot == nil || (c.Unpivoted != q.Unpivot.ValueColumn && c.Unpivoted != q.Unpivot.NameColumn) {
+			return &ErrUnknownToken{Raw: c.Unpivoted}
+		}
+		q.Columns[i].Unpivot = q.Unpivot
+	}
+	return nil
+}
+
+// The alias of the subquery of an EXISTS condition, once it's rewritten to a
+// SEMI or ANTI JOIN. Since EXISTS is a keyword, it can't be another alias.
+const existsAlias = "exists"
+
+// Rewrite the EXISTS condition of the WHERE clause, which must be combined with
+// its other conditions by AND, to a SEMI JOIN of the query's source with the
+// subquery (or an ANTI JOIN for NOT EXISTS). The subquery's conditions may
+// compare with the attributes of the query's file, qualified with its source
+// (e.g. name IS /home.name), which become the ON condition of the JOIN. The
+// subquery is then only evaluated once, unless those conditions can't be
+// separated from its others, or its number of results depends on them (e.g.
+// with COUNT or LIMIT), in which case it's evaluated as a LATERAL subquery.
+func rewriteExists(q *Query) error {
+	for _, root := range []*ConditionNode{q.Having, q.Qualify} {
+		if len(existsConditions(root)) > 0 {
+			return errors.New("EXISTS can only be used in the WHERE clause")
+		}
+	}
+	if q.Join != nil && len(existsConditions(q.Join.On)) > 0 {
+		return errors.New("EXISTS can only be used in the WHERE clause")
+	}
+
+	var exists *Condition
+	conditions := make([]*ConditionNode, 0)
+	for _, node := range conjuncts(q.ConditionTree) {
+		if node.Condition != nil && node.Condition.Exists != nil {
+			if exists != nil {
+				return errors.New("only one EXISTS condition may be used in the WHERE clause")
+			}
+			exists = node.Condition
+			continue
+		}
+		if len(existsConditions(node)) > 0 {
+			return errors.New("EXISTS can only be combined with the other conditions of the WHERE clause by AND")
+		}
+		conditions = append(conditions, node)
+	}
+	if exists == nil {
+		return nil
+	}
+
+	if q.Join != nil {
+		return errors.New("EXISTS cannot be used with JOIN")
+	}
+	if len(q.Sources["include"]) != 1 {
+		return errors.New("EXISTS requires a single source")
+	}
+
+	join := &JoinClause{Type: "semi", Aliases: [2]string{q.Sources["include"][0], existsAlias}}
+	if exists.Negate {
+		join.Type = "anti"
+	}
+
+	// The conditions which compare with the query's file are separated from
+	// the subquery's others (the rest of its conditions are kept in place).
+	sub := exists.Exists
+	correlated, uncorrelated := make([]*ConditionNode, 0), make([]*ConditionNode, 0)
+	for _, node := range conjuncts(sub.ConditionTree) {
+		if node.Condition != nil && node.Condition.Argument == "" {
+			if alias, _ := SplitQualified(node.Condition.Value); alias == join.Aliases[0] {
+				c := *node.Condition
+				c.Attribute = existsAlias + "." + c.Attribute
+				if c.Comparator == Is {
+					c.Comparator = Equals
+				}
+				correlated = append(correlated, &ConditionNode{Condition: &c})
+				continue
+			}
+		}
+		uncorrelated = append(uncorrelated, node)
+	}
+
+	decorrelated := true
+	for _, node := range uncorrelated {
+		for _, attribute := range conditionValues(node) {
+			if alias, _ := SplitQualified(attribute); alias == join.Aliases[0] {
+				decorrelated = false
+			}
+		}
+	}
+	if len(sub.GroupBy) > 0 || sub.Having != nil || sub.Qualify != nil || sub.Join != nil ||
+		sub.Limit >= 0 || sub.Offset > 0 {
+		decorrelated = false
+	}
+	for _, c := range sub.Columns {
+		if c.Aggregate != nil {
+			decorrelated = false
+		}
+	}
+
+	if decorrelated {
+		evaluated := *sub
+		evaluated.ConditionTree = conjoin(uncorrelated)
+		join.Subquery = &evaluated
+		join.On = conjoin(correlated)
+	} else {
+		join.Lateral = sub
+	}
+
+	q.Join = join
+	q.ConditionTree = conjoin(conditions)
+	return nil
+}
+
+// Return the conditions of the tree which it combines with AND, in order (so
+// the tree is satisfied iff each of them is).
+func conjuncts(root *ConditionNode) []*ConditionNode {
+	if root == nil {
+		return nil
+	}
+	if root.Condition == nil && root.Type == And {
+		return append(conjuncts(root.Left), conjuncts(root.Right)...)
+	}
+	return []*ConditionNode{root}
+}
+
+// Return the tree which combines each of the conditions with AND, or nil if
+// there aren't any.
+func conjoin(conditions []*ConditionNode) *ConditionNode {
+	if len(conditions) == 0 {
+		return nil
+	}
+	root := conditions[0]
+	for _, node := range conditions[1:] {
+		root = &ConditionNode{Type: And, Left: root, Right: node}
+	}
+	return root
+}
+
+// Return each of the EXISTS conditions of the tree.
+func existsConditions(root *ConditionNode) []*Condition {
+	if root == nil {
+		return nil
+	}
+	if root.Condition != nil {
+		if root.Condition.Exists != nil {
+			return []*Condition{root.Condition}
+		}
+		return nil
+	}
+	return append(existsConditions(root.Left), existsConditions(root.Right)...)
+}
+
+// Return the value of each of the conditions of the tree.
+func conditionValues(root *ConditionNode) []string {
+	if root == nil {
+		return nil
+	}
+	if root.Condition != nil {
+		return []string{root.Condition.Value}
+	}
+	return append(conditionValues(root.Left), conditionValues(root.Right)...)
+}
+
+// Return an error unless each attribute of a query with a JOIN (in its columns,
+// conditions, and sort keys) is qualified with the alias of one of its
+// sources, or if the query uses a clause which can't be used with a JOIN. A
+// query without a JOIN can't have qualified attributes, and neither can a
+// SEMI or ANTI JOIN (except in its ON condition), whose attributes are those
+// of its left source.
+func checkJoin(q *Query) error {
+	if q.Join != nil && q.Join.Semi() {
+		unqualify(q, q.Join.Aliases[0])
+	}
+
+	attributes := make([]string, 0)
+	coalesced := false
+	addOrderings := func(orderBy []Ordering) {
+		for _, ordering := range orderBy {
+			if ordering.Coalesce != nil {
+				attributes = append(attributes, ordering.Coalesce...)
+				coalesced = true
+			} else {
+				attributes = append(attributes, ordering.Attribute)
+			}
+		}
+	}
+	for _, c := range q.Columns {
+		if c.Table != "" {
+			attributes = append(attributes, c.Table+"."+c.Attribute)
+		}
+		if c.Coalesce != nil {
+			attributes = append(attributes, c.Coalesce...)
+			coalesced = true
+		}
+		if c.Window != nil {
+			addOrderings(c.Window.OrderBy)
+		}
+		if c.Aggregate != nil {
+			addOrderings(c.Aggregate.OrderBy)
+		}
+	}
+	addOrderings(q.OrderBy)
+
+	if q.Join == nil || q.Join.Semi() {
+		if coalesced {
+			return errors.New("COALESCE can only be used with a JOIN which has the attributes of both sources")
+		}
+		for _, attribute := range append(attributes, conditionAttributes(q.ConditionTree)...) {
+			if alias, _ := SplitQualified(attribute); alias != "" {
+				if q.Join != nil {
+					return fmt.Errorf("%s must be an attribute of the left source of a %s JOIN",
+						attribute, strings.ToUpper(q.Join.Type))
+				}
+				return &ErrUnknownToken{Raw: attribute}
+			}
+		}
+		for _, attribute := range attributes {
+			if !IsAttribute(attribute) {
+				return &ErrUnknownToken{Raw: attribute}
+			}
+		}
+		if q.Join == nil {
+			return nil
+		}
+		return checkJoinAliases(q.Join, conditionAttributes(q.Join.On))
+	}
+
+	switch {
+	case len(q.GroupBy) > 0:
+		return errors.New("JOIN cannot be used with GROUP BY")
+	case q.Unpivot != nil:
+		return errors.New("JOIN cannot be used with UNPIVOT")
+	case q.Qualify != nil:
+		return errors.New("JOIN cannot be used with QUALIFY")
+	case q.DistinctOn != nil:
+		return errors.New("JOIN cannot be used with DISTINCT ON")
+	case q.Sample != nil:
+		return errors.New("JOIN cannot be used with TABLESAMPLE")
+	}
+	for _, c := range q.Columns {
+		if c.Aggregate != nil || c.Window != nil && !c.Window.InOutputOrder {
+			return fmt.Errorf("JOIN cannot be used with %s", c.Name())
+		}
+		if c.Attribute != "" && c.Table == "" {
+			attributes = append(attributes, c.Attribute)
+		}
+	}
+
+	attributes = append(attributes, conditionAttributes(q.ConditionTree)...)
+	attributes = append(attributes, conditionAttributes(q.Join.On)...)
+	return checkJoinAliases(q.Join, attributes)
+}
+
+// Return an error unless each of the attributes is qualified with the alias of
+// one of the JOIN's sources.
+func checkJoinAliases(join *JoinClause, attributes []string) error {
+	for _, attribute := range attributes {
+		alias, name := SplitQualified(attribute)
+		if alias != join.Aliases[0] && alias != join.Aliases[1] {
+			return fmt.Errorf("%s must be qualified with the alias of a JOIN source (%s or %s)",
+				attribute, join.Aliases[0], join.Aliases[1])
+		}
+		// Only the attributes which a LATERAL subquery selects are
+		// attributes of its source.
+		if alias == join.Aliases[1] && join.Lateral != nil && !join.Lateral.Attributes[name] {
+			return fmt.Errorf("%s is not selected by the LATERAL subquery %s", name, alias)
+		}
+	}
+	return nil
+}
+
+// Remove the qualification with alias from each of the query's attributes
+// (other than those of its JOIN's ON condition).
+func unqualify(q *Query, alias string) {
+	orderings := [][]Ordering{q.OrderBy}
+	for i, c := range q.Columns {
+		if c.Table == alias {
+			q.Columns[i].Table = ""
+		}
+		if c.Window != nil {
+			orderings = append(orderings, c.Window.OrderBy)
+		}
+		if c.Aggregate != nil {
+			orderings = append(orderings, c.Aggregate.OrderBy)
+		}
+	}
+	for _, orderBy := range orderings {
+		for i, ordering := range orderBy {
+			if table, attribute := SplitQualified(ordering.Attribute); table == alias {
+				orderBy[i].Attribute = attribute
+			}
+		}
+	}
+	unqualifyTree(q.ConditionTree, alias)
+}
+
+func unqualifyTree(root *ConditionNode, alias string) {
+	if root == nil {
+		return
+	}
+	if root.Condition != nil {
+		if table, attribute := SplitQualified(root.Condition.Attribute); table == alias {
+			root.Condition.Attribute = attribute
+		}
+		return
+	}
+	unqualifyTree(root.Left, alias)
+	unqualifyTree(root.Right, alias)
+}
+
+// Return an error if any of the conditions of the HAVING or QUALIFY clause
+// compares neither a column (by its name) nor an attribute.
+func checkColumnConditions(q *Query) error {
+	for _, root := range []*ConditionNode{q.Having, q.Qualify} {
+		for _, attribute := range conditionAttributes(root) {
+			if !q.hasColumn(attribute) && !IsAttribute(attribute) &&
+				attribute != Xattr.String() && attribute != XattrKeys.String() {
+				return &ErrUnknownToken{Raw: attribute}
+			}
+		}
+	}
+	return nil
+}
+
+// Return the attributes (or function names) compared by each condition of the
+// tree rooted at root, in order.
+func conditionAttributes(root *ConditionNode) []string {
+	if root == nil {
+		return nil
+	}
+	if root.Condition != nil {
+		return []string{root.Condition.Attribute}
+	}
+	return append(conditionAttributes(root.Left), conditionAttributes(root.Right)...)
+}
+
+// Parse the list of columns provided to the SELECT clause. Each column is an
+// attribute, a function, or an expression, optionally followed by AS and an
+// alias.
+func (p *parser) parseColumns(q *Query) error {
+	var column Column
+
+	if fn := p.expectIn(windowFunctions); fn != nil {
+		window, err := p.parseWindowFunction(q, fn.Type)
+		if err != nil {
+			return err
+		}
+		column.Window = window
+	} else if fn := p.expectIn(aggregateFunctions); fn != nil {
+		aggregate, err := p.parseAggregateFunction(fn.Type)
+		if err != nil {
+			return err
+		}
+		column.Aggregate = aggregate
+	} else if p.expect(Coalesce) != nil {
+		// COALESCE of a CASE expression (or IIF or NULLIF) is another,
+		// rather than a COALESCE of JOIN attributes.
+		if arg := p.peekToken(1); arg != nil && conditionals[arg.Type] {
+			expr, err := p.parseCoalesceCase()
+			if err != nil {
+				return err
+			}
+			column.Case = expr
+		} else {
+			attributes, err := p.parseCoalesce()
+			if err != nil {
+				return err
+			}
+			column.Coalesce = attributes
+		}
+	} else if fn := p.expectIn(conditionals); fn != nil {
+		expr, err := p.parseConditional(fn.Type)
+		if err != nil {
+			return err
+		}
+		column.Case = expr
+	} else if p.startsExpression() {
+		expr, err := p.parseExpression()
+		if err != nil {
+			return err
+		}
+		column.Expression = expr
+	} else {
+		attribute := p.expect(Identifier)
+		if attribute == nil {
+			return p.currentError()
+		}
+
+		if attribute.Raw == "*" || attribute.Raw == "all" {
+			for _, name := range allAttributeOrder {
+				q.Attributes[name] = true
+				q.Columns = append(q.Columns, Column{Attribute: name})
+			}
+			return p.parseNextColumn(q)
+		}
+
+		// Other names may be qualified attributes of a JOIN source, or
+		// columns of the UNPIVOT clause, which is only parsed after the
+		// columns (see resolveUnpivoted).
+		alias, name := SplitQualified(attribute.Raw)
+		if IsAttribute(name) {
+			q.Attributes[name] = true
+			column.Attribute, column.Table = name, alias
+		} else {
+			column.Unpivoted = attribute.Raw
+		}
+	}
+
+	if p.expect(As) != nil {
+		alias := p.expectColumnName()
+		if alias == nil {
+			return p.currentError()
+		}
+		column.Alias = alias.Raw
+	}
+
+	q.Columns = append(q.Columns, column)
+	return p.parseNextColumn(q)
+}
+
+// Parse the parenthesized arguments of COALESCE, which are the same attribute
+// qualified with the aliases of different JOIN sources (see checkJoin).
+func (p *parser) parseCoalesce() ([]string, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	attributes := make([]string, 0, 2)
+	for {
+		attribute := p.expect(Identifier)
+		if attribute == nil {
+			return nil, p.currentError()
+		}
+		alias, name := SplitQualified(attribute.Raw)
+		if alias == "" || !IsAttribute(name) {
+			return nil, fmt.Errorf("the arguments of COALESCE must be qualified attributes, got %s", attribute.Raw)
+		}
+		if len(attributes) > 0 {
+			if _, first := SplitQualified(attributes[0]); first != name {
+				return nil, fmt.Errorf("the arguments of COALESCE must be the same attribute, got %s and %s",
+					attributes[0], attribute.Raw)
+			}
+		}
+		attributes = append(attributes, attribute.Raw)
+
+		if p.expect(Comma) == nil {
+			break
+		}
+	}
+
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+	return attributes, nil
+}
+
+// Parse the rest of the SELECT clause's columns, if the current column is
+// followed by a comma.
+func (p *parser) parseNextColumn(q *Query) error {
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseColumns(q)
+}
+
+// Parse the window function of type t (its name has already been read) and its
+// arguments, followed by OVER and either the name of a window from the WINDOW
+// clause or a parenthesized window specification. ROW_NUMBER() may be used
+// without OVER to number the results in output order.
+func (p *parser) parseWindowFunction(q *Query, t TokenType) (*WindowFunction, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	fn := &WindowFunction{Type: t}
+	if err := p.parseWindowArguments(q, fn); err != nil {
+		return nil, err
+	}
+
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	if p.expect(Over) == nil {
+		if t != RowNumber {
+			return nil, p.currentError()
+		}
+		fn.InOutputOrder = true
+		return fn, nil
+	}
+
+	if name := p.expect(Identifier); name != nil {
+		fn.WindowName = name.Raw
+		return fn, nil
+	}
+
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	if err := p.parseWindowSpec(&fn.WindowSpec); err != nil {
+		return nil, err
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	return fn, nil
+}
+
+// Parse the arguments of the window function fn, if it has any. LAG and LEAD
+// take an attribute, optionally followed by the (non-negative) number of
+// results to offset it by, which is 1 by default. FIRST_VALUE and LAST_VALUE
+// take an attribute, and NTILE takes the (positive) number of buckets.
+func (p *parser) parseWindowArguments(q *Query, fn *WindowFunction) error {
+	switch fn.Type {
+	case Lag, Lead, FirstValue, LastValue:
+	case Ntile:
+		buckets := p.expect(Identifier)
+		if buckets == nil {
+			return p.currentError()
+		}
+		n, err := strconv.Atoi(buckets.Raw)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of NTILE buckets: %s", buckets.Raw)
+		}
+		fn.Buckets = n
+		return nil
+	default:
+		return nil
+	}
+
+	attribute := p.expect(Identifier)
+	if attribute == nil {
+		return p.currentError()
+	}
+	if !IsAttribute(attribute.Raw) {
+		return &ErrUnknownToken{Raw: attribute.Raw}
+	}
+	q.Attributes[attribute.Raw] = true
+	fn.Attribute = attribute.Raw
+
+	if fn.Type != Lag && fn.Type != Lead {
+		return nil
+	}
+	fn.Offset = 1
+	if p.expect(Comma) == nil {
+		return nil
+	}
+	offset := p.expect(Identifier)
+	if offset == nil {
+		return p.currentError()
+	}
+	n, err := strconv.Atoi(offset.Raw)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid offset of %s: %s", strings.ToUpper(fn.Type.String()), offset.Raw)
+	}
+	fn.Offset = n
+	return nil
+}
+
+// Parse the parenthesized arguments of the aggregate function of type t (its
+// name has already been read), the first of which is an attribute, or * for
+// all files (for COUNT). Numeric aggregate functions (e.g. MEDIAN) require a
+// numeric attribute, and bitwise ones (e.g. BIT_OR) an integer attribute.
+// CHECKSUM takes a list of attributes.
+func (p *parser) parseAggregateFunction(t TokenType) (*AggregateFunction, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	argument := p.expect(Identifier)
+	if argument == nil {
+		return nil, p.currentError()
+	}
+	name := strings.ToUpper(t.String())
+	switch {
+	case argument.Raw == "*":
+		if t != Count {
+			return nil, fmt.Errorf("%s requires an attribute", name)
+		}
+	case !IsAttribute(argument.Raw):
+		return nil, &ErrUnknownToken{Raw: argument.Raw}
+	case numericAggregateFunctions[t] && !numericAttributes[argument.Raw]:
+		return nil, fmt.Errorf("%s requires a numeric attribute, got %s", name, argument.Raw)
+	case bitwiseAggregateFunctions[t] && !integerAttributes[argument.Raw]:
+		return nil, fmt.Errorf("%s requires an integer attribute, got %s", name, argument.Raw)
+	}
+	fn := &AggregateFunction{Type: t, Attribute: argument.Raw}
+
+	if t == Checksum {
+		if argument.Raw == "*" {
+			return nil, fmt.Errorf("%s requires an attribute", name)
+		}
+		fn.Attribute, fn.Attributes = "", []string{argument.Raw}
+		if p.expect(Comma) != nil {
+			if err := p.parseAttributeList(&fn.Attributes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if t == StringAgg {
+		if p.expect(Comma) == nil {
+			return nil, p.currentError()
+		}
+		separator := p.expect(Identifier)
+		if separator == nil {
+			return nil, p.currentError()
+		}
+		fn.Separator = separator.Raw
+	}
+	if t == StringAgg || t == ArrayAgg || t == ChecksumAgg {
+		if err := p.parseAggregateOrderBy(fn); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	return fn, nil
+}
+
+// Parse the optional ORDER BY clause of STRING_AGG, ARRAY_AGG, or CHECKSUM_AGG
+// (after its other arguments), which orders the values of the function.
+func (p *parser) parseAggregateOrderBy(fn *AggregateFunction) error {
+	if p.expect(Order) == nil {
+		return nil
+	}
+	if p.expect(By) == nil {
+		return p.currentError()
+	}
+	return p.parseOrderBy(&fn.OrderBy)
+}
+
+// Parse the optional PARTITION BY and ORDER BY clauses of a window
+// specification.
+func (p *parser) parseWindowSpec(spec *WindowSpec) error {
+	if p.expect(Partition) != nil {
+		if p.expect(By) == nil {
+			return p.currentError()
+		}
+		spec.PartitionBy = make([]string, 0)
+		if err := p.parseAttributeList(&spec.PartitionBy); err != nil {
+			return err
+		}
+	}
+
+	if p.expect(Order) != nil {
+		if p.expect(By) == nil {
+			return p.currentError()
+		}
+		if err := p.parseOrderBy(&spec.OrderBy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parse the list of named window specifications passed to the WINDOW clause.
+func (p *parser) parseWindows(windows map[string]WindowSpec) error {
+	name := p.expect(Identifier)
+	if name == nil {
+		return p.currentError()
+	}
+
+	if p.expect(As) == nil || p.expect(OpenParen) == nil {
+		return p.currentError()
+	}
+
+	var spec WindowSpec
+	if err := p.parseWindowSpec(&spec); err != nil {
+		return err
+	}
+	if p.expect(CloseParen) == nil {
+		return p.currentError()
+	}
+	windows[name.Raw] = spec
+
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseWindows(windows)
+}
+
+// Parse the (non-negative) number of results of a LIMIT clause or TOP, which
+// is named by clause in the error for an invalid number.
+func (p *parser) parseLimit(clause string) (int, error) {
+	limit := p.expect(Identifier)
+	if limit == nil {
+		return 0, p.currentError()
+	}
+	n, err := strconv.Atoi(limit.Raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", clause, limit.Raw)
+	}
+	return n, nil
+}
+
+// Parse the rest of the FETCH clause (after FETCH), FIRST (or NEXT) followed by
+// the number of results (1 by default), ROWS (or ROW) and ONLY. Returns the
+// number of results.
+func (p *parser) parseFetch() (int, error) {
+	if p.expect(First) == nil && p.expectWord("NEXT") == nil {
+		p.expected = First
+		return 0, p.currentError()
+	}
+
+	n := 1
+	if p.expect(Rows) == nil && p.expectWord("ROW") == nil {
+		var err error
+		if n, err = p.parseLimit("FETCH"); err != nil {
+			return 0, err
+		}
+		if p.expect(Rows) == nil && p.expectWord("ROW") == nil {
+			p.expected = Rows
+			return 0, p.currentError()
+		}
+	}
+
+	if p.expect(Only) == nil {
+		return 0, p.currentError()
+	}
+	return n, nil
+}
+
+// Parse the DISTINCT keyword, along with the parenthesized list of attributes
+// passed to DISTINCT ON, if provided.
+func (p *parser) parseDistinct(q *Query) error {
+	if p.expect(Distinct) == nil {
+		return nil
+	}
+	q.Distinct = true
+
+	if p.expect(On) == nil {
+		return nil
+	}
+	if p.expect(OpenParen) == nil {
+		return p.currentError()
+	}
+
+	q.DistinctOn = make([]string, 0)
+	if err := p.parseAttributeList(&q.DistinctOn); err != nil {
+		return err
+	}
+
+	if p.expect(CloseParen) == nil {
+		return p.currentError()
+	}
+
+	return nil
+}
+
+// Parse a comma-separated list of attributes, in order.
+func (p *parser) parseAttributeList(list *[]string) error {
+	attribute := p.expect(Identifier)
+	if attribute == nil {
+		return p.currentError()
+	}
+	if !IsAttribute(attribute.Raw) {
+		return &ErrUnknownToken{Raw: attribute.Raw}
+	}
+	*list = append(*list, attribute.Raw)
+
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseAttributeList(list)
+}
+
+// Parse the list of directories passed to the FROM clause into the query's
+// sources, which have an "include" and "exclude" key.
+func (p *parser) parseSources(q *Query) error {
+	if err := p.parseSource(q); err != nil {
+		return err
+	}
+
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseSources(q)
+}
+
+// Parse a single source of the FROM clause into the query's sources: either a
+// directory or a variable (e.g. @dir) which is set to one (either of which is
+// excluded when it's preceded by a hyphen), a VALUES table, which is named by
+// its alias, or GENERATE_SERIES.
+func (p *parser) parseSource(q *Query) error {
+	if p.expect(GenerateSeries) != nil {
+		src, err := p.parseGenerateSeries()
+		if err != nil {
+			return err
+		}
+		q.Sources["include"] = append(q.Sources["include"], src)
+		return nil
+	}
+
+	if p.expect(OpenParen) != nil {
+		name, table, err := p.parseValues()
+		if err != nil {
+			return err
+		}
+		if q.Values == nil {
+			q.Values = make(map[string]*ValuesTable)
+		}
+		q.Values[name] = table
+		q.Sources["include"] = append(q.Sources["include"], name)
+		return nil
+	}
+
+	sourceType := "include"
+	if p.expect(Minus) != nil {
+		sourceType = "exclude"
+	}
+
+	// A variable is kept as written (e.g. @dir), and replaced by its value
+	// before the query is run.
+	source := p.expectName()
+	if source == nil {
+		if source = p.expect(Variable); source == nil {
+			return p.currentError()
+		}
+	}
+	q.Sources[sourceType] = append(q.Sources[sourceType], source.Raw)
+	return nil
+}
+
+// Parse the optional JOIN which follows the query's source, which must then
+// be aliased with AS (unless it's a VALUES table, which is named by its
+// alias): [INNER | LEFT [OUTER] | RIGHT [OUTER] | FULL [OUTER] | CROSS | SEMI
+// | ANTI] JOIN, the other source and its alias, then ON and a condition tree
+// (except for a CROSS JOIN). The other source may instead be LATERAL followed
+// by a parenthesized subquery (see parseLateral), for which ON is optional,
+// and a comma followed by LATERAL is the same as CROSS JOIN LATERAL. None of
+// INNER, LEFT, RIGHT, FULL, OUTER, CROSS, SEMI, or ANTI is a keyword, so that
+// they may still be used as source names.
+func (p *parser) parseJoin(q *Query) (*JoinClause, error) {
+	join := &JoinClause{}
+	hasAlias := p.expect(As) != nil
+	if hasAlias {
+		alias := p.expect(Identifier)
+		if alias == nil {
+			return nil, p.currentError()
+		}
+		join.Aliases[0] = alias.Raw
+	}
+
+	if p.expect(Join) != nil {
+		join.Type = "inner"
+	} else if hasAlias && p.expect(Comma) != nil {
+		if next := p.peekToken(0); next == nil || next.Type != Lateral {
+			return nil, errors.New("a source may only be joined with a LATERAL subquery after a comma")
+		}
+		join.Type = "cross"
+	} else {
+		switch {
+		case p.expectWord("INNER") != nil:
+			join.Type = "inner"
+		case p.expectWord("LEFT") != nil:
+			p.expectWord("OUTER")
+			join.Type = "left"
+		case p.expectWord("RIGHT") != nil:
+			p.expectWord("OUTER")
+			join.Type = "right"
+		case p.expectWord("FULL") != nil:
+			p.expectWord("OUTER")
+			join.Type = "full"
+		case p.expectWord("CROSS") != nil:
+			join.Type = "cross"
+		case p.expectWord("SEMI") != nil:
+			join.Type = "semi"
+		case p.expectWord("ANTI") != nil:
+			join.Type = "anti"
+		default:
+			if hasAlias {
+				return nil, errors.New("a source may only be aliased to JOIN it with another")
+			}
+			return nil, nil
+		}
+		if p.expect(Join) == nil {
+			return nil, p.currentError()
+		}
+	}
+
+	if len(q.Sources["include"]) != 1 || len(q.Sources["exclude"]) != 0 {
+		return nil, errors.New("JOIN requires a single source before it")
+	}
+	if _, ok := q.Values[q.Sources["include"][0]]; ok {
+		join.Aliases[0] = q.Sources["include"][0]
+	}
+
+	if p.expect(Lateral) != nil {
+		lateral, err := p.parseLateral()
+		if err != nil {
+			return nil, err
+		}
+		join.Lateral = lateral
+		// Each file of the right source is only joined with the file of the
+		// left source it was evaluated for.
+		if join.Type == "right" || join.Type == "full" {
+			return nil, fmt.Errorf("LATERAL cannot be used with %s JOIN", strings.ToUpper(join.Type))
+		}
+	} else if err := p.parseSource(q); err != nil {
+		return nil, err
+	} else if len(q.Sources["include"]) != 2 {
+		return nil, errors.New("the source of a JOIN cannot be excluded")
+	}
+
+	if src := q.Sources["include"]; join.Lateral == nil && q.Values[src[len(src)-1]] != nil {
+		join.Aliases[1] = src[len(src)-1]
+	} else {
+		if p.expect(As) == nil {
+			return nil, errors.New("the sources of a JOIN must be aliased with AS")
+		}
+		alias := p.expect(Identifier)
+		if alias == nil {
+			return nil, p.currentError()
+		}
+		join.Aliases[1] = alias.Raw
+	}
+	if join.Aliases[0] == "" {
+		return nil, errors.New("the sources of a JOIN must be aliased with AS")
+	}
+	if join.Aliases[0] == join.Aliases[1] {
+		return nil, fmt.Errorf("the sources of a JOIN must have different aliases, got %s twice", join.Aliases[0])
+	}
+
+	if join.Type == "cross" {
+		return join, nil
+	}
+	if p.expect(On) == nil {
+		if join.Lateral != nil {
+			return join, nil
+		}
+		return nil, fmt.Errorf("%s JOIN requires ON", strings.ToUpper(join.Type))
+	}
+	root, err := p.parseConditionTree()
+	if err != nil {
+		return nil, err
+	}
+	join.On = root
+	return join, nil
+}
+
+// Parse the parenthesized subquery which follows LATERAL. The subquery is
+// evaluated for each file of the source it's joined with, and its conditions
+// may compare with that file's attributes (qualified with its alias).
+func (p *parser) parseLateral() (*Query, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	q, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if q.Into != nil {
+		return nil, errors.New("INTO cannot be used in a LATERAL subquery")
+	}
+	if q.Pivot {
+		return nil, errors.New("PIVOT cannot be used in a LATERAL subquery")
+	}
+
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+	return q, nil
+}
+
+// Parse the parenthesized arguments of GENERATE_SERIES, the start, end, and
+// (optionally, 1 by default) step of the series, and return the source of the
+// series, series://start,end,step (see seriesVFS).
+func (p *parser) parseGenerateSeries() (string, error) {
+	if p.expect(OpenParen) == nil {
+		return "", p.currentError()
+	}
+
+	args := make([]string, 0, 3)
+	for {
+		negative := p.expect(Minus) != nil
+		arg := p.expect(Identifier)
+		if arg == nil {
+			return "", p.currentError()
+		}
+		n, err := strconv.ParseInt(arg.Raw, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid argument of GENERATE_SERIES: %s", arg.Raw)
+		}
+		if negative {
+			n = -n
+		}
+		args = append(args, strconv.FormatInt(n, 10))
+
+		if p.expect(Comma) == nil {
+			break
+		}
+	}
+	if p.expect(CloseParen) == nil {
+		return "", p.currentError()
+	}
+
+	switch len(args) {
+	case 2:
+		args = append(args, "1")
+	case 3:
+		if args[2] == "0" {
+			return "", errors.New("the step of GENERATE_SERIES cannot be 0")
+		}
+	default:
+		return "", fmt.Errorf("GENERATE_SERIES takes a start, end, and step, got %d arguments", len(args))
+	}
+	return "series://" + strings.Join(args, ","), nil
+}
+
+// Parse the rest of a VALUES source (after its opening parenthesis): VALUES,
+// the rows of values in parentheses, the closing parenthesis, and AS followed
+// by the table's name and its parenthesized columns. Returns the table's name
+// and the table.
+func (p *parser) parseValues() (string, *ValuesTable, error) {
+	if p.expect(Values) == nil {
+		return "", nil, p.currentError()
+	}
+
+	rows := make([][]string, 0)
+	for {
+		if p.expect(OpenParen) == nil {
+			return "", nil, p.currentError()
+		}
+		row := make([]string, 0)
+		for {
+			value := p.expectName()
+			if value == nil {
+				return "", nil, p.currentError()
+			}
+			row = append(row, value.Raw)
+			if p.expect(Comma) == nil {
+				break
+			}
+		}
+		if p.expect(CloseParen) == nil {
+			return "", nil, p.currentError()
+		}
+		rows = append(rows, row)
+
+		if p.expect(Comma) == nil {
+			break
+		}
+	}
+
+	if p.expect(CloseParen) == nil || p.expect(As) == nil {
+		return "", nil, p.currentError()
+	}
+	name := p.expect(Identifier)
+	if name == nil || p.expect(OpenParen) == nil {
+		return "", nil, p.currentError()
+	}
+	table := &ValuesTable{Columns: make([]string, 0), Rows: make([]ValuesRow, 0, len(rows))}
+	if err := p.parseAttributeList(&table.Columns); err != nil {
+		return "", nil, err
+	}
+	if p.expect(CloseParen) == nil {
+		return "", nil, p.currentError()
+	}
+
+	for _, values := range rows {
+		if len(values) != len(table.Columns) {
+			return "", nil, fmt.Errorf("VALUES row (%s) has %d values, but %s has %d columns",
+				strings.Join(values, ", "), len(values), name.Raw, len(table.Columns))
+		}
+
+		var row ValuesRow
+		for i, column := range table.Columns {
+			var err error
+			switch column {
+			case "name":
+				row.Name = values[i]
+			case "size":
+				row.Size, err = strconv.ParseInt(values[i], 10, 64)
+			case "time":
+				row.ModTime, err = time.Parse(TimeLayout, values[i])
+			default:
+				return "", nil, fmt.Errorf("VALUES column %s must be name, size, or time", column)
+			}
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid %s in VALUES: %s", column, values[i])
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return name.Raw, table, nil
+}
+
+// Parse the method and percentage passed to the TABLESAMPLE clause (e.g.
+// SYSTEM (10 PERCENT)). SYSTEM, BERNOULLI, and PERCENT aren't keywords, so that
+// they may still be used as source names.
+func (p *parser) parseTableSample() (*TableSample, error) {
+	method := p.expect(Identifier)
+	if method == nil {
+		return nil, p.currentError()
+	}
+	sample := &TableSample{Method: strings.ToLower(method.Raw)}
+	if sample.Method != "system" && sample.Method != "bernoulli" {
+		return nil, &ErrUnknownToken{Raw: method.Raw, Line: method.Line, Column: method.Column}
+	}
+
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+
+	percent := p.expect(Identifier)
+	if percent == nil {
+		return nil, p.currentError()
+	}
+	n, err := strconv.ParseFloat(percent.Raw, 64)
+	if err != nil || n < 0 || n > 100 {
+		return nil, fmt.Errorf("invalid sample percentage: %s", percent.Raw)
+	}
+	sample.Percent = n
+
+	if unit := p.expect(Identifier); unit == nil || strings.ToUpper(unit.Raw) != "PERCENT" {
+		return nil, fmt.Errorf("expected PERCENT after sample percentage")
+	}
+
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	return sample, nil
+}
+
+// Parse the destination passed to the INTO clause, either a format followed by
+// a path (e.g. SQLITE path), or a path followed by FORMAT and the format. The
+// format may be preceded by OR REPLACE. None of SQLITE, FORMAT, or REPLACE are
+// keywords, so that they may still be used as source names. The destination
+// may instead be a variable, which is set to the value of the query's single
+// column.
+func (p *parser) parseInto(q *Query) (*Destination, error) {
+	if variable := p.expect(Variable); variable != nil {
+		if len(q.Columns) != 1 {
+			return nil, fmt.Errorf("INTO %s requires a single column", variable.Raw)
+		}
+		return &Destination{Variable: variable.Raw[1:]}, nil
+	}
+
+	into := new(Destination)
+	if p.expect(Or) != nil {
+		replace := p.expect(Identifier)
+		if replace == nil || strings.ToUpper(replace.Raw) != "REPLACE" {
+			return nil, errors.New("expected REPLACE after INTO OR")
+		}
+		into.Replace = true
+	}
+
+	first := p.expect(Identifier)
+	if first == nil {
+		return nil, p.currentError()
+	}
+	second := p.expect(Identifier)
+	if second == nil {
+		return nil, p.currentError()
+	}
+
+	if strings.ToUpper(second.Raw) == "FORMAT" {
+		format := p.expect(Identifier)
+		if format == nil {
+			return nil, p.currentError()
+		}
+		into.Path, into.Format = first.Raw, strings.ToLower(format.Raw)
+	} else {
+		into.Format, into.Path = strings.ToLower(first.Raw), second.Raw
+	}
+
+	return into, nil
+}
+
+// Parse the FORMAT clause (after FORMAT): the name of the output format,
+// and for the tabulate format, optionally WIDTH and the most columns each of
+// its lines may take.
+func (p *parser) parseFormat(q *Query) error {
+	format := p.expect(Identifier)
+	if format == nil {
+		return p.currentError()
+	}
+	q.Format = strings.ToLower(format.Raw)
+
+	if p.expectWord("WIDTH") == nil {
+		return nil
+	}
+	if q.Format != "tabulate" {
+		return errors.New("WIDTH can only be used with FORMAT TABULATE")
+	}
+	width := p.expect(Identifier)
+	if width == nil {
+		return p.currentError()
+	}
+	n, err := strconv.Atoi(width.Raw)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("WIDTH must be a positive integer, got %s", width.Raw)
+	}
+	q.FormatWidth = n
+	return nil
+}
+
+// Parse the condition passed to the WHERE clause.
+func (p *parser) parseConditionTree() (*ConditionNode, error) {
+	s := new(stack)
+	depth := 0
+
+	for {
+		p.current = p.next()
+		if p.current == nil {
+			break
+		}
+
+		// ORDER is only the start of the ORDER BY clause when it's followed
+		// by BY, otherwise it's the name of an attribute.
+		if p.current.Type == Order {
+			if next := p.peekToken(0); next == nil || next.Type != By {
+				p.current.Type = Identifier
+			}
+		}
+		// Likewise, FORMAT is only the start of the FORMAT clause when it's
+		// followed by the name of a format, rather than a comparator.
+		if p.current.Type == Identifier && strings.EqualFold(p.current.Raw, "FORMAT") {
+			if next := p.peekToken(0); next != nil && next.Type == Identifier {
+				break
+			}
+		}
+		// TO is only the start of the TO clause of MOVE or COPY when it's
+		// followed by the name of a directory.
+		if p.current.Type == Identifier && strings.EqualFold(p.current.Raw, "TO") {
+			if next := p.peekToken(0); next != nil && next.Type == Identifier {
+				break
+			}
+		}
+		// COMPACT is only the COMPACT clause when it ends the query.
+		if p.current.Type == Identifier && strings.EqualFold(p.current.Raw, "COMPACT") {
+			if next := p.peekToken(0); next == nil || next.Type == Semicolon {
+				break
+			}
+		}
+
+		// The clauses which follow the WHERE clause (or the ON clause of a
+		// JOIN), or the end of the query, mark the end of the condition tree.
+		if p.current.Type == Where || p.current.Type == Group || p.current.Type == Having ||
+			p.current.Type == Pivot || p.current.Type == Window ||
+			p.current.Type == Qualify || p.current.Type == Order ||
+			p.current.Type == Limit || p.current.Type == Offset ||
+			p.current.Type == Fetch || p.current.Type == Into ||
+			p.current.Type == Semicolon {
+			break
+		}
+		// Those of a CASE (and the comma after the condition of IIF) mark the
+		// end of the condition of one of its branches.
+		if p.current.Type == When || p.current.Type == Then ||
+			p.current.Type == Else || p.current.Type == End || p.current.Type == Comma {
+			break
+		}
+
+		switch p.current.Type {
+		case Not, Exists, Case, Iif, NullIf, Minus, Tilde:
+			fallthrough
+		case Xattr, XattrKeys, ContainsText, Soundex, Metaphone, Levenshtein, LevenshteinRatio, Normalize:
+			fallthrough
+		case Identifier:
+			condition, err := p.parseNextCondition()
+			if err != nil {
+				return nil, err
+			}
+			leaf, err := p.parseChain(condition)
+			if err != nil {
+				return nil, err
+			}
+
+			previous := s.pop()
+			if previous == nil {
+				s.push(leaf)
+			} else {
+				if (*previous).Condition == nil {
+					(*previous).Right = leaf
+				}
+				s.push(previous)
+			}
+		case And:
+			fallthrough
+		case Or:
+			left := s.pop()
+			node := ConditionNode{
+				Type: p.current.Type,
+				Left: left,
+			}
+			s.push(&node)
+		case OpenParen:
+			depth++
+			s.push(nil)
+		case CloseParen:
+			// An unmatched parenthesis closes the subquery this condition tree
+			// belongs to.
+			if depth == 0 {
+				return p.endConditionTree(s)
+			}
+			depth--
+
+			right := s.pop()
+			root := s.pop()
+			if root != nil {
+				root.Right = right
+				s.push(root)
+			} else {
+				s.push(right)
+			}
+		}
+	}
+
+	return p.endConditionTree(s)
+}
+
+// Returns the root of the condition tree once the last token of the tree has
+// been read.
+func (p *parser) endConditionTree(s *stack) (*ConditionNode, error) {
+	if s.len() == 0 {
+		return nil, p.currentError()
+	}
+
+	if s.len() > 1 {
+		return nil, errors.New("failed to parse condition tree")
+	}
+
+	return s.pop(), nil
+}
+
+// Parse the list of sort keys passed to the ORDER BY clause. Each key is an
+// attribute, optionally followed by ASC or DESC.
+func (p *parser) parseOrderBy(orderBy *[]Ordering) error {
+	if p.expect(Coalesce) != nil {
+		attributes, err := p.parseCoalesce()
+		if err != nil {
+			return err
+		}
+		ordering := Ordering{Attribute: CoalesceString(attributes), Coalesce: attributes}
+		return p.parseNextOrdering(orderBy, ordering)
+	}
+
+	attribute := p.expect(Identifier)
+	if attribute == nil {
+		return p.currentError()
+	}
+	// Attributes of a JOIN source are qualified with its alias (see
+	// checkJoin).
+	if _, name := SplitQualified(attribute.Raw); !IsAttribute(name) {
+		return &ErrUnknownToken{Raw: attribute.Raw}
+	}
+
+	return p.parseNextOrdering(orderBy, Ordering{Attribute: attribute.Raw})
+}
+
+// Parse the optional ASC or DESC of the ordering, then the rest of the ORDER BY
+// clause's sort keys, if the ordering is followed by a comma.
+func (p *parser) parseNextOrdering(orderBy *[]Ordering, ordering Ordering) error {
+	if p.expect(Desc) != nil {
+		ordering.Desc = true
+	} else {
+		p.expect(Asc)
+	}
+	*orderBy = append(*orderBy, ordering)
+
+	if p.expect(Comma) == nil {
+		return nil
+	}
+
+	return p.parseOrderBy(orderBy)
+}
+
+// Parse a single condition, made up of the negation, identifier (attribute),
+// function call, or expression, comparator, and value. IS NOT is the same as
+// negating IS.
+func (p *parser) parseNextCondition() (*Condition, error) {
+	negate := false
+	if p.expect(Not) != nil {
+		negate = true
+	}
+
+	if p.expect(Exists) != nil {
+		exists, err := p.parseExists()
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{Attribute: "exists", Negate: negate, Exists: exists}, nil
+	}
+
+	if p.expect(ContainsText) != nil {
+		argument, err := p.parseFunctionArgument(ContainsText)
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{Attribute: ContainsText.String(), Argument: argument, Negate: negate}, nil
+	}
+
+	condition := &Condition{}
+	if fn := p.expectIn(conditionals); fn != nil {
+		expr, err := p.parseConditional(fn.Type)
+		if err != nil {
+			return nil, err
+		}
+		condition.Attribute = Case.String()
+		condition.Case = expr
+	} else if fn := p.expectAny(Xattr, XattrKeys); fn != nil {
+		argument, err := p.parseFunctionArgument(fn.Type)
+		if err != nil {
+			return nil, err
+		}
+		condition.Attribute = fn.Type.String()
+		condition.Argument = argument
+	} else if fn := p.expectAny(Soundex, Metaphone); fn != nil {
+		argument, err := p.parsePhoneticArgument(fn)
+		if err != nil {
+			return nil, err
+		}
+		if argument.Raw != "name" {
+			return nil, fmt.Errorf("%s can only be called on name, or on a string in a condition's value",
+				strings.ToUpper(fn.Raw))
+		}
+		condition.Attribute = fn.Type.String()
+		condition.Argument = argument.Raw
+	} else if fn := p.expectAny(Levenshtein, LevenshteinRatio); fn != nil {
+		attribute, target, err := p.parseAttributeArguments(fn)
+		if err != nil {
+			return nil, err
+		}
+		condition.Attribute = fn.Type.String()
+		condition.Argument = attribute
+		condition.Target = target
+	} else if fn := p.expect(Normalize); fn != nil {
+		attribute, form, err := p.parseAttributeArguments(fn)
+		if err != nil {
+			return nil, err
+		}
+		if !normalizationForms[strings.ToUpper(form)] {
+			return nil, fmt.Errorf("NORMALIZE requires a form of NFC, NFD, NFKC, or NFKD, got %s", form)
+		}
+		condition.Attribute = fn.Type.String()
+		condition.Argument = attribute
+		condition.Target = strings.ToUpper(form)
+	} else if p.startsExpression() {
+		expr, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		condition.Attribute = expr.String()
+		condition.Expression = expr
+	} else {
+		attr := p.expect(Identifier)
+		if attr == nil {
+			return nil, p.currentError()
+		}
+		condition.Attribute = attr.Raw
+	}
+
+	// The comparator has already been read if the condition compares an
+	// expression.
+	if p.current == nil {
+		p.current = p.next()
+	}
+	if p.current == nil || p.current.Type == Unknown {
+		return nil, p.currentError()
+	}
+	comp := p.current.Type
+	p.current = nil
+
+	if comp == Is && p.expect(Not) != nil {
+		negate = !negate
+	}
+
+	// ~ compares with a regexp literal, e.g. name ~ /^test/i, which is
+	// equivalent to RLIKE with the regexp.
+	if comp == Tilde {
+		literal := p.expect(RegexLiteral)
+		if literal == nil {
+			if p.current == nil || p.current.Type == Unknown {
+				return nil, p.currentError()
+			}
+			return nil, errors.New("~ must be followed by a regexp literal, e.g. /^test/i")
+		}
+		if _, err := regexp.Compile(literal.Raw); err != nil {
+			return nil, fmt.Errorf("invalid regexp literal: %v", err)
+		}
+		condition.Comparator = RLike
+		condition.Value = literal.Raw
+		condition.Negate = negate
+		return condition, nil
+	}
+
+	if quantifier := p.expectAny(All, Any, Some); quantifier != nil {
+		if _, name := SplitQualified(condition.Attribute); condition.Expression != nil ||
+			condition.Case != nil || condition.Argument != "" || !IsAttribute(name) {
+			return nil, fmt.Errorf("%s can only compare an attribute", strings.ToUpper(quantifier.Raw))
+		}
+		if !assertComparators[comp] {
+			return nil, fmt.Errorf("%s can only be used with =, <>, <, <=, >, or >=", strings.ToUpper(quantifier.Raw))
+		}
+		sub, err := p.parseQuantified(quantifier)
+		if err != nil {
+			return nil, err
+		}
+
+		condition.Comparator = comp
+		condition.Negate = negate
+		condition.Subquery = sub
+		condition.Quantifier = quantifier.Type
+		if quantifier.Type == Some {
+			condition.Quantifier = Any
+		}
+		return condition, nil
+	}
+
+	// The value may be the phonetic code of a string, e.g. SOUNDEX(colour).
+	if fn := p.expectAny(Soundex, Metaphone); fn != nil {
+		argument, err := p.parsePhoneticArgument(fn)
+		if err != nil {
+			return nil, err
+		}
+		condition.Comparator = comp
+		condition.Value = phoneticFunctions[fn.Type](argument.Raw)
+		condition.Negate = negate
+		return condition, nil
+	}
+
+	// An arithmetic expression may be compared with a negative number.
+	sign := ""
+	if condition.Expression != nil && p.expect(Minus) != nil {
+		sign = "-"
+	}
+	value := p.expectName()
+	if value == nil {
+		return nil, p.currentError()
+	}
+
+	condition.Comparator = comp
+	condition.Value = sign + value.Raw
+	condition.Negate = negate
+
+	// An edit distance is a number, so it may only be compared with one.
+	if condition.Attribute == Levenshtein.String() || condition.Attribute == LevenshteinRatio.String() {
+		name := strings.ToUpper(condition.Attribute)
+		if !assertComparators[comp] {
+			return nil, fmt.Errorf("%s can only be compared with =, <>, <, <=, >, or >=", name)
+		}
+		if _, err := strconv.ParseFloat(condition.Value, 64); err != nil {
+			return nil, fmt.Errorf("%s can only be compared with a number, got %s", name, condition.Value)
+		}
+	}
+	return condition, nil
+}
+
+// Parse the parenthesized subquery of a comparison with ALL, ANY, or SOME
+// (after the quantifier), which must select a single column.
+func (p *parser) parseQuantified(quantifier *Token) (*Query, error) {
+	name := strings.ToUpper(quantifier.Raw)
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	sub, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if sub.Into != nil {
+		return nil, fmt.Errorf("INTO cannot be used in the subquery of %s", name)
+	}
+	if sub.Pivot || len(sub.Columns) != 1 {
+		return nil, fmt.Errorf("the subquery of %s must select a single column", name)
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+	return sub, nil
+}
+
+// The aggregate function whose value a comparison with ALL or ANY of the values
+// of an attribute is equivalent to a comparison with, by its comparator and
+// quantifier: a value is greater than ALL of them iff it's greater than their
+// MAX, and greater than ANY of them iff it's greater than their MIN.
+var quantifiedAggregates = map[TokenType]map[TokenType]TokenType{
+	GreaterThan:       {All: Max, Any: Min},
+	GreaterThanEquals: {All: Max, Any: Min},
+	LessThan:          {All: Min, Any: Max},
+	LessThanEquals:    {All: Min, Any: Max},
+}
+
+// Return an error if a comparison with ALL or ANY is used outside of the WHERE
+// clause, or with JOIN, since its subquery is evaluated once, before the files
+// are walked. A comparison by order with the values of a numeric attribute is
+// rewritten to one with the value of their MAX or MIN (see
+// quantifiedAggregates), e.g. size > ALL (SELECT size ...) to size > (SELECT
+// MAX(size) ...), so the subquery has a single result rather than one per
+// file, unless its results are grouped or limited.
+func rewriteQuantified(q *Query) error {
+	for _, root := range []*ConditionNode{q.Having, q.Qualify} {
+		if len(quantifiedConditions(root)) > 0 {
+			return errors.New("ALL and ANY can only be used in the WHERE clause")
+		}
+	}
+	if q.Join != nil && len(quantifiedConditions(q.Join.On)) > 0 {
+		return errors.New("ALL and ANY can only be used in the WHERE clause")
+	}
+
+	conditions := quantifiedConditions(q.ConditionTree)
+	if len(conditions) > 0 && q.Join != nil && !q.Join.Semi() {
+		return errors.New("ALL and ANY cannot be used with JOIN")
+	}
+	for _, c := range conditions {
+		sub, column := c.Subquery, c.Subquery.Columns[0]
+		fn, ok := quantifiedAggregates[c.Comparator][c.Quantifier]
+		if !ok || column.Attribute == "" || column.Table != "" || !numericAttributes[column.Attribute] ||
+			len(sub.GroupBy) > 0 || sub.Having != nil || sub.Qualify != nil || sub.Join != nil ||
+			sub.Unpivot != nil || sub.Limit >= 0 || sub.Offset > 0 {
+			continue
+		}
+		sub.Columns = []Column{{Aggregate: &AggregateFunction{Type: fn, Attribute: column.Attribute}}}
+	}
+	return nil
+}
+
+// Return each of the comparisons of the tree with ALL or ANY.
+func quantifiedConditions(root *ConditionNode) []*Condition {
+	if root == nil {
+		return nil
+	}
+	if root.Condition != nil {
+		if root.Condition.Subquery != nil {
+			return []*Condition{root.Condition}
+		}
+		return nil
+	}
+	return append(quantifiedConditions(root.Left), quantifiedConditions(root.Right)...)
+}
+
+// The comparators which may be chained (see parseChain), and those of them
+// which are satisfied by increasing values.
+var (
+	chainComparators = map[TokenType]bool{
+		LessThan:          true,
+		LessThanEquals:    true,
+		GreaterThan:       true,
+		GreaterThanEquals: true,
+	}
+	increasingComparators = map[TokenType]bool{LessThan: true, LessThanEquals: true}
+)
+
+// The comparator which b and a satisfy when a and b satisfy each of the
+// comparators which may be chained.
+var reversedComparators = map[TokenType]TokenType{
+	LessThan:          GreaterThan,
+	LessThanEquals:    GreaterThanEquals,
+	GreaterThan:       LessThan,
+	GreaterThanEquals: LessThanEquals,
+}
+
+// Parse the rest of a chain of comparisons which begins with the condition,
+// e.g. 1 < size < 1mb (if the condition's value is followed by another
+// comparator), and return the conditions which each adjacent pair of its terms
+// satisfy, combined with AND: 1 < size AND size < 1mb, which is size > 1 AND
+// size < 1mb. The comparators must either all be < or <=, or all be > or >=.
+// NOT negates the whole chain.
+func (p *parser) parseChain(condition *Condition) (*ConditionNode, error) {
+	next := p.peekToken(0)
+	if next == nil || next.Type != Equals && next.Type != NotEquals && !chainComparators[next.Type] {
+		return &ConditionNode{Condition: condition}, nil
+	}
+	if !chainComparators[condition.Comparator] {
+		return nil, errors.New("only <, <=, >, and >= may be chained")
+	}
+
+	increasing := increasingComparators[condition.Comparator]
+	negate := condition.Negate
+	conditions := []*Condition{condition}
+
+	// The first pair's terms may need to be swapped, unless its first term
+	// is an expression or a function.
+	if condition.Expression == nil && condition.Case == nil && condition.Argument == "" {
+		conditions[0] = chainedCondition(condition.Attribute, condition.Comparator, condition.Value)
+	}
+	term := condition.Value
+	for {
+		next := p.peekToken(0)
+		if next != nil && (next.Type == Equals || next.Type == NotEquals) {
+			return nil, errors.New("only <, <=, >, and >= may be chained")
+		}
+		if next == nil || !chainComparators[next.Type] {
+			break
+		}
+		comparator := p.expectIn(chainComparators)
+		if increasingComparators[comparator.Type] != increasing {
+			return nil, fmt.Errorf("a chain of comparisons must be in one direction (e.g. a < b <= c), got %s",
+				comparator.Raw)
+		}
+
+		sign := ""
+		if p.expect(Minus) != nil {
+			sign = "-"
+		}
+		value := p.expectName()
+		if value == nil {
+			return nil, p.currentError()
+		}
+		conditions = append(conditions, chainedCondition(term, comparator.Type, sign+value.Raw))
+		term = sign + value.Raw
+	}
+
+	// NOT (a AND b) is NOT a OR NOT b.
+	op := And
+	if negate {
+		op = Or
+	}
+	for _, c := range conditions {
+		c.Negate = negate
+	}
+	root := &ConditionNode{Condition: conditions[0]}
+	for _, c := range conditions[1:] {
+		root = &ConditionNode{Type: op, Left: root, Right: &ConditionNode{Condition: c}}
+	}
+	return root, nil
+}
+
+// Return the condition that left and right satisfy the comparator: a
+// comparison of whichever of them is an attribute with the other. If neither
+// is, whichever isn't a number is compared (e.g. a column of the QUALIFY
+// clause), otherwise left.
+func chainedCondition(left string, comparator TokenType, right string) *Condition {
+	compareRight := !isChainAttribute(left) && isChainAttribute(right)
+	if !isChainAttribute(left) && !isChainAttribute(right) {
+		_, err := strconv.ParseFloat(left, 64)
+		compareRight = err == nil
+	}
+	if compareRight {
+		return &Condition{Attribute: right, Comparator: reversedComparators[comparator], Value: left}
+	}
+	return &Condition{Attribute: left, Comparator: comparator, Value: right}
+}
+
+// Return true iff the term of a chain of comparisons is an attribute (which
+// may be qualified with the alias of a JOIN source).
+func isChainAttribute(term string) bool {
+	_, name := SplitQualified(term)
+	return IsAttribute(name)
+}
+
+// The TokenTypes of the expressions which are parsed as a CASE expression.
+var conditionals = map[TokenType]bool{
+	Case:   true,
+	Iif:    true,
+	NullIf: true,
+}
+
+// Parse a CASE expression, IIF, or NULLIF (after the keyword of type t).
+func (p *parser) parseConditional(t TokenType) (*CaseExpression, error) {
+	switch t {
+	case Iif:
+		return p.parseIif()
+	case NullIf:
+		return p.parseNullIf()
+	}
+	return p.parseCase()
+}
+
+// Parse a CASE expression (after CASE), up to and including its END. A simple
+// CASE's attribute follows CASE, and each of its branches has a value rather
+// than a condition (see CaseExpression).
+func (p *parser) parseCase() (*CaseExpression, error) {
+	var attribute *Token
+	if p.expect(When) == nil {
+		if attribute = p.expect(Identifier); attribute == nil {
+			return nil, p.currentError()
+		}
+		if !IsAttribute(attribute.Raw) {
+			return nil, &ErrUnknownToken{Raw: attribute.Raw}
+		}
+		if p.expect(When) == nil {
+			return nil, p.currentError()
+		}
+	}
+
+	expr := &CaseExpression{Branches: make([]CaseBranch, 0)}
+	for {
+		var branch CaseBranch
+		if attribute != nil {
+			value := p.expectName()
+			if value == nil {
+				return nil, p.currentError()
+			}
+			branch.When = &ConditionNode{Condition: &Condition{
+				Attribute: attribute.Raw, Comparator: Equals, Value: value.Raw,
+			}}
+		} else {
+			when, err := p.parseConditionTree()
+			if err != nil {
+				return nil, err
+			}
+			if len(existsConditions(when)) > 0 {
+				return nil, errors.New("EXISTS can only be used in the WHERE clause")
+			}
+			if len(quantifiedConditions(when)) > 0 {
+				return nil, errors.New("ALL and ANY can only be used in the WHERE clause")
+			}
+			branch.When = when
+		}
+
+		if p.expect(Then) == nil {
+			return nil, p.currentError()
+		}
+		result, err := p.parseCaseResult()
+		if err != nil {
+			return nil, err
+		}
+		branch.Then = *result
+		expr.Branches = append(expr.Branches, branch)
+
+		if p.expect(When) == nil {
+			break
+		}
+	}
+
+	if p.expect(Else) != nil {
+		result, err := p.parseCaseResult()
+		if err != nil {
+			return nil, err
+		}
+		expr.Else = result
+	}
+	if p.expect(End) == nil {
+		return nil, p.currentError()
+	}
+	return expr, nil
+}
+
+// Parse the parenthesized arguments of IIF (after IIF): a condition, and the
+// values it has when the condition is satisfied and when it isn't. It's
+// parsed as the CASE expression with a branch for the condition and ELSE.
+func (p *parser) parseIif() (*CaseExpression, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	when, err := p.parseConditionTree()
+	if err != nil {
+		return nil, err
+	}
+	if len(existsConditions(when)) > 0 {
+		return nil, errors.New("EXISTS can only be used in the WHERE clause")
+	}
+	if len(quantifiedConditions(when)) > 0 {
+		return nil, errors.New("ALL and ANY can only be used in the WHERE clause")
+	}
+
+	results := make([]*CaseResult, 0, 2)
+	for len(results) < 2 {
+		if p.expect(Comma) == nil {
+			return nil, p.currentError()
+		}
+		result, err := p.parseCaseResult()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	return &CaseExpression{Branches: []CaseBranch{{When: when, Then: *results[0]}}, Else: results[1]}, nil
+}
+
+// Parse the parenthesized arguments of NULLIF (after NULLIF): an attribute,
+// and the value which it's NULL for. It's parsed as the CASE expression whose
+// result is NULL when the attribute is equal to the value, and the attribute
+// otherwise.
+func (p *parser) parseNullIf() (*CaseExpression, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	attribute := p.expect(Identifier)
+	if attribute == nil {
+		return nil, p.currentError()
+	}
+	if !IsAttribute(attribute.Raw) {
+		return nil, &ErrUnknownToken{Raw: attribute.Raw}
+	}
+	if p.expect(Comma) == nil {
+		return nil, p.currentError()
+	}
+	value := p.expectName()
+	if value == nil {
+		return nil, p.currentError()
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	return &CaseExpression{
+		Branches: []CaseBranch{{
+			When: &ConditionNode{Condition: &Condition{Attribute: attribute.Raw, Comparator: Equals, Value: value.Raw}},
+			Then: CaseResult{Null: true},
+		}},
+		Else: &CaseResult{Attribute: attribute.Raw},
+	}, nil
+}
+
+// Parse the parenthesized arguments of COALESCE (after COALESCE) when the
+// first is a CASE expression (or IIF or NULLIF) rather than an attribute, and
+// the rest are the results which replace its NULL results, in order. It's
+// parsed as the CASE expression with its NULL results replaced.
+func (p *parser) parseCoalesceCase() (*CaseExpression, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	fn := p.expectIn(conditionals)
+	if fn == nil {
+		return nil, p.currentError()
+	}
+	expr, err := p.parseConditional(fn.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	fallbacks := make([]*CaseResult, 0, 1)
+	for p.expect(Comma) != nil {
+		result, err := p.parseCaseResult()
+		if err != nil {
+			return nil, err
+		}
+		fallbacks = append(fallbacks, result)
+	}
+	if len(fallbacks) == 0 {
+		return nil, errors.New("COALESCE requires at least two arguments")
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
+
+	fallback := fallbacks[len(fallbacks)-1]
+	for i := len(fallbacks) - 2; i >= 0; i-- {
+		fallback = coalesceResult(fallbacks[i], fallback)
+	}
+	return coalesceCase(expr, fallback), nil
+}
+
+// Return the CASE expression with each of its NULL results (including that
+// of a missing ELSE) replaced by fallback.
+func coalesceCase(expr *CaseExpression, fallback *CaseResult) *CaseExpression {
+	coalesced := &CaseExpression{Branches: make([]CaseBranch, len(expr.Branches)), Else: fallback}
+	for i, branch := range expr.Branches {
+		coalesced.Branches[i] = CaseBranch{When: branch.When, Then: *coalesceResult(&branch.Then, fallback)}
+	}
+	if expr.Else != nil {
+		coalesced.Else = coalesceResult(expr.Else, fallback)
+	}
+	return coalesced
 }
 
-// Return true when no attributes are provided (regardless of if the SELECT
-// keyword is provided). Returns false otherwise.
-func (p *parser) showAllAttributes() (bool, error) {
-	if p.expect(Select) == nil {
-		if p.current == nil {
-			return false, nil
-		}
+// Return the result, or fallback if it's NULL.
+func coalesceResult(result, fallback *CaseResult) *CaseResult {
+	if result.Null {
+		return fallback
+	}
+	if result.Case != nil {
+		return &CaseResult{Case: coalesceCase(result.Case, fallback)}
+	}
+	return result
+}
 
-		if p.current.Type == From || p.current.Type == Where {
-			return true, nil
+// Parse the result of a branch of a CASE expression (after THEN or ELSE, or
+// an argument of IIF), which is either a value or a nested CASE (or IIF or
+// NULLIF).
+func (p *parser) parseCaseResult() (*CaseResult, error) {
+	if fn := p.expectIn(conditionals); fn != nil {
+		expr, err := p.parseConditional(fn.Type)
+		if err != nil {
+			return nil, err
 		}
+		return &CaseResult{Case: expr}, nil
+	}
 
-		if p.current.Type == Identifier {
-			return false, nil
+	value := p.expectName()
+	if value == nil {
+		return nil, p.currentError()
+	}
+	return &CaseResult{Value: value.Raw}, nil
+}
+
+// Return an error if the query uses CASE or an arithmetic expression with a
+// clause whose results aren't the files it finds (JOIN, other than a SEMI or
+// ANTI JOIN, or UNPIVOT), which their values are computed from.
+func checkCase(q *Query) error {
+	roots := []*ConditionNode{q.ConditionTree, q.Having, q.Qualify}
+	if q.Join != nil {
+		roots = append(roots, q.Join.On)
+	}
+
+	hasCase, hasExpression := false, false
+	for _, c := range q.Columns {
+		hasCase = hasCase || c.Case != nil
+		hasExpression = hasExpression || c.Expression != nil
+	}
+	for _, root := range roots {
+		hasCase = hasCase || hasCondition(root, func(c *Condition) bool { return c.Case != nil })
+		hasExpression = hasExpression || hasCondition(root, func(c *Condition) bool { return c.Expression != nil })
+	}
+
+	for _, check := range []struct {
+		used bool
+		name string
+	}{{hasCase, "CASE"}, {hasExpression, "arithmetic expressions"}} {
+		if check.used && q.Join != nil && !q.Join.Semi() {
+			return fmt.Errorf("%s cannot be used with JOIN", check.name)
 		}
+		if check.used && q.Unpivot != nil {
+			return fmt.Errorf("%s cannot be used with UNPIVOT", check.name)
+		}
+	}
+	return nil
+}
 
-		return false, p.currentError()
+// Return true iff the tree has a condition which satisfies fn.
+func hasCondition(root *ConditionNode, fn func(*Condition) bool) bool {
+	if root == nil {
+		return false
+	}
+	if root.Condition != nil {
+		return fn(root.Condition)
 	}
+	return hasCondition(root.Left, fn) || hasCondition(root.Right, fn)
+}
 
-	current := p.expect(Identifier)
-	if current != nil {
-		p.current = current
-		return false, nil
+// The TokenTypes of the arithmetic (including bitwise) operators.
+var arithmeticOperators = map[TokenType]bool{
+	Plus:       true,
+	Minus:      true,
+	Star:       true,
+	Slash:      true,
+	Percent:    true,
+	Ampersand:  true,
+	Pipe:       true,
+	Caret:      true,
+	Tilde:      true,
+	ShiftLeft:  true,
+	ShiftRight: true,
+}
+
+// The arithmetic operators which are also identifiers by themselves (see Plus),
+// including ~ (the home directory).
+var wordOperators = map[TokenType]bool{
+	Plus:      true,
+	Star:      true,
+	Slash:     true,
+	Percent:   true,
+	Ampersand: true,
+	Pipe:      true,
+	Caret:     true,
+	Tilde:     true,
+}
+
+// Return true iff the current token begins an arithmetic expression, rather
+// than an attribute: it's `-`, `~`, or an open parenthesis, or an identifier
+// which is followed by a binary operator (so not `~`, which is then the
+// comparator of a regexp literal).
+func (p *parser) startsExpression() bool {
+	if p.current == nil {
+		p.current = p.next()
+	}
+	if p.current == nil {
+		return false
 	}
 
-	return true, nil
+	switch p.current.Type {
+	case Minus, Tilde, OpenParen:
+		return true
+	case Identifier:
+		next := p.peekToken(0)
+		return next != nil && next.Type != Tilde && arithmeticOperators[next.Type]
+	}
+	return false
 }
 
-// Parse each of the clauses in the input string.
-func (p *parser) parse(input string) (*Query, error) {
-	p.tokenizer = NewTokenizer(input)
-	q := new(Query)
+// Parse an arithmetic expression.
+func (p *parser) parseExpression() (*Expression, error) {
+	return p.parseOperation(0)
+}
+
+// Parse the operands of the operators at the level of operatorPrecedence from
+// left to right, each of which is an operation at the next level (or a
+// factor, after the last level), so that it binds more tightly.
+func (p *parser) parseOperation(level int) (*Expression, error) {
+	if level == len(operatorPrecedence) {
+		return p.parseFactor()
+	}
 
-	all, err := p.showAllAttributes()
+	left, err := p.parseOperation(level + 1)
 	if err != nil {
 		return nil, err
 	}
-	if all {
-		q.Attributes = allAttributes
-	} else {
-		q.Attributes = make(map[string]bool)
-		err := p.parseAttributes(&q.Attributes)
+
+	for {
+		op := p.expectAny(operatorPrecedence[level]...)
+		if op == nil {
+			return left, nil
+		}
+		right, err := p.parseOperation(level + 1)
 		if err != nil {
 			return nil, err
 		}
+		left = &Expression{Operator: op.Type, Left: left, Right: right}
+		if err := checkBitwise(left); err != nil {
+			return nil, err
+		}
 	}
+}
 
-	q.Sources = map[string][]string{
-		"include": make([]string, 0),
-		"exclude": make([]string, 0),
+// Return an error if the operation is bitwise, and its operands aren't
+// integers.
+func checkBitwise(e *Expression) error {
+	if !bitwiseOperators[e.Operator] {
+		return nil
 	}
-	if p.expect(From) == nil {
-		err := p.currentError()
-		if p.expect(Identifier) != nil {
-			return nil, err
+	for _, operand := range []*Expression{e.Left, e.Right} {
+		if operand != nil && !operand.IsInteger() {
+			return fmt.Errorf("%s requires integers, got %s", operatorSymbols[e.Operator], operand)
 		}
-		q.Sources["include"] = append(q.Sources["include"], ".")
-	} else {
-		err := p.parseSources(&q.Sources)
+	}
+	return nil
+}
+
+// Parse a factor of an arithmetic expression: a number, a numeric attribute,
+// a parenthesized expression, or the negation (or bitwise NOT) of one of
+// these.
+func (p *parser) parseFactor() (*Expression, error) {
+	if op := p.expectAny(Minus, Tilde); op != nil {
+		operand, err := p.parseFactor()
 		if err != nil {
 			return nil, err
 		}
+		e := &Expression{Operator: op.Type, Right: operand}
+		if err := checkBitwise(e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
 
-		// Replace the tilde with the home directory in each source directory. This
-		// is only required when the query is wrapped in quotes, since the shell
-		// will automatically expand tildes otherwise.
-		usr, err := user.Current()
+	if p.expect(OpenParen) != nil {
+		expr, err := p.parseExpression()
 		if err != nil {
 			return nil, err
 		}
-		for _, sourceType := range []string{"include", "exclude"} {
-			for i, src := range q.Sources[sourceType] {
-				if strings.Contains(src, "~") {
-					q.Sources[sourceType][i] = filepath.Join(usr.HomeDir, src[1:])
-				}
-			}
+		if p.expect(CloseParen) == nil {
+			return nil, p.currentError()
 		}
+		return expr, nil
 	}
 
-	if p.expect(Where) == nil {
-		err := p.currentError()
-		if p.expect(Identifier) == nil {
-			return q, nil
-		}
-		return nil, err
+	operand := p.expect(Identifier)
+	if operand == nil {
+		return nil, p.currentError()
 	}
-	root, err := p.parseConditionTree()
+	switch {
+	case integerAttributes[operand.Raw]:
+		return &Expression{Attribute: operand.Raw}, nil
+	case IsAttribute(operand.Raw):
+		return nil, fmt.Errorf("arithmetic requires a numeric attribute, got %s", operand.Raw)
+	}
+	e := &Expression{Value: operand.Raw}
+	if _, err := strconv.ParseFloat(operand.Raw, 64); err != nil && !e.IsInteger() {
+		return nil, &ErrUnknownToken{Raw: operand.Raw}
+	}
+	return e, nil
+}
+
+// Parse the parenthesized subquery of an EXISTS condition. Since only whether it
+// has any results matters, it may select 1 rather than any attributes.
+func (p *parser) parseExists() (*Query, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
+	}
+	if tok := p.peekToken(1); tok != nil && tok.Type == Identifier && tok.Raw == "1" &&
+		p.peekToken(0).Type == Select {
+		p.expect(Select)
+		p.expect(Identifier)
+	}
+
+	q, err := p.parseQuery()
 	if err != nil {
 		return nil, err
 	}
-	q.ConditionTree = root
+	if q.Into != nil {
+		return nil, errors.New("INTO cannot be used in the subquery of EXISTS")
+	}
 
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
+	}
 	return q, nil
 }
 
-// Parse the list of attributes provided to the SELECT clause.
-func (p *parser) parseAttributes(attributes *map[string]bool) error {
-	attribute := p.expect(Identifier)
-	if attribute == nil {
-		return p.currentError()
+// Parse the parenthesized argument of a function call in a condition. XATTR
+// takes the key of an extended attribute, CONTAINS_TEXT takes the text to
+// search for, and XATTR_KEYS optionally takes the file's path (as either name
+// or path), which is the only file it can be called on.
+func (p *parser) parseFunctionArgument(fn TokenType) (string, error) {
+	if p.expect(OpenParen) == nil {
+		return "", p.currentError()
 	}
-	if attribute.Raw == "*" || attribute.Raw == "all" {
-		*attributes = allAttributes
-	} else if _, ok := allAttributes[attribute.Raw]; !ok {
-		return &ErrUnknownToken{attribute.Raw}
-	} else {
-		(*attributes)[attribute.Raw] = true
+
+	argument := p.expect(Identifier)
+	if argument == nil && fn != XattrKeys {
+		return "", p.currentError()
+	}
+	if argument != nil && fn == XattrKeys &&
+		argument.Raw != "name" && argument.Raw != "path" {
+		return "", &ErrUnknownToken{Raw: argument.Raw}
 	}
 
-	if p.expect(Comma) == nil {
-		return nil
+	if p.expect(CloseParen) == nil {
+		return "", p.currentError()
 	}
 
-	return p.parseAttributes(attributes)
+	if fn == XattrKeys {
+		return "", nil
+	}
+	return argument.Raw, nil
 }
 
-// Parse the list of directories passed to the FROM clause. Expects that
-// the sources input has an "include" and "exclude" key.
-func (p *parser) parseSources(sources *map[string][]string) error {
-	sourceType := "include"
-	if p.expect(Minus) != nil {
-		sourceType = "exclude"
+// Parse the parenthesized argument of SOUNDEX or METAPHONE (after the
+// function's name): name, when it's compared, or the string whose code is a
+// condition's value.
+func (p *parser) parsePhoneticArgument(fn *Token) (*Token, error) {
+	if p.expect(OpenParen) == nil {
+		return nil, p.currentError()
 	}
-
-	source := p.expect(Identifier)
-	if source == nil {
-		return p.currentError()
+	argument := p.expect(Identifier)
+	if argument == nil {
+		return nil, fmt.Errorf("%s requires an argument", strings.ToUpper(fn.Raw))
+	}
+	if p.expect(CloseParen) == nil {
+		return nil, p.currentError()
 	}
+	return argument, nil
+}
 
-	(*sources)[sourceType] = append((*sources)[sourceType], source.Raw)
+// The Unicode normalization forms which NORMALIZE may convert to.
+var normalizationForms = map[string]bool{
+	"NFC":  true,
+	"NFD":  true,
+	"NFKC": true,
+	"NFKD": true,
+}
 
+// Parse the parenthesized arguments of LEVENSHTEIN, LEVENSHTEIN_RATIO, or
+// NORMALIZE (after the function's name): the attribute (name or dir), and the
+// string it's compared with (or, for NORMALIZE, the form).
+func (p *parser) parseAttributeArguments(fn *Token) (string, string, error) {
+	name := strings.ToUpper(fn.Raw)
+	if p.expect(OpenParen) == nil {
+		return "", "", p.currentError()
+	}
+	attribute := p.expect(Identifier)
+	if attribute == nil {
+		return "", "", fmt.Errorf("%s requires an attribute and a string", name)
+	}
+	if attribute.Raw != "name" && attribute.Raw != "dir" {
+		return "", "", fmt.Errorf("%s can only be called on name or dir, got %s", name, attribute.Raw)
+	}
 	if p.expect(Comma) == nil {
-		return nil
+		return "", "", fmt.Errorf("%s requires an attribute and a string", name)
 	}
-
-	return p.parseSources(sources)
+	target := p.expect(Identifier)
+	if target == nil {
+		return "", "", fmt.Errorf("%s requires an attribute and a string", name)
+	}
+	if p.expect(CloseParen) == nil {
+		return "", "", p.currentError()
+	}
+	return attribute.Raw, target.Raw, nil
 }
 
-// Parse the condition passed to the WHERE clause.
-func (p *parser) parseConditionTree() (*ConditionNode, error) {
-	s := new(stack)
+// Returns the next token (consuming it), or nil at the end of the input.
+func (p *parser) next() *Token {
+	if len(p.lookahead) > 0 {
+		tok := p.lookahead[0]
+		p.lookahead = p.lookahead[1:]
+		return tok
+	}
 
-	for {
-		p.current = p.tokenizer.Next()
-		if p.current == nil {
-			break
+	return p.tokenizer.Next()
+}
+
+// Returns the token n tokens after the current token (so peekToken(0) is the
+// token which follows the current token) without consuming it, or nil if the
+// input ends before then.
+func (p *parser) peekToken(n int) *Token {
+	for len(p.lookahead) <= n {
+		tok := p.tokenizer.Next()
+		if tok == nil {
+			return nil
 		}
+		p.lookahead = append(p.lookahead, tok)
+	}
 
-		switch p.current.Type {
-		case Not:
-			fallthrough
-		case Identifier:
-			condition, err := p.parseNextCondition()
-			if err != nil {
-				return nil, p.currentError()
-			}
+	return p.lookahead[n]
+}
 
-			leaf := ConditionNode{Condition: condition}
-			previous := s.pop()
-			if previous == nil {
-				s.push(&leaf)
-			} else {
-				if (*previous).Condition == nil {
-					(*previous).Right = &leaf
-				}
-				s.push(previous)
-			}
-		case And:
-			fallthrough
-		case Or:
-			left := s.pop()
-			node := ConditionNode{
-				Type: p.current.Type,
-				Left: left,
-			}
-			s.push(&node)
-		case OpenParen:
-			s.push(nil)
-		case CloseParen:
-			right := s.pop()
-			root := s.pop()
-			if root != nil {
-				root.Right = right
-				s.push(root)
-			} else {
-				s.push(right)
-			}
-		}
+// Returns the next token if it matches the expectation, nil otherwise.
+func (p *parser) expect(t TokenType) *Token {
+	p.expected = t
+
+	if p.current == nil {
+		p.current = p.next()
 	}
 
-	if s.len() == 0 {
-		return nil, p.currentError()
+	if p.current != nil && p.current.Type == t {
+		tok := p.current
+		p.current = nil
+		return tok
 	}
 
-	if s.len() > 1 {
-		return nil, errors.New("failed to parse condition tree")
+	// Some arithmetic operators are also identifiers, e.g. the source / or
+	// the attribute * (see wordOperators).
+	if p.current != nil && t == Identifier && wordOperators[p.current.Type] {
+		tok := *p.current
+		tok.Type = Identifier
+		p.current = nil
+		return &tok
 	}
 
-	return s.pop(), nil
+	return nil
 }
 
-// Parse a single condition, made up of the negation, identifier (attribute),
-// comparator, and value.
-func (p *parser) parseNextCondition() (*Condition, error) {
-	negate := false
-	if p.expect(Not) != nil {
-		negate = true
+// Returns the next token if its TokenType is one of types, nil otherwise.
+func (p *parser) expectIn(types map[TokenType]bool) *Token {
+	if p.current == nil {
+		p.current = p.next()
 	}
 
-	attr := p.expect(Identifier)
-	if attr == nil {
-		return nil, p.currentError()
+	if p.current != nil && types[p.current.Type] {
+		tok := p.current
+		p.current = nil
+		return tok
 	}
 
-	p.current = p.tokenizer.Next()
-	if p.current == nil {
-		return nil, p.currentError()
+	return nil
+}
+
+// Returns the next token if it's an identifier or the name of a window or
+// aggregate function (which may also be used as an alias), nil otherwise.
+func (p *parser) expectColumnName() *Token {
+	if tok := p.expect(Identifier); tok != nil {
+		return tok
 	}
-	comp := p.current.Type
-	p.current = nil
+	if tok := p.expectIn(windowFunctions); tok != nil {
+		return tok
+	}
+	return p.expectIn(aggregateFunctions)
+}
 
-	value := p.expect(Identifier)
-	if value == nil {
-		return nil, p.currentError()
+// Returns the next token if it's an identifier or a keyword, as an identifier,
+// nil otherwise. This is used where a name (e.g. a source, a value, or a
+// label) is expected, so that keywords may also be names, e.g. the file top
+// in name = top.
+func (p *parser) expectName() *Token {
+	if tok := p.expect(Identifier); tok != nil {
+		return tok
 	}
 
-	return &Condition{
-		Attribute:  attr.Raw,
-		Comparator: comp,
-		Value:      value.Raw,
-		Negate:     negate,
-	}, nil
+	if p.current != nil && p.current.isKeyword() {
+		tok := *p.current
+		tok.Type = Identifier
+		p.current = nil
+		return &tok
+	}
+
+	return nil
 }
 
-// Returns the next token if it matches the expectation, nil otherwise.
-func (p *parser) expect(t TokenType) *Token {
-	p.expected = t
+// Returns the next token if it's an identifier which matches word (ignoring
+// case), nil otherwise. This is used for words which aren't keywords.
+func (p *parser) expectWord(word string) *Token {
+	p.expected = Identifier
 
 	if p.current == nil {
-		p.current = p.tokenizer.Next()
+		p.current = p.next()
 	}
 
-	if p.current != nil && p.current.Type == t {
+	if p.current != nil && p.current.Type == Identifier && strings.EqualFold(p.current.Raw, word) {
 		tok := p.current
 		p.current = nil
 		return tok
@@ -278,6 +3504,17 @@ func (p *parser) expect(t TokenType) *Token {
 	return nil
 }
 
+// Returns the next token if it matches any of the expectations, nil otherwise.
+func (p *parser) expectAny(types ...TokenType) *Token {
+	for _, t := range types {
+		if tok := p.expect(t); tok != nil {
+			return tok
+		}
+	}
+
+	return nil
+}
+
 // Returns the current error, based on the parser's current Token and the
 // previously expected TokenType (set in expect).
 func (p *parser) currentError() error {
@@ -286,7 +3523,11 @@ func (p *parser) currentError() error {
 	}
 
 	if p.current.Type == Unknown {
-		return &ErrUnknownToken{Raw: p.current.Raw}
+		return &ErrUnknownToken{
+			Raw:    p.current.Raw,
+			Line:   p.current.Line,
+			Column: p.current.Column,
+		}
 	}
 
 	return &ErrUnexpectedToken{Actual: p.current.Type, Expected: p.expected}
@@ -302,11 +3543,28 @@ func (e *ErrUnexpectedToken) Error() string {
 	return fmt.Sprintf("Expected %s; got %s", e.Expected.String(), e.Actual.String())
 }
 
-// ErrUnknownToken represents an unknown token error.
+// ErrUndefinedCTE represents a reference to a CTE before (or while) it's
+// defined.
+type ErrUndefinedCTE struct {
+	Name string
+}
+
+func (e *ErrUndefinedCTE) Error() string {
+	return fmt.Sprintf("CTE %s is used before it's defined", e.Name)
+}
+
+// ErrUnknownToken represents an unknown token error. The position is only set
+// when it's known (i.e. Line is 0 otherwise).
 type ErrUnknownToken struct {
-	Raw string
+	Raw    string
+	Line   int
+	Column int
 }
 
 func (e *ErrUnknownToken) Error() string {
-	return fmt.Sprintf("Unknown token: %s", e.Raw)
+	if e.Line == 0 {
+		return fmt.Sprintf("Unknown token: %s", e.Raw)
+	}
+	return fmt.Sprintf("Unknown token: %s (line %d, column %d)", e.Raw, e.Line,
+		e.Column)
 }
@@ -1 +1,2180 @@
 package query
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOrderBy(t *testing.T) {
+	type Case struct {
+		input    string
+		expected []Ordering
+	}
+
+	cases := []Case{
+		{"SELECT name FROM .", nil},
+		{"SELECT name FROM . ORDER BY size", []Ordering{{Attribute: "size"}}},
+		{"SELECT name FROM . ORDER BY size ASC", []Ordering{{Attribute: "size"}}},
+		{
+			"SELECT name FROM . WHERE size > 5 ORDER BY size DESC, name",
+			[]Ordering{{Attribute: "size", Desc: true}, {Attribute: "name"}},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.OrderBy, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.OrderBy)
+		}
+	}
+}
+
+func TestParseOrderByConditionTree(t *testing.T) {
+	q, err := RunParser("SELECT name FROM . WHERE size > 5 ORDER BY size")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := &ConditionNode{Condition: &Condition{
+		Attribute:  "size",
+		Comparator: GreaterThan,
+		Value:      "5",
+	}}
+	if !reflect.DeepEqual(q.ConditionTree, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.ConditionTree)
+	}
+}
+
+func TestParseOrderByErrors(t *testing.T) {
+	cases := []string{
+		"SELECT name FROM . ORDER size",
+		"SELECT name FROM . ORDER BY",
+		"SELECT name FROM . ORDER BY foo",
+	}
+
+	for _, input := range cases {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestRunFileParser(t *testing.T) {
+	input := `SELECT name FROM . WHERE name LIKE %.go;
+SELECT size FROM src WHERE name = 'a;b' ORDER BY size;
+SELECT name
+  FROM ., -.git;
+`
+
+	file, err := RunFileParser(input)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(file.Queries) != 3 {
+		t.Fatalf("\nExpected 3 queries\n     Got %d", len(file.Queries))
+	}
+
+	type Case struct {
+		attributes map[string]bool
+		sources    map[string][]string
+		condition  *Condition
+	}
+
+	cases := []Case{
+		{
+			map[string]bool{"name": true},
+			map[string][]string{"include": {"."}, "exclude": {}},
+			&Condition{Attribute: "name", Comparator: Like, Value: "%.go"},
+		},
+		{
+			map[string]bool{"size": true},
+			map[string][]string{"include": {"src"}, "exclude": {}},
+			&Condition{Attribute: "name", Comparator: Equals, Value: "a;b"},
+		},
+		{
+			map[string]bool{"name": true},
+			map[string][]string{"include": {"."}, "exclude": {".git"}},
+			nil,
+		},
+	}
+
+	for i, c := range cases {
+		q := file.Queries[i]
+		if !reflect.DeepEqual(q.Attributes, c.attributes) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.attributes, q.Attributes)
+		}
+		if !reflect.DeepEqual(q.Sources, c.sources) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.sources, q.Sources)
+		}
+		if c.condition == nil {
+			if q.ConditionTree != nil {
+				t.Fatalf("\nExpected no condition\n     Got %v", q.ConditionTree)
+			}
+		} else if !reflect.DeepEqual(q.ConditionTree.Condition, c.condition) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.condition, q.ConditionTree.Condition)
+		}
+	}
+
+	if len(file.Queries[1].OrderBy) != 1 {
+		t.Fatalf("\nExpected ORDER BY in second query\n     Got %v", file.Queries[1].OrderBy)
+	}
+}
+
+func TestRunFileParserErrors(t *testing.T) {
+	cases := []string{
+		"SELECT name FROM .; SELECT foo FROM .",
+		"SELECT name FROM . ORDER BY size name; SELECT name FROM .",
+	}
+
+	for _, input := range cases {
+		if _, err := RunFileParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	input := "WITH small AS (SELECT * FROM ./src WHERE size < 10 AND (name LIKE %.go OR name LIKE %.py))," +
+		" tiny AS (SELECT name FROM small WHERE size < 2)" +
+		" SELECT name FROM tiny, ./src ORDER BY name"
+
+	q, err := RunParser(input)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	if len(q.With) != 2 || q.With[0].Name != "small" || q.With[1].Name != "tiny" {
+		t.Fatalf("\nExpected CTEs small and tiny\n     Got %v", q.With)
+	}
+
+	expected := "(and ((size less-than 10), (or ((name like %.go), (name like %.py)))))"
+	if actual := conditionString(q.With[0].Query.ConditionTree); actual != expected {
+		t.Fatalf("\nExpected %s\n     Got %s", expected, actual)
+	}
+
+	if !reflect.DeepEqual(q.With[1].Query.Sources["include"], []string{"small"}) {
+		t.Fatalf("\nExpected source small\n     Got %v", q.With[1].Query.Sources)
+	}
+	if !reflect.DeepEqual(q.Sources["include"], []string{"tiny", "./src"}) {
+		t.Fatalf("\nExpected sources tiny, ./src\n     Got %v", q.Sources)
+	}
+	if len(q.OrderBy) != 1 {
+		t.Fatalf("\nExpected ORDER BY\n     Got %v", q.OrderBy)
+	}
+}
+
+func TestParseWithErrors(t *testing.T) {
+	type Case struct {
+		input    string
+		expected error
+	}
+
+	cases := []Case{
+		{"WITH a AS (SELECT * FROM a) SELECT * FROM a", &ErrUndefinedCTE{Name: "a"}},
+		{"WITH a AS (SELECT * FROM b), b AS (SELECT * FROM .) SELECT * FROM a", &ErrUndefinedCTE{Name: "b"}},
+		{"WITH a (SELECT * FROM .) SELECT * FROM a", nil},
+		{"WITH a AS (SELECT * FROM . SELECT * FROM a", nil},
+		{"WITH a AS (SELECT * FROM .), a AS (SELECT * FROM .) SELECT * FROM a", nil},
+		// A source which is a name must be one of the CTEs.
+		{"WITH g AS (SELECT * FROM .) SELECT name FROM h", nil},
+		{"WITH g AS (SELECT * FROM .) SELECT name FROM g, src", nil},
+		// So must a source of a CTE's body, which can only use the CTEs before it.
+		{"WITH g AS (SELECT * FROM src) SELECT name FROM g", nil},
+		{"WITH g AS (SELECT * FROM .), h AS (SELECT * FROM g, f) SELECT name FROM h", nil},
+	}
+
+	for _, c := range cases {
+		_, err := RunParser(c.input)
+		if err == nil {
+			t.Fatalf("\nExpected error for %q", c.input)
+		}
+		if c.expected != nil && !reflect.DeepEqual(err, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, err)
+		}
+	}
+
+	// Paths aren't CTEs, even when they're in the current directory.
+	for _, input := range []string{
+		"WITH g AS (SELECT * FROM ./src) SELECT name FROM g, ./src, ~, ..",
+		"WITH g AS (SELECT * FROM .), h AS (SELECT * FROM g, ~/src) SELECT name FROM g, h",
+		"WITH g AS (SELECT * FROM .) SELECT name FROM g, /tmp, -vendor",
+	} {
+		if _, err := RunParser(input); err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", input, err)
+		}
+	}
+}
+
+// Return a compact representation of the condition tree rooted at root.
+func conditionString(root *ConditionNode) string {
+	if root == nil {
+		return "(nil)"
+	}
+
+	if root.Condition != nil {
+		c := root.Condition
+		return fmt.Sprintf("(%s %s %s)", c.Attribute, c.Comparator, c.Value)
+	}
+
+	return fmt.Sprintf("(%s (%s, %s))", root.Type, conditionString(root.Left),
+		conditionString(root.Right))
+}
+
+func TestParseDistinct(t *testing.T) {
+	type Case struct {
+		input      string
+		distinct   bool
+		distinctOn []string
+		attributes map[string]bool
+	}
+
+	cases := []Case{
+		{"SELECT name FROM .", false, nil, map[string]bool{"name": true}},
+		{"SELECT DISTINCT size FROM .", true, nil, map[string]bool{"size": true}},
+		{"SELECT DISTINCT FROM .", true, nil, allAttributes},
+		{
+			"SELECT DISTINCT ON (dir, size) name, size FROM .",
+			true, []string{"dir", "size"}, map[string]bool{"name": true, "size": true},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Distinct != c.distinct || !reflect.DeepEqual(q.DistinctOn, c.distinctOn) {
+			t.Fatalf("\nExpected distinct %t on %v\n     Got %t on %v",
+				c.distinct, c.distinctOn, q.Distinct, q.DistinctOn)
+		}
+		if !reflect.DeepEqual(q.Attributes, c.attributes) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.attributes, q.Attributes)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT DISTINCT ON dir name FROM .",
+		"SELECT DISTINCT ON (foo) name FROM .",
+		"SELECT DISTINCT ON (dir name FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseWindowFunctions(t *testing.T) {
+	input := "SELECT name, ROW_NUMBER() OVER (PARTITION BY ext ORDER BY size DESC) AS rank," +
+		" DENSE_RANK() OVER w FROM . WHERE size > 5 WINDOW w AS (ORDER BY time) ORDER BY name"
+
+	q, err := RunParser(input)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := []Column{
+		{Attribute: "name"},
+		{
+			Window: &WindowFunction{Type: RowNumber, WindowSpec: WindowSpec{
+				PartitionBy: []string{"ext"},
+				OrderBy:     []Ordering{{Attribute: "size", Desc: true}},
+			}},
+			Alias: "rank",
+		},
+		{Window: &WindowFunction{Type: DenseRank, WindowName: "w", WindowSpec: WindowSpec{
+			OrderBy: []Ordering{{Attribute: "time"}},
+		}}},
+	}
+	if !reflect.DeepEqual(q.Columns, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Columns)
+	}
+
+	names := []string{q.Columns[0].Name(), q.Columns[1].Name(), q.Columns[2].Name()}
+	if !reflect.DeepEqual(names, []string{"name", "rank", "dense_rank"}) {
+		t.Fatalf("\nExpected column names name, rank, dense_rank\n     Got %v", names)
+	}
+
+	if q.ConditionTree == nil || len(q.OrderBy) != 1 {
+		t.Fatalf("\nExpected WHERE and ORDER BY clauses\n     Got %v and %v", q.ConditionTree, q.OrderBy)
+	}
+
+	q, err = RunParser("SELECT ROW_NUMBER(), name FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn := &WindowFunction{Type: RowNumber, InOutputOrder: true}
+	if !reflect.DeepEqual(q.Columns[0].Window, fn) || q.Columns[0].Name() != "row_number" {
+		t.Fatalf("\nExpected %v named row_number\n     Got %v", fn, q.Columns[0])
+	}
+
+	q, err = RunParser("SELECT LAG(size, 2) OVER (ORDER BY time), LEAD(ext) OVER () FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected = []Column{
+		{Window: &WindowFunction{Type: Lag, WindowSpec: WindowSpec{
+			OrderBy: []Ordering{{Attribute: "time"}},
+		}, Attribute: "size", Offset: 2}},
+		{Window: &WindowFunction{Type: Lead, Attribute: "ext", Offset: 1}},
+	}
+	if !reflect.DeepEqual(q.Columns, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Columns)
+	}
+	if !q.HasAttribute("size", "ext") {
+		t.Fatalf("\nExpected size and ext to be attributes of the query\n     Got %v", q.Attributes)
+	}
+
+	q, err = RunParser("SELECT FIRST_VALUE(name) OVER w, LAST_VALUE(size) OVER w FROM . WINDOW w AS ()")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected = []Column{
+		{Window: &WindowFunction{Type: FirstValue, WindowName: "w", WindowSpec: WindowSpec{}, Attribute: "name"}},
+		{Window: &WindowFunction{Type: LastValue, WindowName: "w", WindowSpec: WindowSpec{}, Attribute: "size"}},
+	}
+	if !reflect.DeepEqual(q.Columns, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Columns)
+	}
+
+	q, err = RunParser("SELECT NTILE(4) OVER (ORDER BY size) AS quartile FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn = &WindowFunction{Type: Ntile, WindowSpec: WindowSpec{
+		OrderBy: []Ordering{{Attribute: "size"}},
+	}, Buckets: 4}
+	if !reflect.DeepEqual(q.Columns[0].Window, fn) {
+		t.Fatalf("\nExpected %v\n     Got %v", fn, q.Columns[0].Window)
+	}
+
+	for _, input := range []string{
+		"SELECT RANK() FROM .",
+		"SELECT NTILE() OVER () FROM .",
+		"SELECT NTILE(0) OVER () FROM .",
+		"SELECT NTILE(-2) OVER () FROM .",
+		"SELECT NTILE(size) OVER () FROM .",
+		"SELECT FIRST_VALUE() OVER () FROM .",
+		"SELECT FIRST_VALUE(size, 1) OVER () FROM .",
+		"SELECT LAST_VALUE(size) FROM .",
+		"SELECT LAG() OVER () FROM .",
+		"SELECT LAG(foo) OVER () FROM .",
+		"SELECT LAG(size, -1) OVER () FROM .",
+		"SELECT LEAD(size, one) OVER () FROM .",
+		"SELECT LEAD(size, 1) FROM .",
+		"SELECT RANK(size) OVER () FROM .",
+		"SELECT DENSE_RANK() FROM .",
+		"SELECT CUME_DIST() FROM .",
+		"SELECT ROW_NUMBER( FROM .",
+		"SELECT RANK OVER () FROM .",
+		"SELECT RANK() OVER (PARTITION size) FROM .",
+		"SELECT RANK() OVER (ORDER BY foo) FROM .",
+		"SELECT RANK() OVER w FROM .",
+		"SELECT RANK() OVER w FROM . WINDOW v AS ()",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseUnknownComparator(t *testing.T) {
+	_, err := RunParser("SELECT name FROM . WHERE size ! 1000")
+
+	expected := &ErrUnknownToken{Raw: "!", Line: 1, Column: 31}
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, err)
+	}
+}
+
+func TestParserPeekToken(t *testing.T) {
+	p := &parser{tokenizer: NewTokenizer("ORDER BY size")}
+
+	if tok := p.peekToken(2); tok == nil || tok.Raw != "size" {
+		t.Fatalf("\nExpected size\n     Got %v", tok)
+	}
+	if tok := p.peekToken(3); tok != nil {
+		t.Fatalf("\nExpected nil\n     Got %v", tok)
+	}
+	for _, expected := range []TokenType{Order, By, Identifier} {
+		if tok := p.next(); tok == nil || tok.Type != expected {
+			t.Fatalf("\nExpected %s\n     Got %v", expected, tok)
+		}
+	}
+	if tok := p.next(); tok != nil {
+		t.Fatalf("\nExpected nil\n     Got %v", tok)
+	}
+}
+
+func TestParseOrderAttribute(t *testing.T) {
+	q, err := RunParser("SELECT name FROM . WHERE order = 5 ORDER BY size")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := &Condition{Attribute: "order", Comparator: Equals, Value: "5"}
+	if q.ConditionTree == nil || !reflect.DeepEqual(q.ConditionTree.Condition, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.ConditionTree)
+	}
+	if !reflect.DeepEqual(q.OrderBy, []Ordering{{Attribute: "size"}}) {
+		t.Fatalf("\nExpected ORDER BY size\n     Got %v", q.OrderBy)
+	}
+}
+
+func TestParsePragma(t *testing.T) {
+	q, err := RunParser("PRAGMA Case_Sensitive = false; PRAGMA max_depth = 2 SELECT name FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := []PragmaStatement{{"case_sensitive", "false"}, {"max_depth", "2"}}
+	if !reflect.DeepEqual(q.Pragmas, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Pragmas)
+	}
+
+	for _, input := range []string{
+		"PRAGMA SELECT name FROM .",
+		"PRAGMA max_depth 2; SELECT name FROM .",
+		"PRAGMA max_depth =; SELECT name FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseRebuildIndex(t *testing.T) {
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"REBUILD INDEX", []string{"."}},
+		{"rebuild index FROM a, b", []string{"a", "b"}},
+		{"PRAGMA max_depth = 2; REBUILD INDEX FROM a", []string{"a"}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !q.RebuildIndex || !reflect.DeepEqual(q.Sources["include"], c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.Sources["include"])
+		}
+	}
+
+	for _, input := range []string{
+		"REBUILD",
+		"REBUILD a",
+		"REBUILD INDEX a",
+		"REBUILD INDEX FROM a, -b",
+		`REBUILD INDEX FROM (VALUES ("a.go", 100)) AS t(name, size)`,
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseOperation(t *testing.T) {
+	type Case struct {
+		input     string
+		operation FileOperation
+		sources   []string
+		condition string
+	}
+
+	cases := []Case{
+		{"DELETE FROM .", FileOperation{Type: "DELETE"}, []string{"."}, "(nil)"},
+		{"delete FROM a, b WHERE name LIKE %.tmp", FileOperation{Type: "DELETE"}, []string{"a", "b"},
+			"(name like %.tmp)"},
+		{`MOVE FROM . WHERE ext IS ".tmp" TO /archive`, FileOperation{Type: "MOVE", Target: "/archive"},
+			[]string{"."}, "(ext is .tmp)"},
+		{"COPY FROM src TO backup", FileOperation{Type: "COPY", Target: "backup"}, []string{"src"}, "(nil)"},
+		{"MOVE FROM . WHERE name LIKE %.tmp TO /archive atomic",
+			FileOperation{Type: "MOVE", Target: "/archive", Atomic: true}, []string{"."}, "(name like %.tmp)"},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Operation == nil || *q.Operation != c.operation {
+			t.Fatalf("\nExpected %v\n     Got %v", c.operation, q.Operation)
+		}
+		if !reflect.DeepEqual(q.Sources["include"], c.sources) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.sources, q.Sources["include"])
+		}
+		if actual := conditionString(q.ConditionTree); actual != c.condition {
+			t.Fatalf("\nExpected %s\n     Got %s", c.condition, actual)
+		}
+	}
+
+	for _, input := range []string{
+		"DELETE",
+		"DELETE FROM",
+		"DELETE FROM . TO /archive",
+		"MOVE FROM .",
+		"MOVE FROM . WHERE size > 1",
+		"COPY FROM . TO",
+		"COPY FROM . TO /archive ATOMIC",
+		`COPY FROM (VALUES ("a.go", 100)) AS t(name, size) TO /archive`,
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseAssert(t *testing.T) {
+	type Case struct {
+		input    string
+		expected Assertion
+	}
+
+	cases := []Case{
+		{
+			"ASSERT (SELECT COUNT(*) FROM . WHERE name LIKE %.pem) = 0",
+			Assertion{Comparator: Equals, Value: "0"},
+		},
+		{
+			`assert (SELECT name FROM a) <> "b" message 'No b allowed'`,
+			Assertion{Comparator: NotEquals, Value: "b", Message: "No b allowed"},
+		},
+		{
+			"PRAGMA max_depth = 1; ASSERT (SELECT MEDIAN(size) FROM .) <= 100",
+			Assertion{Comparator: LessThanEquals, Value: "100"},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Assert == nil || q.Assert.Query == nil {
+			t.Fatalf("\nExpected an assertion for %q", c.input)
+		}
+		actual := *q.Assert
+		actual.Query = nil
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	for _, input := range []string{
+		"ASSERT",
+		"ASSERT SELECT COUNT(*) FROM . = 0",
+		"ASSERT (SELECT COUNT(*) FROM .)",
+		"ASSERT (SELECT COUNT(*) FROM .) = ",
+		"ASSERT (SELECT COUNT(*) FROM .) LIKE 0",
+		"ASSERT (SELECT name, size FROM .) = 0",
+		"ASSERT (SELECT * FROM .) = 0",
+		"ASSERT (SELECT name INTO @x FROM .) = 0",
+		"ASSERT (SELECT COUNT(*) FROM .) = 0 MESSAGE",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseJump(t *testing.T) {
+	file, err := RunFileParser("LABEL start; GOTO start; " +
+		"if (SELECT COUNT(*) FROM . WHERE name = go.mod) > 0 goto has_go_mod; LABEL has_go_mod")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	type Jump struct {
+		label, target string
+		condition     bool
+	}
+	expected := []Jump{{"start", "", false}, {"", "start", false}, {"", "has_go_mod", true}, {"has_go_mod", "", false}}
+	actual := make([]Jump, 0)
+	for _, q := range file.Queries {
+		actual = append(actual, Jump{q.Label, q.Goto, q.If != nil})
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+	if c := file.Queries[2].If; c.Comparator != GreaterThan || c.Value != "0" || len(c.Query.Columns) != 1 {
+		t.Fatalf("\nExpected the condition COUNT(*) > 0\n     Got %v", c)
+	}
+
+	for _, input := range []string{
+		"LABEL",
+		"GOTO",
+		"IF (SELECT COUNT(*) FROM .) > 0",
+		"IF (SELECT COUNT(*) FROM .) > 0 GOTO",
+		"IF (SELECT name, size FROM .) > 0 GOTO a",
+		"PRAGMA max_depth = 1; GOTO a",
+		"LABEL a; SELECT name FROM .; LABEL a",
+	} {
+		if _, err := RunFileParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseRaise(t *testing.T) {
+	type Case struct {
+		input    string
+		expected RaiseStatement
+	}
+
+	cases := []Case{
+		{`RAISE NOTICE "done"`, RaiseStatement{Level: Notice, Message: []MessagePart{{Text: "done"}}}},
+		{
+			`raise warning "Found files larger than 1GB: " || @count`,
+			RaiseStatement{Level: Warning, Message: []MessagePart{{Text: "Found files larger than 1GB: "}, {Variable: "count"}}},
+		},
+		{
+			`RAISE ERROR @a || ' and ' || @b`,
+			RaiseStatement{Level: Error, Message: []MessagePart{{Variable: "a"}, {Text: " and "}, {Variable: "b"}}},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Raise == nil || !reflect.DeepEqual(*q.Raise, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.Raise)
+		}
+	}
+
+	for _, input := range []string{
+		"RAISE",
+		"RAISE 'message'",
+		"RAISE INFO 'message'",
+		"RAISE NOTICE",
+		"RAISE NOTICE 'a' ||",
+		"RAISE NOTICE 'a' 'b'",
+		"PRAGMA max_depth = 1; RAISE NOTICE 'a'",
+	} {
+		if _, err := RunFileParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseCase(t *testing.T) {
+	leaf := func(attribute string, comparator TokenType, value string) *ConditionNode {
+		return &ConditionNode{Condition: &Condition{Attribute: attribute, Comparator: comparator, Value: value}}
+	}
+
+	type Case struct {
+		input    string
+		expected *CaseExpression
+	}
+
+	cases := []Case{
+		{
+			`SELECT CASE WHEN size < 1024 THEN "tiny" WHEN size < 1048576 THEN "small" ELSE "large" END AS category FROM .`,
+			&CaseExpression{
+				Branches: []CaseBranch{
+					{When: leaf("size", LessThan, "1024"), Then: CaseResult{Value: "tiny"}},
+					{When: leaf("size", LessThan, "1048576"), Then: CaseResult{Value: "small"}},
+				},
+				Else: &CaseResult{Value: "large"},
+			},
+		},
+		{
+			`SELECT name, case size when 0 then "empty" end FROM .`,
+			&CaseExpression{Branches: []CaseBranch{{When: leaf("size", Equals, "0"), Then: CaseResult{Value: "empty"}}}},
+		},
+		{
+			"SELECT CASE WHEN name LIKE %.go AND size > 0 THEN CASE WHEN size > 10 THEN big END ELSE other END FROM .",
+			&CaseExpression{
+				Branches: []CaseBranch{{
+					When: &ConditionNode{Type: And, Left: leaf("name", Like, "%.go"), Right: leaf("size", GreaterThan, "0")},
+					Then: CaseResult{Case: &CaseExpression{
+						Branches: []CaseBranch{{When: leaf("size", GreaterThan, "10"), Then: CaseResult{Value: "big"}}},
+					}},
+				}},
+				Else: &CaseResult{Value: "other"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		var actual *CaseExpression
+		for _, column := range q.Columns {
+			if column.Case != nil {
+				actual = column.Case
+			}
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+
+	q, err := RunParser("SELECT name FROM . WHERE CASE WHEN size > 0 THEN full END = full AND name LIKE %.go")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if c := q.ConditionTree.Left.Condition; c == nil || c.Attribute != "case" || c.Case == nil ||
+		c.Comparator != Equals || c.Value != "full" {
+		t.Fatalf("\nExpected a condition on CASE\n     Got %v", q.ConditionTree.Left)
+	}
+
+	for _, input := range []string{
+		"SELECT CASE END FROM .",
+		"SELECT CASE WHEN size > 0 END FROM .",
+		"SELECT CASE WHEN size > 0 THEN a FROM .",
+		"SELECT CASE WHEN size > 0 THEN a ELSE END FROM .",
+		"SELECT CASE foo WHEN 0 THEN a END FROM .",
+		"SELECT CASE size THEN a END FROM .",
+		"SELECT CASE WHEN EXISTS (SELECT name FROM a) THEN a END FROM .",
+		"SELECT CASE WHEN size > 0 THEN a END, COUNT(*) FROM .",
+		"SELECT CASE WHEN size > 0 THEN a END, name FROM . GROUP BY name",
+		"SELECT CASE WHEN a.size > 0 THEN a END FROM a JOIN b ON a.name = b.name",
+		"SELECT CASE WHEN size > 0 THEN a END FROM . UNPIVOT (v FOR n IN (size, mode))",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseIif(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	// IIF is parsed as the CASE expression with a branch and ELSE.
+	cases := []Case{
+		{
+			`SELECT IIF(size > 1mb, "big", "small") FROM .`,
+			`SELECT CASE WHEN size > 1mb THEN "big" ELSE "small" END FROM .`,
+		},
+		{
+			"SELECT iif(name LIKE %.go OR (size = 0 AND NOT name = a), a, iif(size > 0, b, c)) FROM .",
+			"SELECT CASE WHEN name LIKE %.go OR (size = 0 AND NOT name = a) THEN a " +
+				"ELSE CASE WHEN size > 0 THEN b ELSE c END END FROM .",
+		},
+		{
+			"SELECT name FROM . WHERE IIF(size > 0, full, empty) = full",
+			"SELECT name FROM . WHERE CASE WHEN size > 0 THEN full ELSE empty END = full",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected, err := RunParser(c.expected)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.expected, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT IIF FROM .",
+		"SELECT IIF() FROM .",
+		"SELECT IIF(size > 0) FROM .",
+		"SELECT IIF(size > 0, a) FROM .",
+		"SELECT IIF(size > 0, a, b, c) FROM .",
+		"SELECT IIF(size > 0, a, b FROM .",
+		"SELECT IIF(, a, b) FROM .",
+		"SELECT IIF(EXISTS (SELECT name FROM a), a, b) FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseNullIf(t *testing.T) {
+	sizeIs := func(value string) *ConditionNode {
+		return &ConditionNode{Condition: &Condition{Attribute: "size", Comparator: Equals, Value: value}}
+	}
+
+	type Case struct {
+		input    string
+		expected *CaseExpression
+	}
+
+	// NULLIF is parsed as a CASE expression which is NULL when the attribute
+	// is equal to the value, and COALESCE of it replaces the NULL result.
+	cases := []Case{
+		{"SELECT NULLIF(size, 0) FROM .", &CaseExpression{
+			Branches: []CaseBranch{{When: sizeIs("0"), Then: CaseResult{Null: true}}},
+			Else:     &CaseResult{Attribute: "size"},
+		}},
+		{"SELECT nullif(name, 'a b') FROM .", &CaseExpression{
+			Branches: []CaseBranch{{
+				When: &ConditionNode{Condition: &Condition{Attribute: "name", Comparator: Equals, Value: "a b"}},
+				Then: CaseResult{Null: true},
+			}},
+			Else: &CaseResult{Attribute: "name"},
+		}},
+		{"SELECT COALESCE(NULLIF(size, 0), 1) FROM .", &CaseExpression{
+			Branches: []CaseBranch{{When: sizeIs("0"), Then: CaseResult{Value: "1"}}},
+			Else:     &CaseResult{Attribute: "size"},
+		}},
+		{"SELECT COALESCE(IIF(size = 0, NULLIF(size, 1), a), b) FROM .", &CaseExpression{
+			Branches: []CaseBranch{{When: sizeIs("0"), Then: CaseResult{Case: &CaseExpression{
+				Branches: []CaseBranch{{When: sizeIs("1"), Then: CaseResult{Value: "b"}}},
+				Else:     &CaseResult{Attribute: "size"},
+			}}}},
+			Else: &CaseResult{Value: "a"},
+		}},
+		{"SELECT COALESCE(CASE WHEN size = 0 THEN a END, NULLIF(size, 1), b) FROM .", &CaseExpression{
+			Branches: []CaseBranch{{When: sizeIs("0"), Then: CaseResult{Value: "a"}}},
+			Else: &CaseResult{Case: &CaseExpression{
+				Branches: []CaseBranch{{When: sizeIs("1"), Then: CaseResult{Value: "b"}}},
+				Else:     &CaseResult{Attribute: "size"},
+			}},
+		}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.Columns[0].Case, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.Columns[0].Case)
+		}
+	}
+
+	// NULLIF in WHERE is compared like a CASE expression.
+	q, err := RunParser("SELECT name FROM . WHERE NULLIF(size, 0) IS NULL")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if c := q.ConditionTree.Condition; c == nil || c.Case == nil || !reflect.DeepEqual(c.Case.Else, &CaseResult{Attribute: "size"}) {
+		t.Fatalf("\nExpected NULLIF condition\n     Got %v", q.ConditionTree)
+	}
+
+	for _, input := range []string{
+		"SELECT NULLIF FROM .",
+		"SELECT NULLIF() FROM .",
+		"SELECT NULLIF(size) FROM .",
+		"SELECT NULLIF(size, 0, 1) FROM .",
+		"SELECT NULLIF(foo, 0) FROM .",
+		"SELECT NULLIF(size, 0 FROM .",
+		"SELECT COALESCE(NULLIF(size, 0)) FROM .",
+		"SELECT COALESCE(NULLIF(size, 0), 1 FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseArithmetic(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	// * / % are evaluated before + -, and operators with the same precedence
+	// from left to right.
+	cases := []Case{
+		{"SELECT size + depth * 2 FROM .", "SELECT size + (depth * 2) FROM ."},
+		{"SELECT size - depth - 1 FROM .", "SELECT (size - depth) - 1 FROM ."},
+		{"SELECT size / 2 * 3 % 4 FROM .", "SELECT ((size / 2) * 3) % 4 FROM ."},
+		{"SELECT size * 2 + depth / 3 - 1 FROM .", "SELECT ((size * 2) + (depth / 3)) - 1 FROM ."},
+		{"SELECT -size * 2 FROM .", "SELECT (-size) * 2 FROM ."},
+		{"SELECT size - -1 FROM .", "SELECT size - (-1) FROM ."},
+		{"SELECT name FROM . WHERE 1 + size * 2 > 1mb", "SELECT name FROM . WHERE 1 + (size * 2) > 1mb"},
+	}
+
+	for _, c := range cases {
+		actual, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected, err := RunParser(c.expected)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.expected, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+		}
+	}
+
+	q, err := RunParser("SELECT name, size / 1024 AS kb, (size + 1) * -depth, size / (depth - 1.5) FROM / WHERE -size <= -10")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := &Expression{
+		Operator: Slash,
+		Left:     &Expression{Attribute: "size"},
+		Right:    &Expression{Value: "1024"},
+	}
+	if !reflect.DeepEqual(q.Columns[1].Expression, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Columns[1].Expression)
+	}
+
+	// Columns are named with their expressions, with only the parentheses
+	// their precedence requires.
+	names := []string{q.Columns[1].Name(), q.Columns[2].Name(), q.Columns[3].Name()}
+	if expected := []string{"kb", "(size + 1) * -depth", "size / (depth - 1.5)"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, names)
+	}
+
+	c := q.ConditionTree.Condition
+	if c.Attribute != "-size" || c.Expression == nil || c.Comparator != LessThanEquals || c.Value != "-10" {
+		t.Fatalf("\nExpected -size <= -10\n     Got %v", c)
+	}
+	if !reflect.DeepEqual(q.Sources["include"], []string{"/"}) {
+		t.Fatalf("\nExpected source /\n     Got %v", q.Sources["include"])
+	}
+
+	for _, input := range []string{
+		"SELECT size + FROM .",
+		"SELECT size * * 2 FROM .",
+		"SELECT name + 1 FROM .",
+		"SELECT size + foo FROM .",
+		"SELECT (size + 1 FROM .",
+		"SELECT COUNT(*), size + 1 FROM . GROUP BY size",
+		"SELECT name FROM . WHERE size * 2 >",
+		"SELECT name FROM . WHERE size * ext > 1",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseBitwise(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	// ~ (and -) before * / % before + - before shifts before & before ^
+	// before |.
+	cases := []Case{
+		{"SELECT 1 | 2 ^ 3 & 4 FROM .", "SELECT 1 | (2 ^ (3 & 4)) FROM ."},
+		{"SELECT mode & 1 << 2 + 3 FROM .", "SELECT mode & (1 << (2 + 3)) FROM ."},
+		{"SELECT size >> 10 * 8 FROM .", "SELECT size >> (10 * 8) FROM ."},
+		{"SELECT ~size & ~0 FROM .", "SELECT (~size) & (~0) FROM ."},
+		{"SELECT 1 << 2 >> 3 FROM .", "SELECT (1 << 2) >> 3 FROM ."},
+		{"SELECT name FROM . WHERE mode & 0644 = 0644", "SELECT name FROM . WHERE mode & 0644 = 0644"},
+	}
+
+	for _, c := range cases {
+		actual, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected, err := RunParser(c.expected)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.expected, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+		}
+	}
+
+	q, err := RunParser("SELECT (size >> 10) * 8, ~0, mode | 0x10 FROM . WHERE ~mode & 0111 <> 0")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	names := []string{q.Columns[0].Name(), q.Columns[1].Name(), q.Columns[2].Name(), q.ConditionTree.Condition.Attribute}
+	if expected := []string{"(size >> 10) * 8", "~0", "mode | 0x10", "~mode & 0111"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, names)
+	}
+
+	// The operands of the bitwise operators must be integers.
+	for _, input := range []string{
+		"SELECT size & 1.5 FROM .",
+		"SELECT 2.0 << 1 FROM .",
+		"SELECT ~1e3 FROM .",
+		"SELECT (size / 2.0) | 1 FROM .",
+		"SELECT size & FROM .",
+		"SELECT name & 1 FROM .",
+		"SELECT 0x FROM . WHERE 0x1g & 1 = 1",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseChain(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	// Each adjacent pair of a chain's terms is compared, combined with AND,
+	// and the attribute of each pair is compared with the other term.
+	cases := []Case{
+		{"SELECT name FROM . WHERE 1024 < size < 1048576", "SELECT name FROM . WHERE size > 1024 AND size < 1048576"},
+		{"SELECT name FROM . WHERE 1 <= size <= 1mb", "SELECT name FROM . WHERE size >= 1 AND size <= 1mb"},
+		{"SELECT name FROM . WHERE 1 > size > 0", "SELECT name FROM . WHERE size < 1 AND size > 0"},
+		{"SELECT name FROM . WHERE 10 >= depth > 0", "SELECT name FROM . WHERE depth <= 10 AND depth > 0"},
+		{"SELECT name FROM . WHERE size < 10 <= size", "SELECT name FROM . WHERE size < 10 AND size >= 10"},
+		{
+			"SELECT name FROM . WHERE 0 < depth < 3 < size <= 1kb",
+			"SELECT name FROM . WHERE depth > 0 AND depth < 3 AND size > 3 AND size <= 1kb",
+		},
+		{
+			"SELECT name FROM . WHERE name = a OR 1 < size < 5 AND depth = 1",
+			"SELECT name FROM . WHERE name = a OR (size > 1 AND size < 5) AND depth = 1",
+		},
+		{"SELECT name FROM . WHERE NOT 1 < size < 5", "SELECT name FROM . WHERE NOT size > 1 OR NOT size < 5"},
+		{"SELECT name FROM . WHERE size * 2 < 10 < size", "SELECT name FROM . WHERE size * 2 < 10 AND size > 10"},
+		{
+			"SELECT name, ROW_NUMBER() OVER (ORDER BY size) AS rn FROM . QUALIFY 1 < rn <= 3",
+			"SELECT name, ROW_NUMBER() OVER (ORDER BY size) AS rn FROM . QUALIFY rn > 1 AND rn <= 3",
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected, err := RunParser(c.expected)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.expected, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+		}
+	}
+
+	// The conditions are evaluated in order, and the rest aren't once one
+	// isn't satisfied.
+	q, err := RunParser("SELECT name FROM . WHERE 1 < size < 10 < depth")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	evaluated := make([]string, 0)
+	q.ConditionTree.Evaluate(nil, func(c Condition, info os.FileInfo) bool {
+		evaluated = append(evaluated, c.Attribute+c.Comparator.String()+c.Value)
+		return c.Comparator == GreaterThan && c.Attribute == "size"
+	})
+	if expected := []string{"sizegreater-than1", "sizeless-than10"}; !reflect.DeepEqual(evaluated, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, evaluated)
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . WHERE 1 < size > 0",
+		"SELECT name FROM . WHERE 10 > size <= 1",
+		"SELECT name FROM . WHERE 1 < size < 5 > 2",
+		"SELECT name FROM . WHERE 1 = size < 5",
+		"SELECT name FROM . WHERE 1 < size = 5",
+		"SELECT name FROM . WHERE name LIKE a < b",
+		"SELECT name FROM . WHERE 1 < size <",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseQuantified(t *testing.T) {
+	type Case struct {
+		input      string
+		comparator TokenType
+		quantifier TokenType
+		column     Column
+	}
+
+	// A comparison by order with the values of a numeric attribute is
+	// rewritten to a comparison with their MAX or MIN.
+	max := func(attribute string) Column {
+		return Column{Aggregate: &AggregateFunction{Type: Max, Attribute: attribute}}
+	}
+	min := func(attribute string) Column {
+		return Column{Aggregate: &AggregateFunction{Type: Min, Attribute: attribute}}
+	}
+
+	cases := []Case{
+		{"SELECT name FROM . WHERE size > ALL (SELECT size FROM /tmp WHERE name LIKE %.log)", GreaterThan, All, max("size")},
+		{"SELECT name FROM . WHERE size >= all(SELECT size FROM /tmp)", GreaterThanEquals, All, max("size")},
+		{"SELECT name FROM . WHERE size > ANY (SELECT size FROM /tmp)", GreaterThan, Any, min("size")},
+		{"SELECT name FROM . WHERE size > SOME (SELECT size FROM /tmp)", GreaterThan, Any, min("size")},
+		{"SELECT name FROM . WHERE depth < ALL (SELECT depth FROM /tmp)", LessThan, All, min("depth")},
+		{"SELECT name FROM . WHERE depth <= ANY (SELECT depth FROM /tmp)", LessThanEquals, Any, max("depth")},
+
+		// Otherwise, the subquery's column is kept.
+		{"SELECT name FROM . WHERE size = ANY (SELECT size FROM /tmp)", Equals, Any, Column{Attribute: "size"}},
+		{"SELECT name FROM . WHERE size <> ALL (SELECT size FROM /tmp)", NotEquals, All, Column{Attribute: "size"}},
+		{"SELECT name FROM . WHERE name > ALL (SELECT name FROM /tmp)", GreaterThan, All, Column{Attribute: "name"}},
+		{"SELECT name FROM . WHERE size > ALL (SELECT size FROM /tmp LIMIT 1)", GreaterThan, All, Column{Attribute: "size"}},
+		{"SELECT name FROM . WHERE size > ALL (SELECT MEDIAN(size) FROM /tmp)", GreaterThan, All,
+			Column{Aggregate: &AggregateFunction{Type: Median, Attribute: "size"}}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		actual := q.ConditionTree.Condition
+		if actual == nil || actual.Subquery == nil {
+			t.Fatalf("\nExpected a comparison with a subquery for %q\n     Got %v", c.input, q.ConditionTree)
+		}
+		if actual.Comparator != c.comparator || actual.Quantifier != c.quantifier ||
+			!reflect.DeepEqual(actual.Subquery.Columns, []Column{c.column}) {
+			t.Fatalf("\nExpected %v %v %v\n     Got %v %v %v", c.comparator, c.quantifier, c.column,
+				actual.Comparator, actual.Quantifier, actual.Subquery.Columns)
+		}
+	}
+
+	// ALL, ANY, and SOME are only keywords before a parenthesis.
+	q, err := RunParser("SELECT all FROM any WHERE name = some")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if !reflect.DeepEqual(q.Sources["include"], []string{"any"}) || q.ConditionTree.Condition.Value != "some" {
+		t.Fatalf("\nExpected source any and value some\n     Got %v %v", q.Sources, q.ConditionTree)
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . WHERE size > ALL (SELECT name, size FROM /tmp)",
+		"SELECT name FROM . WHERE size > ALL (SELECT size FROM /tmp",
+		"SELECT name FROM . WHERE name LIKE ANY (SELECT name FROM /tmp)",
+		"SELECT name FROM . WHERE size * 2 > ALL (SELECT size FROM /tmp)",
+		"SELECT name FROM . WHERE XATTR(a) = ANY (SELECT name FROM /tmp)",
+		"SELECT name FROM . WHERE size > ALL (SELECT size FROM /tmp INTO out.csv)",
+		"SELECT name, COUNT(*) AS n FROM . GROUP BY name HAVING n > ALL (SELECT size FROM /tmp)",
+		"SELECT a.name FROM . AS a JOIN /tmp AS b ON a.name = b.name WHERE a.size > ALL (SELECT size FROM /tmp)",
+		"SELECT CASE WHEN size > ALL (SELECT size FROM /tmp) THEN big END FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseLimitExplain(t *testing.T) {
+	type Case struct {
+		input   string
+		limit   int
+		explain bool
+		analyze bool
+	}
+
+	cases := []Case{
+		{"SELECT name FROM .", -1, false, false},
+		{"SELECT name FROM . LIMIT 10", 10, false, false},
+		{"SELECT name FROM . WHERE size > 10 ORDER BY size LIMIT 0", 0, false, false},
+		{"EXPLAIN SELECT name FROM . LIMIT 5", 5, true, false},
+		{"explain analyze SELECT name FROM .", -1, true, true},
+		{"EXPLAIN SELECT name FROM analyze", -1, true, false},
+		{"EXPLAIN ANALYZE WITH a AS (SELECT * FROM .) SELECT name FROM a", -1, true, true},
+		{"SELECT TOP 10 name FROM . ORDER BY size DESC", 10, false, false},
+		{"SELECT TOP 3 DISTINCT ext FROM .", 3, false, false},
+		{"SELECT TOP 5 FROM .", 5, false, false},
+		{"SELECT TOP 5 name FROM . LIMIT 2", 2, false, false},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Limit != c.limit || q.Explain != c.explain || q.Analyze != c.analyze {
+			t.Fatalf("\nExpected limit %d, explain %t, analyze %t\n     Got %d, %t, %t",
+				c.limit, c.explain, c.analyze, q.Limit, q.Explain, q.Analyze)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . LIMIT",
+		"SELECT name FROM . LIMIT -1",
+		"SELECT name FROM . LIMIT ten",
+		"SELECT TOP name FROM .",
+		"SELECT TOP -1 name FROM .",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseTopWarning(t *testing.T) {
+	q, err := RunParser("SELECT TOP 5 name FROM . LIMIT 2")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := []string{"TOP 5 is overridden by LIMIT 2"}; !reflect.DeepEqual(q.Warnings, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Warnings)
+	}
+
+	q, _ = RunParser("SELECT TOP 5 name FROM . LIMIT 5")
+	if len(q.Warnings) != 0 {
+		t.Fatalf("\nExpected no warnings\n     Got %v", q.Warnings)
+	}
+}
+
+func TestParseFetch(t *testing.T) {
+	type Case struct {
+		input    string
+		limit    int
+		offset   int
+		warnings []string
+	}
+
+	unordered := "FETCH without ORDER BY returns the first results in the order they're walked in"
+	cases := []Case{
+		{"SELECT name FROM . ORDER BY size FETCH FIRST 10 ROWS ONLY", 10, 0, nil},
+		{"SELECT name FROM . ORDER BY size FETCH NEXT 1 ROW ONLY", 1, 0, nil},
+		{"SELECT name FROM . ORDER BY size FETCH FIRST ROW ONLY", 1, 0, nil},
+		{"SELECT name FROM . ORDER BY size OFFSET 20 ROWS FETCH NEXT 10 ROWS ONLY", 10, 20, nil},
+		{"SELECT name FROM . ORDER BY size LIMIT 5 OFFSET 10", 5, 10, nil},
+		{"SELECT name FROM . OFFSET 1 ROW", -1, 1, nil},
+		{"SELECT name FROM . WHERE size > 0 FETCH FIRST 0 ROWS ONLY", 0, 0, []string{unordered}},
+		{"SELECT TOP 5 name FROM . ORDER BY size FETCH FIRST 2 ROWS ONLY", 2, 0,
+			[]string{"TOP 5 is overridden by FETCH 2"}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Limit != c.limit || q.Offset != c.offset || !reflect.DeepEqual(q.Warnings, c.warnings) {
+			t.Fatalf("\nExpected limit %d, offset %d, warnings %v\n     Got %d, %d, %v",
+				c.limit, c.offset, c.warnings, q.Limit, q.Offset, q.Warnings)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . ORDER BY size FETCH 10 ROWS ONLY",
+		"SELECT name FROM . ORDER BY size FETCH FIRST 10 ONLY",
+		"SELECT name FROM . ORDER BY size FETCH FIRST 10 ROWS",
+		"SELECT name FROM . ORDER BY size FETCH FIRST -1 ROWS ONLY",
+		"SELECT name FROM . ORDER BY size LIMIT 5 FETCH FIRST 5 ROWS ONLY",
+		"SELECT name FROM . OFFSET -1",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseQualifyHaving(t *testing.T) {
+	q, err := RunParser("SELECT ext, COUNT(*) AS n FROM . GROUP BY ext HAVING n > 1 AND ext = .go ORDER BY ext")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := &ConditionNode{
+		Type:  And,
+		Left:  &ConditionNode{Condition: &Condition{Attribute: "n", Comparator: GreaterThan, Value: "1"}},
+		Right: &ConditionNode{Condition: &Condition{Attribute: "ext", Comparator: Equals, Value: ".go"}},
+	}
+	if !reflect.DeepEqual(q.Having, expected) || !reflect.DeepEqual(q.OrderBy, []Ordering{{Attribute: "ext"}}) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Having)
+	}
+
+	q, err = RunParser("SELECT name, RANK() OVER w AS r FROM . WHERE size > 1 WINDOW w AS (ORDER BY size) QUALIFY r <= 3 LIMIT 2")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected = &ConditionNode{Condition: &Condition{Attribute: "r", Comparator: LessThanEquals, Value: "3"}}
+	if !reflect.DeepEqual(q.Qualify, expected) || q.Limit != 2 || q.ConditionTree == nil {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Qualify)
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . HAVING size > 1",
+		"SELECT ext, COUNT(*) AS n FROM . GROUP BY ext HAVING size > 1",
+		"SELECT ext, COUNT(*) AS n FROM . GROUP BY ext HAVING foo > 1",
+		"SELECT name, RANK() OVER (ORDER BY size) AS r FROM . QUALIFY foo <= 3",
+		"SELECT name, RANK() OVER (ORDER BY size) AS r FROM . QUALIFY",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseUnpivot(t *testing.T) {
+	q, err := RunParser("SELECT name, k, v AS value FROM . UNPIVOT (v FOR k IN (size, mode)) WHERE size > 1")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := &UnpivotClause{ValueColumn: "v", NameColumn: "k", Attributes: []string{"size", "mode"}}
+	if !reflect.DeepEqual(q.Unpivot, expected) || q.ConditionTree == nil {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Unpivot)
+	}
+	columns := []Column{
+		{Attribute: "name"},
+		{Unpivot: expected, Unpivoted: "k"},
+		{Unpivot: expected, Unpivoted: "v", Alias: "value"},
+	}
+	if !reflect.DeepEqual(q.Columns, columns) {
+		t.Fatalf("\nExpected %v\n     Got %v", columns, q.Columns)
+	}
+
+	for _, input := range []string{
+		"SELECT k FROM .",
+		"SELECT k, x FROM . UNPIVOT (v FOR k IN (size))",
+		"SELECT k, v FROM . UNPIVOT v FOR k IN (size)",
+		"SELECT k, v FROM . UNPIVOT (v k IN (size))",
+		"SELECT k, v FROM . UNPIVOT (v FOR k (size))",
+		"SELECT k, v FROM . UNPIVOT (v FOR k IN ())",
+		"SELECT k, v FROM . UNPIVOT (v FOR k IN (foo))",
+		"SELECT k, v FROM . UNPIVOT (v FOR k IN (size)",
+		"SELECT k FROM . UNPIVOT (k FOR k IN (size))",
+		"SELECT size FROM . UNPIVOT (size FOR k IN (mode))",
+		"SELECT k, COUNT(*) FROM . UNPIVOT (v FOR k IN (size))",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	q, err := RunParser(`SELECT name FROM (VALUES ("a.go", 100), ('b.py', 200)) AS t(name, size), . WHERE size > 100`)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	expected := map[string]*ValuesTable{
+		"t": {
+			Columns: []string{"name", "size"},
+			Rows:    []ValuesRow{{Name: "a.go", Size: 100}, {Name: "b.py", Size: 200}},
+		},
+	}
+	if !reflect.DeepEqual(q.Values, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Values)
+	}
+	if sources := []string{"t", "."}; !reflect.DeepEqual(q.Sources["include"], sources) {
+		t.Fatalf("\nExpected %v\n     Got %v", sources, q.Sources["include"])
+	}
+
+	q, err = RunParser(`SELECT time FROM (VALUES ("Jan 02 2020 10 00")) AS files(time)`)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if modTime := time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC); !q.Values["files"].Rows[0].ModTime.Equal(modTime) {
+		t.Fatalf("\nExpected %v\n     Got %v", modTime, q.Values["files"].Rows[0].ModTime)
+	}
+
+	for _, input := range []string{
+		`SELECT name FROM (VALUES ("a", 1))`,
+		`SELECT name FROM (VALUES ("a", 1)) AS t`,
+		`SELECT name FROM (VALUES ("a", 1)) AS t(name)`,
+		`SELECT name FROM (VALUES ("a", 1) AS t(name, size)`,
+		`SELECT name FROM (VALUES) AS t(name)`,
+		`SELECT name FROM (VALUES ()) AS t(name)`,
+		`SELECT name FROM (VALUES ("a")) AS t(mode)`,
+		`SELECT name FROM (VALUES ("a")) AS t(foo)`,
+		`SELECT name FROM (VALUES (ten)) AS t(size)`,
+		`SELECT name FROM (VALUES ("yesterday")) AS t(time)`,
+		`SELECT name FROM ("a", 1) AS t(name, size)`,
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseGenerateSeries(t *testing.T) {
+	type Case struct {
+		input    string
+		expected []string
+	}
+
+	cases := []Case{
+		{"SELECT size FROM GENERATE_SERIES(0, 1000, 100)", []string{"series://0,1000,100"}},
+		{"SELECT size FROM generate_series(1, 10)", []string{"series://1,10,1"}},
+		{"SELECT size FROM GENERATE_SERIES(10, -10, -2), .", []string{"series://10,-10,-2", "."}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.Sources["include"], c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.Sources["include"])
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT size FROM GENERATE_SERIES",
+		"SELECT size FROM GENERATE_SERIES()",
+		"SELECT size FROM GENERATE_SERIES(1)",
+		"SELECT size FROM GENERATE_SERIES(1, 10, 0)",
+		"SELECT size FROM GENERATE_SERIES(1, 10, 1, 1)",
+		"SELECT size FROM GENERATE_SERIES(1, ten)",
+		"SELECT size FROM GENERATE_SERIES(1, 10",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseJoin(t *testing.T) {
+	type Case struct {
+		input    string
+		expected *JoinClause
+	}
+
+	on := &ConditionNode{Condition: &Condition{Attribute: "a.name", Comparator: Equals, Value: "b.name"}}
+	cases := []Case{
+		{"SELECT a.name FROM . AS a JOIN src AS b ON a.name = b.name", &JoinClause{"inner", [2]string{"a", "b"}, on, nil, nil}},
+		{"SELECT a.name FROM . AS a INNER JOIN src AS b ON a.name = b.name", &JoinClause{"inner", [2]string{"a", "b"}, on, nil, nil}},
+		{"SELECT a.name, b.size FROM . AS a left join src AS b ON a.name = b.name WHERE b.size > 1", &JoinClause{"left", [2]string{"a", "b"}, on, nil, nil}},
+		{"SELECT a.name FROM . AS a LEFT OUTER JOIN src AS b ON a.name = b.name ORDER BY b.size", &JoinClause{"left", [2]string{"a", "b"}, on, nil, nil}},
+		{"SELECT a.name FROM . AS a CROSS JOIN src AS b", &JoinClause{"cross", [2]string{"a", "b"}, nil, nil, nil}},
+		{"SELECT a.name FROM (VALUES (\"a\")) AS a(name) JOIN src AS b ON a.name = b.name", &JoinClause{"inner", [2]string{"a", "b"}, on, nil, nil}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.Join, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.Join)
+		}
+		if !reflect.DeepEqual(q.Sources["include"], []string{q.Sources["include"][0], "src"}) {
+			t.Fatalf("\nExpected 2 sources\n     Got %v", q.Sources["include"])
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT a.name FROM . JOIN src AS b ON a.name = b.name",
+		"SELECT a.name FROM . AS a JOIN src ON a.name = b.name",
+		"SELECT a.name FROM . AS a JOIN src AS a ON a.name = a.name",
+		"SELECT a.name FROM . AS a JOIN src AS b",
+		"SELECT a.name FROM . AS a LEFT JOIN src AS b",
+		"SELECT a.name FROM ., src AS a JOIN src AS b ON a.name = b.name",
+		"SELECT a.name FROM . AS a JOIN -src AS b ON a.name = b.name",
+		"SELECT name FROM . AS a JOIN src AS b ON a.name = b.name",
+		"SELECT a.name FROM . AS a JOIN src AS b ON name = b.name",
+		"SELECT a.name FROM . AS a JOIN src AS b ON a.name = b.name WHERE c.size > 1",
+		"SELECT a.name FROM . AS a JOIN src AS b ON a.name = b.name ORDER BY size",
+		"SELECT COUNT(*) FROM . AS a JOIN src AS b ON a.name = b.name",
+		"SELECT a.name FROM . AS a JOIN src AS b ON a.name = b.name GROUP BY a.name",
+		"SELECT a.name FROM . AS a",
+		"SELECT a.name FROM .",
+		"SELECT name FROM . WHERE a.size > 1",
+		"SELECT name FROM . ORDER BY a.size",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseFullJoin(t *testing.T) {
+	q, err := RunParser("SELECT COALESCE(a.name, b.name) AS name FROM . AS a FULL OUTER JOIN src AS b ON a.name IS b.name ORDER BY COALESCE(a.name, b.name) DESC")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if q.Join == nil || q.Join.Type != "full" {
+		t.Fatalf("\nExpected a FULL JOIN\n     Got %v", q.Join)
+	}
+
+	expectedColumns := []Column{{Coalesce: []string{"a.name", "b.name"}, Alias: "name"}}
+	if !reflect.DeepEqual(q.Columns, expectedColumns) {
+		t.Fatalf("\nExpected %v\n     Got %v", expectedColumns, q.Columns)
+	}
+	expectedOrderBy := []Ordering{{Attribute: "coalesce(a.name, b.name)", Desc: true, Coalesce: []string{"a.name", "b.name"}}}
+	if !reflect.DeepEqual(q.OrderBy, expectedOrderBy) {
+		t.Fatalf("\nExpected %v\n     Got %v", expectedOrderBy, q.OrderBy)
+	}
+
+	for _, input := range []string{
+		"SELECT a.name FROM . AS a RIGHT JOIN src AS b",
+		"SELECT a.name FROM . AS a FULL JOIN src AS b",
+		"SELECT a.name FROM . AS a FULL JOIN LATERAL (SELECT name FROM src) AS b",
+		"SELECT COALESCE(a.name, b.size) FROM . AS a FULL JOIN src AS b ON a.name = b.name",
+		"SELECT COALESCE(a.name, name) FROM . AS a FULL JOIN src AS b ON a.name = b.name",
+		"SELECT COALESCE(a.name, c.name) FROM . AS a FULL JOIN src AS b ON a.name = b.name",
+		"SELECT COALESCE(a.name, b.name FROM . AS a FULL JOIN src AS b ON a.name = b.name",
+		"SELECT COALESCE(a.name, b.name) FROM .",
+		"SELECT name FROM . ORDER BY COALESCE(a.name, b.name)",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseLateral(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		{"SELECT a.name, b.name FROM . AS a, LATERAL (SELECT name FROM src WHERE name IS a.name) AS b", "CROSS JOIN LATERAL a, b"},
+		{"SELECT a.name FROM . AS a JOIN LATERAL (SELECT size FROM src) AS b ON b.size > a.size", "INNER JOIN LATERAL a, b ON " +
+			`({attribute: b.size, comparator: greater-than, value: "a.size", negate: false})`},
+		{"SELECT a.name, b.size FROM . AS a LEFT JOIN LATERAL (SELECT size FROM src ORDER BY size LIMIT 1) AS b", "LEFT JOIN LATERAL a, b"},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Join == nil || q.Join.Lateral == nil || q.Join.String() != c.expected {
+			t.Fatalf("\nExpected %s\n     Got %v", c.expected, q.Join)
+		}
+		if !reflect.DeepEqual(q.Join.Lateral.Sources["include"], []string{"src"}) {
+			t.Fatalf("\nExpected [src]\n     Got %v", q.Join.Lateral.Sources["include"])
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT a.name FROM ., LATERAL (SELECT name FROM src) AS b",
+		"SELECT a.name FROM . AS a, LATERAL (SELECT name FROM src)",
+		"SELECT a.name FROM . AS a, LATERAL SELECT name FROM src AS b",
+		"SELECT a.name FROM . AS a, LATERAL (SELECT name FROM src AS b",
+		"SELECT a.name FROM . AS a, src AS b",
+		"SELECT a.name, b.size FROM . AS a, LATERAL (SELECT name FROM src) AS b",
+		"SELECT a.name FROM . AS a, LATERAL (SELECT name FROM src INTO sqlite files.db) AS b",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseSemiJoin(t *testing.T) {
+	q, err := RunParser("SELECT a.name, size FROM . AS a SEMI JOIN src AS b ON a.name = b.name WHERE a.size > 1 ORDER BY a.time")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if q.Join == nil || q.Join.Type != "semi" {
+		t.Fatalf("\nExpected a SEMI JOIN\n     Got %v", q.Join)
+	}
+
+	// The attributes of a SEMI JOIN are those of its left source.
+	expectedColumns := []Column{{Attribute: "name"}, {Attribute: "size"}}
+	if !reflect.DeepEqual(q.Columns, expectedColumns) {
+		t.Fatalf("\nExpected %v\n     Got %v", expectedColumns, q.Columns)
+	}
+	if q.ConditionTree.Condition.Attribute != "size" || q.OrderBy[0].Attribute != "time" {
+		t.Fatalf("\nExpected unqualified attributes\n     Got %v and %v", q.ConditionTree, q.OrderBy)
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . AS a SEMI JOIN src AS b",
+		"SELECT b.name FROM . AS a SEMI JOIN src AS b ON a.name = b.name",
+		"SELECT name FROM . AS a ANTI JOIN src AS b ON a.name = b.name WHERE b.size > 1",
+		"SELECT name FROM . AS a ANTI JOIN src AS b ON name = b.name",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseExists(t *testing.T) {
+	type Case struct {
+		input      string
+		join       string
+		correlated bool
+		condition  *ConditionNode
+	}
+
+	size := &ConditionNode{Condition: &Condition{Attribute: "size", Comparator: GreaterThan, Value: "1"}}
+	cases := []Case{
+		{"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp WHERE name IS /home.name)",
+			"SEMI JOIN /home, exists ON " + `({attribute: exists.name, comparator: equal, value: "/home.name", negate: false})`, false, nil},
+		{"SELECT name FROM /home WHERE size > 1 AND NOT EXISTS (SELECT name FROM /tmp WHERE size > 1 AND name = /home.name)",
+			"ANTI JOIN /home, exists ON " + `({attribute: exists.name, comparator: equal, value: "/home.name", negate: false})`, false, size},
+		{"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp)", "SEMI JOIN /home, exists", false, nil},
+		{"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp WHERE name IS /home.name OR size > 1)", "SEMI JOIN LATERAL /home, exists", true, nil},
+		{"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp WHERE name IS /home.name LIMIT 1)", "SEMI JOIN LATERAL /home, exists", true, nil},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Join == nil || q.Join.String() != c.join {
+			t.Fatalf("\nExpected %s\n     Got %v", c.join, q.Join)
+		}
+		if (q.Join.Lateral != nil) != c.correlated || (q.Join.Subquery != nil) == c.correlated {
+			t.Fatalf("\nExpected only a LATERAL subquery: %t\n     Got %+v", c.correlated, q.Join)
+		}
+		if !reflect.DeepEqual(q.ConditionTree, c.condition) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.condition, q.ConditionTree)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM /home WHERE EXISTS",
+		"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp",
+		"SELECT name FROM /home WHERE size > 1 OR EXISTS (SELECT 1 FROM /tmp)",
+		"SELECT name FROM /home WHERE EXISTS (SELECT 1 FROM /tmp) AND EXISTS (SELECT 1 FROM /var)",
+		"SELECT name FROM /home, /var WHERE EXISTS (SELECT 1 FROM /tmp)",
+		"SELECT name FROM /home AS h JOIN /var AS v ON h.name = v.name WHERE EXISTS (SELECT 1 FROM /tmp)",
+		"SELECT name, COUNT(*) FROM /home GROUP BY name HAVING EXISTS (SELECT 1 FROM /tmp)",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected an error for %q\n     Got nil", input)
+		}
+	}
+}
+
+func TestParseRegexLiteral(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	// A regexp literal is equivalent to RLIKE with the regexp, whose flags
+	// follow the literal.
+	cases := []Case{
+		{"SELECT name FROM . WHERE name ~ /^test/", "SELECT name FROM . WHERE name RLIKE ^test"},
+		{"SELECT name FROM . WHERE name ~ /^test/i", "SELECT name FROM . WHERE name RLIKE '(?i)^test'"},
+		{"SELECT name FROM . WHERE name ~ /^a$/msi", "SELECT name FROM . WHERE name RLIKE '(?msi)^a$'"},
+		{"SELECT name FROM . WHERE name ~ /a/ii", "SELECT name FROM . WHERE name RLIKE '(?i)a'"},
+		{`SELECT name FROM . WHERE path ~ /^src\/.*\.go$/`, `SELECT name FROM . WHERE path RLIKE '^src/.*\.go$'`},
+		{`SELECT name FROM . WHERE name ~ /a\\/`, `SELECT name FROM . WHERE name RLIKE 'a\\'`},
+		{"SELECT name FROM . WHERE name ~ /a b (c)/", "SELECT name FROM . WHERE name RLIKE 'a b (c)'"},
+		{"SELECT name FROM . WHERE name ~ //", "SELECT name FROM . WHERE name RLIKE ''"},
+		{
+			"SELECT name FROM . WHERE NOT name ~ /x/ AND size > 5",
+			"SELECT name FROM . WHERE NOT name RLIKE x AND size > 5",
+		},
+		{"SELECT name FROM ~ WHERE name ~ /x/", "SELECT name FROM ~ WHERE name RLIKE x"},
+	}
+
+	for _, c := range cases {
+		actual, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		expected, err := RunParser(c.expected)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.expected, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+		}
+	}
+
+	// An unterminated regexp literal is an unknown token.
+	for _, input := range []string{
+		"SELECT name FROM . WHERE name ~ /^test",
+		`SELECT name FROM . WHERE name ~ /^test\/`,
+	} {
+		_, err := RunParser(input)
+		if _, ok := err.(*ErrUnknownToken); !ok {
+			t.Fatalf("\nExpected an unknown token for %q\n     Got %v", input, err)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . WHERE name ~ /a/x",
+		"SELECT name FROM . WHERE name ~ a",
+		"SELECT name FROM . WHERE name ~ /(/",
+		"SELECT name FROM . WHERE name ~",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	type Case struct {
+		input  string
+		format string
+		width  int
+	}
+
+	cases := []Case{
+		{"SELECT name FROM .", "", 0},
+		{"SELECT name, size FROM . FORMAT TABULATE WIDTH 80", "tabulate", 80},
+		{"SELECT name FROM . WHERE name LIKE %.go FORMAT tabulate", "tabulate", 0},
+		{"SELECT name FROM ., -.git ORDER BY name LIMIT 3 FORMAT json", "json", 0},
+		{"SELECT name FROM format WHERE format = json", "", 0},
+		{"SELECT name FROM . COMPACT", "compact", 0},
+		{"SELECT name FROM . WHERE name LIKE %.go ORDER BY name compact", "compact", 0},
+		{"SELECT name FROM . WHERE name = compact", "", 0},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if q.Format != c.format || q.FormatWidth != c.width {
+			t.Fatalf("\nExpected %q %d\n     Got %q %d", c.format, c.width, q.Format, q.FormatWidth)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . FORMAT",
+		"SELECT name FROM . FORMAT json WIDTH 80",
+		"SELECT name FROM . FORMAT tabulate WIDTH",
+		"SELECT name FROM . FORMAT tabulate WIDTH 0",
+		"SELECT name FROM . FORMAT tabulate WIDTH wide",
+		"SELECT name INTO csv files.csv FROM . FORMAT tabulate",
+		"SELECT name FROM . FORMAT json COMPACT",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func TestParseKeywordNames(t *testing.T) {
+	type Case struct {
+		input   string
+		sources []string
+		value   string
+	}
+
+	// Keywords are names where a source or value is expected.
+	cases := []Case{
+		{"SELECT name FROM by WHERE name = by", []string{"by"}, "by"},
+		{"SELECT name FROM ., order WHERE name = order ORDER BY name", []string{".", "order"}, "order"},
+		{"SELECT name FROM select WHERE name LIKE from", []string{"select"}, "from"},
+		{"SELECT TOP 2 name FROM top WHERE name = top", []string{"top"}, "top"},
+		{"SELECT name FROM first WHERE name = first FETCH FIRST 1 ROWS ONLY", []string{"first"}, "first"},
+		{"SELECT name FROM values, (VALUES (values, 1)) AS t(name, size) WHERE name = values", []string{"values", "t"}, "values"},
+		{"SELECT name FROM case WHERE name = end", []string{"case"}, "end"},
+		{"SELECT name FROM end WHERE name = case", []string{"end"}, "case"},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.Sources["include"], c.sources) {
+			t.Fatalf("\nExpected %v for %q\n     Got %v", c.sources, c.input, q.Sources["include"])
+		}
+		if value := q.ConditionTree.Condition.Value; value != c.value {
+			t.Fatalf("\nExpected the value %q for %q\n     Got %q", c.value, c.input, value)
+		}
+	}
+
+	// So are labels.
+	file, err := RunFileParser("LABEL top; GOTO top")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if file.Queries[0].Label != "top" || file.Queries[1].Goto != "top" {
+		t.Fatalf("\nExpected LABEL top and GOTO top\n     Got %v and %v", file.Queries[0].Label, file.Queries[1].Goto)
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	type Case struct {
+		input    string
+		expected *Destination
+	}
+
+	cases := []Case{
+		{"SELECT name FROM .", nil},
+		{"SELECT name FROM . INTO SQLITE '/tmp/files.db'", &Destination{Format: "sqlite", Path: "/tmp/files.db"}},
+		{"SELECT name FROM . ORDER BY size LIMIT 1 INTO OR REPLACE sqlite files.db", &Destination{Format: "sqlite", Path: "files.db", Replace: true}},
+		{"SELECT name FROM . WHERE size > 1 INTO \"files.parquet\" FORMAT PARQUET", &Destination{Format: "parquet", Path: "files.parquet"}},
+		{"SELECT dir INTO @root FROM . WHERE name = go.mod LIMIT 1", &Destination{Variable: "root"}},
+		{"SELECT dir FROM . WHERE name = go.mod LIMIT 1 INTO @root", &Destination{Variable: "root"}},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser(c.input)
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.input, err)
+		}
+		if !reflect.DeepEqual(q.Into, c.expected) {
+			t.Fatalf("\nExpected %+v\n     Got %+v", c.expected, q.Into)
+		}
+	}
+
+	for _, input := range []string{
+		"SELECT name FROM . INTO",
+		"SELECT name FROM . INTO SQLITE",
+		"SELECT name FROM . INTO OR SQLITE files.db",
+		"SELECT name FROM . INTO files.parquet FORMAT",
+		"SELECT name, size INTO @root FROM .",
+		"SELECT name INTO SQLITE files.db FROM .",
+		"SELECT name INTO @a FROM . INTO @b",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+
+	// Variables are kept as sources, to be replaced by their values.
+	q, err := RunParser("SELECT name FROM @root, -@vendor")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := map[string][]string{"include": {"@root"}, "exclude": {"@vendor"}}; !reflect.DeepEqual(q.Sources, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Sources)
+	}
+}
+
+func TestParseFunctionCondition(t *testing.T) {
+	type Case struct {
+		where    string
+		expected *Condition
+		err      bool
+	}
+
+	cases := []Case{
+		{
+			where:    `xattr("com.apple.quarantine") IS NOT NULL`,
+			expected: &Condition{Attribute: "xattr", Argument: "com.apple.quarantine", Comparator: Is, Value: "NULL", Negate: true},
+		},
+		{
+			where:    `NOT XATTR(user.tag) = red`,
+			expected: &Condition{Attribute: "xattr", Argument: "user.tag", Comparator: Equals, Value: "red", Negate: true},
+		},
+		{
+			where:    `XATTR_KEYS(path) CONTAINS user`,
+			expected: &Condition{Attribute: "xattr_keys", Comparator: Contains, Value: "user"},
+		},
+		{
+			where:    `XATTR_KEYS() IS NULL`,
+			expected: &Condition{Attribute: "xattr_keys", Comparator: Is, Value: "NULL"},
+		},
+		{
+			where:    `dir BEGINSWITH "src/vendor"`,
+			expected: &Condition{Attribute: "dir", Comparator: BeginsWith, Value: "src/vendor"},
+		},
+		{
+			where:    `depth <= 2`,
+			expected: &Condition{Attribute: "depth", Comparator: LessThanEquals, Value: "2"},
+		},
+		{
+			where:    `file IS NOT dir`,
+			expected: &Condition{Attribute: "file", Comparator: Is, Value: "dir", Negate: true},
+		},
+		{
+			where:    `contains_text("TODO")`,
+			expected: &Condition{Attribute: "contains_text", Argument: "TODO"},
+		},
+		{
+			where:    `NOT CONTAINS_TEXT(fixme)`,
+			expected: &Condition{Attribute: "contains_text", Argument: "fixme", Negate: true},
+		},
+		{
+			where:    `SOUNDEX(name) = SOUNDEX("colour")`,
+			expected: &Condition{Attribute: "soundex", Argument: "name", Comparator: Equals, Value: "C460"},
+		},
+		{
+			where:    `NOT metaphone(name) <> METAPHONE('knight')`,
+			expected: &Condition{Attribute: "metaphone", Argument: "name", Comparator: NotEquals, Value: "NT", Negate: true},
+		},
+		{
+			where:    `SOUNDEX(name) = R163`,
+			expected: &Condition{Attribute: "soundex", Argument: "name", Comparator: Equals, Value: "R163"},
+		},
+		{
+			where:    `name = SOUNDEX(robert)`,
+			expected: &Condition{Attribute: "name", Comparator: Equals, Value: "R163"},
+		},
+		{
+			where:    `LEVENSHTEIN(name, "readme") < 3`,
+			expected: &Condition{Attribute: "levenshtein", Argument: "name", Target: "readme", Comparator: LessThan, Value: "3"},
+		},
+		{
+			where:    `NOT levenshtein_ratio(dir, 'src/a b') >= 0.25`,
+			expected: &Condition{Attribute: "levenshtein_ratio", Argument: "dir", Target: "src/a b", Comparator: GreaterThanEquals, Value: "0.25", Negate: true},
+		},
+		{
+			where:    `LEVENSHTEIN(name, "") = 0`,
+			expected: &Condition{Attribute: "levenshtein", Argument: "name", Comparator: Equals, Value: "0"},
+		},
+		{
+			where:    `NORMALIZE(name, "nfc") IS "café"`,
+			expected: &Condition{Attribute: "normalize", Argument: "name", Target: "NFC", Comparator: Is, Value: "café"},
+		},
+		{
+			where:    `NOT NORMALIZE(dir, NFKD) LIKE %src%`,
+			expected: &Condition{Attribute: "normalize", Argument: "dir", Target: "NFKD", Comparator: Like, Value: "%src%", Negate: true},
+		},
+		{where: `NORMALIZE(name, NFX) = a`, err: true},
+		{where: `NORMALIZE(name) = a`, err: true},
+		{where: `NORMALIZE(size, NFC) = a`, err: true},
+		{where: `LEVENSHTEIN(size, "readme") < 3`, err: true},
+		{where: `LEVENSHTEIN(name) < 3`, err: true},
+		{where: `LEVENSHTEIN(name, readme < 3`, err: true},
+		{where: `LEVENSHTEIN(name, readme) < three`, err: true},
+		{where: `LEVENSHTEIN(name, readme) LIKE 3`, err: true},
+		{where: `SOUNDEX(size) = R163`, err: true},
+		{where: `SOUNDEX() = R163`, err: true},
+		{where: `SOUNDEX(name) = SOUNDEX(`, err: true},
+		{where: `xattr() IS NULL`, err: true},
+		{where: `contains_text()`, err: true},
+		{where: `xattr("user.tag" IS NULL`, err: true},
+		{where: `XATTR_KEYS(size) IS NULL`, err: true},
+	}
+
+	for _, c := range cases {
+		q, err := RunParser("SELECT name FROM . WHERE " + c.where)
+		if c.err {
+			if err == nil {
+				t.Fatalf("\nExpected an error for %q\n     Got %v", c.where, q.ConditionTree)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.where, err)
+		}
+		if q.ConditionTree == nil || !reflect.DeepEqual(q.ConditionTree.Condition, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, q.ConditionTree)
+		}
+	}
+}
+
+func TestParseNestedArchives(t *testing.T) {
+	q, err := RunParser("SELECT name FROM a.zip, b.zip include nested archives WHERE name LIKE %.go")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if !q.NestedArchives || !reflect.DeepEqual(q.Sources["include"], []string{"a.zip", "b.zip"}) {
+		t.Fatalf("\nExpected nested archives of a.zip and b.zip\n     Got %v %v", q.NestedArchives, q.Sources)
+	}
+
+	if _, err := RunParser("SELECT name FROM a.zip INCLUDE ARCHIVES"); err == nil {
+		t.Fatalf("\nExpected an error for INCLUDE without NESTED")
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	q, err := RunParser("SELECT ext, COUNT(*), COUNT(ext) AS n FROM . WHERE size > 5 GROUP BY ext ORDER BY ext")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	expected := []Column{
+		{Attribute: "ext"},
+		{Aggregate: &AggregateFunction{Type: Count, Attribute: "*"}},
+		{Aggregate: &AggregateFunction{Type: Count, Attribute: "ext"}, Alias: "n"},
+	}
+	if !reflect.DeepEqual(q.Columns, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, q.Columns)
+	}
+	if !reflect.DeepEqual(q.GroupBy, []string{"ext"}) || q.Pivot {
+		t.Fatalf("\nExpected GROUP BY ext without PIVOT\n     Got %v %v", q.GroupBy, q.Pivot)
+	}
+
+	names := []string{q.Columns[0].Name(), q.Columns[1].Name(), q.Columns[2].Name()}
+	if !reflect.DeepEqual(names, []string{"ext", "count(*)", "n"}) {
+		t.Fatalf("\nExpected column names ext, count(*), n\n     Got %v", names)
+	}
+
+	q, err = RunParser("SELECT ext, COUNT(*) FROM . GROUP BY ext PIVOT")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if !q.Pivot {
+		t.Fatalf("\nExpected PIVOT")
+	}
+
+	q, err = RunParser("SELECT ext, MEDIAN(size) FROM . GROUP BY ext")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if fn := (&AggregateFunction{Type: Median, Attribute: "size"}); !reflect.DeepEqual(q.Columns[1].Aggregate, fn) {
+		t.Fatalf("\nExpected %v\n     Got %v", fn, q.Columns[1].Aggregate)
+	}
+
+	q, err = RunParser("SELECT STRING_AGG(name, ', ' ORDER BY size DESC) FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn := &AggregateFunction{Type: StringAgg, Attribute: "name", Separator: ", ",
+		OrderBy: []Ordering{{Attribute: "size", Desc: true}}}
+	if !reflect.DeepEqual(q.Columns[0].Aggregate, fn) {
+		t.Fatalf("\nExpected %v\n     Got %v", fn, q.Columns[0].Aggregate)
+	}
+
+	q, err = RunParser("SELECT CHECKSUM(name, size, time) FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn = &AggregateFunction{Type: Checksum, Attributes: []string{"name", "size", "time"}}
+	if !reflect.DeepEqual(q.Columns[0].Aggregate, fn) || q.Columns[0].Name() != "checksum(name, size, time)" {
+		t.Fatalf("\nExpected %v\n     Got %v", fn, q.Columns[0].Aggregate)
+	}
+
+	q, err = RunParser("SELECT CHECKSUM_AGG(name ORDER BY name) FROM .")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn = &AggregateFunction{Type: ChecksumAgg, Attribute: "name", OrderBy: []Ordering{{Attribute: "name"}}}
+	if !reflect.DeepEqual(q.Columns[0].Aggregate, fn) || q.Columns[0].Name() != "checksum_agg(name)" {
+		t.Fatalf("\nExpected %v\n     Got %v", fn, q.Columns[0].Aggregate)
+	}
+
+	// Without GROUP BY, the query's ORDER BY orders the values of the
+	// functions which don't have their own.
+	q, err = RunParser("SELECT CHECKSUM_AGG(name), STRING_AGG(name, ',' ORDER BY size) FROM . ORDER BY name DESC")
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	fn = &AggregateFunction{Type: ChecksumAgg, Attribute: "name", OrderBy: []Ordering{{Attribute: "name", Desc: true}}}
+	if !reflect.DeepEqual(q.Columns[0].Aggregate, fn) || q.Columns[1].Aggregate.OrderBy[0].Attribute != "size" ||
+		q.OrderBy != nil {
+		t.Fatalf("\nExpected %v, ordered by size, and no ORDER BY\n     Got %v, %v, and %v",
+			fn, q.Columns[0].Aggregate, q.Columns[1].Aggregate, q.OrderBy)
+	}
+
+	_, err = RunParser("SELECT MEDIAN(name) FROM .")
+	if err == nil || err.Error() != "MEDIAN requires a numeric attribute, got name" {
+		t.Fatalf("\nExpected MEDIAN requires a numeric attribute, got name\n     Got %v", err)
+	}
+
+	for _, input := range []string{
+		"SELECT COUNT() FROM .",
+		"SELECT MEDIAN(*) FROM .",
+		"SELECT MEDIAN(mode) FROM .",
+		"SELECT STDDEV(name) FROM .",
+		"SELECT VARIANCE(*) FROM .",
+		"SELECT STRING_AGG(name) FROM .",
+		"SELECT STRING_AGG(name, ) FROM .",
+		"SELECT STRING_AGG(name, ',' ORDER size) FROM .",
+		"SELECT STRING_AGG(name, ',' ORDER BY foo) FROM .",
+		"SELECT ARRAY_AGG(name, ',') FROM .",
+		"SELECT ARRAY_AGG(name ORDER size) FROM .",
+		"SELECT BIT_OR(name) FROM .",
+		"SELECT BIT_AND(ext) FROM .",
+		"SELECT BIT_XOR(*) FROM .",
+		"SELECT CHECKSUM() FROM .",
+		"SELECT CHECKSUM(*) FROM .",
+		"SELECT CHECKSUM(name, foo) FROM .",
+		"SELECT CHECKSUM_AGG(*) FROM .",
+		"SELECT CHECKSUM_AGG(name, size) FROM .",
+		"SELECT CHECKSUM_AGG(name ORDER size) FROM .",
+		"SELECT CHECKSUM_AGG(name ORDER BY size) FROM . ORDER BY name",
+		"SELECT ext, CHECKSUM_AGG(name) FROM . GROUP BY ext ORDER BY name",
+		"SELECT COUNT(*) FROM . ORDER BY name",
+		"SELECT COUNT(foo) FROM .",
+		"SELECT name, COUNT(*) FROM .",
+		"SELECT name, COUNT(*) FROM . GROUP BY ext",
+		"SELECT ext FROM . GROUP ext",
+		"SELECT ext FROM . GROUP BY foo",
+		"SELECT ext, COUNT(*) FROM . GROUP BY ext ORDER BY size",
+		"SELECT ext, RANK() OVER (ORDER BY ext) FROM . GROUP BY ext",
+		"SELECT ext, COUNT(*) FROM . PIVOT",
+		"SELECT ext FROM . GROUP BY ext PIVOT",
+		"SELECT ext, dir, COUNT(*) FROM . GROUP BY ext, dir PIVOT",
+		"WITH t AS (SELECT ext, COUNT(*) FROM . GROUP BY ext PIVOT) SELECT name FROM t",
+	} {
+		if _, err := RunParser(input); err == nil {
+			t.Fatalf("\nExpected error for %q", input)
+		}
+	}
+}
+
+func BenchmarkParserParse(b *testing.B) {
+	queries := readBenchQueries(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, query := range queries {
+			if _, err := RunParser(query); err != nil {
+				b.Fatalf("%s: %v", query, err)
+			}
+		}
+	}
+}
+
+func FuzzParser(f *testing.F) {
+	// Each of the queries in this package's tests.
+	fset := token.NewFileSet()
+	files, err := filepath.Glob("*_test.go")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		tree, err := goparser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			f.Fatal(err)
+		}
+		ast.Inspect(tree, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			if s, err := strconv.Unquote(lit.Value); err == nil {
+				if tok := NewTokenizer(s).Next(); tok != nil && tok.Type != Identifier && tok.Type != Unknown {
+					f.Add(s)
+				}
+			}
+			return true
+		})
+	}
+
+	// Each keyword without the arguments it requires, on its own and in each
+	// clause of a query.
+	for typ := Select; typ.String() != Unknown.String(); typ++ {
+		keyword := strings.ToUpper(typ.String())
+		for _, format := range []string{
+			"%s", "%s (", "%s )", "%s ,", "SELECT %s", "SELECT name, %s", "SELECT %s FROM .",
+			"SELECT name FROM %s", "SELECT name FROM . %s", "SELECT name FROM . WHERE %s",
+			"SELECT name FROM . WHERE name %s", "SELECT name FROM . WHERE name = foo %s",
+			"SELECT name FROM . ORDER BY %s", "SELECT name FROM . GROUP BY %s",
+			"WITH %s", "WITH t AS (%s) SELECT name FROM t", "EXPLAIN %s",
+		} {
+			f.Add(fmt.Sprintf(format, keyword))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		type result struct {
+			q   *Query
+			err error
+		}
+		done := make(chan result)
+		go func() {
+			q, err := RunParser(input)
+			done <- result{q, err}
+		}()
+
+		var r result
+		select {
+		case r = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("\nExpected RunParser to return for %q\n     Got no result after 5s", input)
+		}
+
+		if r.err == nil {
+			if r.q == nil {
+				t.Fatalf("\nExpected a query or an error for %q\n     Got neither", input)
+			}
+			return
+		}
+		if v := reflect.ValueOf(r.err); v.Kind() == reflect.Ptr && v.IsNil() {
+			t.Fatalf("\nExpected a non-nil error for %q\n     Got %#v", input, r.err)
+		}
+		if r.err.Error() == "" {
+			t.Fatalf("\nExpected an error message for %q\n     Got %#v", input, r.err)
+		}
+	})
+}
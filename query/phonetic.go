@@ -0,0 +1,220 @@
+package query
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// The phonetic functions, which may be compared in a condition, e.g.
+// SOUNDEX(name) = SOUNDEX(colour), by the function which computes each.
+var phoneticFunctions = map[TokenType]func(string) string{
+	Soundex:   SoundexCode,
+	Metaphone: MetaphoneCode,
+}
+
+// PhoneticName returns the part of a file's name which its phonetic codes are
+// computed from: the name without its extension (e.g. colour for colour.txt),
+// unless it's only an extension (e.g. .bashrc).
+func PhoneticName(name string) string {
+	if stem := strings.TrimSuffix(name, filepath.Ext(name)); stem != "" {
+		return stem
+	}
+	return name
+}
+
+// Return the letters of s (from A to Z) in upper case, ignoring each of its
+// other characters.
+func phoneticLetters(s string) []byte {
+	letters := make([]byte, 0, len(s))
+	for _, r := range strings.ToUpper(s) {
+		if r >= 'A' && r <= 'Z' {
+			letters = append(letters, byte(r))
+		}
+	}
+	return letters
+}
+
+// The digit of each consonant of a Soundex code (vowels, H, W, and Y have
+// none).
+var soundexDigits = map[byte]byte{
+	'B': '1', 'F': '1', 'P': '1', 'V': '1',
+	'C': '2', 'G': '2', 'J': '2', 'K': '2', 'Q': '2', 'S': '2', 'X': '2', 'Z': '2',
+	'D': '3', 'T': '3',
+	'L': '4',
+	'M': '5', 'N': '5',
+	'R': '6',
+}
+
+// SoundexCode returns the (American) Soundex code of s, e.g. R163 for Robert
+// (and Rupert): its first letter, followed by the digits of the rest of its
+// consonants, padded with zeros (or truncated) to three digits. Adjacent
+// consonants with the same digit (including those separated by H or W, but
+// not by a vowel) have it once. Characters other than letters are ignored,
+// and the code of a string without any is 0000.
+func SoundexCode(s string) string {
+	letters := phoneticLetters(s)
+	if len(letters) == 0 {
+		return "0000"
+	}
+
+	code := []byte{letters[0]}
+	last := soundexDigits[letters[0]]
+	for _, c := range letters[1:] {
+		if len(code) == 4 {
+			break
+		}
+		digit, ok := soundexDigits[c]
+		switch {
+		case c == 'H' || c == 'W':
+			continue
+		case !ok:
+			last = 0
+		case digit != last:
+			code = append(code, digit)
+			last = digit
+		}
+	}
+
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
+
+// Return true iff c is a vowel (Y isn't one).
+func isVowel(c byte) bool {
+	return c == 'A' || c == 'E' || c == 'I' || c == 'O' || c == 'U'
+}
+
+// MetaphoneCode returns the (original) Metaphone code of s, e.g. SM0 for
+// Smith, in which 0 is "th". Unlike Soundex, it encodes how groups of letters
+// (rather than each consonant) are pronounced in English, e.g. PH is F, and
+// both Knight and Night are NT. Characters other than letters are ignored,
+// and the code of a string without any is empty.
+func MetaphoneCode(s string) string {
+	w := phoneticLetters(s)
+
+	// Some of the letters at the start are silent, or pronounced differently.
+	switch {
+	case len(w) >= 2 && strings.Contains("AE GN KN PN WR", string(w[:2])):
+		w = w[1:]
+	case len(w) >= 2 && w[0] == 'W' && w[1] == 'H':
+		w = append([]byte{'W'}, w[2:]...)
+	case len(w) >= 1 && w[0] == 'X':
+		w[0] = 'S'
+	}
+
+	// Return the letter at i, or 0 before the start or after the end.
+	at := func(i int) byte {
+		if i < 0 || i >= len(w) {
+			return 0
+		}
+		return w[i]
+	}
+	in := func(c byte, letters string) bool {
+		return c != 0 && strings.IndexByte(letters, c) >= 0
+	}
+
+	code := make([]byte, 0, len(w))
+	for i, c := range w {
+		// Doubled letters (but C) are pronounced once.
+		if c == at(i-1) && c != 'C' {
+			continue
+		}
+
+		switch c {
+		case 'A', 'E', 'I', 'O', 'U':
+			if i == 0 {
+				code = append(code, c)
+			}
+		case 'B':
+			// B is silent at the end after M, e.g. dumb.
+			if i != len(w)-1 || at(i-1) != 'M' {
+				code = append(code, 'B')
+			}
+		case 'C':
+			switch {
+			case at(i+1) == 'H' && at(i-1) == 'S':
+				code = append(code, 'K')
+			case at(i+1) == 'H' || at(i+1) == 'I' && at(i+2) == 'A':
+				code = append(code, 'X')
+			case in(at(i+1), "EIY"):
+				// C is silent in SCE, SCI, and SCY.
+				if at(i-1) != 'S' {
+					code = append(code, 'S')
+				}
+			default:
+				code = append(code, 'K')
+			}
+		case 'D':
+			if at(i+1) == 'G' && in(at(i+2), "EIY") {
+				code = append(code, 'J')
+			} else {
+				code = append(code, 'T')
+			}
+		case 'G':
+			switch {
+			case at(i+1) == 'H' && i+2 < len(w) && !isVowel(at(i+2)):
+				// G is silent in GH, unless it's at the end or before a
+				// vowel, e.g. light.
+			case at(i+1) == 'N' && (i+2 == len(w) || i+4 == len(w) && at(i+2) == 'E' && at(i+3) == 'D'):
+				// G is silent in GN and GNED at the end, e.g. sign.
+			case at(i-1) == 'D' && in(at(i+1), "EIY"):
+				// DG is J before E, I, or Y, e.g. judge.
+			case in(at(i+1), "EIY") && at(i-1) != 'G':
+				code = append(code, 'J')
+			default:
+				code = append(code, 'K')
+			}
+		case 'H':
+			// H is silent after a vowel unless a vowel follows it, and after
+			// the letters whose sound it changes.
+			if !(isVowel(at(i-1)) && !isVowel(at(i+1))) && !in(at(i-1), "CGPST") {
+				code = append(code, 'H')
+			}
+		case 'K':
+			if at(i-1) != 'C' {
+				code = append(code, 'K')
+			}
+		case 'P':
+			if at(i+1) == 'H' {
+				code = append(code, 'F')
+			} else {
+				code = append(code, 'P')
+			}
+		case 'Q':
+			code = append(code, 'K')
+		case 'S':
+			if at(i+1) == 'H' || at(i+1) == 'I' && in(at(i+2), "AO") {
+				code = append(code, 'X')
+			} else {
+				code = append(code, 'S')
+			}
+		case 'T':
+			switch {
+			case at(i+1) == 'I' && in(at(i+2), "AO"):
+				code = append(code, 'X')
+			case at(i+1) == 'H':
+				code = append(code, '0')
+			case at(i+1) == 'C' && at(i+2) == 'H':
+				// T is silent in TCH, e.g. watch.
+			default:
+				code = append(code, 'T')
+			}
+		case 'V':
+			code = append(code, 'F')
+		case 'W', 'Y':
+			// W and Y are only consonants before a vowel.
+			if isVowel(at(i + 1)) {
+				code = append(code, c)
+			}
+		case 'X':
+			code = append(code, 'K', 'S')
+		case 'Z':
+			code = append(code, 'S')
+		default:
+			code = append(code, c)
+		}
+	}
+	return string(code)
+}
@@ -0,0 +1,93 @@
+package query
+
+import "testing"
+
+func TestSoundexCode(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		{"Robert", "R163"},
+		{"Rupert", "R163"},
+		{"Rubin", "R150"},
+		{"Ashcraft", "A261"},
+		{"Tymczak", "T522"},
+		{"Pfister", "P236"},
+		{"Honeyman", "H555"},
+		{"Lee", "L000"},
+		{"colour", "C460"},
+		{"color", "C460"},
+
+		// Characters other than letters are ignored.
+		{"r-o_b 3e.r't", "R163"},
+		{"  robert!", "R163"},
+		{"", "0000"},
+		{"123 ._-", "0000"},
+	}
+
+	for _, c := range cases {
+		if actual := SoundexCode(c.input); actual != c.expected {
+			t.Fatalf("\nExpected %s for %q\n     Got %s", c.expected, c.input, actual)
+		}
+	}
+}
+
+func TestMetaphoneCode(t *testing.T) {
+	type Case struct {
+		input    string
+		expected string
+	}
+
+	cases := []Case{
+		{"Smith", "SM0"},
+		{"Thomas", "0MS"},
+		{"knight", "NT"},
+		{"night", "NT"},
+		{"phone", "FN"},
+		{"fone", "FN"},
+		{"Wright", "RT"},
+		{"rite", "RT"},
+		{"colour", "KLR"},
+		{"color", "KLR"},
+		{"Xavier", "SFR"},
+		{"whitney", "WTN"},
+		{"dumb", "TM"},
+		{"science", "SNS"},
+		{"church", "XRX"},
+		{"school", "SKL"},
+		{"nation", "NXN"},
+		{"judge", "JJ"},
+		{"watch", "WX"},
+		{"sign", "SN"},
+		{"box", "BKS"},
+		{"Ahead", "AHT"},
+
+		// Characters other than letters are ignored.
+		{"k-n_i g.h't", "NT"},
+		{"", ""},
+		{"123", ""},
+	}
+
+	for _, c := range cases {
+		if actual := MetaphoneCode(c.input); actual != c.expected {
+			t.Fatalf("\nExpected %s for %q\n     Got %s", c.expected, c.input, actual)
+		}
+	}
+}
+
+func TestPhoneticName(t *testing.T) {
+	cases := map[string]string{
+		"colour.txt":     "colour",
+		"archive.tar.gz": "archive.tar",
+		"colour":         "colour",
+		".bashrc":        ".bashrc",
+	}
+
+	for input, expected := range cases {
+		if actual := PhoneticName(input); actual != expected {
+			t.Fatalf("\nExpected %s for %q\n     Got %s", expected, input, actual)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package query
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+)
+
+// PragmaStatement represents a PRAGMA statement, which configures how the query which
+// follows it is evaluated.
+type PragmaStatement struct {
+	Name  string
+	Value string
+}
+
+// QueryOptions represents the runtime configuration of a query. The zero
+// value is the default configuration.
+type QueryOptions struct {
+	CaseInsensitive bool // Whether string comparisons ignore case.
+	FollowSymlinks  bool // Whether symbolic links to directories are walked.
+	MaxDepth        int  // How many levels below each source to walk, 0 for no limit.
+
+	// The most characters of each value of STRING_AGG (before the ... it's
+	// truncated with), 0 for no limit.
+	StringAggMaxLength int
+
+	// The filesystem which sources without a scheme are walked in, or nil for
+	// the operating system's. It isn't set by any pragma.
+	FS fs.FS
+
+	// Whether the walk of each local source stays on the source's device,
+	// rather than entering the filesystems mounted below it (like find
+	// -xdev). It's set by the -no-cross-device flag rather than a pragma.
+	NoCrossDevice bool
+
+	// The largest file (in bytes) whose contents are read, 0 for no limit.
+	// The content attributes (e.g. CONTAINS_TEXT) of larger files are NULL,
+	// and each of them is logged to SkipLog unless it's nil. They're set by
+	// the -max-file-size and -verbose flags.
+	MaxFileSize int64
+	SkipLog     io.Writer
+}
+
+// NewQueryOptions returns the options set by each of the pragmas, in order.
+// Unknown pragmas are ignored (so that queries written for newer versions
+// still run), a warning is returned for each of them instead. An error is
+// returned for a known pragma with an invalid value.
+func NewQueryOptions(pragmas []PragmaStatement) (QueryOptions, []string, error) {
+	var opts QueryOptions
+	warnings := make([]string, 0)
+
+	for _, pragma := range pragmas {
+		var err error
+
+		switch pragma.Name {
+		case "case_sensitive":
+			var sensitive bool
+			sensitive, err = strconv.ParseBool(pragma.Value)
+			opts.CaseInsensitive = !sensitive
+
+		case "follow_symlinks":
+			opts.FollowSymlinks, err = strconv.ParseBool(pragma.Value)
+
+		case "max_depth":
+			opts.MaxDepth, err = strconv.Atoi(pragma.Value)
+			if err == nil && opts.MaxDepth <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+
+		case "string_agg_max_length":
+			opts.StringAggMaxLength, err = strconv.Atoi(pragma.Value)
+			if err == nil && opts.StringAggMaxLength <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+
+		default:
+			warnings = append(warnings, fmt.Sprintf("unknown pragma: %s", pragma.Name))
+		}
+
+		if err != nil {
+			return opts, warnings, fmt.Errorf("invalid value for pragma %s: %s",
+				pragma.Name, pragma.Value)
+		}
+	}
+
+	return opts, warnings, nil
+}
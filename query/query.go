@@ -3,13 +3,354 @@ package query
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// TimeLayout is the layout of the time values of a query (in conditions and
+// VALUES sources), MMM DD YYYY HH MM.
+const TimeLayout = "Jan 02 2006 15 04"
+
 // Query represents an input query.
 type Query struct {
 	Attributes    map[string]bool
+	Columns       []Column // Columns of the SELECT clause, in order.
 	Sources       map[string][]string
 	ConditionTree *ConditionNode // Root node of this query's condition tree.
+	OrderBy       []Ordering     // Sort keys of the ORDER BY clause, in order.
+	With          []CTE          // Common table expressions, in order.
+
+	// Tables of the VALUES sources, by their names (which are also among the
+	// included Sources).
+	Values map[string]*ValuesTable
+
+	// The JOIN of the query's two sources, nil if there isn't one.
+	Join *JoinClause
+
+	// Distinct is set when only results with distinct values for each of the
+	// selected attributes should be kept. If DistinctOn isn't nil, the results
+	// must only be distinct for those attributes.
+	Distinct   bool
+	DistinctOn []string
+
+	// Windows defined by the WINDOW clause, by name.
+	Windows map[string]WindowSpec
+
+	// Attributes of the GROUP BY clause, in order. When there are any, or any
+	// of the columns is an aggregate function, there's a result per group of
+	// the files with the same values for each of the attributes. If Pivot is
+	// also set, the groups are turned into the columns of a single result.
+	GroupBy []string
+	Pivot   bool
+
+	// Condition trees of the HAVING clause, which filters the groups (after
+	// the aggregate functions are computed), and of the QUALIFY clause, which
+	// filters the results after the window functions are computed. Their
+	// conditions compare either a column (by its name) or an attribute.
+	Having  *ConditionNode
+	Qualify *ConditionNode
+
+	// Pragmas which precede the query, in order.
+	Pragmas []PragmaStatement
+
+	// NestedArchives is set by INCLUDE NESTED ARCHIVES, when the archives
+	// inside an archive source should be expanded too.
+	NestedArchives bool
+
+	// Sample of the sources set by the TABLESAMPLE clause, nil for all files.
+	Sample *TableSample
+
+	// The UNPIVOT clause, nil if the results aren't unpivoted.
+	Unpivot *UnpivotClause
+
+	// Maximum number of results set by the LIMIT clause (or TOP, or FETCH),
+	// -1 for no limit, and the number of results skipped before them by the
+	// OFFSET clause.
+	Limit  int
+	Offset int
+
+	// Warnings about the query which don't prevent it from being evaluated
+	// (e.g. a TOP which is overridden by LIMIT).
+	Warnings []string
+
+	// Explain is set when the query's plan should be shown instead of its
+	// results. If Analyze is also set, the query is evaluated and the plan
+	// shows the number of results and time taken by each step.
+	Explain bool
+	Analyze bool
+
+	// RebuildIndex is set by REBUILD INDEX, when the index of each of the
+	// sources should be rebuilt instead of evaluating the query.
+	RebuildIndex bool
+
+	// Destination of the results set by the INTO clause, nil to show them.
+	Into *Destination
+
+	// The output format of the results set by the FORMAT clause (e.g.
+	// "tabulate"), which overrides the -format flag, and the most columns
+	// each line of the tabulate format may take (set by WIDTH), 0 for its
+	// default.
+	Format      string
+	FormatWidth int
+
+	// Assert is set by an ASSERT statement, when its assertion should be
+	// checked instead of evaluating the query.
+	Assert *Assertion
+
+	// Label is set by a LABEL statement, which names its position in a query
+	// file, and Goto by a GOTO statement, which continues the file after
+	// the statement with that label. If is the condition of IF ... GOTO, nil
+	// if the GOTO is unconditional (its Message is unused).
+	Label string
+	Goto  string
+	If    *Assertion
+
+	// Raise is set by a RAISE statement, when its message should be reported
+	// instead of evaluating the query.
+	Raise *RaiseStatement
+
+	// Operation is set by a DELETE, MOVE, or COPY statement, when the files
+	// matched by the query should be deleted, moved, or copied instead of
+	// shown.
+	Operation *FileOperation
+}
+
+// FileOperation represents a DELETE, MOVE, or COPY statement, whose Type is
+// its first word. MOVE and COPY are followed by TO and the directory Target,
+// which the files are moved or copied into. Atomic is set by MOVE ... ATOMIC,
+// when either all of the files are moved or none are.
+type FileOperation struct {
+	Type   string
+	Target string
+	Atomic bool
+}
+
+// RaiseStatement represents a RAISE statement, which reports a message at a
+// Level (Notice, Warning, or Error). The message is the concatenation of its
+// parts.
+type RaiseStatement struct {
+	Level   TokenType
+	Message []MessagePart
+}
+
+// MessagePart represents a part of the message of a RAISE statement: either
+// Text, or the value of Variable (without its @) if it's set.
+type MessagePart struct {
+	Text     string
+	Variable string
+}
+
+// Assertion represents an ASSERT statement, which fails unless the single
+// column of the first result of Query, compared to Value with Comparator, is
+// true. Message is reported when it fails, if it's set (otherwise the
+// expected and actual values are).
+type Assertion struct {
+	Query      *Query
+	Comparator TokenType
+	Value      string
+	Message    string
+}
+
+// Destination represents an INTO clause, which writes the results to the file at Path
+// in Format (e.g. "sqlite") instead of showing them. If Replace is set,
+// existing results for the same paths are replaced rather than appended to.
+// An INTO @variable clause instead sets the Variable (named without the @) to
+// the value of the first result's single column.
+type Destination struct {
+	Format   string
+	Path     string
+	Replace  bool
+	Variable string
+}
+
+// JoinClause represents a JOIN of the query's two included sources, each of which
+// has an alias which qualifies its attributes (e.g. a.name). An INNER JOIN
+// has a result per pair of files which satisfies the On condition tree, while
+// a LEFT JOIN also has a result (whose right attributes are NULL) for each
+// file of the left source which isn't in any pair, a RIGHT JOIN for each such
+// file of the right source, and a FULL JOIN for both. A CROSS JOIN has a
+// result per pair of files.
+//
+// A SEMI JOIN only has the files of the left source which are in at least one
+// such pair, and an ANTI JOIN only those which aren't in any. Since their
+// results are the left source's files, their attributes needn't be qualified.
+//
+// When Lateral is set, the right source is a LATERAL subquery rather than one
+// of the query's sources, which is evaluated for each file of the left source.
+// Its conditions may compare with the file's attributes (e.g. name IS a.name),
+// and its selected attributes are qualified with Aliases[1]. When Subquery is
+// set, the right source is a subquery which is only evaluated once (e.g. the
+// subquery of an EXISTS condition, rewritten to a SEMI JOIN).
+type JoinClause struct {
+	Type     string    // Either "inner", "left", "right", "full", "cross", "semi", or "anti".
+	Aliases  [2]string // The aliases of the left and right sources.
+	On       *ConditionNode
+	Lateral  *Query
+	Subquery *Query
+}
+
+// Semi returns true iff the JOIN is a SEMI or ANTI JOIN, which only keeps or
+// drops the files of its left source.
+func (j JoinClause) Semi() bool {
+	return j.Type == "semi" || j.Type == "anti"
+}
+
+func (j JoinClause) String() string {
+	join := "JOIN"
+	if j.Lateral != nil {
+		join += " LATERAL"
+	}
+	s := fmt.Sprintf("%s %s %s, %s", strings.ToUpper(j.Type), join, j.Aliases[0], j.Aliases[1])
+	if j.On != nil {
+		s += " ON " + j.On.String()
+	}
+	return s
+}
+
+// ValuesTable represents a VALUES source (e.g. (VALUES ("a.go", 1)) AS t(name,
+// size)), an in-memory table of files which have the attributes of Columns
+// (name, size, or time), in order. The other attributes of each file are
+// zero, and the files are regular files.
+type ValuesTable struct {
+	Columns []string
+	Rows    []ValuesRow
+}
+
+// ValuesRow represents a single file of a VALUES source.
+type ValuesRow struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// TableSample represents a TABLESAMPLE clause. With the SYSTEM method, each
+// directory (along with its contents) is kept with a probability of Percent
+// percent, with the BERNOULLI method, each file is.
+type TableSample struct {
+	Method  string // Either "system" or "bernoulli".
+	Percent float64
+}
+
+// UnpivotClause represents an UNPIVOT clause, which turns each result into a
+// result per attribute of Attributes, with the attribute's name in the column
+// NameColumn and its value in the column ValueColumn.
+type UnpivotClause struct {
+	ValueColumn string
+	NameColumn  string
+	Attributes  []string
+}
+
+func (u UnpivotClause) String() string {
+	return fmt.Sprintf("%s FOR %s IN (%s)", u.ValueColumn, u.NameColumn, strings.Join(u.Attributes, ", "))
+}
+
+// Column represents a single column of the SELECT clause: either an attribute
+// (of the JOIN source aliased Table, if it's set), a window function, an
+// aggregate function, a column of the UNPIVOT clause (named Unpivoted), or
+// COALESCE of the qualified attributes of a JOIN's sources, a CASE expression,
+// or an arithmetic expression, optionally renamed with an alias.
+type Column struct {
+	Attribute  string
+	Table      string
+	Window     *WindowFunction
+	Aggregate  *AggregateFunction
+	Unpivot    *UnpivotClause
+	Coalesce   []string
+	Unpivoted  string
+	Case       *CaseExpression
+	Expression *Expression
+	Alias      string
+}
+
+// Name returns the name this column is shown with.
+func (c Column) Name() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+	if c.Window != nil {
+		return strings.ToLower(c.Window.Type.String())
+	}
+	if c.Aggregate != nil {
+		return c.Aggregate.String()
+	}
+	if c.Unpivoted != "" {
+		return c.Unpivoted
+	}
+	if c.Coalesce != nil {
+		return CoalesceString(c.Coalesce)
+	}
+	if c.Case != nil {
+		return "case"
+	}
+	if c.Expression != nil {
+		return c.Expression.String()
+	}
+	if c.Table != "" {
+		return c.Table + "." + c.Attribute
+	}
+	return c.Attribute
+}
+
+// AggregateFunction represents an aggregate function (e.g. COUNT(*)) which is
+// computed over each group of the results.
+type AggregateFunction struct {
+	Type       TokenType
+	Attribute  string   // The function's argument, * for all files.
+	Attributes []string // The arguments of CHECKSUM, rather than Attribute.
+
+	// The separator of the values of STRING_AGG, and the order the values of
+	// STRING_AGG, ARRAY_AGG, or CHECKSUM_AGG are in (if it isn't the order the
+	// files were walked in).
+	Separator string
+	OrderBy   []Ordering
+}
+
+func (a AggregateFunction) String() string {
+	if a.Attributes != nil {
+		return fmt.Sprintf("%s(%s)", a.Type.String(), strings.Join(a.Attributes, ", "))
+	}
+	return fmt.Sprintf("%s(%s)", a.Type.String(), a.Attribute)
+}
+
+// WindowFunction represents a window function (e.g. ROW_NUMBER() OVER (...))
+// which is computed over a partition of the results.
+type WindowFunction struct {
+	Type       TokenType
+	WindowName string // Name of the WINDOW clause window, if one is used.
+	WindowSpec
+
+	// The attribute to show (for LAG, LEAD, FIRST_VALUE, and LAST_VALUE), and
+	// how many results before (for LAG) or after (for LEAD) the result it's
+	// shown from.
+	Attribute string
+	Offset    int
+
+	Buckets int // The number of buckets NTILE divides the partition into.
+
+	// Set for ROW_NUMBER() without OVER, which numbers the results in the
+	// order they're output (i.e. after ORDER BY, DISTINCT, and LIMIT).
+	InOutputOrder bool
+}
+
+// WindowSpec represents how the results are partitioned and ordered for a
+// window function. With no PartitionBy attributes, all results are in a single
+// partition.
+type WindowSpec struct {
+	PartitionBy []string
+	OrderBy     []Ordering
+}
+
+// CTE represents a common table expression of a WITH clause: a named query
+// whose results may be used as a source by the queries which follow it.
+type CTE struct {
+	Name  string
+	Query *Query
+}
+
+// QueryFile represents the queries of a query file, in order.
+type QueryFile struct {
+	Queries []*Query
 }
 
 // HasAttribute checks if the query's attribute map contains the provided
@@ -23,6 +364,16 @@ func (q *Query) HasAttribute(attributes ...string) bool {
 	return false
 }
 
+// Return true iff one of the query's columns is shown with the name.
+func (q *Query) hasColumn(name string) bool {
+	for _, c := range q.Columns {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ConditionNode represents a single node of a query's WHERE clause tree.
 type ConditionNode struct {
 	Type      TokenType
@@ -70,16 +421,209 @@ func (root *ConditionNode) Evaluate(file os.FileInfo, compareFn interface{}) boo
 	return false
 }
 
-// Condition represents a WHERE condition.
+// Condition represents a WHERE condition. When the condition compares the
+// result of a function (e.g. XATTR) rather than an attribute, Attribute is the
+// function's name and Argument is its argument. A CONTAINS_TEXT condition
+// doesn't compare its result, so it has no Comparator or Value.
 type Condition struct {
 	Attribute  string
+	Argument   string
 	Comparator TokenType
 	Value      string
 	Negate     bool
+
+	// The subquery of an EXISTS condition, whose Attribute is "exists". It's
+	// rewritten to a SEMI (or, when negated, ANTI) JOIN when it's parsed.
+	Exists *Query
+
+	// The CASE expression whose value is compared, when Attribute is "case".
+	Case *CaseExpression
+
+	// The arithmetic expression whose value is compared, when Attribute is
+	// the expression in its query form.
+	Expression *Expression
+
+	// The subquery of a comparison with ALL or ANY (the Quantifier, which is
+	// Any for SOME), whose single column's values the attribute is compared
+	// with, rather than Value.
+	Subquery   *Query
+	Quantifier TokenType
+
+	// The second argument of the function which is the condition's Attribute,
+	// after the attribute (the Argument): the string which an edit distance
+	// function compares it with, e.g. readme in LEVENSHTEIN(name, readme) < 3,
+	// or the form of NORMALIZE, e.g. NFC.
+	Target string
+}
+
+// CaseExpression represents a CASE expression, whose value is the result of
+// the first of its branches whose condition is satisfied, or Else if none are
+// (NULL, if Else is nil). A simple CASE (CASE size WHEN 0 THEN ...) is parsed
+// as the searched CASE which compares its attribute with each of the values
+// (CASE WHEN size = 0 THEN ...).
+type CaseExpression struct {
+	Branches []CaseBranch
+	Else     *CaseResult
+}
+
+// CaseBranch represents a WHEN condition THEN result branch of a CASE.
+type CaseBranch struct {
+	When *ConditionNode
+	Then CaseResult
+}
+
+// CaseResult represents the result of a branch of a CASE: a Value, the value
+// of a nested CASE expression, the value of the file's Attribute (which only
+// NULLIF's results are), or NULL if Null is set.
+type CaseResult struct {
+	Value     string
+	Case      *CaseExpression
+	Attribute string
+	Null      bool
+}
+
+// Expression represents an arithmetic expression: a number (Value), the value
+// of a numeric Attribute, or the result of an Operator (see operatorPrecedence)
+// applied to Left and Right. Negation is Minus (or Tilde, for bitwise NOT)
+// without a Left.
+type Expression struct {
+	Operator    TokenType
+	Left, Right *Expression
+	Attribute   string
+	Value       string
+}
+
+// The symbol of each arithmetic operator.
+var operatorSymbols = map[TokenType]string{
+	Plus:       "+",
+	Minus:      "-",
+	Star:       "*",
+	Slash:      "/",
+	Percent:    "%",
+	Ampersand:  "&",
+	Pipe:       "|",
+	Caret:      "^",
+	Tilde:      "~",
+	ShiftLeft:  "<<",
+	ShiftRight: ">>",
+}
+
+// The levels of the binary arithmetic operators, from that which
+// binds least tightly: | before ^ before & before shifts before + - before
+// * / %. Operators at the same level are evaluated from left to right.
+var operatorPrecedence = [][]TokenType{
+	{Pipe},
+	{Caret},
+	{Ampersand},
+	{ShiftLeft, ShiftRight},
+	{Plus, Minus},
+	{Star, Slash, Percent},
+}
+
+// The bitwise operators, whose operands must be integers.
+var bitwiseOperators = map[TokenType]bool{
+	Ampersand:  true,
+	Pipe:       true,
+	Caret:      true,
+	Tilde:      true,
+	ShiftLeft:  true,
+	ShiftRight: true,
+}
+
+// String returns the expression in its query form, e.g. size / (depth + 1),
+// with only the parentheses its precedence requires.
+func (e *Expression) String() string {
+	switch {
+	case e.Operator == Unknown && e.Attribute != "":
+		return e.Attribute
+	case e.Operator == Unknown:
+		return e.Value
+	case e.Left == nil:
+		return operatorSymbols[e.Operator] + e.Right.operand(e.precedence())
+	}
+	precedence := e.precedence()
+	return e.Left.operand(precedence) + " " + operatorSymbols[e.Operator] + " " +
+		e.Right.operand(precedence+1)
+}
+
+// Return how tightly the expression's operator binds: its level of
+// operatorPrecedence, and negation more tightly than any binary operator.
+func (e *Expression) precedence() int {
+	switch {
+	case e.Operator == Unknown:
+		return len(operatorPrecedence) + 2
+	case e.Left == nil:
+		return len(operatorPrecedence) + 1
+	}
+	for i, level := range operatorPrecedence {
+		for _, op := range level {
+			if op == e.Operator {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+// IsInteger returns true iff each of the expression's operands is an integer:
+// an attribute (each numeric attribute is), or a number without a decimal
+// point or exponent. An integer beginning with 0 is octal (e.g. 0644), and
+// with 0x hexadecimal.
+func (e *Expression) IsInteger() bool {
+	if e.Operator == Unknown {
+		if e.Attribute != "" {
+			return true
+		}
+		_, err := strconv.ParseInt(e.Value, 0, 64)
+		return err == nil
+	}
+	return (e.Left == nil || e.Left.IsInteger()) && e.Right.IsInteger()
+}
+
+// Return the expression in its query form as the operand of an operator which
+// binds at least as tightly as precedence, in parentheses if it binds less
+// tightly.
+func (e *Expression) operand(precedence int) string {
+	if e.precedence() < precedence {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
 }
 
 func (c *Condition) String() string {
+	attribute := c.Attribute
+	if c.Argument != "" {
+		attribute = fmt.Sprintf("%s(%s)", c.Attribute, c.Argument)
+	}
+	switch c.Attribute {
+	case Levenshtein.String(), LevenshteinRatio.String(), Normalize.String():
+		attribute = fmt.Sprintf("%s(%s, %q)", c.Attribute, c.Argument, c.Target)
+	}
+	value := c.Value
+	if c.Subquery != nil {
+		value = fmt.Sprintf("%s(%s)", c.Quantifier, c.Subquery.Columns[0].Name())
+	}
+
 	return fmt.Sprintf(
 		"{attribute: %s, comparator: %s, value: \"%s\", negate: %t}",
-		c.Attribute, c.Comparator, c.Value, c.Negate)
+		attribute, c.Comparator, value, c.Negate)
+}
+
+// Ordering represents a single sort key of an ORDER BY clause. When Coalesce
+// is set, the results are sorted by the first of its qualified attributes
+// which isn't NULL, and Attribute is COALESCE in its query form.
+type Ordering struct {
+	Attribute string
+	Desc      bool
+	Coalesce  []string
+}
+
+// CoalesceString returns COALESCE of the attributes in its query form, e.g.
+// coalesce(a.name, b.name).
+func CoalesceString(attributes []string) string {
+	return fmt.Sprintf("coalesce(%s)", strings.Join(attributes, ", "))
+}
+
+func (o *Ordering) String() string {
+	return fmt.Sprintf("{attribute: %s, desc: %t}", o.Attribute, o.Desc)
 }
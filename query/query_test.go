@@ -0,0 +1,110 @@
+package query
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"testing"
+)
+
+// The profiles which the tests write when TEST_PROFILE=1 is set (see
+// PROFILING.md), in the package's directory.
+const (
+	cpuProfile  = "cpu.pprof"
+	heapProfile = "heap.pprof"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	stop, err := startProfiles(os.Getenv("TEST_PROFILE"), ".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	if err := stop(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// If setting is 1, start a CPU profile of the tests in dir, and return the
+// function which stops it and writes a heap profile once they're complete.
+// The CPU profile isn't started when go test's -cpuprofile flag is set, since
+// only one may be written at a time. Otherwise, nothing is profiled.
+func startProfiles(setting, dir string) (stop func() error, err error) {
+	if setting != "1" {
+		return func() error { return nil }, nil
+	}
+
+	var cpu *os.File
+	if f := flag.Lookup("test.cpuprofile"); f == nil || f.Value.String() == "" {
+		if cpu, err = os.Create(filepath.Join(dir, cpuProfile)); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+
+	return func() error {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return err
+			}
+		}
+
+		heap, err := os.Create(filepath.Join(dir, heapProfile))
+		if err != nil {
+			return err
+		}
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(heap); err != nil {
+			heap.Close()
+			return err
+		}
+		return heap.Close()
+	}, nil
+}
+
+func TestStartProfiles(t *testing.T) {
+	dir := t.TempDir()
+	stop, err := startProfiles("", dir)
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Fatalf("\nExpected no profiles without TEST_PROFILE\n     Got %v", files)
+	}
+
+	// The CPU profile of the tests is already being written.
+	if os.Getenv("TEST_PROFILE") == "1" {
+		return
+	}
+	if stop, err = startProfiles("1", dir); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	for _, name := range []string{cpuProfile, heapProfile} {
+		if f := flag.Lookup("test.cpuprofile"); name == cpuProfile && f.Value.String() != "" {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() == 0 {
+			t.Fatalf("\nExpected a %s profile\n     Got %v", name, err)
+		}
+	}
+}
@@ -38,7 +38,8 @@ const (
 	CloseParen
 	// Comma represents a comma.
 	Comma
-	// Minus represents the `-` operator for directory exclusion.
+	// Minus represents the `-` operator for directory exclusion, subtraction,
+	// and negation.
 	Minus
 	// Equals represents the `=` comparator for string/numeric comparisons.
 	Equals
@@ -52,6 +53,222 @@ const (
 	LessThanEquals
 	// LessThan represents the `<` comparator for numeric comparisons.
 	LessThan
+	// Order represents the ORDER keyword of the ORDER BY clause.
+	Order
+	// By represents the BY keyword of the ORDER BY clause.
+	By
+	// Asc represents the ASC keyword for ascending sort order.
+	Asc
+	// Desc represents the DESC keyword for descending sort order.
+	Desc
+	// Semicolon represents a semicolon, which separates the queries of a
+	// query file.
+	Semicolon
+	// With represents the WITH clause for common table expressions.
+	With
+	// As represents the AS keyword for naming a common table expression.
+	As
+	// Distinct represents the DISTINCT keyword for removing duplicate results.
+	Distinct
+	// On represents the ON keyword of DISTINCT ON.
+	On
+	// RowNumber represents the ROW_NUMBER window function.
+	RowNumber
+	// Rank represents the RANK window function.
+	Rank
+	// DenseRank represents the DENSE_RANK window function.
+	DenseRank
+	// PercentRank represents the PERCENT_RANK window function.
+	PercentRank
+	// CumeDist represents the CUME_DIST window function.
+	CumeDist
+	// Lag represents the LAG window function.
+	Lag
+	// Lead represents the LEAD window function.
+	Lead
+	// FirstValue represents the FIRST_VALUE window function.
+	FirstValue
+	// LastValue represents the LAST_VALUE window function.
+	LastValue
+	// Ntile represents the NTILE window function.
+	Ntile
+	// Over represents the OVER keyword which follows a window function.
+	Over
+	// Partition represents the PARTITION keyword of PARTITION BY.
+	Partition
+	// Window represents the WINDOW clause for naming a window.
+	Window
+	// Pragma represents the PRAGMA statement for configuring a query.
+	Pragma
+	// Contains represents the CONTAINS keyword for substring comparisons.
+	Contains
+	// Tablesample represents the TABLESAMPLE clause for sampling the sources.
+	Tablesample
+	// Limit represents the LIMIT clause for limiting the number of results.
+	Limit
+	// Explain represents the EXPLAIN statement for showing a query's plan.
+	Explain
+	// Into represents the INTO clause for writing the results to a file.
+	Into
+	// Xattr represents the XATTR function, which returns the value of one of
+	// a file's extended attributes.
+	Xattr
+	// XattrKeys represents the XATTR_KEYS function, which returns the keys of
+	// a file's extended attributes.
+	XattrKeys
+	// Group represents the GROUP keyword of the GROUP BY clause.
+	Group
+	// Count represents the COUNT aggregate function.
+	Count
+	// Median represents the MEDIAN aggregate function.
+	Median
+	// Stddev represents the STDDEV aggregate function.
+	Stddev
+	// StddevSamp represents the STDDEV_SAMP aggregate function.
+	StddevSamp
+	// Variance represents the VARIANCE aggregate function.
+	Variance
+	// StringAgg represents the STRING_AGG aggregate function.
+	StringAgg
+	// ArrayAgg represents the ARRAY_AGG aggregate function.
+	ArrayAgg
+	// BitAnd represents the BIT_AND aggregate function.
+	BitAnd
+	// BitOr represents the BIT_OR aggregate function.
+	BitOr
+	// BitXor represents the BIT_XOR aggregate function.
+	BitXor
+	// Checksum represents the CHECKSUM aggregate function.
+	Checksum
+	// Top represents the TOP keyword, which limits the number of results
+	// (like LIMIT) from the SELECT clause.
+	Top
+	// Offset represents the OFFSET clause for skipping the first results.
+	Offset
+	// Fetch represents the FETCH clause (FETCH FIRST n ROWS ONLY), which
+	// limits the number of results like LIMIT.
+	Fetch
+	// First represents the FIRST keyword of the FETCH clause.
+	First
+	// Rows represents the ROWS keyword of the OFFSET and FETCH clauses.
+	Rows
+	// Only represents the ONLY keyword of the FETCH clause.
+	Only
+	// Pivot represents the PIVOT keyword, which turns the groups of the
+	// results into the columns of a single row.
+	Pivot
+	// Having represents the HAVING clause for filtering the groups of the
+	// results.
+	Having
+	// Qualify represents the QUALIFY clause for filtering the results by the
+	// values of their window functions.
+	Qualify
+	// Unpivot represents the UNPIVOT clause, which turns each result into a
+	// result per attribute.
+	Unpivot
+	// Values represents a VALUES source, an in-memory table of files.
+	Values
+	// GenerateSeries represents the GENERATE_SERIES source, a series of
+	// numbers.
+	GenerateSeries
+	// Join represents the JOIN keyword for joining two sources.
+	Join
+	// Lateral represents a LATERAL subquery, which is evaluated for each file
+	// of the source it's joined with.
+	Lateral
+	// Exists represents the EXISTS condition, which is satisfied when its
+	// subquery has any results.
+	Exists
+	// Coalesce represents the COALESCE function, the first of its attributes
+	// which isn't NULL.
+	Coalesce
+	// ContainsText represents the CONTAINS_TEXT function, which is satisfied
+	// by the text files whose contents contain its argument.
+	ContainsText
+	// BeginsWith represents the BEGINSWITH keyword for prefix comparisons.
+	BeginsWith
+	// ChecksumAgg represents the CHECKSUM_AGG aggregate function.
+	ChecksumAgg
+	// Variable represents a variable (e.g. @dir), which is set by INTO and
+	// used as a source.
+	Variable
+	// Assert represents the ASSERT statement, which checks the result of a
+	// subquery.
+	Assert
+	// Message represents the MESSAGE keyword, after which the message of a
+	// failed assertion is provided.
+	Message
+	// If represents the IF keyword, which makes a GOTO conditional.
+	If
+	// Label represents the LABEL statement, which names a position in a
+	// query file.
+	Label
+	// Goto represents the GOTO statement, which continues a query file at
+	// the position of a label.
+	Goto
+	// Raise represents the RAISE statement, which reports a message.
+	Raise
+	// Notice, Warning, and Error represent the levels of a RAISE statement.
+	Notice
+	Warning
+	Error
+	// Case, When, Then, Else, and End represent the keywords of a CASE
+	// expression.
+	Case
+	When
+	Then
+	Else
+	End
+	// Iif represents the IIF function, a shorthand for a CASE expression with
+	// a single branch and ELSE.
+	Iif
+	// NullIf represents the NULLIF function, which is NULL when an attribute
+	// is equal to a value, and the attribute otherwise.
+	NullIf
+	// Plus, Star, Slash, and Percent represent the `+`, `*`, `/`, and `%`
+	// arithmetic operators (along with Minus). Each is only a token when
+	// it's a word by itself, since the characters are also those of paths
+	// and patterns (e.g. ./src, or %.go).
+	Plus
+	Star
+	Slash
+	Percent
+	// Ampersand, Pipe, Caret, Tilde, ShiftLeft, and ShiftRight represent the
+	// `&`, `|`, `^`, `~`, `<<`, and `>>` bitwise operators. Like Plus, the
+	// first three are only tokens when they're a word by themselves, while
+	// `~` is a token unless it begins a path (e.g. ~/Desktop).
+	Ampersand
+	Pipe
+	Caret
+	Tilde
+	ShiftLeft
+	ShiftRight
+	// Max and Min represent the MAX and MIN aggregate functions.
+	Max
+	Min
+	// All, Any, and Some represent the quantifiers of a comparison with the
+	// values of a subquery (ANY and SOME are synonyms). Each is only a
+	// keyword when it's followed by an open parenthesis, so that e.g. `all`
+	// may still be selected.
+	All
+	Any
+	Some
+	// Soundex and Metaphone represent the SOUNDEX and METAPHONE functions,
+	// which return the phonetic codes of a file's name (or a string).
+	Soundex
+	Metaphone
+	// Levenshtein and LevenshteinRatio represent the LEVENSHTEIN and
+	// LEVENSHTEIN_RATIO functions, which return the edit distance between an
+	// attribute of a file and a string (normalized to [0, 1] by the latter).
+	Levenshtein
+	LevenshteinRatio
+	// Normalize represents the NORMALIZE function, which returns an attribute
+	// of a file in a Unicode normalization form (e.g. NFC).
+	Normalize
+	// RegexLiteral represents a regexp literal, e.g. /^test/i, whose Raw is
+	// the regexp it's equivalent to, e.g. (?i)^test. It's only a token after
+	// `~` (its comparator), since `/` otherwise begins a path.
+	RegexLiteral
 )
 
 func (t TokenType) String() string {
@@ -96,6 +313,204 @@ func (t TokenType) String() string {
 		return "less-than-or-equal"
 	case LessThan:
 		return "less-than"
+	case Order:
+		return "order"
+	case By:
+		return "by"
+	case Asc:
+		return "asc"
+	case Desc:
+		return "desc"
+	case Semicolon:
+		return "semicolon"
+	case With:
+		return "with"
+	case As:
+		return "as"
+	case Distinct:
+		return "distinct"
+	case On:
+		return "on"
+	case RowNumber:
+		return "row_number"
+	case Rank:
+		return "rank"
+	case DenseRank:
+		return "dense_rank"
+	case PercentRank:
+		return "percent_rank"
+	case CumeDist:
+		return "cume_dist"
+	case Lag:
+		return "lag"
+	case Lead:
+		return "lead"
+	case FirstValue:
+		return "first_value"
+	case LastValue:
+		return "last_value"
+	case Ntile:
+		return "ntile"
+	case Over:
+		return "over"
+	case Partition:
+		return "partition"
+	case Window:
+		return "window"
+	case Pragma:
+		return "pragma"
+	case Contains:
+		return "contains"
+	case Tablesample:
+		return "tablesample"
+	case Limit:
+		return "limit"
+	case Explain:
+		return "explain"
+	case Into:
+		return "into"
+	case Xattr:
+		return "xattr"
+	case XattrKeys:
+		return "xattr_keys"
+	case Group:
+		return "group"
+	case Count:
+		return "count"
+	case Median:
+		return "median"
+	case Stddev:
+		return "stddev"
+	case StddevSamp:
+		return "stddev_samp"
+	case Variance:
+		return "variance"
+	case StringAgg:
+		return "string_agg"
+	case ArrayAgg:
+		return "array_agg"
+	case BitAnd:
+		return "bit_and"
+	case BitOr:
+		return "bit_or"
+	case BitXor:
+		return "bit_xor"
+	case Checksum:
+		return "checksum"
+	case Top:
+		return "top"
+	case Offset:
+		return "offset"
+	case Fetch:
+		return "fetch"
+	case First:
+		return "first"
+	case Rows:
+		return "rows"
+	case Only:
+		return "only"
+	case Pivot:
+		return "pivot"
+	case Having:
+		return "having"
+	case Qualify:
+		return "qualify"
+	case Unpivot:
+		return "unpivot"
+	case Values:
+		return "values"
+	case GenerateSeries:
+		return "generate_series"
+	case Join:
+		return "join"
+	case Lateral:
+		return "lateral"
+	case Exists:
+		return "exists"
+	case Coalesce:
+		return "coalesce"
+	case ContainsText:
+		return "contains_text"
+	case BeginsWith:
+		return "beginswith"
+	case ChecksumAgg:
+		return "checksum_agg"
+	case Variable:
+		return "variable"
+	case Assert:
+		return "assert"
+	case Message:
+		return "message"
+	case If:
+		return "if"
+	case Label:
+		return "label"
+	case Goto:
+		return "goto"
+	case Raise:
+		return "raise"
+	case Notice:
+		return "notice"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	case Case:
+		return "case"
+	case When:
+		return "when"
+	case Then:
+		return "then"
+	case Else:
+		return "else"
+	case End:
+		return "end"
+	case Iif:
+		return "iif"
+	case NullIf:
+		return "nullif"
+	case Plus:
+		return "plus"
+	case Star:
+		return "star"
+	case Slash:
+		return "slash"
+	case Percent:
+		return "percent"
+	case Ampersand:
+		return "ampersand"
+	case Pipe:
+		return "pipe"
+	case Caret:
+		return "caret"
+	case Tilde:
+		return "tilde"
+	case ShiftLeft:
+		return "shift-left"
+	case ShiftRight:
+		return "shift-right"
+	case Max:
+		return "max"
+	case Min:
+		return "min"
+	case All:
+		return "all"
+	case Any:
+		return "any"
+	case Some:
+		return "some"
+	case Soundex:
+		return "soundex"
+	case Metaphone:
+		return "metaphone"
+	case Levenshtein:
+		return "levenshtein"
+	case LevenshteinRatio:
+		return "levenshtein_ratio"
+	case Normalize:
+		return "normalize"
+	case RegexLiteral:
+		return "regex-literal"
 	default:
 		return "unknown"
 	}
@@ -105,6 +520,29 @@ func (t TokenType) String() string {
 type Token struct {
 	Type TokenType
 	Raw  string
+
+	// Position of the token's first rune in the input, starting at line 1,
+	// column 1.
+	Line   int
+	Column int
+}
+
+// Return true iff the token is a keyword (e.g. SELECT, or TOP), rather than
+// an identifier, a variable, an operator, or punctuation.
+func (t Token) isKeyword() bool {
+	switch t.Type {
+	case Identifier, Variable, RegexLiteral, Unknown:
+		return false
+	}
+	if t.Raw == "" {
+		return false
+	}
+	for _, r := range t.Raw {
+		if !unicode.IsLetter(r) && r != '_' {
+			return false
+		}
+	}
+	return true
 }
 
 func (t Token) String() string {
@@ -114,11 +552,37 @@ func (t Token) String() string {
 // Tokenizer represents a token worker.
 type Tokenizer struct {
 	input []rune
+	buf   []rune // Backing array of input, kept so it can be reused.
+
+	// Position of the next rune of the input, starting at line 1, column 1.
+	// Each rune counts as a single column.
+	Line   int
+	Column int
+
+	// The type of the last token which was read, or Unknown before the first.
+	prev TokenType
 }
 
 // NewTokenizer initializes a new Tokenizer.
 func NewTokenizer(input string) *Tokenizer {
-	return &Tokenizer{input: []rune(input)}
+	t := new(Tokenizer)
+	t.Reset(input)
+	return t
+}
+
+// Reset discards the remaining input and prepares this Tokenizer to read
+// input from the start. The rune buffer from the previous input is reused, so
+// tokenizing many queries with a single Tokenizer avoids allocating a new one
+// for each query.
+func (t *Tokenizer) Reset(input string) {
+	t.buf = t.buf[:0]
+	for _, r := range input {
+		t.buf = append(t.buf, r)
+	}
+
+	t.input = t.buf
+	t.Line, t.Column = 1, 1
+	t.prev = Unknown
 }
 
 // All parses all tokens for this Tokenizer.
@@ -131,6 +595,20 @@ func (t *Tokenizer) All() []Token {
 	return tokens
 }
 
+// AllStrict parses all tokens for this Tokenizer, like All, but returns an
+// ErrUnknownToken (with the token's position) for the first Unknown token.
+func (t *Tokenizer) AllStrict() ([]Token, error) {
+	tokens := []Token{}
+	for tok := t.Next(); tok != nil; tok = t.Next() {
+		if tok.Type == Unknown {
+			return nil, &ErrUnknownToken{Raw: tok.Raw, Line: tok.Line, Column: tok.Column}
+		}
+		tokens = append(tokens, *tok)
+	}
+
+	return tokens, nil
+}
+
 // Next gets the next Token in this Tokenizer.
 func (t *Tokenizer) Next() *Token {
 	for {
@@ -138,56 +616,98 @@ func (t *Tokenizer) Next() *Token {
 			break
 		}
 
-		t.input = t.input[1:]
+		t.advance(1)
+	}
+
+	line, column := t.Line, t.Column
+	tok := t.next()
+	if tok != nil {
+		tok.Line, tok.Column = line, column
+		t.prev = tok.Type
 	}
 
+	return tok
+}
+
+// Read the token which begins at the current (non-whitespace) rune.
+func (t *Tokenizer) next() *Token {
 	current := t.current()
 	if current == -1 {
 		return nil
 	}
 
+	if current == '/' && t.prev == Tilde {
+		return t.readRegexLiteral()
+	}
+
 	switch current {
 	case '(':
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: OpenParen, Raw: "("}
 
 	case ')':
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: CloseParen, Raw: ")"}
 
 	case ',':
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: Comma, Raw: ","}
 
+	case ';':
+		t.advance(1)
+		return &Token{Type: Semicolon, Raw: ";"}
+
+	case '!':
+		// There is no `!` operator (use NOT or <> instead), so this is
+		// reserved rather than treated as the start of a word.
+		t.advance(1)
+		return &Token{Type: Unknown, Raw: "!"}
+
 	case '-':
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: Minus, Raw: "-"}
 
+	case '~':
+		if t.peek() != '/' {
+			t.advance(1)
+			return &Token{Type: Tilde, Raw: "~"}
+		}
+
 	case '=':
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: Equals, Raw: "="}
 
 	case '>':
+		if t.peek() == '>' {
+			t.advance(2)
+			return &Token{Type: ShiftRight, Raw: ">>"}
+		}
+
 		if t.peek() == '=' {
-			t.input = t.input[2:]
+			t.advance(2)
 			return &Token{Type: GreaterThanEquals, Raw: ">="}
 		}
 
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: GreaterThan, Raw: ">"}
 
 	case '<':
+		if t.peek() == '<' {
+			t.advance(2)
+			return &Token{Type: ShiftLeft, Raw: "<<"}
+		}
+
 		if t.peek() == '=' {
-			t.input = t.input[2:]
-			return &Token{Type: LessThanEquals, Raw: ">="}
+			t.advance(2)
+			return &Token{Type: LessThanEquals, Raw: "<="}
 		}
 
 		if t.peek() == '>' {
-			t.input = t.input[2:]
+			t.advance(2)
 			return &Token{Type: NotEquals, Raw: "<>"}
 		}
 
-		t.input = t.input[1:]
+		t.advance(1)
 		return &Token{Type: LessThan, Raw: "<"}
 	}
 
@@ -195,6 +715,10 @@ func (t *Tokenizer) Next() *Token {
 		current == ',' || current == '(' || current == ')') {
 		word := t.readWord()
 		tok := &Token{Raw: word}
+		if len(word) > 1 && word[0] == '@' {
+			tok.Type = Variable
+			return tok
+		}
 
 		switch strings.ToUpper(word) {
 		case "SELECT":
@@ -215,6 +739,192 @@ func (t *Tokenizer) Next() *Token {
 			tok.Type = Like
 		case "RLIKE":
 			tok.Type = RLike
+		case "ORDER":
+			tok.Type = Order
+		case "BY":
+			tok.Type = By
+		case "ASC":
+			tok.Type = Asc
+		case "DESC":
+			tok.Type = Desc
+		case "WITH":
+			tok.Type = With
+		case "AS":
+			tok.Type = As
+		case "DISTINCT":
+			tok.Type = Distinct
+		case "ON":
+			tok.Type = On
+		case "ROW_NUMBER":
+			tok.Type = RowNumber
+		case "RANK":
+			tok.Type = Rank
+		case "DENSE_RANK":
+			tok.Type = DenseRank
+		case "PERCENT_RANK":
+			tok.Type = PercentRank
+		case "CUME_DIST":
+			tok.Type = CumeDist
+		case "LAG":
+			tok.Type = Lag
+		case "LEAD":
+			tok.Type = Lead
+		case "FIRST_VALUE":
+			tok.Type = FirstValue
+		case "LAST_VALUE":
+			tok.Type = LastValue
+		case "NTILE":
+			tok.Type = Ntile
+		case "OVER":
+			tok.Type = Over
+		case "PARTITION":
+			tok.Type = Partition
+		case "WINDOW":
+			tok.Type = Window
+		case "PRAGMA":
+			tok.Type = Pragma
+		case "CONTAINS":
+			tok.Type = Contains
+		case "TABLESAMPLE":
+			tok.Type = Tablesample
+		case "LIMIT":
+			tok.Type = Limit
+		case "EXPLAIN":
+			tok.Type = Explain
+		case "INTO":
+			tok.Type = Into
+		case "XATTR":
+			tok.Type = Xattr
+		case "XATTR_KEYS":
+			tok.Type = XattrKeys
+		case "GROUP":
+			tok.Type = Group
+		case "COUNT":
+			tok.Type = Count
+		case "MEDIAN":
+			tok.Type = Median
+		case "STDDEV":
+			tok.Type = Stddev
+		case "STDDEV_SAMP":
+			tok.Type = StddevSamp
+		case "VARIANCE":
+			tok.Type = Variance
+		case "STRING_AGG":
+			tok.Type = StringAgg
+		case "ARRAY_AGG":
+			tok.Type = ArrayAgg
+		case "BIT_AND":
+			tok.Type = BitAnd
+		case "BIT_OR":
+			tok.Type = BitOr
+		case "BIT_XOR":
+			tok.Type = BitXor
+		case "CHECKSUM":
+			tok.Type = Checksum
+		case "TOP":
+			tok.Type = Top
+		case "OFFSET":
+			tok.Type = Offset
+		case "FETCH":
+			tok.Type = Fetch
+		case "FIRST":
+			tok.Type = First
+		case "ROWS":
+			tok.Type = Rows
+		case "ONLY":
+			tok.Type = Only
+		case "PIVOT":
+			tok.Type = Pivot
+		case "HAVING":
+			tok.Type = Having
+		case "QUALIFY":
+			tok.Type = Qualify
+		case "UNPIVOT":
+			tok.Type = Unpivot
+		case "VALUES":
+			tok.Type = Values
+		case "GENERATE_SERIES":
+			tok.Type = GenerateSeries
+		case "JOIN":
+			tok.Type = Join
+		case "LATERAL":
+			tok.Type = Lateral
+		case "EXISTS":
+			tok.Type = Exists
+		case "COALESCE":
+			tok.Type = Coalesce
+		case "CONTAINS_TEXT":
+			tok.Type = ContainsText
+		case "BEGINSWITH":
+			tok.Type = BeginsWith
+		case "CHECKSUM_AGG":
+			tok.Type = ChecksumAgg
+		case "ASSERT":
+			tok.Type = Assert
+		case "MESSAGE":
+			tok.Type = Message
+		case "IF":
+			tok.Type = If
+		case "LABEL":
+			tok.Type = Label
+		case "GOTO":
+			tok.Type = Goto
+		case "RAISE":
+			tok.Type = Raise
+		case "NOTICE":
+			tok.Type = Notice
+		case "WARNING":
+			tok.Type = Warning
+		case "ERROR":
+			tok.Type = Error
+		case "CASE":
+			tok.Type = Case
+		case "WHEN":
+			tok.Type = When
+		case "THEN":
+			tok.Type = Then
+		case "ELSE":
+			tok.Type = Else
+		case "END":
+			tok.Type = End
+		case "IIF":
+			tok.Type = Iif
+		case "NULLIF":
+			tok.Type = NullIf
+		case "+":
+			tok.Type = Plus
+		case "*":
+			tok.Type = Star
+		case "/":
+			tok.Type = Slash
+		case "%":
+			tok.Type = Percent
+		case "&":
+			tok.Type = Ampersand
+		case "|":
+			tok.Type = Pipe
+		case "^":
+			tok.Type = Caret
+		case "SOUNDEX":
+			tok.Type = Soundex
+		case "METAPHONE":
+			tok.Type = Metaphone
+		case "LEVENSHTEIN":
+			tok.Type = Levenshtein
+		case "LEVENSHTEIN_RATIO":
+			tok.Type = LevenshteinRatio
+		case "NORMALIZE":
+			tok.Type = Normalize
+		case "MAX":
+			tok.Type = Max
+		case "MIN":
+			tok.Type = Min
+		case "ALL":
+			tok.Type = t.quantifier(All)
+		case "ANY":
+			tok.Type = t.quantifier(Any)
+		case "SOME":
+			tok.Type = t.quantifier(Some)
 		default:
 			tok.Type = Identifier
 		}
@@ -223,40 +933,132 @@ func (t *Tokenizer) Next() *Token {
 	}
 
 	if current == '\'' || current == '`' || current == '"' {
-		t.input = t.input[1:]
+		t.advance(1)
 
-		word := t.readWord()
-
-		for t.current() != '\'' && t.current() != '`' && t.current() != '"' {
-			for unicode.IsSpace(t.current()) {
-				t.input = t.input[1:]
+		// Everything up to the matching quote (including whitespace and
+		// reserved characters) is part of the value.
+		word := []rune{}
+		for t.current() != current {
+			if t.current() == -1 {
+				return &Token{Type: Unknown, Raw: string(current) + string(word)}
 			}
 
-			word = fmt.Sprintf("%s %s", word, t.readWord())
+			word = append(word, t.current())
+			t.advance(1)
 		}
 
-		t.input = t.input[1:]
-		return &Token{Type: Identifier, Raw: word}
+		t.advance(1)
+		return &Token{Type: Identifier, Raw: string(word)}
 	}
 
-	t.input = t.input[1:]
+	t.advance(1)
 	return &Token{Type: Unknown, Raw: string([]rune{current})}
 }
 
-func (t *Tokenizer) current() rune {
-	if len(t.input) == 0 {
-		return -1
+// Consume the next n runes of the input, updating the position.
+func (t *Tokenizer) advance(n int) {
+	for _, r := range t.input[:n] {
+		if r == '\n' {
+			t.Line++
+			t.Column = 1
+		} else {
+			t.Column++
+		}
 	}
 
-	return t.input[0]
+	t.input = t.input[n:]
 }
 
-func (t *Tokenizer) peek() rune {
-	if len(t.input) == 1 {
+// PeekN returns the rune n runes after the current rune (so PeekN(0) is the
+// current rune) without consuming any input, or -1 if the input ends before
+// then.
+func (t *Tokenizer) PeekN(n int) rune {
+	if n < 0 || n >= len(t.input) {
 		return -1
 	}
 
-	return t.input[1]
+	return t.input[n]
+}
+
+// Return the quantifier q if the next rune other than whitespace is an open
+// parenthesis (of a subquery), otherwise Identifier.
+func (t *Tokenizer) quantifier(q TokenType) TokenType {
+	for i := 0; ; i++ {
+		if r := t.PeekN(i); !unicode.IsSpace(r) {
+			if r == '(' {
+				return q
+			}
+			return Identifier
+		}
+	}
+}
+
+// The flags which may follow a regexp literal, which set the regexp's flags
+// of the same names: i is case-insensitive, m is multi-line (^ and $ match at
+// the start and end of each line), and s lets . match a newline.
+const regexFlags = "ims"
+
+// Read a regexp literal, e.g. /^test/i: the pattern up to the next `/` which
+// isn't escaped (as `\/`, for a `/` in the pattern), followed by any flags
+// (see regexFlags). The literal is Unknown if it's unterminated, or if any of
+// its flags isn't one of regexFlags.
+func (t *Tokenizer) readRegexLiteral() *Token {
+	raw := []rune{t.current()}
+	t.advance(1)
+
+	pattern := []rune{}
+	for t.current() != '/' {
+		switch t.current() {
+		case -1:
+			return &Token{Type: Unknown, Raw: string(raw)}
+		case '\\':
+			n := 1
+			if t.peek() != -1 {
+				n = 2
+			}
+			escape := t.input[:n]
+			raw = append(raw, escape...)
+			// Other escapes (e.g. \d, or \\) are the regexp's.
+			if t.peek() == '/' {
+				pattern = append(pattern, '/')
+			} else {
+				pattern = append(pattern, escape...)
+			}
+			t.advance(n)
+			continue
+		}
+		pattern = append(pattern, t.current())
+		raw = append(raw, t.current())
+		t.advance(1)
+	}
+	raw = append(raw, t.current())
+	t.advance(1)
+
+	flags := []rune{}
+	for unicode.IsLetter(t.current()) {
+		r := t.current()
+		raw = append(raw, r)
+		t.advance(1)
+		if !strings.ContainsRune(regexFlags, r) {
+			return &Token{Type: Unknown, Raw: string(raw)}
+		}
+		if !strings.ContainsRune(string(flags), r) {
+			flags = append(flags, r)
+		}
+	}
+
+	if len(flags) > 0 {
+		pattern = append([]rune("(?"+string(flags)+")"), pattern...)
+	}
+	return &Token{Type: RegexLiteral, Raw: string(pattern)}
+}
+
+func (t *Tokenizer) current() rune {
+	return t.PeekN(0)
+}
+
+func (t *Tokenizer) peek() rune {
+	return t.PeekN(1)
 }
 
 func (t *Tokenizer) readWord() string {
@@ -266,11 +1068,11 @@ func (t *Tokenizer) readWord() string {
 		r := t.current()
 
 		if r == -1 || unicode.IsSpace(r) || r == '`' || r == '\'' ||
-			r == '"' || r == ',' || r == '(' || r == ')' {
+			r == '"' || r == ',' || r == ';' || r == '(' || r == ')' {
 			return string(word)
 		}
 
 		word = append(word, r)
-		t.input = t.input[1:]
+		t.advance(1)
 	}
 }
@@ -1 +1,276 @@
 package query
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenizerPosition(t *testing.T) {
+	input := "SELECT name,\n  size FROM .\nWHERE name = 'héllo wörld' AND size >= 5"
+
+	type Position struct {
+		Raw    string
+		Line   int
+		Column int
+	}
+
+	expected := []Position{
+		{"SELECT", 1, 1},
+		{"name", 1, 8},
+		{",", 1, 12},
+		{"size", 2, 3},
+		{"FROM", 2, 8},
+		{".", 2, 13},
+		{"WHERE", 3, 1},
+		{"name", 3, 7},
+		{"=", 3, 12},
+		{"héllo wörld", 3, 14},
+		{"AND", 3, 28},
+		{"size", 3, 32},
+		{">=", 3, 37},
+		{"5", 3, 40},
+	}
+
+	actual := make([]Position, 0)
+	for _, tok := range NewTokenizer(input).All() {
+		actual = append(actual, Position{tok.Raw, tok.Line, tok.Column})
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+}
+
+func TestTokenizerPositionNewline(t *testing.T) {
+	tokenizer := NewTokenizer("ü\nname")
+	tokenizer.Next()
+
+	tok := tokenizer.Next()
+	if tok.Line != 2 || tok.Column != 1 {
+		t.Fatalf("\nExpected line 2, column 1\n     Got line %d, column %d", tok.Line, tok.Column)
+	}
+	if tokenizer.Line != 2 || tokenizer.Column != 5 {
+		t.Fatalf("\nExpected tokenizer at line 2, column 5\n     Got line %d, column %d",
+			tokenizer.Line, tokenizer.Column)
+	}
+}
+
+func TestTokenizerReset(t *testing.T) {
+	tokenizer := NewTokenizer("SELECT name, size FROM ~/Desktop WHERE size > 5")
+	tokenizer.Next()
+	tokenizer.Next()
+
+	tokenizer.Reset("FROM .\nWHERE")
+	expected := []Token{
+		{Type: From, Raw: "FROM", Line: 1, Column: 1},
+		{Type: Identifier, Raw: ".", Line: 1, Column: 6},
+		{Type: Where, Raw: "WHERE", Line: 2, Column: 1},
+	}
+	if actual := tokenizer.All(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, actual)
+	}
+
+	tokenizer.Reset("")
+	if tok := tokenizer.Next(); tok != nil {
+		t.Fatalf("\nExpected no tokens\n     Got %v", tok)
+	}
+}
+
+var benchmarkQueries = []string{
+	"SELECT name FROM . WHERE name LIKE %.go",
+	"SELECT name, size FROM ~/Desktop, -.git WHERE size >= 10kb ORDER BY size DESC",
+	"SELECT * FROM . WHERE name = main.go AND (size >= 10.5kb OR size < 100)",
+	"SELECT name, time FROM . WHERE time > 'Apr 01 2017 00 00'",
+}
+
+func BenchmarkTokenizerNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			NewTokenizer(benchmarkQueries[j%len(benchmarkQueries)]).All()
+		}
+	}
+}
+
+func BenchmarkTokenizerReset(b *testing.B) {
+	b.ReportAllocs()
+	tokenizer := new(Tokenizer)
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			tokenizer.Reset(benchmarkQueries[j%len(benchmarkQueries)])
+			tokenizer.All()
+		}
+	}
+}
+
+// Return the queries of testdata/bench_queries.txt (one per line), which are
+// shared by the benchmarks of each package so that they're comparable across
+// commits.
+func readBenchQueries(b *testing.B) []string {
+	contents, err := ioutil.ReadFile(filepath.Join("..", "testdata", "bench_queries.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(contents)), "\n")
+}
+
+func BenchmarkTokenizerAll(b *testing.B) {
+	queries := readBenchQueries(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, query := range queries {
+			NewTokenizer(query).All()
+		}
+	}
+}
+
+func TestTokenizerAllStrict(t *testing.T) {
+	tokens, err := NewTokenizer("SELECT name FROM . WHERE name = 'a!b' AND size > 5").AllStrict()
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if len(tokens) != 12 {
+		t.Fatalf("\nExpected 12 tokens\n     Got %v", tokens)
+	}
+
+	type Case struct {
+		input    string
+		expected *ErrUnknownToken
+	}
+
+	cases := []Case{
+		{"SELECT name FROM . WHERE size ! 1000", &ErrUnknownToken{Raw: "!", Line: 1, Column: 31}},
+		{"SELECT name FROM .\nWHERE name = 'foo", &ErrUnknownToken{Raw: "'foo", Line: 2, Column: 14}},
+	}
+
+	for _, c := range cases {
+		_, err := NewTokenizer(c.input).AllStrict()
+		if !reflect.DeepEqual(err, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, err)
+		}
+		if !strings.Contains(err.Error(), c.expected.Raw) {
+			t.Fatalf("\nExpected message to contain %q\n     Got %q", c.expected.Raw, err.Error())
+		}
+	}
+
+	// All still includes unknown tokens.
+	if tokens := NewTokenizer("size ! 1000").All(); len(tokens) != 3 || tokens[1].Type != Unknown {
+		t.Fatalf("\nExpected an unknown token\n     Got %v", tokens)
+	}
+}
+
+func TestTokenizerOperators(t *testing.T) {
+	type Case struct {
+		input    string
+		expected []TokenType
+	}
+
+	// The operators are only tokens by themselves, not within paths and
+	// patterns.
+	cases := []Case{
+		{"size + 1 * 2 / 3 % 4", []TokenType{Identifier, Plus, Identifier, Star, Identifier, Slash, Identifier, Percent, Identifier}},
+		{"-size", []TokenType{Minus, Identifier}},
+		{"SELECT * FROM /", []TokenType{Select, Star, From, Slash}},
+		{"FROM ./a/b WHERE name LIKE %.go", []TokenType{From, Identifier, Where, Identifier, Like, Identifier}},
+		{"size/2 c++ 50%", []TokenType{Identifier, Identifier, Identifier}},
+		{"mode & 1 | 2 ^ ~ 3", []TokenType{Identifier, Ampersand, Identifier, Pipe, Identifier, Caret, Tilde, Identifier}},
+		{"~0 << 1 >> 2 <= 3", []TokenType{Tilde, Identifier, ShiftLeft, Identifier, ShiftRight, Identifier, LessThanEquals, Identifier}},
+		{"FROM ~/Desktop, ~", []TokenType{From, Identifier, Comma, Tilde}},
+		{"~mode", []TokenType{Tilde, Identifier}},
+		{"name ~ /a (b)/i OR name ~ /c/", []TokenType{Identifier, Tilde, RegexLiteral, Or, Identifier, Tilde, RegexLiteral}},
+		{"size / 2 ~ /c", []TokenType{Identifier, Slash, Identifier, Tilde, Unknown}},
+	}
+
+	for _, c := range cases {
+		actual := make([]TokenType, 0)
+		for _, tok := range NewTokenizer(c.input).All() {
+			actual = append(actual, tok.Type)
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, actual)
+		}
+	}
+}
+
+func TestTokenizerPeekN(t *testing.T) {
+	tokenizer := NewTokenizer("ab")
+
+	type Case struct {
+		n        int
+		expected rune
+	}
+
+	cases := []Case{
+		{0, tokenizer.current()},
+		{1, tokenizer.peek()},
+		{0, 'a'},
+		{1, 'b'},
+		{2, -1},
+		{-1, -1},
+	}
+
+	for _, c := range cases {
+		if actual := tokenizer.PeekN(c.n); actual != c.expected {
+			t.Fatalf("\nExpected PeekN(%d) = %q\n     Got %q", c.n, c.expected, actual)
+		}
+	}
+
+	tokenizer.All()
+	for _, n := range []int{0, 1, 5} {
+		if actual := tokenizer.PeekN(n); actual != -1 {
+			t.Fatalf("\nExpected PeekN(%d) = -1 on exhausted input\n     Got %q", n, actual)
+		}
+	}
+	if tokenizer.current() != -1 || tokenizer.peek() != -1 {
+		t.Fatalf("\nExpected current() and peek() to be -1 on exhausted input")
+	}
+}
+
+func FuzzTokenizer(f *testing.F) {
+	for _, query := range benchmarkQueries {
+		f.Add(query)
+	}
+	for typ := Select; typ.String() != Unknown.String(); typ++ {
+		f.Add(strings.ToUpper(typ.String()))
+	}
+	for _, seed := range []string{
+		"<=>=<>!=(),;-", "<<==>>", "'", "`unterminated", "\"", "''``\"\"",
+		"héllo wörld", "名前 = '値'", "\u200b\u00a0\u2028", "\xff\xfe",
+		"\x00", "a\x00b", " ", "\t\n\r\v\f", "\n\n\n",
+		strings.Repeat("a", 1<<16), strings.Repeat("(", 1<<12), strings.Repeat("'a' ", 1<<10),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		done := make(chan []Token)
+		go func() {
+			done <- NewTokenizer(input).All()
+		}()
+
+		var tokens []Token
+		select {
+		case tokens = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("\nExpected All to return for %q\n     Got no result after 5s", input)
+		}
+
+		// Each token consumes at least a rune of the input, so its position
+		// is after the previous token's.
+		if n := len([]rune(input)); len(tokens) > n {
+			t.Fatalf("\nExpected at most %d tokens\n     Got %v", n, tokens)
+		}
+		for i := 1; i < len(tokens); i++ {
+			prev, tok := tokens[i-1], tokens[i]
+			if tok.Line < prev.Line || tok.Line == prev.Line && tok.Column <= prev.Column {
+				t.Fatalf("\nExpected %v to be after %v (%d:%d)\n     Got %d:%d",
+					tok, prev, prev.Line, prev.Column, tok.Line, tok.Column)
+			}
+		}
+	})
+}
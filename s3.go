@@ -0,0 +1,155 @@
+//go:build s3
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["s3"] = newS3VFS
+}
+
+// The S3 operations used by an s3VFS, which are implemented by *s3.Client.
+type s3API interface {
+	s3.ListObjectsV2APIClient
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// An s3VFS is the S3 buckets, in which paths are URLs of the form
+// s3://bucket/key. The slash-separated components of each object's key are
+// directories (e.g. the object a/b.csv is the file b.csv in the directory a).
+type s3VFS struct {
+	client s3API
+	opts   query.QueryOptions
+}
+
+// Return the S3 filesystem, with credentials from the standard AWS credential
+// chain (e.g. the AWS_ACCESS_KEY_ID environment variable, or
+// ~/.aws/credentials).
+func newS3VFS(src string, opts query.QueryOptions) (vfs, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &s3VFS{client: s3.NewFromConfig(cfg), opts: opts}, nil
+}
+
+// Stat returns the information of the object at p, or of the directory at p
+// if there isn't one, but there are objects below it.
+func (s *s3VFS) Stat(p string) (os.FileInfo, error) {
+	bucket, key, err := parseBucketPath(p)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+
+	if key != "" {
+		out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err == nil {
+			return objectInfo{
+				name:    path.Base(key),
+				size:    aws.ToInt64(out.ContentLength),
+				modTime: aws.ToTime(out.LastModified),
+			}, nil
+		}
+	}
+
+	out, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(bucketPrefix(key)),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return objectInfo{name: bucket, dir: true}, nil
+	}
+	if len(out.Contents) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return objectInfo{name: path.Base(key), dir: true}, nil
+}
+
+// Walk the objects below root. Every page of the listing is read before the
+// first object is walked, since the directories aren't listed.
+func (s *s3VFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	bucket, key, err := parseBucketPath(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	root = strings.TrimSuffix(root, "/")
+	if !info.IsDir() {
+		return walkListing(root, info, nil, s.opts, fn)
+	}
+
+	prefix := bucketPrefix(key)
+	listing := make(map[string]os.FileInfo)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return fn(root, info, err)
+		}
+
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+
+			// Keys which end with a slash are (empty) directories.
+			if strings.HasSuffix(name, "/") {
+				if name = strings.TrimSuffix(name, "/"); name != "" {
+					listing[name] = impliedDir(path.Base(name))
+				}
+				continue
+			}
+
+			listing[name] = objectInfo{
+				name:    path.Base(name),
+				size:    aws.ToInt64(obj.Size),
+				modTime: aws.ToTime(obj.LastModified),
+			}
+		}
+	}
+
+	return walkListing(root, info, listing, s.opts, fn)
+}
+
+// Open returns the contents of the object at p.
+func (s *s3VFS) Open(p string) (io.ReadCloser, error) {
+	bucket, key, err := parseBucketPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
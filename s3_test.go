@@ -0,0 +1,142 @@
+//go:build s3
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A fakeS3 is a single bucket of objects, mapping each key to its size. Like
+// S3, a listing returns at most 1000 keys per page.
+type fakeS3 struct {
+	keys     []string
+	sizes    map[string]int64
+	modified time.Time
+	lists    int
+}
+
+func newFakeS3(sizes map[string]int64, modified time.Time) *fakeS3 {
+	s := &fakeS3{sizes: sizes, modified: modified}
+	for key := range sizes {
+		s.keys = append(s.keys, key)
+	}
+	sort.Strings(s.keys)
+	return s
+}
+
+func (s *fakeS3) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	s.lists++
+
+	maxKeys := 1000
+	if params.MaxKeys != nil && int(*params.MaxKeys) < maxKeys {
+		maxKeys = int(*params.MaxKeys)
+	}
+	start := 0
+	if params.ContinuationToken != nil {
+		start, _ = strconv.Atoi(*params.ContinuationToken)
+	}
+
+	out := &s3.ListObjectsV2Output{}
+	for i := start; i < len(s.keys); i++ {
+		if !strings.HasPrefix(s.keys[i], aws.ToString(params.Prefix)) {
+			continue
+		}
+		if len(out.Contents) == maxKeys {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(strconv.Itoa(i))
+			break
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(s.keys[i]),
+			Size:         aws.Int64(s.sizes[s.keys[i]]),
+			LastModified: aws.Time(s.modified),
+		})
+	}
+	return out, nil
+}
+
+func (s *fakeS3) HeadObject(ctx context.Context, params *s3.HeadObjectInput,
+	optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	size, ok := s.sizes[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(size), LastModified: aws.Time(s.modified)}, nil
+}
+
+func (s *fakeS3) GetObject(ctx context.Context, params *s3.GetObjectInput,
+	optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	size, ok := s.sizes[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(make([]byte, size)))}, nil
+}
+
+func TestS3(t *testing.T) {
+	modified := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	sizes := map[string]int64{"data/sub/a.json": 7, "other/b.csv": 1}
+	for i := 0; i < 2500; i++ {
+		sizes[fmt.Sprintf("data/part-%04d.csv", i)] = int64(i)
+	}
+	client := newFakeS3(sizes, modified)
+
+	previous := vfsProviders["s3"]
+	vfsProviders["s3"] = func(src string, opts query.QueryOptions) (vfs, error) {
+		return &s3VFS{client: client, opts: opts}, nil
+	}
+	defer func() { vfsProviders["s3"] = previous }()
+
+	lines, err := runLines("SELECT name FROM s3://bucket/data WHERE name LIKE %.csv", &options{count: true})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if lines[0] != "2500" {
+		t.Fatalf("\nExpected %d objects\n     Got %s", 2500, lines[0])
+	}
+	if client.lists < 3 {
+		t.Fatalf("\nExpected at least 3 pages to be listed\n     Got %d", client.lists)
+	}
+
+	lines, err = runLines("SELECT name FROM s3://bucket/data WHERE file IS dir", &options{})
+	if err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if expected := []string{"s3://bucket/data", "s3://bucket/data/sub"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, lines)
+	}
+
+	var buf bytes.Buffer
+	if err := run("SELECT name, size, time FROM s3://bucket/data/sub", &options{format: "json"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"name": "s3://bucket/data/sub/a.json",
+		"size": 7.0,
+		"time": modified.Format(time.RFC3339),
+	}
+	if len(rows) != 2 || !reflect.DeepEqual(rows[1], expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows)
+	}
+}
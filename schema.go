@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The version of JSON Schema which schemas are written in.
+const schemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// A JSON Schema document. Only the keywords which describe the json format's
+// output are included.
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Type                 interface{}        `json:"type"`
+	Format               string             `json:"format,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// Return the schema of a value of the attribute type.
+func newTypeSchema(t attributeType) *schema {
+	s := &schema{Type: t.jsonType, Format: t.format}
+	if t.nullable {
+		s.Type = []string{t.jsonType, "null"}
+	}
+	return s
+}
+
+// Return the schema of an object with a property for each of the columns,
+// each of which is required.
+func newObjectSchema(names []string, types []attributeType) *schema {
+	additional := false
+	s := &schema{
+		Type:                 "object",
+		Properties:           make(map[string]*schema, len(names)),
+		Required:             names,
+		AdditionalProperties: &additional,
+	}
+	for i, name := range names {
+		s.Properties[name] = newTypeSchema(types[i])
+	}
+	return s
+}
+
+// Write the JSON Schema of the query's output in the json format to w (of
+// the count of its results, if count is set). The schema only depends on the
+// query's columns, so the query isn't evaluated.
+func writeSchema(w io.Writer, q *query.Query, count bool) error {
+	var s *schema
+	if count {
+		s = newObjectSchema([]string{"count"}, []attributeType{{jsonType: "integer"}})
+	} else {
+		names := make([]string, 0, len(q.Columns))
+		types := make([]attributeType, 0, len(q.Columns))
+		for _, c := range q.Columns {
+			names = append(names, c.Name())
+			types = append(types, columnType(c))
+		}
+		s = &schema{Type: "array", Items: newObjectSchema(names, types)}
+	}
+	s.Schema = schemaVersion
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["series"] = newSeriesVFS
+}
+
+// A seriesVFS is the series of numbers of a GENERATE_SERIES source, which is
+// parsed as a source of the form series://start,end,step. Each number is an
+// empty regular file whose name and size are the number, at the path
+// series://start,end,step/number.
+type seriesVFS struct {
+	root             string // The source of the series, e.g. series://0,10,1.
+	start, end, step int64
+}
+
+// Return the filesystem of the series of numbers from start to end (both
+// inclusive, when the step reaches it) of the source series://start,end,step.
+func newSeriesVFS(src string, opts query.QueryOptions) (vfs, error) {
+	root := strings.TrimSuffix(src, "/")
+	args := strings.Split(root[len("series://"):], ",")
+	if len(args) != 3 {
+		return nil, fmt.Errorf("invalid series source %s: expected series://start,end,step", src)
+	}
+
+	var numbers [3]int64
+	for i, arg := range args {
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid series source %s: %v", src, err)
+		}
+		numbers[i] = n
+	}
+	if numbers[2] == 0 {
+		return nil, fmt.Errorf("invalid series source %s: the step cannot be 0", src)
+	}
+
+	return &seriesVFS{root: root, start: numbers[0], end: numbers[1], step: numbers[2]}, nil
+}
+
+// Return true iff n is one of the numbers of the series.
+func (s *seriesVFS) contains(n int64) bool {
+	if s.step > 0 && (n < s.start || n > s.end) || s.step < 0 && (n > s.start || n < s.end) {
+		return false
+	}
+	return (n-s.start)%s.step == 0
+}
+
+func (s *seriesVFS) Stat(p string) (os.FileInfo, error) {
+	p = strings.TrimSuffix(p, "/")
+	if p == s.root {
+		return impliedDir(path.Base(s.root)), nil
+	}
+
+	if strings.HasPrefix(p, s.root+"/") {
+		name := p[len(s.root)+1:]
+		if n, err := strconv.ParseInt(name, 10, 64); err == nil && s.contains(n) {
+			return objectInfo{name: name, size: n}, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// Open returns the contents of a number of the series, which are empty.
+func (s *seriesVFS) Open(p string) (io.ReadCloser, error) {
+	if _, err := s.Stat(p); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// Walk the numbers of the series in order. Unlike other filesystems, fn isn't
+// called for the root itself, since it isn't one of the numbers.
+func (s *seriesVFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := s.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if !info.IsDir() {
+		return fn(root, info, nil)
+	}
+
+	for n := s.start; s.contains(n); n += s.step {
+		name := strconv.FormatInt(n, 10)
+		if err := fn(s.root+"/"+name, objectInfo{name: name, size: n}, nil); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+
+		// Stop before the next number overflows.
+		if s.step > 0 && n > s.end-s.step || s.step < 0 && n < s.end-s.step {
+			break
+		}
+	}
+	return nil
+}
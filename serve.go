@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The largest request body which is read by the server.
+const maxRequestSize = 1 << 20
+
+// An HTTP server which evaluates the queries posted to /query. At most
+// maxResults results are returned for each query, and each query is given
+// timeout to complete. Only as many queries as there are slots are evaluated
+// at once, the rest wait for a free slot (or until they time out).
+type server struct {
+	timeout    time.Duration
+	maxResults int
+	slots      chan struct{}
+
+	// Origins of the browser-based tools which may use the server (see
+	// withCORS), none unless they're set with -allow-origin. An origin of *
+	// allows any.
+	origins []string
+}
+
+// The body of a request to /query.
+type queryRequest struct {
+	Query string `json:"query"`
+}
+
+// Errors returned by server.run when the query couldn't be evaluated, rather
+// than because it's invalid.
+var (
+	errTooManyQueries = errors.New("too many queries")
+	errTimeout        = errors.New("query timed out")
+)
+
+// The gRPC server, which is only set when built with the grpc tag (see
+// grpc.go), so that its dependencies aren't required otherwise. It serves the
+// same queries as s on addr.
+var grpcServe func(addr string, s *server) error
+
+// Create a server with the provided limits.
+func newServer(timeout time.Duration, maxResults, concurrency int) *server {
+	return &server{
+		timeout:    timeout,
+		maxResults: maxResults,
+		slots:      make(chan struct{}, concurrency),
+	}
+}
+
+// Return the handler of the server's HTTP endpoints.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", s.handleQuery)
+	mux.HandleFunc("/health", s.handleHealth)
+	return withCORS(mux, s.origins)
+}
+
+// Parse and evaluate the query within the server's limits. If there are more
+// than maxResults results, only the first maxResults are returned and
+// truncated is set. Errors other than errTooManyQueries and errTimeout mean
+// that the query is invalid.
+func (s *server) run(ctx context.Context, input string) (q *query.Query, results []result,
+	truncated bool, err error) {
+	q, qopts, err := s.prepare(input)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if q.Raise != nil {
+		return q, nil, false, nil
+	}
+	return s.evaluate(ctx, q, qopts)
+}
+
+// Parse the query, check that the server may evaluate it, and return it with
+// its options. Only queries (which start with SELECT or WITH, after any
+// pragmas) are evaluated, rather than rejecting each of the other statements,
+// so that a statement which changes files is never evaluated by accident. A
+// RAISE statement is run instead (so it has no results): the message of a
+// RAISE ERROR is the error of the query, and the messages of the other levels
+// are only logged.
+func (s *server) prepare(input string) (*query.Query, query.QueryOptions, error) {
+	q, err := query.RunParser(input)
+	if err != nil {
+		return nil, query.QueryOptions{}, err
+	}
+	switch statementType(input) {
+	case query.Select, query.With:
+	case query.Raise:
+		if err := runRaise(q.Raise, nil, log.Writer()); err != nil {
+			return nil, query.QueryOptions{}, err
+		}
+		return q, query.QueryOptions{}, nil
+	default:
+		return nil, query.QueryOptions{}, errors.New("only SELECT and WITH queries are supported")
+	}
+	if q.Into != nil {
+		return nil, query.QueryOptions{}, errors.New("INTO is not supported")
+	}
+	qopts, _, err := query.NewQueryOptions(q.Pragmas)
+	if err != nil {
+		return nil, query.QueryOptions{}, err
+	}
+	// Variables are only set by the queries of a query file, so a source which
+	// is one is never set.
+	if _, err := bindVariables(q, nil); err != nil {
+		return nil, query.QueryOptions{}, err
+	}
+	if err := checkSources(q, qopts); err != nil {
+		return nil, query.QueryOptions{}, err
+	}
+	return q, qopts, nil
+}
+
+// Return the type of the first token of the statement after its pragmas
+// (PRAGMA name = value, each optionally followed by a semicolon), or Unknown
+// if there isn't one.
+func statementType(input string) query.TokenType {
+	t := query.NewTokenizer(input)
+	tok := t.Next()
+	for tok != nil && tok.Type == query.Pragma {
+		for i := 0; i < 3; i++ {
+			t.Next()
+		}
+		if tok = t.Next(); tok != nil && tok.Type == query.Semicolon {
+			tok = t.Next()
+		}
+	}
+	if tok == nil {
+		return query.Unknown
+	}
+	return tok.Type
+}
+
+// Wait for a free slot, and return the function which frees it, or
+// errTooManyQueries if ctx is done first.
+func (s *server) acquire(ctx context.Context) (func(), error) {
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-ctx.Done():
+		return nil, errTooManyQueries
+	}
+}
+
+// Evaluate the prepared query within the server's limits, like run.
+func (s *server) evaluate(ctx context.Context, q *query.Query, qopts query.QueryOptions) (*query.Query,
+	[]result, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer release()
+
+	// Evaluate one result past the maximum, to know if there are more.
+	if q.Limit < 0 || q.Limit > s.maxResults {
+		q.Limit = s.maxResults + 1
+	}
+	results, err := evaluateWith(ctx, q, qopts, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if ctx.Err() != nil {
+		return nil, nil, false, errTimeout
+	}
+	truncated := false
+	if len(results) > s.maxResults {
+		results, truncated = results[:s.maxResults], true
+	}
+	if q.Pivot {
+		if q, results, err = pivotResults(q, results); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	return q, results, truncated, nil
+}
+
+// Allow the server to be used by browser-based tools on each of the origins
+// (or any, if one of them is *), and respond to each preflight request. The
+// CORS headers are only set for a request from one of the origins, so that
+// other sites can't read the results of the queries they send.
+func withCORS(h http.Handler, origins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && allowsOrigin(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Fsql-Truncated")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Return true iff origin is one of origins, or one of them is *.
+func allowsOrigin(origins []string, origin string) bool {
+	for _, o := range origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// Evaluate the query of the request and respond with its results, in the same
+// form as the json format. If there are more than maxResults results, only the
+// first maxResults are sent and the X-Fsql-Truncated header is set.
+func (s *server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	var req queryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+
+	q, results, truncated, err := s.run(r.Context(), req.Query)
+	switch err {
+	case nil:
+	case errTooManyQueries, errTimeout:
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if truncated {
+		w.Header().Set("X-Fsql-Truncated", "true")
+	}
+
+	var buf bytes.Buffer
+	if err := formatJSON(&buf, q.Columns, results); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+// Respond with the error as a JSON object.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// Run the server with the options in args (the arguments which follow
+// `fsql serve`).
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("fsql serve", flag.ExitOnError)
+	host := fs.String("addr", "127.0.0.1", "listen on `host` (e.g. 0.0.0.0 for every interface)")
+	port := fs.Int("port", 8080, "listen on `port`")
+	timeout := fs.Duration("timeout", 30*time.Second, "the longest a query may take")
+	maxResults := fs.Int("max-results", 10000, "the most results returned for a query")
+	concurrency := fs.Int("concurrency", 4, "the most queries evaluated at once")
+	grpcPort := fs.Int("grpc-port", 0, "also serve queries over gRPC on `port` (requires building with -tags grpc)")
+	allowOrigin := fs.String("allow-origin", "", "allow browser-based tools on each of the comma-separated `origins` (or * for any)")
+	fs.Parse(args)
+
+	if *maxResults <= 0 || *concurrency <= 0 {
+		return errors.New("-max-results and -concurrency must be positive")
+	}
+
+	s := newServer(*timeout, *maxResults, *concurrency)
+	if *allowOrigin != "" {
+		for _, origin := range strings.Split(*allowOrigin, ",") {
+			s.origins = append(s.origins, strings.TrimSpace(origin))
+		}
+	}
+
+	if *grpcPort != 0 {
+		if grpcServe == nil {
+			return errors.New("-grpc-port requires building with -tags grpc")
+		}
+		go func() {
+			addr := net.JoinHostPort(*host, strconv.Itoa(*grpcPort))
+			log.Printf("serving gRPC on %s", addr)
+			log.Fatal(grpcServe(addr, s))
+		}()
+	}
+
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	log.Printf("listening on %s", addr)
+	return http.ListenAndServe(addr, s.handler())
+}
@@ -0,0 +1,248 @@
+//go:build sftp
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["sftp"] = newSFTPVFS
+}
+
+// The private keys which are tried when a source doesn't provide one, relative
+// to the home directory.
+var sftpDefaultKeys = []string{".ssh/id_ed25519", ".ssh/id_ecdsa", ".ssh/id_rsa"}
+
+// The open SFTP connections, by user@host:port. Connections are shared by
+// every query (e.g. of fsql serve) of the same server.
+var sftpPool = struct {
+	sync.Mutex
+	clients map[string]*sftp.Client
+}{clients: make(map[string]*sftp.Client)}
+
+// An sftpVFS is a server's filesystem, in which paths are URLs of the form
+// sftp://user@host:port/path.
+type sftpVFS struct {
+	client *sftp.Client
+	opts   query.QueryOptions
+}
+
+// Return the filesystem of the server of the source, which is of the form
+// sftp://[user[:password]@]host[:port]/path[?key=file]. The user defaults to
+// the current user, and the port to 22. The server is authenticated by
+// ~/.ssh/known_hosts, and the user by the password, the SSH agent, and the
+// private key file (or, without one, the default keys in ~/.ssh).
+func newSFTPVFS(src string, opts query.QueryOptions) (vfs, error) {
+	u, err := url.Parse(sftpAuthority(src))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SFTP source %s: %v", src, err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SFTP source %s: missing host", src)
+	}
+
+	username := u.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		username = current.Username
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	sftpPool.Lock()
+	defer sftpPool.Unlock()
+
+	key := username + "@" + addr
+	if client, ok := sftpPool.clients[key]; ok {
+		// Connections which were closed (e.g. by the server) are replaced.
+		if _, err := client.Getwd(); err == nil {
+			return &sftpVFS{client: client, opts: opts}, nil
+		}
+		client.Close()
+		delete(sftpPool.clients, key)
+	}
+
+	password, _ := u.User.Password()
+	config, err := sftpConfig(username, password, u.Query().Get("key"))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s over SFTP: %v", addr, err)
+	}
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s over SFTP: %v", addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("starting an SFTP session on %s: %v", addr, err)
+	}
+
+	sftpPool.clients[key] = client
+	return &sftpVFS{client: client, opts: opts}, nil
+}
+
+// Return the scheme and authority (and query) of the SFTP source, without
+// its path, which isn't escaped (so it can't be parsed as part of a URL).
+func sftpAuthority(src string) string {
+	authority, rawQuery := src, ""
+	if i := strings.IndexByte(src, '?'); i >= 0 {
+		authority, rawQuery = src[:i], src[i:]
+	}
+
+	rest := authority[len("sftp://"):]
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	return "sftp://" + rest + rawQuery
+}
+
+// Return the path on the server of the SFTP path p (without its query), or
+// "." (the user's home directory) if it doesn't have one.
+func sftpPath(p string) string {
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		p = p[:i]
+	}
+
+	rest := p[len("sftp://"):]
+	if i := strings.IndexByte(rest, '/'); i >= 0 && i < len(rest)-1 {
+		return rest[i:]
+	}
+	return "."
+}
+
+// Return the SSH client configuration of the user.
+func sftpConfig(username, password, keyFile string) (*ssh.ClientConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %v", err)
+	}
+
+	var methods []ssh.AuthMethod
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	keyFiles := []string{keyFile}
+	if keyFile == "" {
+		keyFiles = nil
+		for _, key := range sftpDefaultKeys {
+			keyFiles = append(keyFiles, filepath.Join(home, key))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range keyFiles {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			if keyFile != "" {
+				return nil, fmt.Errorf("reading private key: %v", err)
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("parsing private key %s: %v", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            methods,
+		HostKeyCallback: hostKeys,
+	}, nil
+}
+
+func (s *sftpVFS) Stat(p string) (os.FileInfo, error) {
+	return s.client.Stat(sftpPath(p))
+}
+
+func (s *sftpVFS) Open(p string) (io.ReadCloser, error) {
+	return s.client.Open(sftpPath(p))
+}
+
+// Walk the file tree rooted at root on the server, like walk (but without
+// following symbolic links).
+func (s *sftpVFS) Walk(root string, fn filepath.WalkFunc) error {
+	remote := sftpPath(root)
+	info, err := s.client.Lstat(remote)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = s.walk(strings.TrimSuffix(root, "/"), remote, info, 0, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// Walk the file tree rooted at p (which is remote on the server), which is
+// depth levels below the root.
+func (s *sftpVFS) walk(p, remote string, info os.FileInfo, depth int, fn filepath.WalkFunc) error {
+	if err := fn(p, info, nil); err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() || (s.opts.MaxDepth > 0 && depth >= s.opts.MaxDepth) {
+		return nil
+	}
+
+	entries, err := s.client.ReadDir(remote)
+	if err != nil {
+		return fn(p, info, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		err := s.walk(p+"/"+entry.Name(), path.Join(remote, entry.Name()), entry, depth+1, fn)
+		if err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+//go:build sftp
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return a client of an in-memory SFTP server, which is closed when the test
+// ends.
+func newTestSFTPClient(t *testing.T) *sftp.Client {
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestSFTP(t *testing.T) {
+	client := newTestSFTPClient(t)
+	files := map[string]int{
+		"/data/a.txt":       3,
+		"/data/logs/b.log":  2 << 20,
+		"/data/logs/c.log":  10,
+		"/data/logs/d/e.gz": 4 << 20,
+	}
+	for _, dir := range []string{"/data", "/data/logs", "/data/logs/d"} {
+		if err := client.Mkdir(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, size := range files {
+		f, err := client.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	provider := vfsProviders["sftp"]
+	defer func() { vfsProviders["sftp"] = provider }()
+	vfsProviders["sftp"] = func(src string, opts query.QueryOptions) (vfs, error) {
+		return &sftpVFS{client: client, opts: opts}, nil
+	}
+
+	type Case struct {
+		query    string
+		expected []string
+	}
+
+	cases := []Case{
+		{
+			query: "SELECT name FROM sftp://user@host/data",
+			expected: []string{
+				"sftp://user@host/data",
+				"sftp://user@host/data/a.txt",
+				"sftp://user@host/data/logs",
+				"sftp://user@host/data/logs/b.log",
+				"sftp://user@host/data/logs/c.log",
+				"sftp://user@host/data/logs/d",
+				"sftp://user@host/data/logs/d/e.gz",
+			},
+		},
+		{
+			query: "SELECT name, size FROM sftp://user@host:2222/data WHERE size > 1MB",
+			expected: []string{
+				"sftp://user@host:2222/data/logs/b.log\t2097152",
+				"sftp://user@host:2222/data/logs/d/e.gz\t4194304",
+			},
+		},
+		{
+			query:    "SELECT name FROM sftp://host/data/logs WHERE file IS reg AND name LIKE %.log",
+			expected: []string{"sftp://host/data/logs/b.log", "sftp://host/data/logs/c.log"},
+		},
+		{
+			query:    "SELECT name, size FROM sftp://host/data/a.txt",
+			expected: []string{"sftp://host/data/a.txt\t3"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines(c.query, &options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Fatalf("%s\nExpected %v\n     Got %v", c.query, c.expected, actual)
+		}
+	}
+
+	opts := query.QueryOptions{MaxDepth: 1}
+	fsys := &sftpVFS{client: client, opts: opts}
+	var walked []string
+	err := fsys.Walk("sftp://host/data/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"sftp://host/data", "sftp://host/data/a.txt", "sftp://host/data/logs"}
+	if !reflect.DeepEqual(expected, walked) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, walked)
+	}
+}
+
+func TestSFTPConnectionErrors(t *testing.T) {
+	home := t.TempDir()
+	if err := os.Mkdir(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	type Case struct {
+		src      string
+		expected string
+	}
+
+	cases := []Case{
+		{src: "sftp:///data", expected: "invalid SFTP source sftp:///data: missing host"},
+		{src: "sftp://user@127.0.0.1:1/data", expected: "connecting to 127.0.0.1:1 over SFTP: "},
+		{src: "sftp://user@127.0.0.1:1/data?key=" + filepath.Join(home, "missing"),
+			expected: "connecting to 127.0.0.1:1 over SFTP: reading private key: "},
+	}
+
+	for _, c := range cases {
+		fsys, err := newSFTPVFS(c.src, query.QueryOptions{})
+		if err == nil || fsys != nil {
+			t.Fatalf("%v\nExpected an error", c.src)
+		}
+		if !strings.HasPrefix(err.Error(), c.expected) {
+			t.Fatalf("%v\nExpected %v\n     Got %v", c.src, c.expected, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The I/O operations which a query performs: how many files' information it
+// reads (with Lstat, or Stat), how many directories it lists, and how many
+// files' contents it reads.
+type ioCounts struct {
+	lstats       int
+	dirReads     int
+	contentReads int
+}
+
+// A simulateVFS wraps the filesystem of a source, counting the operations of
+// its walk. The information of each file and the listing of each directory
+// are read from the filesystem, since the walk depends on them, but the
+// contents of each file are mock data (they're empty), so none are read.
+type simulateVFS struct {
+	fsys   vfs
+	opts   query.QueryOptions
+	counts *ioCounts
+}
+
+// Each file which the walk calls fn for was read with Lstat, and each of the
+// directories which fn doesn't skip (and which are above opts.MaxDepth, and on
+// root's device when opts.NoCrossDevice is set) are then listed.
+func (s simulateVFS) Walk(root string, fn filepath.WalkFunc) error {
+	var rootDevice *uint64
+	if info, err := s.fsys.Stat(root); err == nil && s.opts.NoCrossDevice {
+		if dev, ok := device(root, info); ok {
+			rootDevice = &dev
+		}
+	}
+
+	return s.fsys.Walk(root, func(path string, info os.FileInfo, err error) error {
+		s.counts.lstats++
+		if err := fn(path, info, err); err != nil {
+			return err
+		}
+		if err == nil && info.IsDir() && !crossesDevice(rootDevice, path, info) &&
+			(s.opts.MaxDepth <= 0 || depthBelow(root, path) < s.opts.MaxDepth) {
+			s.counts.dirReads++
+		}
+		return nil
+	})
+}
+
+func (s simulateVFS) Stat(path string) (os.FileInfo, error) {
+	s.counts.lstats++
+	return s.fsys.Stat(path)
+}
+
+func (s simulateVFS) Open(path string) (io.ReadCloser, error) {
+	s.counts.contentReads++
+	return ioutil.NopCloser(strings.NewReader("")), nil
+}
+
+// Evaluate the query with each of its sources' filesystems wrapped in a
+// simulateVFS, and write the I/O operations it performed to w instead of its
+// results. Nothing is written by INTO, and conditions on the files' contents
+// (e.g. CONTAINS_TEXT) count their reads, but are evaluated as if each file
+// were empty.
+func runSimulate(q *query.Query, qopts query.QueryOptions, w io.Writer) error {
+	counts := new(ioCounts)
+
+	realSourceVFS, realReadFile := sourceVFS, readFile
+	defer func() { sourceVFS, readFile = realSourceVFS, realReadFile }()
+	sourceVFS = func(src string, opts query.QueryOptions) (vfs, error) {
+		fsys, err := realSourceVFS(src, opts)
+		if err != nil {
+			return nil, err
+		}
+		return simulateVFS{fsys: fsys, opts: opts, counts: counts}, nil
+	}
+	readFile = func(path string) ([]byte, error) {
+		counts.contentReads++
+		return nil, nil
+	}
+
+	if _, err := evaluateWith(context.Background(), q, qopts, nil); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "lstat calls: %d\ndirectory reads: %d\ncontent reads: %d\n",
+		counts.lstats, counts.dirReads, counts.contentReads)
+	return err
+}
@@ -0,0 +1,116 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The table which the results are written to.
+const sqliteTable = "files"
+
+func init() {
+	destinationWriters["sqlite"] = writeSQLite
+}
+
+// Return the SQLite type of the column's values.
+func sqliteType(c query.Column) string {
+	switch columnType(c).jsonType {
+	case "integer":
+		return "INTEGER"
+	case "number":
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+// Return the SQLite value of the i-th column of the query for the result.
+func sqliteValue(r result, i int, c query.Column) interface{} {
+	switch v := r.column(i, c).(type) {
+	case os.FileMode:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case string:
+		if v == "" && columnType(c).nullable {
+			return nil
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// Quote the identifier for use in an SQLite statement.
+func sqliteQuote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Write the results to the files table of the SQLite database at dest.Path,
+// creating the table if it doesn't exist (with a path column, followed by a
+// column for each of the columns). When dest.Replace is set, any rows with the
+// same path as one of the results are replaced.
+func writeSQLite(dest *query.Destination, columns []query.Column, results []result) error {
+	db, err := sql.Open("sqlite3", dest.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	definitions := []string{"path TEXT NOT NULL"}
+	names := []string{"path"}
+	for _, c := range columns {
+		definitions = append(definitions, sqliteQuote(c.Name())+" "+sqliteType(c))
+		names = append(names, sqliteQuote(c.Name()))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)",
+		sqliteTable, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s)",
+		sqliteTable, strings.Join(names, ", "), strings.Repeat(", ?", len(columns))))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	remove, err := tx.Prepare(fmt.Sprintf("DELETE FROM %s WHERE path = ?", sqliteTable))
+	if err != nil {
+		return err
+	}
+	defer remove.Close()
+
+	for _, r := range results {
+		if dest.Replace {
+			if _, err := remove.Exec(r.path); err != nil {
+				return err
+			}
+		}
+
+		values := []interface{}{r.path}
+		for i, c := range columns {
+			values = append(values, sqliteValue(r, i, c))
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,74 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLite(t *testing.T) {
+	dir := createTree(t, map[string]string{"a.go": "aaa", "b": "b"})
+	path := filepath.Join(t.TempDir(), "files.db")
+	input := "SELECT name, size, ext FROM " + dir + " WHERE file IS reg INTO SQLITE '" + path + "'"
+
+	for i := 0; i < 2; i++ {
+		if _, err := runLines(input, &options{}); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var schema string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'files'").Scan(&schema); err != nil {
+		t.Fatal(err)
+	}
+	expected := `CREATE TABLE files (path TEXT NOT NULL, "name" TEXT, "size" INTEGER, "ext" TEXT)`
+	if schema != expected {
+		t.Fatalf("\nExpected %q\n     Got %q", expected, schema)
+	}
+
+	// Each run appends its results, unless they replace the existing ones.
+	count := func() (n int) {
+		if err := db.QueryRow("SELECT COUNT(*) FROM files").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(); n != 4 {
+		t.Fatalf("\nExpected 4 rows\n     Got %d", n)
+	}
+	replace := strings.Replace(input, "INTO SQLITE", "INTO OR REPLACE SQLITE", 1)
+	if _, err := runLines(replace, &options{}); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("\nExpected 2 rows\n     Got %d", n)
+	}
+
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 isn't installed")
+	}
+	out, err := exec.Command("sqlite3", path,
+		"SELECT path, size, ifnull(ext, 'NULL') FROM files ORDER BY path").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := strings.Split(strings.TrimSpace(string(out)), "\n")
+	expectedRows := []string{
+		filepath.Join(dir, "a.go") + "|3|.go",
+		filepath.Join(dir, "b") + "|1|NULL",
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Fatalf("\nExpected %q\n     Got %q", expectedRows, rows)
+	}
+}
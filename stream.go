@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The terminator of each row of the formats whose rows may be written as soon
+// as they're found, since each row is written on its own.
+var streamTerminators = map[string]string{
+	"":                 "\n",
+	"default":          "\n",
+	"machine-readable": "\x00",
+}
+
+// Return true iff the query's results may be written as they're found, rather
+// than once they've all been: none of its clauses needs all of the results at
+// once, to sort, group, number, or compare them with each other. QUALIFY
+// filters the results once they've all been found, so they can't be written
+// before it.
+func canStream(q *query.Query) bool {
+	if len(q.OrderBy) > 0 || isGrouped(q) || q.Distinct || q.Pivot || q.Unpivot != nil || q.Join != nil ||
+		q.Qualify != nil {
+		return false
+	}
+	for _, c := range q.Columns {
+		if c.Window != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate the query, along with each of its CTEs, like evaluateWith, but pass
+// each of its results to emit as soon as it's found (see evaluateEach). The
+// walk stops once emit returns false, or once one of the query's expressions
+// overflows, whose error is returned.
+func streamWith(ctx context.Context, q *query.Query, qopts query.QueryOptions, prog *progress,
+	emit func(result) bool) (err error) {
+	defer recoverEvaluation(&err)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tables := make(map[string][]result, len(q.With))
+	for _, cte := range q.With {
+		tables[cte.Name] = evaluate(ctx, cte.Query, tables, qopts, prog, nil)
+	}
+	evaluateEach(ctx, q, tables, qopts, prog, nil, func(r result) {
+		if !emit(r) {
+			cancel()
+		}
+	})
+	return nil
+}
+
+// Write each of the results of the query (which must satisfy canStream) to w
+// as soon as it's found, with each row followed by terminator. The results
+// before the query's OFFSET are skipped, and the walk stops at its LIMIT.
+func streamResults(q *query.Query, qopts query.QueryOptions, prog *progress, w io.Writer,
+	terminator string) error {
+	skipped, written := 0, 0
+	var err error
+
+	evalErr := streamWith(context.Background(), q, qopts, prog, func(r result) bool {
+		if err != nil || q.Limit >= 0 && written >= q.Limit {
+			return false
+		}
+		if skipped < q.Offset {
+			skipped++
+			return true
+		}
+
+		if err = writeDelimitedRow(w, q.Columns, r, terminator); err != nil {
+			return false
+		}
+		written++
+		return q.Limit < 0 || written < q.Limit
+	})
+	if err == nil {
+		err = evalErr
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The width of the tabulate format's lines, unless the query sets it with
+// WIDTH.
+const defaultTabulateWidth = 80
+
+// The characters which the tabulate format draws its borders with.
+type tabulateBorders struct {
+	horizontal, vertical string
+
+	// The corners and junctions of the top, middle (below the header), and
+	// bottom borders, from left to right.
+	top, middle, bottom [3]string
+
+	// The character which marks the end of a truncated value.
+	truncated string
+}
+
+var (
+	unicodeBorders = tabulateBorders{
+		horizontal: "─",
+		vertical:   "│",
+		top:        [3]string{"┌", "┬", "┐"},
+		middle:     [3]string{"├", "┼", "┤"},
+		bottom:     [3]string{"└", "┴", "┘"},
+		truncated:  "…",
+	}
+	asciiBorders = tabulateBorders{
+		horizontal: "-",
+		vertical:   "|",
+		top:        [3]string{"+", "+", "+"},
+		middle:     [3]string{"+", "+", "+"},
+		bottom:     [3]string{"+", "+", "+"},
+		truncated:  "~",
+	}
+)
+
+// Write the results as a table of the default width, with box-drawing borders.
+func formatTabulateDefault(w io.Writer, columns []query.Column, results []result) error {
+	return formatTabulate(w, columns, results, defaultTabulateWidth, false)
+}
+
+// Write the results as a table with borders (drawn with -, |, and + if ascii
+// is set, otherwise with box-drawing characters), each of whose lines is
+// exactly width columns wide. Unlike the markdown format, which pads each
+// column to its longest value, values are truncated to fit: each column but
+// the rightmost is as wide as its longest value, unless they don't fit, in
+// which case the widest are narrowed, and the rightmost column takes the
+// remaining space.
+func formatTabulate(w io.Writer, columns []query.Column, results []result, width int, ascii bool) error {
+	borders := unicodeBorders
+	if ascii {
+		borders = asciiBorders
+	}
+
+	rows := make([][]string, 0, len(results)+1)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = tabulateCell(c.Name())
+	}
+	rows = append(rows, header)
+	for _, r := range results {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = tabulateCell(formatValue(r.column(i, c)))
+		}
+		rows = append(rows, row)
+	}
+
+	widths, err := tabulateWidths(rows, width)
+	if err != nil {
+		return err
+	}
+
+	rule := func(corners [3]string) string {
+		parts := make([]string, len(widths))
+		for i, n := range widths {
+			parts[i] = strings.Repeat(borders.horizontal, n+2)
+		}
+		return corners[0] + strings.Join(parts, corners[1]) + corners[2]
+	}
+	line := func(cells []string) string {
+		parts := make([]string, len(cells))
+		for i, cell := range cells {
+			cell = truncate(cell, widths[i], borders.truncated)
+			parts[i] = " " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " "
+		}
+		return borders.vertical + strings.Join(parts, borders.vertical) + borders.vertical
+	}
+
+	lines := []string{rule(borders.top), line(rows[0]), rule(borders.middle)}
+	for _, row := range rows[1:] {
+		lines = append(lines, line(row))
+	}
+	lines = append(lines, rule(borders.bottom))
+
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the width of each of the columns of the rows, such that the rows'
+// lines (with their borders, and the space on either side of each value) are
+// width columns wide. If the columns' longest values don't fit, the widest
+// columns are narrowed until they do.
+func tabulateWidths(rows [][]string, width int) ([]int, error) {
+	n := len(rows[0])
+	if n == 0 {
+		return nil, errors.New("the tabulate format requires at least one column")
+	}
+	available := width - 3*n - 1
+	if available < n {
+		return nil, fmt.Errorf("WIDTH %d is too narrow for %d columns (at least %d are needed)", width, n, 4*n+1)
+	}
+
+	widths := make([]int, n)
+	total := 0
+	for i := range widths {
+		widths[i] = 1
+		for _, row := range rows {
+			if w := utf8.RuneCountInString(row[i]); w > widths[i] {
+				widths[i] = w
+			}
+		}
+		total += widths[i]
+	}
+
+	for ; total > available; total-- {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		widths[widest]--
+	}
+	widths[n-1] += available - total
+	return widths, nil
+}
+
+// Return the value as a cell of the tabulate format, in which each whitespace
+// character (e.g. a newline, or a tab) is a space, so that it's a single line.
+func tabulateCell(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// Return the value truncated to width runes, the last of which is marker if
+// it's truncated.
+func truncate(value string, width int, marker string) string {
+	if utf8.RuneCountInString(value) <= width {
+		return value
+	}
+	runes := []rune(value)
+	return string(runes[:width-1]) + marker
+}
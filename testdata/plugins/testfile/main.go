@@ -0,0 +1,20 @@
+// A plugin which registers the is_test_file attribute, which is true for Go
+// test files. It's built and loaded by TestPlugins.
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/kshvmdn/fsql/fsqlplugin"
+)
+
+func init() {
+	fsqlplugin.RegisterAttribute("is_test_file", fsqlplugin.AttributeFunc(
+		func(path string, info os.FileInfo) string {
+			if !info.IsDir() && strings.HasSuffix(info.Name(), "_test.go") {
+				return "true"
+			}
+			return "false"
+		}))
+}
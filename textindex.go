@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cmp "github.com/kshvmdn/fsql/compare"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// The name of the SQLite database which a directory's text index is stored
+// in, at the root of the directory.
+const textIndexFile = ".fsql_text_index"
+
+// The most trigrams of a CONTAINS_TEXT argument which are looked up in a text
+// index. The files which contain the rest are a subset of those which contain
+// these, so the candidates are still a superset of the matches.
+const maxTextTrigrams = 500
+
+// An indexedText is a file of a text index: its modification time and size
+// at the time it was indexed, and whether its contents were text (see
+// compare.IsText). Only text files have their trigrams indexed.
+type indexedText struct {
+	ModTime int64
+	Size    int64
+	Text    bool
+}
+
+// The metadata of a text index: when it was built, and how many files it has.
+type textIndexInfo struct {
+	Built time.Time
+	Files int
+}
+
+// A textIndex is an inverted index of the trigrams of the contents of the text
+// files below a directory (built by `fsql index build`), which is stored in a
+// SQLite database. The paths of its files are relative to the directory.
+type textIndex interface {
+	// Return each of the indexed files, by path.
+	files() (map[string]indexedText, error)
+
+	// Return the paths of the text files which contain each of the trigrams.
+	candidates(trigrams []string) (map[string]bool, error)
+
+	// Return the index's metadata.
+	info() (*textIndexInfo, error)
+
+	Close() error
+}
+
+// A textIndexWriter adds the files of a text index to a new database.
+type textIndexWriter interface {
+	add(path string, f indexedText, trigrams []string) error
+
+	// Write the index's metadata and close the database, or if info is nil,
+	// discard the files added so far.
+	close(info *textIndexInfo) error
+}
+
+// The functions which create and open the text index databases at a path,
+// which are only set when built with the sqlite tag (see textindex_sqlite.go),
+// so that its dependencies aren't required otherwise.
+var (
+	createTextIndex func(path string) (textIndexWriter, error)
+	openTextIndex   func(path string) (textIndex, error)
+)
+
+// Run the index command with its arguments: `build dir` builds the text index
+// of the directory, `rebuild dir` replaces it, and `info dir` prints its
+// metadata.
+func runIndex(args []string, w io.Writer) error {
+	if len(args) != 2 || args[0] != "build" && args[0] != "rebuild" && args[0] != "info" {
+		return errors.New("usage: fsql index build|rebuild|info dir")
+	}
+	if createTextIndex == nil {
+		return errors.New("fsql index requires building with -tags sqlite")
+	}
+
+	var info *textIndexInfo
+	var err error
+	if args[0] == "info" {
+		info, err = readTextIndexInfo(args[1])
+	} else {
+		info, err = buildTextIndex(args[1], args[0] == "rebuild")
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "built: %s\nfiles: %d\n", info.Built.Format(time.RFC3339), info.Files)
+	return nil
+}
+
+// Build the text index of the directory root, reading each of the files below
+// it. Unless rebuild is set, the directory mustn't already have an index. The
+// index is built in a temporary database, which replaces any existing index
+// once it's complete.
+func buildTextIndex(root string, rebuild bool) (*textIndexInfo, error) {
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("cannot index %s: not a directory", root)
+	}
+
+	path := filepath.Join(root, textIndexFile)
+	if _, err := os.Stat(path); err == nil && !rebuild {
+		return nil, fmt.Errorf("%s already has a text index (use fsql index rebuild)", root)
+	}
+
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	w, err := createTextIndex(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files which can't be read aren't indexed, so they're read when they're
+	// queried instead. The time and size of each file are those it had before
+	// it was read, so that a change while it's read makes it stale.
+	files := 0
+	err = walk(root, query.QueryOptions{}, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if filepath.Dir(p) == filepath.Clean(root) && strings.HasPrefix(info.Name(), textIndexFile) {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		f := indexedText{ModTime: info.ModTime().UnixNano(), Size: info.Size(), Text: cmp.IsText(contents)}
+		var grams []string
+		if f.Text {
+			grams = trigrams(string(contents))
+		}
+		files++
+		return w.add(rel, f, grams)
+	})
+	if err != nil {
+		w.close(nil)
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	info := &textIndexInfo{Built: time.Now(), Files: files}
+	if err := w.close(info); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return info, os.Rename(tmp, path)
+}
+
+// Return the metadata of the text index of the directory root.
+func readTextIndexInfo(root string) (*textIndexInfo, error) {
+	path := filepath.Join(root, textIndexFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%s has no text index (use fsql index build)", root)
+	}
+
+	index, err := openTextIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	return index.info()
+}
+
+// A textFilter rules out the files below a source which can't satisfy a
+// CONTAINS_TEXT condition without reading them, using the source's text
+// index. Files which have changed since they were indexed (or which weren't
+// indexed) aren't ruled out, so they're read. A nil *textFilter rules out
+// nothing.
+type textFilter struct {
+	root  string
+	index textIndex
+	files map[string]indexedText
+
+	// The candidates of each of the conditions' arguments, which are looked
+	// up as they're needed. A lookup which fails has no candidates, in which
+	// case none of the files are ruled out.
+	candidates map[string]map[string]bool
+}
+
+// Return the filter of the source's text index, or nil if the query has no
+// CONTAINS_TEXT condition, or the source has no text index.
+func newTextFilter(q *query.Query, src string) *textFilter {
+	if openTextIndex == nil || sourceScheme(src) != "" || !hasContainsText(q.ConditionTree) {
+		return nil
+	}
+
+	path := filepath.Join(src, textIndexFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	index, err := openTextIndex(path)
+	if err != nil {
+		return nil
+	}
+	files, err := index.files()
+	if err != nil {
+		index.Close()
+		return nil
+	}
+
+	return &textFilter{root: src, index: index, files: files, candidates: make(map[string]map[string]bool)}
+}
+
+// Return true iff the condition tree has a CONTAINS_TEXT condition.
+func hasContainsText(root *query.ConditionNode) bool {
+	if root == nil {
+		return false
+	}
+	if root.Condition != nil {
+		return root.Condition.Attribute == query.ContainsText.String()
+	}
+	return hasContainsText(root.Left) || hasContainsText(root.Right)
+}
+
+// Return true iff the file at path (with info) is known not to contain text,
+// because it hasn't changed since it was indexed, and either it isn't a text
+// file, or it's not one of the candidates which contain each of text's
+// trigrams. Text with fewer than three bytes can't be looked up.
+func (f *textFilter) excludes(text, path string, info os.FileInfo) bool {
+	if f == nil || info == nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return false
+	}
+	indexed, ok := f.files[rel]
+	if !ok || indexed.ModTime != info.ModTime().UnixNano() || indexed.Size != info.Size() {
+		return false
+	}
+	if !indexed.Text {
+		return true
+	}
+
+	grams := trigrams(text)
+	if len(grams) == 0 {
+		return false
+	}
+	candidates, ok := f.candidates[text]
+	if !ok {
+		if len(grams) > maxTextTrigrams {
+			grams = grams[:maxTextTrigrams]
+		}
+		candidates, _ = f.index.candidates(grams)
+		f.candidates[text] = candidates
+	}
+	return candidates != nil && !candidates[rel]
+}
+
+// Close the filter's index.
+func (f *textFilter) close() {
+	if f != nil {
+		f.index.Close()
+	}
+}
@@ -0,0 +1,189 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+func init() {
+	createTextIndex = createSQLiteTextIndex
+	openTextIndex = openSQLiteTextIndex
+}
+
+// The schema of a text index database: a row per file, a row per trigram of
+// each text file (which are blobs, since the contents may not be valid UTF-8),
+// and a single row of metadata.
+const textIndexSchema = `
+CREATE TABLE files (
+	id INTEGER PRIMARY KEY,
+	path TEXT NOT NULL UNIQUE,
+	mtime INTEGER NOT NULL,
+	size INTEGER NOT NULL,
+	text INTEGER NOT NULL
+);
+CREATE TABLE trigrams (
+	trigram BLOB NOT NULL,
+	file INTEGER NOT NULL REFERENCES files (id),
+	PRIMARY KEY (trigram, file)
+) WITHOUT ROWID;
+CREATE TABLE metadata (
+	built TEXT NOT NULL,
+	files INTEGER NOT NULL
+);
+`
+
+// A sqliteTextIndex is a text index stored in a SQLite database.
+type sqliteTextIndex struct {
+	db *sql.DB
+}
+
+// Open the text index database at path.
+func openSQLiteTextIndex(path string) (textIndex, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteTextIndex{db: db}, nil
+}
+
+func (s *sqliteTextIndex) files() (map[string]indexedText, error) {
+	rows, err := s.db.Query("SELECT path, mtime, size, text FROM files")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]indexedText)
+	for rows.Next() {
+		var path string
+		var f indexedText
+		if err := rows.Scan(&path, &f.ModTime, &f.Size, &f.Text); err != nil {
+			return nil, err
+		}
+		files[path] = f
+	}
+	return files, rows.Err()
+}
+
+func (s *sqliteTextIndex) candidates(trigrams []string) (map[string]bool, error) {
+	args := make([]interface{}, 0, len(trigrams)+1)
+	for _, gram := range trigrams {
+		args = append(args, []byte(gram))
+	}
+	args = append(args, len(trigrams))
+
+	rows, err := s.db.Query(`SELECT files.path FROM trigrams JOIN files ON files.id = trigrams.file
+		WHERE trigrams.trigram IN (?`+strings.Repeat(", ?", len(trigrams)-1)+`)
+		GROUP BY trigrams.file HAVING COUNT(*) = ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := make(map[string]bool)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		candidates[path] = true
+	}
+	return candidates, rows.Err()
+}
+
+func (s *sqliteTextIndex) info() (*textIndexInfo, error) {
+	var built string
+	info := new(textIndexInfo)
+	if err := s.db.QueryRow("SELECT built, files FROM metadata").Scan(&built, &info.Files); err != nil {
+		return nil, err
+	}
+
+	var err error
+	info.Built, err = time.Parse(time.RFC3339, built)
+	return info, err
+}
+
+func (s *sqliteTextIndex) Close() error {
+	return s.db.Close()
+}
+
+// A sqliteTextIndexWriter adds the files of a text index to a new SQLite
+// database in a single transaction.
+type sqliteTextIndexWriter struct {
+	db         *sql.DB
+	tx         *sql.Tx
+	insertFile *sql.Stmt
+	insertGram *sql.Stmt
+}
+
+// Create a text index database at path.
+func createSQLiteTextIndex(path string) (textIndexWriter, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	w := &sqliteTextIndexWriter{db: db, tx: tx}
+	if err := w.prepare(); err != nil {
+		tx.Rollback()
+		db.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Create the tables of the index, and prepare the statements which add to them.
+func (w *sqliteTextIndexWriter) prepare() error {
+	if _, err := w.tx.Exec(textIndexSchema); err != nil {
+		return err
+	}
+
+	var err error
+	if w.insertFile, err = w.tx.Prepare("INSERT INTO files (path, mtime, size, text) VALUES (?, ?, ?, ?)"); err != nil {
+		return err
+	}
+	w.insertGram, err = w.tx.Prepare("INSERT INTO trigrams (trigram, file) VALUES (?, ?)")
+	return err
+}
+
+func (w *sqliteTextIndexWriter) add(path string, f indexedText, trigrams []string) error {
+	res, err := w.insertFile.Exec(path, f.ModTime, f.Size, f.Text)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, gram := range trigrams {
+		if _, err := w.insertGram.Exec([]byte(gram), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *sqliteTextIndexWriter) close(info *textIndexInfo) error {
+	defer w.db.Close()
+	w.insertFile.Close()
+	w.insertGram.Close()
+
+	if info == nil {
+		return w.tx.Rollback()
+	}
+	if _, err := w.tx.Exec("INSERT INTO metadata (built, files) VALUES (?, ?)",
+		info.Built.Format(time.RFC3339), info.Files); err != nil {
+		w.tx.Rollback()
+		return err
+	}
+	return w.tx.Commit()
+}
@@ -0,0 +1,36 @@
+//go:build toml
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	formatters["toml"] = formatTOML
+}
+
+// Write a TOML document with a [[results]] table per result. Times are
+// written as TOML datetimes.
+func formatTOML(w io.Writer, columns []query.Column, results []result) error {
+	rows := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			switch v := r.column(i, c).(type) {
+			case os.FileMode:
+				row[c.Name()] = v.String()
+			default:
+				row[c.Name()] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return toml.NewEncoder(w).Encode(map[string]interface{}{"results": rows})
+}
@@ -0,0 +1,66 @@
+//go:build toml
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestFormatTOML(t *testing.T) {
+	dir := createTree(t, map[string]string{`a\b`: "aaa", "c": "c", "d": "dd"})
+
+	type Row struct {
+		Name string    `toml:"name"`
+		Size int64     `toml:"size"`
+		Time time.Time `toml:"time"`
+	}
+
+	type Case struct {
+		limit    string
+		expected []Row
+	}
+
+	cases := []Case{
+		{expected: []Row{
+			{Name: filepath.Join(dir, "c"), Size: 1},
+			{Name: filepath.Join(dir, "d"), Size: 2},
+			{Name: filepath.Join(dir, `a\b`), Size: 3},
+		}},
+		{limit: " LIMIT 2", expected: []Row{
+			{Name: filepath.Join(dir, "c"), Size: 1},
+			{Name: filepath.Join(dir, "d"), Size: 2},
+		}},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		input := "SELECT name, size, time FROM " + dir + " WHERE file IS reg ORDER BY size" + c.limit
+		if err := run(input, &options{format: "toml"}, &buf, ioutil.Discard); err != nil {
+			t.Fatalf("\nExpected no error\n     Got %v", err)
+		}
+
+		var doc struct {
+			Results []Row `toml:"results"`
+		}
+		if _, err := toml.Decode(buf.String(), &doc); err != nil {
+			t.Fatalf("\nExpected valid TOML\n     Got %v", err)
+		}
+
+		for i, r := range doc.Results {
+			if r.Time.IsZero() {
+				t.Fatalf("\nExpected a datetime\n     Got %v", r.Time)
+			}
+			doc.Results[i].Time = time.Time{}
+		}
+		if !reflect.DeepEqual(doc.Results, c.expected) {
+			t.Fatalf("\nExpected %v\n     Got %v", c.expected, doc.Results)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return a result per attribute of the UNPIVOT clause for each of the results,
+// in order, with the attribute's name and value as the values of the clause's
+// columns. When the attributes have different types, their values are shown
+// as text, so that the value column has a single type.
+func unpivotResults(q *query.Query, results []result) []result {
+	u := q.Unpivot
+	_, uniform := unpivotType(u)
+
+	unpivoted := make([]result, 0, len(results)*len(u.Attributes))
+	for _, r := range results {
+		for _, attribute := range u.Attributes {
+			value := r.value(attribute)
+			if !uniform && value != nil {
+				value = formatValue(value)
+			}
+
+			row := result{path: r.path, info: r.info, computed: make([]interface{}, len(q.Columns))}
+			for i, c := range q.Columns {
+				if c.Unpivot == nil {
+					continue
+				}
+				if c.Unpivoted == u.NameColumn {
+					row.computed[i] = attribute
+				} else {
+					row.computed[i] = value
+				}
+			}
+			unpivoted = append(unpivoted, row)
+		}
+	}
+	return unpivoted
+}
+
+// Return the type of the values of the UNPIVOT clause's value column, along
+// with true iff each of its attributes has that type. Otherwise, the values
+// are strings (which are NULL when the attribute is).
+func unpivotType(u *query.UnpivotClause) (attributeType, bool) {
+	var t attributeType
+	uniform := true
+	for i, attribute := range u.Attributes {
+		at := columnType(query.Column{Attribute: attribute})
+		if i == 0 {
+			t = at
+		} else if at.jsonType != t.jsonType || at.format != t.format {
+			uniform = false
+		}
+		t.nullable = t.nullable || at.nullable
+	}
+
+	if !uniform {
+		return attributeType{jsonType: "string", nullable: t.nullable}, false
+	}
+	return t, true
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kshvmdn/fsql/fsqlplugin"
+	"github.com/kshvmdn/fsql/query"
+)
+
+// A vfs is a (virtual) filesystem which sources are walked in. Sources are on
+// the local filesystem (see localVFS), unless another filesystem which
+// implements this interface is used for them.
+type vfs interface {
+	// Walk the file tree rooted at root, calling fn for each file or
+	// directory in the tree (including root), like filepath.Walk.
+	Walk(root string, fn filepath.WalkFunc) error
+
+	// Stat returns the information of the file at path.
+	Stat(path string) (os.FileInfo, error)
+
+	// Open opens the file at path for reading.
+	Open(path string) (io.ReadCloser, error)
+}
+
+// A localVFS is the local filesystem, which is walked with the query's options
+// (see walk).
+type localVFS struct {
+	opts query.QueryOptions
+}
+
+func (l localVFS) Walk(root string, fn filepath.WalkFunc) error {
+	return walk(root, l.opts, fn)
+}
+
+func (l localVFS) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (l localVFS) Open(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+// A vfsProvider returns the filesystem which a source with the provider's
+// scheme (e.g. s3 for s3://bucket/prefix) is walked in with the query's
+// options.
+type vfsProvider func(src string, opts query.QueryOptions) (vfs, error)
+
+// The provider of each source scheme. Providers which require third-party
+// dependencies are only registered when built with their build tag (e.g. the
+// s3 provider in s3.go).
+var vfsProviders = map[string]vfsProvider{}
+
+// The build tag which registers the provider of each scheme that isn't built
+// by default.
+var vfsTags = map[string]string{
+	"s3":   "s3",
+	"gs":   "gcs",
+	"sftp": "sftp",
+	"git":  "git",
+}
+
+// Return the scheme of the source (e.g. s3 for s3://bucket/prefix), or the
+// empty string if it's a local path.
+func sourceScheme(src string) string {
+	if i := strings.Index(src, "://"); i > 0 {
+		return strings.ToLower(src[:i])
+	}
+	return ""
+}
+
+// Return the provider of the source's scheme, or an error if there isn't one.
+func lookupProvider(scheme string) (vfsProvider, error) {
+	provider, ok := vfsProviders[scheme]
+	if !ok {
+		if factory, ok := fsqlplugin.LookupVFS(scheme); ok {
+			return pluginProvider(factory), nil
+		}
+		if tag, ok := vfsTags[scheme]; ok {
+			return nil, fmt.Errorf("%s sources require building with -tags %s", scheme, tag)
+		}
+		return nil, fmt.Errorf("unknown source scheme: %s", scheme)
+	}
+	return provider, nil
+}
+
+// Return the provider of the filesystems returned by a plugin's factory, which
+// doesn't take the query's options.
+func pluginProvider(factory fsqlplugin.VFSFactory) vfsProvider {
+	return func(src string, opts query.QueryOptions) (vfs, error) {
+		fsys, err := factory(src)
+		if err != nil {
+			return nil, err
+		}
+		return fsys, nil
+	}
+}
+
+// Return an error if the filesystem of any source of the query (or of its
+// CTEs) which has a scheme can't be used, e.g. if there's no provider for
+// the scheme, or it fails to connect to the server, or if a source without a
+// scheme is outside of opts.FS (see fsVFS).
+func checkSources(q *query.Query, opts query.QueryOptions) error {
+	for _, cte := range q.With {
+		if err := checkSources(cte.Query, opts); err != nil {
+			return err
+		}
+	}
+	for _, src := range q.Sources["include"] {
+		if sourceScheme(src) != "" {
+			if _, err := sourceVFS(src, opts); err != nil {
+				return err
+			}
+		} else if opts.FS != nil {
+			if _, err := (fsVFS{fsys: opts.FS}).resolve(src); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Return the filesystem which the source is walked in with the query's
+// options: the local filesystem, unless the source has a scheme.
+var sourceVFS = func(src string, opts query.QueryOptions) (vfs, error) {
+	scheme := sourceScheme(src)
+	if scheme == "" && opts.FS != nil {
+		return fsVFS{fsys: opts.FS, opts: opts}, nil
+	} else if scheme == "" {
+		return localVFS{opts: opts}, nil
+	}
+
+	provider, err := lookupProvider(scheme)
+	if err != nil {
+		return nil, err
+	}
+	return provider(src, opts)
+}
+
+// Walk a listing of the files below root (e.g. the objects of a bucket with
+// root's prefix) as if it were a file tree, calling fn for root (with info),
+// then each file and directory in order of their paths. The listing maps the
+// slash-separated path of each file below root to its information. Directories
+// which aren't in the listing, but contain files which are, are walked too.
+func walkListing(root string, info os.FileInfo, listing map[string]os.FileInfo,
+	opts query.QueryOptions, fn filepath.WalkFunc) error {
+	if err := fn(root, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+
+	infos := make(map[string]os.FileInfo, len(listing))
+	for name, info := range listing {
+		infos[name] = info
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := infos[dir]; !ok {
+				infos[dir] = impliedDir(path.Base(dir))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var skipped []string
+	for _, name := range names {
+		if hasAnyPrefix(name, skipped) {
+			continue
+		}
+		if opts.MaxDepth > 0 && strings.Count(name, "/")+1 > opts.MaxDepth {
+			continue
+		}
+
+		info := infos[name]
+		if err := fn(root+"/"+name, info, nil); err != nil {
+			if err != filepath.SkipDir {
+				return err
+			}
+			if info.IsDir() {
+				skipped = append(skipped, name+"/")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the bucket and key (without leading or trailing slashes) of the path
+// p of an object store (e.g. s3://bucket/key).
+func parseBucketPath(p string) (bucket, key string, err error) {
+	rest := p[len(sourceScheme(p))+len("://"):]
+	bucket = rest
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		bucket, key = rest[:i], strings.Trim(rest[i+1:], "/")
+	}
+	if bucket == "" {
+		return "", "", errors.New("invalid path: " + p)
+	}
+	return bucket, key, nil
+}
+
+// Return the prefix of the keys of the objects in the directory key of an
+// object store.
+func bucketPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// The information of an object (or directory) of an object store.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return i.dir }
+func (i objectInfo) Sys() interface{}   { return nil }
+
+func (i objectInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// A commitInfo is the information of a file in a Git repository, along with
+// the hash of the last commit which changed it.
+type commitInfo struct {
+	os.FileInfo
+	commit string
+}
+
+// Return the hash of the last commit which changed the file, and false if it
+// isn't in a Git repository.
+func fileCommit(info os.FileInfo) (string, bool) {
+	if c, ok := info.(commitInfo); ok {
+		return c.commit, true
+	}
+	return "", false
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Return the directory of the write-ahead logs of the DELETE, MOVE, and COPY
+// statements, or an empty string if there's no home directory.
+func walDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".fsql", "wal")
+}
+
+// A walEntry is a line of a write-ahead log, which is newline-delimited JSON:
+// one of the statement's steps, or the index of the step which was just taken
+// (Done). The steps are all written, in order, before any of them is taken.
+type walEntry struct {
+	fileStep
+	Done *int `json:"done,omitempty"`
+}
+
+// A writeAheadLog is the log of a statement which is running.
+type writeAheadLog struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// Create a write-ahead log in dir, and write each of the steps to it.
+func createWAL(dir string, steps []fileStep) (*writeAheadLog, error) {
+	if dir == "" {
+		return nil, errors.New("cannot find the home directory to write the write-ahead log to")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d-%d.ndjson", time.Now().UnixNano(), os.Getpid()))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	wal := &writeAheadLog{f: f, enc: json.NewEncoder(f)}
+	for _, step := range steps {
+		if err := wal.enc.Encode(walEntry{fileStep: step}); err != nil {
+			wal.close()
+			return nil, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		wal.close()
+		return nil, err
+	}
+	return wal, nil
+}
+
+// Record that the step with index i was taken.
+func (wal *writeAheadLog) done(i int) error {
+	if err := wal.enc.Encode(walEntry{Done: &i}); err != nil {
+		return err
+	}
+	return wal.f.Sync()
+}
+
+// Close the log, which is left for fsql recover since its statement didn't
+// complete.
+func (wal *writeAheadLog) close() {
+	wal.f.Close()
+}
+
+// Remove the log, once its statement has completed.
+func (wal *writeAheadLog) remove() error {
+	wal.f.Close()
+	return os.Remove(wal.f.Name())
+}
+
+// An interruptedLog is the write-ahead log of a statement which didn't
+// complete. Since the steps are taken in order, the ones which were taken are
+// always the first.
+type interruptedLog struct {
+	path  string
+	steps []fileStep
+	taken int
+}
+
+// Read each of the write-ahead logs in dir, in the order they were created. A
+// line which can't be decoded is ignored, since it's the last one if the
+// statement was interrupted while writing it.
+func readWALs(dir string) ([]*interruptedLog, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*interruptedLog, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		l := &interruptedLog{path: path}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry walEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				continue
+			}
+			if entry.Done != nil {
+				l.taken = *entry.Done + 1
+			} else {
+				l.steps = append(l.steps, entry.fileStep)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		// The step after the last one which was recorded may have been taken
+		// before the statement was interrupted.
+		if l.taken < len(l.steps) && l.steps[l.taken].taken() {
+			l.taken++
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// Return the steps of the log which weren't taken.
+func (l *interruptedLog) remaining() []fileStep {
+	return l.steps[l.taken:]
+}
+
+// Describe the log's statement, e.g. "MOVE of 3 files".
+func (l *interruptedLog) String() string {
+	if len(l.steps) == 0 {
+		return "statement with no files"
+	}
+	files := len(l.steps)
+	if l.steps[0].Op == "MOVE ATOMIC" {
+		files /= 2
+	}
+	return fmt.Sprintf("%s of %d files", l.steps[0].Op, files)
+}
+
+// Take the steps which weren't taken before the statement was interrupted.
+func (l *interruptedLog) complete() error {
+	for _, step := range l.remaining() {
+		if err := step.apply(); err != nil {
+			return err
+		}
+		l.taken++
+	}
+	return nil
+}
+
+// Undo the steps which were taken before the statement was interrupted, in
+// reverse order. A step which the files show was already undone (by a
+// rollback which was itself interrupted) is skipped.
+func (l *interruptedLog) rollback() error {
+	for ; l.taken > 0; l.taken-- {
+		step := l.steps[l.taken-1]
+		if !step.taken() {
+			continue
+		}
+		if err := step.undo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warn about each of the statements in dir which were interrupted, so that
+// they can be completed or rolled back.
+func warnInterrupted(dir string, w io.Writer) {
+	logs, err := readWALs(dir)
+	if err != nil {
+		fmt.Fprintf(w, "warning: cannot read the write-ahead logs: %v\n", err)
+		return
+	}
+	for _, l := range logs {
+		fmt.Fprintf(w, "warning: a %s was interrupted after %d of its %d steps (run fsql recover complete or fsql "+
+			"recover rollback)\n", l, l.taken, len(l.steps))
+	}
+}
+
+// Run the recover command with its argument: `complete` takes the remaining
+// steps of each of the interrupted statements, and `rollback` undoes the steps
+// each of them took (which a DELETE can't). The log of each statement which is
+// completed or rolled back is removed.
+func runRecover(args []string, w io.Writer) error {
+	if len(args) != 1 || args[0] != "complete" && args[0] != "rollback" {
+		return errors.New("usage: fsql recover complete|rollback")
+	}
+
+	logs, err := readWALs(walDir())
+	if err != nil {
+		return err
+	}
+	for _, l := range logs {
+		action, verb := l.complete, "completed"
+		if args[0] == "rollback" {
+			action, verb = l.rollback, "rolled back"
+		}
+		if err := action(); err != nil {
+			return fmt.Errorf("cannot recover the %s: %w", l, err)
+		}
+		if err := os.Remove(l.path); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s the %s\n", verb, l)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Return the information of the file at path, without following a symbolic
+// link. Tests replace it to count the files which are walked.
+var lstat = os.Lstat
+
+// Walk the file tree rooted at root, calling fn for each file or directory in
+// the tree (including root), like filepath.Walk. Unlike filepath.Walk, the
+// walk is limited to opts.MaxDepth levels below root (if it's set), and
+// symbolic links to directories are walked when opts.FollowSymlinks is set
+// (in which case fn is passed the information of the link's target). When
+// opts.NoCrossDevice is set, directories on a different device than root are
+// passed to fn, but not walked.
+func walk(root string, opts query.QueryOptions, fn filepath.WalkFunc) error {
+	info, err := lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	var rootDevice *uint64
+	if opts.NoCrossDevice {
+		if dev, ok := device(root, info); ok {
+			rootDevice = &dev
+		}
+	}
+
+	err = walkPath(root, info, 0, opts, rootDevice, make(map[string]bool), fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// Walk the file tree rooted at path, which is depth levels below the root.
+// Directories which are already being walked (i.e. a symbolic link to one of
+// path's ancestors) are skipped to avoid cycles, and so are those which aren't
+// on rootDevice, unless it's nil.
+func walkPath(path string, info os.FileInfo, depth int, opts query.QueryOptions,
+	rootDevice *uint64, walking map[string]bool, fn filepath.WalkFunc) error {
+	if opts.FollowSymlinks && info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Stat(path); err == nil {
+			info = target
+		}
+	}
+
+	if err := fn(path, info, nil); err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() || (opts.MaxDepth > 0 && depth >= opts.MaxDepth) ||
+		crossesDevice(rootDevice, path, info) {
+		return nil
+	}
+
+	if opts.FollowSymlinks {
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return fn(path, info, err)
+		}
+		if walking[real] {
+			return nil
+		}
+		walking[real] = true
+		defer delete(walking, real)
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+
+	for _, name := range names {
+		child := filepath.Join(path, name)
+		childInfo, err := lstat(child)
+		if err != nil {
+			if err := fn(child, childInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		if err := walkPath(child, childInfo, depth+1, opts, rootDevice, walking, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Return the sorted names of the entries of the directory at path.
+func readDirNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Return true iff the file at path (with info) is known to be on a different
+// device than rootDevice, which is nil if any device may be walked.
+func crossesDevice(rootDevice *uint64, path string, info os.FileInfo) bool {
+	if rootDevice == nil {
+		return false
+	}
+	dev, ok := device(path, info)
+	return ok && dev != *rootDevice
+}
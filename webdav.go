@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	vfsProviders["webdav"] = newWebDAVVFS
+	vfsProviders["webdavs"] = newWebDAVVFS
+}
+
+// The body of a PROPFIND request for the properties of a file which are
+// mapped to its information.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop>` +
+	`<D:resourcetype/><D:getcontentlength/><D:getlastmodified/>` +
+	`</D:prop></D:propfind>`
+
+// A webdavVFS is a WebDAV server's filesystem, in which paths are URLs of the
+// form webdav://host/path (over HTTP) or webdavs://host/path (over HTTPS).
+// Directories are listed with a PROPFIND request each.
+type webdavVFS struct {
+	client   *http.Client
+	scheme   string // The scheme of the server's URLs, http or https.
+	host     string
+	username string
+	password string
+	opts     query.QueryOptions
+
+	mu     sync.Mutex
+	digest map[string]string // The server's digest challenge, once it has sent one.
+	basic  bool              // Whether the server has asked for basic auth.
+	count  int               // The number of requests which used the digest nonce.
+}
+
+// Return the filesystem of the WebDAV server of the source, which is of the
+// form webdav://[user[:password]@]host[:port]/path. The user is authenticated
+// with basic or digest auth, whichever the server asks for.
+func newWebDAVVFS(src string, opts query.QueryOptions) (vfs, error) {
+	scheme := sourceScheme(src)
+	rest := src[len(scheme)+len("://"):]
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+
+	u, err := url.Parse("http://" + rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV source %s: %v", src, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV source %s: missing host", src)
+	}
+
+	w := &webdavVFS{client: http.DefaultClient, scheme: "http", host: u.Host, opts: opts}
+	if scheme == "webdavs" {
+		w.scheme = "https"
+	}
+	if u.User != nil {
+		w.username = u.User.Username()
+		w.password, _ = u.User.Password()
+	}
+	return w, nil
+}
+
+// Return the URL on the server of the WebDAV path p.
+func (w *webdavVFS) url(p string) *url.URL {
+	rest := p[len(sourceScheme(p))+len("://"):]
+	remote := "/"
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		remote = rest[i:]
+	}
+	return &url.URL{Scheme: w.scheme, Host: w.host, Path: remote}
+}
+
+func (w *webdavVFS) Stat(p string) (os.FileInfo, error) {
+	u := w.url(p)
+	infos, err := w.propfind(u, "0")
+	if err != nil {
+		return nil, err
+	}
+	info, ok := infos[strings.TrimSuffix(u.Path, "/")]
+	if !ok {
+		return nil, fmt.Errorf("PROPFIND %s: missing response", u.Redacted())
+	}
+	return info, nil
+}
+
+func (w *webdavVFS) Open(p string) (io.ReadCloser, error) {
+	u := w.url(p)
+	resp, err := w.do("GET", u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u.Redacted(), resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Walk the file tree rooted at root on the server, like walk.
+func (w *webdavVFS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := w.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = w.walk(strings.TrimSuffix(root, "/"), info, 0, fn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// Walk the file tree rooted at p, which is depth levels below the root.
+func (w *webdavVFS) walk(p string, info os.FileInfo, depth int, fn filepath.WalkFunc) error {
+	if err := fn(p, info, nil); err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if !info.IsDir() || (w.opts.MaxDepth > 0 && depth >= w.opts.MaxDepth) {
+		return nil
+	}
+
+	u := w.url(p)
+	infos, err := w.propfind(u, "1")
+	if err != nil {
+		return fn(p, info, err)
+	}
+	delete(infos, strings.TrimSuffix(u.Path, "/"))
+
+	names := make([]string, 0, len(infos))
+	for remote := range infos {
+		names = append(names, remote)
+	}
+	sort.Strings(names)
+
+	for _, remote := range names {
+		err := w.walk(p+"/"+path.Base(remote), infos[remote], depth+1, fn)
+		if err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A davMultistatus is the body of the response to a PROPFIND request.
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				Collection    *struct{} `xml:"DAV: resourcetype>collection"`
+				ContentLength string    `xml:"DAV: getcontentlength"`
+				LastModified  string    `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// Return the information of the file at u (with a depth of 0), or of it and
+// the files in it (with a depth of 1), by their paths on the server (without
+// trailing slashes).
+func (w *webdavVFS) propfind(u *url.URL, depth string) (map[string]os.FileInfo, error) {
+	header := http.Header{"Depth": {depth}, "Content-Type": {"application/xml; charset=utf-8"}}
+	resp, err := w.do("PROPFIND", u, header, []byte(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("PROPFIND %s: %w", u.Redacted(), os.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", u.Redacted(), resp.Status)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %v", u.Redacted(), err)
+	}
+
+	infos := make(map[string]os.FileInfo, len(ms.Responses))
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		remote := strings.TrimSuffix(href.Path, "/")
+
+		info := objectInfo{name: path.Base(remote)}
+		for _, ps := range r.Propstats {
+			// Properties which the server doesn't have are in a propstat with
+			// another status (e.g. the length of a directory).
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			info.dir = ps.Prop.Collection != nil
+			info.size, _ = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+			info.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+		}
+		infos[remote] = info
+	}
+	return infos, nil
+}
+
+// Send a request to the server, authenticated with the scheme the server has
+// asked for. If the server rejects a request's authentication, it's sent again
+// with the server's new challenge.
+func (w *webdavVFS) do(method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if err := w.authorize(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || retried || w.username == "" {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		challenge := resp.Header.Get("WWW-Authenticate")
+		w.mu.Lock()
+		switch scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0]); scheme {
+		case "basic":
+			w.basic = true
+		case "digest":
+			w.digest, w.count = parseAuthParams(challenge[len(scheme):]), 0
+		default:
+			w.mu.Unlock()
+			return nil, fmt.Errorf("%s %s: unsupported authentication: %s", method, u.Redacted(), challenge)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// Set the authorization of the request, once the server has asked for it.
+func (w *webdavVFS) authorize(req *http.Request) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.basic {
+		req.SetBasicAuth(w.username, w.password)
+		return nil
+	}
+	if w.digest == nil {
+		return nil
+	}
+
+	cnonce := make([]byte, 8)
+	if _, err := rand.Read(cnonce); err != nil {
+		return err
+	}
+	w.count++
+
+	realm, nonce, qop := w.digest["realm"], w.digest["nonce"], w.digest["qop"]
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(w.username + ":" + realm + ":" + w.password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	params := []string{
+		fmt.Sprintf(`username=%q`, w.username),
+		fmt.Sprintf(`realm=%q`, realm),
+		fmt.Sprintf(`nonce=%q`, nonce),
+		fmt.Sprintf(`uri=%q`, uri),
+	}
+	if qop != "" {
+		// Only the auth quality of protection is supported, since the body
+		// isn't hashed.
+		nc, cn := fmt.Sprintf("%08x", w.count), hex.EncodeToString(cnonce)
+		response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cn + ":auth:" + ha2)
+		params = append(params, "qop=auth", "nc="+nc, fmt.Sprintf(`cnonce=%q`, cn),
+			fmt.Sprintf(`response=%q`, response))
+	} else {
+		params = append(params, fmt.Sprintf(`response=%q`, md5Hex(ha1+":"+nonce+":"+ha2)))
+	}
+	if opaque, ok := w.digest["opaque"]; ok {
+		params = append(params, fmt.Sprintf(`opaque=%q`, opaque))
+	}
+	if algorithm, ok := w.digest["algorithm"]; ok {
+		params = append(params, "algorithm="+algorithm)
+	}
+
+	req.Header.Set("Authorization", "Digest "+strings.Join(params, ", "))
+	return nil
+}
+
+// Return the comma-separated key=value parameters of an authentication
+// challenge (e.g. realm="files", nonce="abc"), by their lowercase keys.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, ", ") {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var quoted strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				quoted.WriteByte(s[i])
+			}
+			value, s = quoted.String(), s[i:]
+			s = strings.TrimPrefix(s, `"`)
+		} else if j := strings.IndexByte(s, ','); j >= 0 {
+			value, s = strings.TrimSpace(s[:j]), s[j:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		params[key] = value
+	}
+	return params
+}
+
+// Return the hex-encoded MD5 hash of s.
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
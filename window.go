@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Compute the value of each window function column for each result.
+func computeWindows(columns []query.Column, results []result) {
+	for i := range results {
+		if results[i].computed == nil {
+			results[i].computed = make([]interface{}, len(columns))
+		}
+	}
+
+	for i, c := range columns {
+		if c.Window == nil || c.Window.InOutputOrder {
+			continue
+		}
+
+		for _, partition := range partitionResults(results, c.Window.PartitionBy) {
+			computeWindow(i, c.Window, results, partition)
+		}
+	}
+}
+
+// Number the results, which are in output order, for each ROW_NUMBER() column
+// without OVER, starting at 1.
+func numberResults(columns []query.Column, results []result) {
+	for i, c := range columns {
+		if c.Window == nil || !c.Window.InOutputOrder {
+			continue
+		}
+		for j := range results {
+			results[j].computed[i] = j + 1
+		}
+	}
+}
+
+// Group the indices of results by their values for each of the attributes.
+// Each partition's indices are in the same order as results.
+func partitionResults(results []result, attributes []string) [][]int {
+	keys := make(map[string]int)
+	partitions := make([][]int, 0)
+
+	for i, r := range results {
+		values := make([]string, len(attributes))
+		for j, attribute := range attributes {
+			values[j] = fmt.Sprint(r.value(attribute))
+		}
+
+		key := strings.Join(values, "\x00")
+		if _, ok := keys[key]; !ok {
+			keys[key] = len(partitions)
+			partitions = append(partitions, make([]int, 0))
+		}
+		partitions[keys[key]] = append(partitions[keys[key]], i)
+	}
+
+	return partitions
+}
+
+// Compute the window function for each result in partition (a list of indices
+// of results), storing the value as the result's column-th column.
+func computeWindow(column int, fn *query.WindowFunction, results []result, partition []int) {
+	sort.SliceStable(partition, func(i, j int) bool {
+		return compareOrdering(fn.OrderBy, results[partition[i]], results[partition[j]]) < 0
+	})
+
+	n := len(partition)
+	denseRank := 0
+	for start := 0; start < n; {
+		// Results which are equal according to the window's ORDER BY (i.e.
+		// peers) share the same rank.
+		end := start + 1
+		for end < n && compareOrdering(fn.OrderBy, results[partition[start]], results[partition[end]]) == 0 {
+			end++
+		}
+		rank := start + 1
+		denseRank++
+
+		for i := start; i < end; i++ {
+			var value interface{}
+			switch fn.Type {
+			case query.RowNumber:
+				value = i + 1
+			case query.Rank:
+				value = rank
+			case query.DenseRank:
+				value = denseRank
+			case query.PercentRank:
+				value = 0.0
+				if n > 1 {
+					value = float64(rank-1) / float64(n-1)
+				}
+			case query.CumeDist:
+				// The fraction of the partition ordered before or with the
+				// result (including all of its peers).
+				value = float64(end) / float64(n)
+			case query.Lag:
+				if i-fn.Offset >= 0 {
+					value = results[partition[i-fn.Offset]].value(fn.Attribute)
+				}
+			case query.Lead:
+				if i+fn.Offset < n {
+					value = results[partition[i+fn.Offset]].value(fn.Attribute)
+				}
+			case query.Ntile:
+				value = ntile(i, n, fn.Buckets)
+			case query.FirstValue:
+				value = results[partition[0]].value(fn.Attribute)
+			case query.LastValue:
+				// The frame is always the whole partition, rather than ending
+				// with the result's last peer.
+				value = results[partition[n-1]].value(fn.Attribute)
+			}
+			results[partition[i]].computed[column] = value
+		}
+		start = end
+	}
+}
+
+// Return the bucket (from 1 to buckets) of the i-th of n results, when they're
+// divided into buckets of equal size. When n isn't divisible by buckets, the
+// first n % buckets buckets have an extra result.
+func ntile(i, n, buckets int) int {
+	size, extra := n/buckets, n%buckets
+	if large := extra * (size + 1); i >= large {
+		return extra + (i-large)/size + 1
+	}
+	return i/(size+1) + 1
+}
+
+// Return the type of the window function's values. The values of LAG, LEAD,
+// FIRST_VALUE, and LAST_VALUE are the values of their attribute (or NULL, for
+// LAG and LEAD past the partition's ends).
+func windowType(fn *query.WindowFunction) attributeType {
+	switch fn.Type {
+	case query.PercentRank, query.CumeDist:
+		return attributeType{jsonType: "number"}
+	case query.Lag, query.Lead, query.FirstValue, query.LastValue:
+		t := columnType(query.Column{Attribute: fn.Attribute})
+		t.nullable = true
+		return t
+	}
+	return attributeType{jsonType: "integer"}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestXattr(t *testing.T) {
+	dir := createTree(t, map[string]string{"a": "a", "b": "b"})
+	err := syscall.Setxattr(filepath.Join(dir, "a"), "user.fsql.test", []byte("yes"), 0)
+	if err == syscall.ENOTSUP {
+		t.Skip("extended attributes aren't supported by the temporary directory's filesystem")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Case struct {
+		where    string
+		expected []string
+	}
+
+	cases := []Case{
+		{where: `xattr("user.fsql.test") IS NOT NULL`, expected: []string{"a"}},
+		{where: `xattr("user.fsql.test") IS NULL`, expected: []string{"b"}},
+		{where: `xattr("user.fsql.test") = yes`, expected: []string{"a"}},
+		{where: `NOT xattr("user.fsql.test") = no`, expected: []string{"a"}},
+		{where: `xattr("user.fsql.other") IS NOT NULL`, expected: []string{}},
+		{where: `XATTR_KEYS(path) CONTAINS user.fsql`, expected: []string{"a"}},
+		{where: `XATTR_KEYS() = ''`, expected: []string{"b"}},
+	}
+
+	for _, c := range cases {
+		actual, err := runLines("SELECT name FROM "+dir+" WHERE file IS reg AND "+c.where+" ORDER BY name",
+			&options{})
+		if err != nil {
+			t.Fatalf("\nExpected no error for %q\n     Got %v", c.where, err)
+		}
+		for i := range actual {
+			actual[i] = filepath.Base(actual[i])
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Fatalf("\nExpected %v for %q\n     Got %v", c.expected, c.where, actual)
+		}
+	}
+}
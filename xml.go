@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+// Write an XML document with a <results> root element, which has a <file>
+// element per result, with an element per column containing its value.
+func formatXML(w io.Writer, columns []query.Column, results []result) error {
+	names := make([]xml.Name, len(columns))
+	for i, c := range columns {
+		names[i] = xml.Name{Local: xmlName(c.Name())}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	root := xml.StartElement{Name: xml.Name{Local: "results"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	for _, r := range results {
+		file := xml.StartElement{Name: xml.Name{Local: "file"}}
+		if err := enc.EncodeToken(file); err != nil {
+			return err
+		}
+		for i, c := range columns {
+			if err := enc.EncodeElement(xmlValue(r.column(i, c)), xml.StartElement{Name: names[i]}); err != nil {
+				return err
+			}
+		}
+		if err := enc.EncodeToken(file.End()); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// Return the value as it's written by the xml format. Times are written in
+// the same format as xs:dateTime.
+func xmlValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case os.FileMode:
+		return v.String()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// Return name as a valid XML element name: each character which isn't allowed
+// is replaced by an underscore, and names which don't start with a letter or
+// underscore (or which start with the reserved "xml") are prefixed by one.
+// Colons are replaced too, since they're only allowed by namespaces.
+func xmlName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+
+	s := b.String()
+	if s == "" || !(unicode.IsLetter([]rune(s)[0]) || s[0] == '_') ||
+		strings.HasPrefix(strings.ToLower(s), "xml") {
+		s = "_" + s
+	}
+	return s
+}
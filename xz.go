@@ -0,0 +1,15 @@
+//go:build xz
+
+package main
+
+import (
+	"io"
+
+	"github.com/xi2/xz"
+)
+
+func init() {
+	decompress := func(r io.Reader) (io.Reader, error) { return xz.NewReader(r, xz.DefaultDict) }
+	tarDecompressors[".tar.xz"] = decompress
+	tarDecompressors[".txz"] = decompress
+}
@@ -0,0 +1,46 @@
+//go:build yaml
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/kshvmdn/fsql/query"
+)
+
+func init() {
+	formatters["yaml"] = formatYAML
+}
+
+// Write a YAML sequence with a mapping per result (like the json format).
+// Times are written as !!timestamp values, and an empty result set as [].
+func formatYAML(w io.Writer, columns []query.Column, results []result) error {
+	rows := make([]map[string]interface{}, 0, len(results))
+	for _, r := range results {
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			switch v := r.column(i, c).(type) {
+			case os.FileMode:
+				row[c.Name()] = v.String()
+			case string:
+				if v == "" && columnType(c).nullable {
+					row[c.Name()] = nil
+				} else {
+					row[c.Name()] = v
+				}
+			default:
+				row[c.Name()] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(rows); err != nil {
+		return err
+	}
+	return enc.Close()
+}
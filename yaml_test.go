@@ -0,0 +1,53 @@
+//go:build yaml
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFormatYAML(t *testing.T) {
+	dir := createTree(t, map[string]string{"a: #&b": "aaa", "c": "c"})
+
+	var buf bytes.Buffer
+	input := "SELECT name, size, time FROM " + dir + " WHERE file IS reg ORDER BY size"
+	if err := run(input, &options{format: "yaml"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+
+	var rows []map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatalf("\nExpected valid YAML\n     Got %v", err)
+	}
+
+	for _, row := range rows {
+		if _, ok := row["time"].(time.Time); !ok {
+			t.Fatalf("\nExpected a timestamp\n     Got %#v", row["time"])
+		}
+		delete(row, "time")
+	}
+	expected := []map[string]interface{}{
+		{"name": filepath.Join(dir, "c"), "size": 1},
+		{"name": filepath.Join(dir, "a: #&b"), "size": 3},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("\nExpected %v\n     Got %v", expected, rows)
+	}
+
+	buf.Reset()
+	input = "SELECT name FROM " + dir + " WHERE size > 10"
+	if err := run(input, &options{format: "yaml"}, &buf, ioutil.Discard); err != nil {
+		t.Fatalf("\nExpected no error\n     Got %v", err)
+	}
+	if actual := strings.TrimSpace(buf.String()); actual != "[]" {
+		t.Fatalf("\nExpected %q\n     Got %q", "[]", actual)
+	}
+}